@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/advanderveer/dfs/ffs/chunkstore"
+	"github.com/advanderveer/dfs/ffs/crypt"
+	"github.com/advanderveer/dfs/ffs/volumes"
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+//targetFlags adds the flags that select a backup target to fs, the returned
+//function builds its config from the directory argument
+func targetFlags(fs *flag.FlagSet) func(dir string) chunkstore.Config {
+	cfg, s3cfg := chunkstore.Config{}, chunkstore.S3Config{}
+	fs.StringVar(&cfg.Backend, "backend", chunkstore.KVFiles, "backend that stores the backup: kvfiles, bolt or s3")
+	fs.StringVar(&cfg.Compression, "compression", "", "codec chunks are compressed with in the backup: none or deflate")
+	fs.StringVar(&s3cfg.Endpoint, "s3-endpoint", "", "url of the S3 compatible object store")
+	fs.StringVar(&s3cfg.Bucket, "s3-bucket", "", "bucket that stores the backup")
+	fs.StringVar(&s3cfg.Region, "s3-region", "", "region of the bucket")
+	fs.StringVar(&s3cfg.Prefix, "s3-prefix", "", "prefix of the backup objects in the bucket")
+	return func(dir string) chunkstore.Config {
+		cfg.ChunkDir = dir
+		if cfg.Backend == chunkstore.S3 {
+			cfg.S3 = &s3cfg
+		}
+
+		return cfg
+	}
+}
+
+type volumeBackup struct {
+	ui cli.Ui
+}
+
+func volumeBackupFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeBackup{ui}, nil
+	}
+}
+
+func (cmd *volumeBackup) Help() string {
+	return "Usage: volume backup [-backend=kvfiles|bolt|s3] [-s3-...] <name> <target-dir>\n\n  " + cmd.Synopsis() +
+		"\n\n  Every backup adds a numbered snapshot under the ID of the volume, chunks that the target holds\n  already are not copied again. Backups of encrypted volumes can only be restored with the key of the volume."
+}
+
+func (cmd *volumeBackup) Synopsis() string {
+	return "copy the metadata and chunks of a volume to a backup target"
+}
+
+func (cmd *volumeBackup) Run(args []string) int {
+	fs := flag.NewFlagSet("volume backup", flag.ContinueOnError)
+	target := targetFlags(fs)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	rep, err := vm.Backup(fs.Arg(0), target(fs.Arg(1)))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to back up volume: {{err}}", err))
+	}
+
+	v, err := vm.Get(fs.Arg(0))
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Created snapshot %d of volume %s with %d nodes and %d files, copied %d chunks (%d bytes), %d were backed up already",
+		rep.Snapshot, v.ID, rep.Nodes, rep.Files, rep.Chunks, rep.Bytes, rep.Skipped))
+	return 0
+}
+
+type volumeRestore struct {
+	ui cli.Ui
+}
+
+func volumeRestoreFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeRestore{ui}, nil
+	}
+}
+
+func (cmd *volumeRestore) Help() string {
+	return "Usage: volume restore [-volume=<id>] [-snapshot=<n>] [-key=<file>] [-backend=kvfiles|bolt|s3] [-s3-...] <target-dir> <name>\n\n  " + cmd.Synopsis() +
+		"\n\n  The volume is created with the encryption, chunking and compression of the backed up volume,\n  every chunk is verified against its key before it is stored. The key file of the backed up\n  volume is needed if it was encrypted."
+}
+
+func (cmd *volumeRestore) Synopsis() string {
+	return "create a volume from a snapshot of a backup target"
+}
+
+func (cmd *volumeRestore) Run(args []string) int {
+	var seq uint64
+	var key, src string
+	fs := flag.NewFlagSet("volume restore", flag.ContinueOnError)
+	fs.StringVar(&src, "volume", "", "ID of the backed up volume, empty for backups made before snapshots were stored per volume")
+	fs.Uint64Var(&seq, "snapshot", 0, "snapshot to restore, the latest when zero")
+	fs.StringVar(&key, "key", "", "key file of the encrypted volume that was backed up")
+	target := targetFlags(fs)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return cli.RunResultHelp
+	}
+
+	cfg := target(fs.Arg(0))
+	if key != "" {
+		kr, err := crypt.Load(key)
+		if err != nil {
+			return exit(cmd.ui, err)
+		}
+
+		cfg.Keyring = kr
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	//the restored volume takes its config from the backed up volume
+	rep, err := vm.Restore(cfg, src, seq, fs.Arg(1), volumes.Config{})
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to restore volume: {{err}}", err))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Restored snapshot %d into volume '%s': %d nodes and %d files, verified %d chunks (%d bytes)",
+		rep.Snapshot, fs.Arg(1), rep.Nodes, rep.Files, rep.Chunks, rep.Bytes))
+	return 0
+}
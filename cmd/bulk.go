@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/advanderveer/dfs/ffs"
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+//bulkPoll is how often the progress of a bulk job is reported
+var bulkPoll = time.Second
+
+type bulk struct {
+	ui cli.Ui
+}
+
+func bulkFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &bulk{ui}, nil
+	}
+}
+
+func (cmd *bulk) Help() string {
+	return "Usage: bulk <volume> rm <path>\n       bulk <volume> cp|mv <src> <dst>\n       bulk -resume=<id> <volume>\n\n  " + cmd.Synopsis() +
+		"\n\n  Trees are handled in batches of one transaction each, a job that was interrupted\n  continues where it stopped when it is resumed with its id."
+}
+
+func (cmd *bulk) Synopsis() string {
+	return "recursively remove, copy or move a tree of a volume"
+}
+
+func (cmd *bulk) Run(args []string) int {
+	var resume uint64
+	fs := flag.NewFlagSet("bulk", flag.ContinueOnError)
+	fs.Uint64Var(&resume, "resume", 0, "id of an interrupted job to resume")
+	if err := fs.Parse(args); err != nil {
+		return cli.RunResultHelp
+	}
+
+	if resume != 0 && fs.NArg() != 1 {
+		return cli.RunResultHelp
+	} else if resume == 0 && (fs.NArg() < 3 || fs.NArg() > 4 || (fs.Arg(1) == ffs.BulkRemove) != (fs.NArg() == 3)) {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	vfs, err := vm.Open(fs.Arg(0))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to open volume: {{err}}", err))
+	}
+
+	id := resume
+	if resume != 0 {
+		if errc := vfs.ResumeBulk(id); errc != 0 {
+			return exit(cmd.ui, fmt.Errorf("failed to resume job %d: %d", id, errc))
+		}
+	} else {
+		var errc int
+		if errc, id = vfs.Bulk(fs.Arg(1), fs.Arg(2), fs.Arg(3)); errc != 0 {
+			return exit(cmd.ui, fmt.Errorf("failed to start %s of '%s': %d", fs.Arg(1), fs.Arg(2), errc))
+		}
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Started job %d", id))
+	for {
+		errc, job := vfs.BulkStatus(id)
+		if errc != 0 {
+			return exit(cmd.ui, fmt.Errorf("failed to get job %d: %d", id, errc))
+		}
+
+		if job.Finished {
+			if job.Errc != 0 {
+				return exit(cmd.ui, fmt.Errorf("job %d failed after %d of %d nodes: %d, resume it with -resume=%d", id, job.Done, job.Total, job.Errc, id))
+			}
+
+			cmd.ui.Info(fmt.Sprintf("Finished %s of '%s': %d nodes", job.Op, job.Src, job.Done))
+			return 0
+		}
+
+		cmd.ui.Info(fmt.Sprintf("%d/%d nodes", job.Done, job.Total))
+		<-time.After(bulkPoll)
+	}
+}
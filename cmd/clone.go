@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+type clone struct {
+	ui cli.Ui
+}
+
+func cloneFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &clone{ui}, nil
+	}
+}
+
+func (cmd *clone) Help() string {
+	return "Usage: clone <volume> <src> <dst>\n\n  " + cmd.Synopsis() +
+		"\n\n  The copy shares the chunks of the original, writing to either of them stores new chunks\n  such that the other is left alone. Directories are cloned with all their content."
+}
+
+func (cmd *clone) Synopsis() string {
+	return "copy a file or directory tree of a volume without copying its content"
+}
+
+func (cmd *clone) Run(args []string) int {
+	if len(args) != 3 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	vfs, err := vm.Open(args[0])
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to open volume: {{err}}", err))
+	}
+
+	if errc := vfs.Clone(args[1], args[2]); errc != 0 {
+		return exit(cmd.ui, fmt.Errorf("failed to clone '%s': %d", args[1], errc))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Cloned '%s' to '%s'", args[1], args[2]))
+	return 0
+}
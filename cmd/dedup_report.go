@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/advanderveer/dfs/ffs/cdc"
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+type dedupReport struct {
+	ui cli.Ui
+}
+
+func dedupReportFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &dedupReport{ui}, nil
+	}
+}
+
+func (cmd *dedupReport) Help() string {
+	return "Usage: dedup report [-size=<bytes>] <dir>\n\n  " + cmd.Synopsis()
+}
+func (cmd *dedupReport) Synopsis() string {
+	return "compare how well local files deduplicate with fixed and content-defined chunks"
+}
+
+func (cmd *dedupReport) Run(args []string) int {
+	var size uint
+	fs := flag.NewFlagSet("dedup report", flag.ContinueOnError)
+	fs.UintVar(&size, "size", 1024*1024, "(average) chunk size to compare")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return cli.RunResultHelp
+	}
+
+	schemes := []cdc.Scheme{
+		cdc.Fixed(4 * 1024 * 1024),
+		cdc.Fixed(int(size)),
+		cdc.ContentDefined(uint32(size)),
+	}
+
+	stats, err := cdc.Measure(schemes, func(f func(rd io.Reader) error) error {
+		return filepath.Walk(fs.Arg(0), func(p string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() {
+				return err
+			}
+
+			file, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+
+			defer file.Close()
+			return f(file)
+		})
+	})
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to measure deduplication: {{err}}", err))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	tw := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCHEME\tCHUNKS\tUNIQUE\tBYTES\tSTORED\tRATIO")
+	for _, st := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.2f\n", st.Scheme, st.Chunks, st.Unique, st.Bytes, st.UniqueBytes, st.Ratio())
+	}
+
+	tw.Flush()
+	cmd.ui.Output(strings.TrimRight(buf.String(), "\n"))
+	return 0
+}
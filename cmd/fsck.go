@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+type fsck struct {
+	ui cli.Ui
+}
+
+func fsckFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &fsck{ui}, nil
+	}
+}
+
+func (cmd *fsck) Help() string {
+	return "Usage: fsck [-repair] <volume>\n\n  " + cmd.Synopsis() +
+		"\n\n  The volume should not be mounted while it is checked, nodes that are no longer\n  referenced by any directory are repaired by linking them in /lost+found"
+}
+func (cmd *fsck) Synopsis() string {
+	return "check the metadata of a volume for inconsistencies"
+}
+
+func (cmd *fsck) Run(args []string) int {
+	var repair bool
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.BoolVar(&repair, "repair", false, "repair the issues that are found where possible")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	vfs, err := vm.Open(fs.Arg(0))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to open volume: {{err}}", err))
+	}
+
+	errc, rep := vfs.Fsck(repair)
+	if errc != 0 {
+		return exit(cmd.ui, fmt.Errorf("failed to check volume: %d", errc))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Checked %d nodes and %d handles, found %d issues", rep.Nodes, rep.Handles, len(rep.Issues)))
+	if len(rep.Issues) < 1 {
+		return 0
+	}
+
+	unrepaired := 0
+	buf := bytes.NewBuffer(nil)
+	tw := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tINO\tPATH\tREPAIRED\tDETAIL")
+	for _, i := range rep.Issues {
+		if !i.Repaired {
+			unrepaired++
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%t\t%s\n", i.Kind, i.Ino, i.Path, i.Repaired, i.Detail)
+	}
+
+	tw.Flush()
+	cmd.ui.Output(strings.TrimRight(buf.String(), "\n"))
+	if unrepaired > 0 {
+		return 1
+	}
+
+	return 0
+}
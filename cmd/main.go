@@ -19,6 +19,23 @@ func main() {
 		"login":      loginFactory(ui),
 		"logout":     logoutFactory(ui),
 		"disk mount": diskMountFactory(ui),
+		"upload":     uploadFactory(ui),
+
+		"dedup report": dedupReportFactory(ui),
+		"fsck":         fsckFactory(ui),
+		"clone":        cloneFactory(ui),
+		"bulk":         bulkFactory(ui),
+		"tar export":   tarExportFactory(ui),
+		"tar import":   tarImportFactory(ui),
+
+		"volume create":     volumeCreateFactory(ui),
+		"volume list":       volumeListFactory(ui),
+		"volume rename":     volumeRenameFactory(ui),
+		"volume delete":     volumeDeleteFactory(ui),
+		"volume rotate-key": volumeRotateKeyFactory(ui),
+		"volume scrub":      volumeScrubFactory(ui),
+		"volume backup":     volumeBackupFactory(ui),
+		"volume restore":    volumeRestoreFactory(ui),
 	}
 
 	status, err := c.Run()
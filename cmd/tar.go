@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/advanderveer/dfs/ffs"
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+type tarExport struct {
+	ui cli.Ui
+}
+
+func tarExportFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &tarExport{ui}, nil
+	}
+}
+
+func (cmd *tarExport) Help() string {
+	return "Usage: tar export [-f <file>] <volume> <path>\n\n  " + cmd.Synopsis() +
+		"\n\n  The archive is written to stdout unless a file is given, names are relative to the path."
+}
+
+func (cmd *tarExport) Synopsis() string {
+	return "write a directory tree of a volume as a tar archive"
+}
+
+func (cmd *tarExport) Run(args []string) int {
+	var file string
+	fs := flag.NewFlagSet("tar export", flag.ContinueOnError)
+	fs.StringVar(&file, "f", "", "file the archive is written to")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	vfs, err := vm.Open(fs.Arg(0))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to open volume: {{err}}", err))
+	}
+
+	var w io.Writer = os.Stdout
+	if file != "" && file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return exit(cmd.ui, errwrap.Wrapf("failed to create archive: {{err}}", err))
+		}
+
+		defer f.Close()
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+	if err = ffs.NewBrowser(vfs).ExportTar(fs.Arg(1), bw); err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to export: {{err}}", err))
+	}
+
+	if err = bw.Flush(); err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to write archive: {{err}}", err))
+	}
+
+	return 0
+}
+
+type tarImport struct {
+	ui cli.Ui
+}
+
+func tarImportFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &tarImport{ui}, nil
+	}
+}
+
+func (cmd *tarImport) Help() string {
+	return "Usage: tar import [-f <file>] <volume> <dir>\n\n  " + cmd.Synopsis() +
+		"\n\n  The archive is read from stdin unless a file is given, existing directories are merged with."
+}
+
+func (cmd *tarImport) Synopsis() string {
+	return "create the content of a tar archive in a directory of a volume"
+}
+
+func (cmd *tarImport) Run(args []string) int {
+	var file string
+	fs := flag.NewFlagSet("tar import", flag.ContinueOnError)
+	fs.StringVar(&file, "f", "", "file the archive is read from")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	vfs, err := vm.Open(fs.Arg(0))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to open volume: {{err}}", err))
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return exit(cmd.ui, errwrap.Wrapf("failed to open archive: {{err}}", err))
+		}
+
+		defer f.Close()
+		r = f
+	}
+
+	n, err := ffs.NewBrowser(vfs).ImportTar(fs.Arg(1), bufio.NewReader(r))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf(fmt.Sprintf("failed after importing %d entries: {{err}}", n), err))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Imported %d entries into '%s'", n, fs.Arg(1)))
+	return 0
+}
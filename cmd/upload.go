@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/advanderveer/dfs/ffs/dedup"
+	"github.com/advanderveer/dfs/ffs/fsrpc"
+	"github.com/billziss-gh/cgofuse/fuse"
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+type upload struct {
+	ui cli.Ui
+}
+
+func uploadFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &upload{ui}, nil
+	}
+}
+
+func (cmd *upload) Help() string {
+	return "Usage: upload <addr> <local-path> <remote-path>\n\n  " + cmd.Synopsis()
+}
+func (cmd *upload) Synopsis() string {
+	return "upload files while only sending content the server doesn't have"
+}
+
+func (cmd *upload) Run(args []string) int {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 3 {
+		return cli.RunResultHelp
+	}
+
+	//files are written as the subject of the session's id token
+	sess, _, err := continueSession(cmd.ui, loginFactory(cmd.ui))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to continue session: {{err}}", err))
+	}
+
+	remote, err := fsrpc.DialHTTPToken(fs.Arg(0), "/fs", sess.IDToken)
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	total := dedup.Stats{}
+	src, dst := fs.Arg(1), fs.Arg(2)
+	if err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		rpath := path.Join(dst, filepath.ToSlash(rel))
+		switch {
+		case fi.IsDir():
+			if errc := remote.Mkdir(rpath, uint32(fi.Mode().Perm())); errc != 0 && errc != -fuse.EEXIST {
+				return fmt.Errorf("failed to create directory '%s': %d", rpath, errc)
+			}
+
+			return nil
+		case !fi.Mode().IsRegular():
+			cmd.ui.Warn(fmt.Sprintf("Skipping '%s', only regular files and directories are uploaded", p))
+			return nil
+		}
+
+		if errc := remote.Mknod(rpath, fuse.S_IFREG|uint32(fi.Mode().Perm()), 0); errc != 0 && errc != -fuse.EEXIST {
+			return fmt.Errorf("failed to create file '%s': %d", rpath, errc)
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+		stats, err := dedup.Upload(remote, rpath, f)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to upload '%s': {{err}}", p), err)
+		}
+
+		total.Chunks, total.Sent = total.Chunks+stats.Chunks, total.Sent+stats.Sent
+		total.Bytes, total.SentBytes = total.Bytes+stats.Bytes, total.SentBytes+stats.SentBytes
+		cmd.ui.Info(fmt.Sprintf("%s: sent %d of %d chunks", rpath, stats.Sent, stats.Chunks))
+		return nil
+	}); err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	cmd.ui.Output(fmt.Sprintf("Uploaded %d bytes, sent %d bytes in %d of %d chunks", total.Bytes, total.SentBytes, total.Sent, total.Chunks))
+	return 0
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/advanderveer/dfs/ffs/chunkstore"
+	"github.com/advanderveer/dfs/ffs/volumes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/cli"
+)
+
+//volumeManager connects to the default FDB cluster and opens the volumes
+//that are stored in it
+func volumeManager() (vm *volumes.Manager, err error) {
+	if err = fdb.APIVersion(510); err != nil {
+		return nil, errwrap.Wrapf("failed to select FDB api version: {{err}}", err)
+	}
+
+	db, err := fdb.OpenDefault()
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to open FDB: {{err}}", err)
+	}
+
+	dir, err := volumes.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return volumes.NewManager(db, dir)
+}
+
+type volumeCreate struct {
+	ui cli.Ui
+}
+
+func volumeCreateFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeCreate{ui}, nil
+	}
+}
+
+func (cmd *volumeCreate) Help() string {
+	return "Usage: volume create [-backend=kvfiles|bolt|s3] [-chunk-dir=<dir>] [-s3-endpoint=<url> -s3-bucket=<bucket>] [-chunking=fixed|cdc] [-compression=none|deflate] [-encrypt [-convergent]] <name>\n\n  " +
+		cmd.Synopsis() + "\n\n  S3 credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, keys of\n  encrypted volumes are stored in FFS_KEY_DIR and must be backed up separately"
+}
+func (cmd *volumeCreate) Synopsis() string { return "create a new named volume" }
+
+func (cmd *volumeCreate) Run(args []string) int {
+	cfg, s3cfg := volumes.Config{}, chunkstore.S3Config{}
+	fs := flag.NewFlagSet("volume create", flag.ContinueOnError)
+	fs.StringVar(&cfg.Backend, "backend", chunkstore.KVFiles, "backend that stores the chunks: kvfiles, bolt or s3")
+	fs.StringVar(&cfg.ChunkDir, "chunk-dir", "", "directory that stores the chunks of the volume")
+	fs.StringVar(&cfg.Compression, "compression", "", "codec new chunks are compressed with: none or deflate")
+	fs.BoolVar(&cfg.Encrypted, "encrypt", false, "encrypt chunks and xattr values with a key that is stored outside of the cluster")
+	fs.BoolVar(&cfg.Convergent, "convergent", false, "encrypt identical chunks identically such that they are still deduplicated")
+	fs.StringVar(&cfg.Chunking, "chunking", "", "how files are split in chunks: fixed or cdc for content-defined chunks")
+	var csize uint
+	fs.UintVar(&csize, "chunk-size", 0, "(average) chunk size in bytes, zero selects the default")
+	fs.StringVar(&s3cfg.Endpoint, "s3-endpoint", "", "url of the S3 compatible object store")
+	fs.StringVar(&s3cfg.Bucket, "s3-bucket", "", "bucket that stores the chunks")
+	fs.StringVar(&s3cfg.Region, "s3-region", "", "region of the bucket")
+	fs.StringVar(&s3cfg.Prefix, "s3-prefix", "", "prefix of the chunk objects in the bucket")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return cli.RunResultHelp
+	}
+
+	if cfg.Backend == chunkstore.S3 {
+		cfg.S3 = &s3cfg
+	}
+
+	switch cfg.Chunking {
+	case "", "fixed":
+		cfg.Chunking = ""
+	case "cdc":
+	default:
+		return cli.RunResultHelp
+	}
+
+	if cfg.Convergent && !cfg.Encrypted {
+		return cli.RunResultHelp
+	}
+
+	cfg.ChunkSize = uint32(csize)
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	v, err := vm.Create(fs.Arg(0), cfg)
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to create volume: {{err}}", err))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Created volume '%s' (%s)", v.Name, v.ID))
+	if v.Config.Encrypted {
+		cmd.ui.Warn(fmt.Sprintf("The volume is encrypted with key %s, data is lost without it", v.Config.KeyFingerprint))
+	}
+
+	return 0
+}
+
+type volumeList struct {
+	ui cli.Ui
+}
+
+func volumeListFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeList{ui}, nil
+	}
+}
+
+func (cmd *volumeList) Help() string     { return "Usage: volume list\n\n  " + cmd.Synopsis() }
+func (cmd *volumeList) Synopsis() string { return "list all volumes" }
+
+func (cmd *volumeList) Run(args []string) int {
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	vols, err := vm.List()
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to list volumes: {{err}}", err))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	tw := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tID\tCREATED\tBACKEND\tLOCATION")
+	for _, v := range vols {
+		backend, location := v.Config.Backend, v.Config.ChunkDir
+		if backend == "" {
+			backend = chunkstore.KVFiles
+		}
+
+		if v.Config.S3 != nil {
+			location = v.Config.S3.Endpoint + "/" + v.Config.S3.Bucket + "/" + v.Config.S3.Prefix
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.Name, v.ID, v.Created.Format("2006-01-02 15:04"), backend, location)
+	}
+
+	tw.Flush()
+	cmd.ui.Output(strings.TrimRight(buf.String(), "\n"))
+	return 0
+}
+
+type volumeRename struct {
+	ui cli.Ui
+}
+
+func volumeRenameFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeRename{ui}, nil
+	}
+}
+
+func (cmd *volumeRename) Help() string {
+	return "Usage: volume rename <name> <new-name>\n\n  " + cmd.Synopsis()
+}
+func (cmd *volumeRename) Synopsis() string { return "rename a volume" }
+
+func (cmd *volumeRename) Run(args []string) int {
+	if len(args) != 2 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	if err = vm.Rename(args[0], args[1]); err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to rename volume: {{err}}", err))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Renamed volume '%s' to '%s'", args[0], args[1]))
+	return 0
+}
+
+type volumeDelete struct {
+	ui cli.Ui
+}
+
+func volumeDeleteFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeDelete{ui}, nil
+	}
+}
+
+func (cmd *volumeDelete) Help() string {
+	return "Usage: volume delete <name>\n\n  " + cmd.Synopsis()
+}
+func (cmd *volumeDelete) Synopsis() string { return "delete a volume and all its data" }
+
+func (cmd *volumeDelete) Run(args []string) int {
+	if len(args) != 1 {
+		return cli.RunResultHelp
+	}
+
+	answer, err := cmd.ui.Ask(fmt.Sprintf("delete volume '%s' and all its data? (yes/no):", args[0]))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to ask for confirmation: {{err}}", err))
+	}
+
+	if answer != "yes" {
+		cmd.ui.Info("Nothing deleted")
+		return 0
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	if err = vm.Delete(args[0]); err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to delete volume: {{err}}", err))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Deleted volume '%s'", args[0]))
+	return 0
+}
+
+type volumeRotateKey struct {
+	ui cli.Ui
+}
+
+func volumeRotateKeyFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeRotateKey{ui}, nil
+	}
+}
+
+func (cmd *volumeRotateKey) Help() string {
+	return "Usage: volume rotate-key <name>\n\n  " + cmd.Synopsis() + "\n\n  Existing data stays encrypted with the keys it was written with"
+}
+func (cmd *volumeRotateKey) Synopsis() string { return "encrypt new data of a volume with a new key" }
+
+func (cmd *volumeRotateKey) Run(args []string) int {
+	if len(args) != 1 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	id, err := vm.RotateKey(args[0])
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to rotate key: {{err}}", err))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Volume '%s' now encrypts new data with key %d", args[0], id))
+	return 0
+}
+
+type volumeScrub struct {
+	ui cli.Ui
+}
+
+func volumeScrubFactory(ui cli.Ui) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return &volumeScrub{ui}, nil
+	}
+}
+
+func (cmd *volumeScrub) Help() string {
+	return "Usage: volume scrub [-quarantine] [-verify-reads=on|off] <name>\n\n  " + cmd.Synopsis() +
+		"\n\n  Quarantined chunks are moved to the quarantine dir of the chunk store, uploading the\n  affected files again repairs them"
+}
+func (cmd *volumeScrub) Synopsis() string {
+	return "verify every chunk of a volume against its key and report corrupt files"
+}
+
+func (cmd *volumeScrub) Run(args []string) int {
+	var quarantine bool
+	var verify string
+	fs := flag.NewFlagSet("volume scrub", flag.ContinueOnError)
+	fs.BoolVar(&quarantine, "quarantine", false, "move corrupt chunks out of the chunk store")
+	fs.StringVar(&verify, "verify-reads", "", "turn verification of every chunk that is read on or off")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return cli.RunResultHelp
+	}
+
+	vm, err := volumeManager()
+	if err != nil {
+		return exit(cmd.ui, err)
+	}
+
+	vfs, err := vm.Open(fs.Arg(0))
+	if err != nil {
+		return exit(cmd.ui, errwrap.Wrapf("failed to open volume: {{err}}", err))
+	}
+
+	switch verify {
+	case "":
+	case "on", "off":
+		if errc := vfs.SetVerifyReads(verify == "on"); errc != 0 {
+			return exit(cmd.ui, fmt.Errorf("failed to configure verified reads: %d", errc))
+		}
+
+		cmd.ui.Info(fmt.Sprintf("Verified reads turned %s", verify))
+	default:
+		return cli.RunResultHelp
+	}
+
+	errc, rep := vfs.Scrub(quarantine)
+	if errc != 0 {
+		return exit(cmd.ui, fmt.Errorf("failed to scrub volume: %d", errc))
+	}
+
+	cmd.ui.Info(fmt.Sprintf("Verified %d chunks (%d bytes) of %d files", rep.Chunks, rep.Bytes, rep.Files))
+	if len(rep.Corrupt) < 1 {
+		return 0
+	}
+
+	buf := bytes.NewBuffer(nil)
+	tw := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHUNK\tSTATE\tPATHS")
+	for _, c := range rep.Corrupt {
+		state := "corrupt"
+		if c.Missing {
+			state = "missing"
+		} else if c.Quarantined {
+			state = "quarantined"
+		}
+
+		fmt.Fprintf(tw, "%.16s\t%s\t%s\n", c.Key, state, strings.Join(c.Paths, ", "))
+	}
+
+	tw.Flush()
+	cmd.ui.Output(strings.TrimRight(buf.String(), "\n"))
+	return 1
+}
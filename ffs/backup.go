@@ -0,0 +1,717 @@
+package ffs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"bazil.org/bazil/kv"
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/advanderveer/dfs/ffs/scrub"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//backupBatch is the nr of nodes that are exported or restored per transaction
+const backupBatch = 500
+
+//BackupTarget holds the chunks and snapshots of backups, chunks are content
+//addressed so every chunk is copied to it once. A chunkstore.Store is one.
+type BackupTarget interface {
+	chunks.Store
+	Has(ctx context.Context, key cas.Key) (bool, error)
+	PutObject(ctx context.Context, name string, d []byte) error
+	HasObject(ctx context.Context, name string) (bool, error)
+	GetObject(ctx context.Context, name string) ([]byte, error)
+}
+
+//SnapshotManifest describes the blob that holds the content of a file
+type SnapshotManifest struct {
+	Type      string `json:"type"`
+	Root      []byte `json:"root"`
+	Size      uint64 `json:"size"`
+	ChunkSize uint32 `json:"chunk_size"`
+	Fanout    uint32 `json:"fanout"`
+}
+
+func snapshotManifest(m *blobs.Manifest) *SnapshotManifest {
+	return &SnapshotManifest{Type: m.Type, Root: m.Root.Bytes(), Size: m.Size, ChunkSize: m.ChunkSize, Fanout: m.Fanout}
+}
+
+func (m *SnapshotManifest) blob() *blobs.Manifest {
+	return &blobs.Manifest{Type: m.Type, Root: cas.NewKey(m.Root), Size: m.Size, ChunkSize: m.ChunkSize, Fanout: m.Fanout}
+}
+
+//SnapshotEntry is a node as it was backed up, entries with the same inode
+//number are hard links of the same node
+type SnapshotEntry struct {
+	Path     string            `json:"path"`
+	Ino      uint64            `json:"ino"`
+	Mode     uint32            `json:"mode"`
+	Size     int64             `json:"size"`
+	Rdev     uint64            `json:"rdev,omitempty"`
+	Uid      uint32            `json:"uid"`
+	Gid      uint32            `json:"gid"`
+	Flags    uint32            `json:"flags,omitempty"`
+	Atim     fuse.Timespec     `json:"atim"`
+	Mtim     fuse.Timespec     `json:"mtim"`
+	Ctim     fuse.Timespec     `json:"ctim"`
+	Birthtim fuse.Timespec     `json:"birthtim"`
+	Owner    string            `json:"owner,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	Manifest *SnapshotManifest `json:"manifest,omitempty"`
+	Extents  []nodes.Extent    `json:"extents,omitempty"`
+	XAttrs   map[string][]byte `json:"xattrs,omitempty"`
+
+	inline []byte                     //content that is stored inline, it is backed up as a blob
+	xblobs map[string]*blobs.Manifest //large xattr values that were written before restoring them
+}
+
+//Snapshot is the metadata of a filesystem at the time it was backed up,
+//directories are listed before their content
+type Snapshot struct {
+	Seq     uint64           `json:"seq"`
+	Created time.Time        `json:"created"`
+	Entries []*SnapshotEntry `json:"entries"`
+}
+
+//BackupReport is the outcome of a backup, Skipped counts the chunks that the
+//target already held without the chunks below them
+type BackupReport struct {
+	Snapshot uint64 `json:"snapshot"`
+	Nodes    int    `json:"nodes"`
+	Files    int    `json:"files"`
+	Chunks   int    `json:"chunks"`
+	Skipped  int    `json:"skipped"`
+	Bytes    int64  `json:"bytes"`
+}
+
+//RestoreReport is the outcome of a restore, every chunk it counts matched
+//its key
+type RestoreReport struct {
+	Snapshot uint64 `json:"snapshot"`
+	Nodes    int    `json:"nodes"`
+	Files    int    `json:"files"`
+	Chunks   int    `json:"chunks"`
+	Bytes    int64  `json:"bytes"`
+}
+
+//snapshotName is the object that snapshot seq of volume is stored under, the
+//snapshots of each volume are numbered on their own such that volumes can
+//share a target. Backups without a volume were made before they were.
+func snapshotName(volume string, seq uint64) string {
+	if volume == "" {
+		return fmt.Sprintf("ffs-backup/snapshot/%d", seq)
+	}
+
+	return fmt.Sprintf("ffs-backup/%s/snapshot/%d", volume, seq)
+}
+
+//ReadSnapshot reads snapshot seq of volume from target, it returns a
+//kv.NotFoundError if there is no such snapshot
+func ReadSnapshot(ctx context.Context, target BackupTarget, volume string, seq uint64) (snap *Snapshot, err error) {
+	d, err := target.GetObject(ctx, snapshotName(volume, seq))
+	if err != nil {
+		return nil, err
+	}
+
+	snap = &Snapshot{}
+	if err = json.Unmarshal(d, snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %d: %v", seq, err)
+	}
+
+	return snap, nil
+}
+
+//LatestSnapshot returns the newest snapshot of volume in target or zero if
+//there is none. Snapshots are numbered from one without gaps as some targets
+//can't list or overwrite what they store.
+func LatestSnapshot(ctx context.Context, target BackupTarget, volume string) (seq uint64, err error) {
+	for ; ; seq++ {
+		has, err := target.HasObject(ctx, snapshotName(volume, seq+1))
+		if err != nil {
+			return 0, err
+		} else if !has {
+			return seq, nil
+		}
+	}
+}
+
+//recentStore remembers the chunk that was read last, walks read a chunk
+//right before it is copied
+type recentStore struct {
+	chunks.Store
+	ref scrub.Ref
+	c   *chunks.Chunk
+}
+
+func (r *recentStore) Get(ctx context.Context, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
+	ref := scrub.Ref{Key: key, Type: typ, Level: level}
+	if nil != r.c && ref == r.ref {
+		return r.c, nil
+	}
+
+	c, err := r.Store.Get(ctx, key, typ, level)
+	if err != nil {
+		return nil, err
+	}
+
+	r.ref, r.c = ref, c
+	return c, nil
+}
+
+//backupInline adds content that is stored inline to target as a blob of
+//its own, a restore doesn't need to know about inlining
+func backupInline(ctx context.Context, target chunks.Store, m *blobs.Manifest, data []byte) (*blobs.Manifest, error) {
+	blob, err := blobs.Open(target, &blobs.Manifest{Type: "blob", ChunkSize: m.ChunkSize, Fanout: m.Fanout})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = blob.IO(ctx).WriteAt(data, 0); err != nil {
+		return nil, err
+	}
+
+	return blob.Save(ctx)
+}
+
+//copyChunks copies the chunks of m from src to dst that are not in done yet
+//and that need is true for, each chunk is verified against its key on both
+//ends. Chunks below a chunk that is done or not needed are skipped. Index
+//chunks are added after the chunks below them, so a chunk in dst means that
+//all chunks below it are there too.
+func copyChunks(ctx context.Context, src *recentStore, dst chunks.Store, m *blobs.Manifest, done map[cas.Key]bool, need func(ref scrub.Ref, size int) (bool, error)) error {
+	type indexChunk struct {
+		ref scrub.Ref
+		buf []byte
+	}
+
+	index := []indexChunk{}
+	if err := scrub.Walk(ctx, src, m, func(ref scrub.Ref, size int, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if done[ref.Key] {
+			return scrub.SkipChunks
+		}
+
+		done[ref.Key] = true
+		if ok, err := need(ref, size); err != nil {
+			return err
+		} else if !ok {
+			return scrub.SkipChunks
+		}
+
+		c, err := src.Get(ctx, ref.Key, ref.Type, ref.Level)
+		if err != nil {
+			return err
+		}
+
+		if ref.Level > 0 {
+			index = append(index, indexChunk{ref: ref, buf: c.Buf})
+			return nil
+		}
+
+		return addChunk(ctx, dst, ref, c.Buf)
+	}); err != nil {
+		return err
+	}
+
+	//index chunks are walked before the chunks below them
+	for i := len(index) - 1; i >= 0; i-- {
+		if err := addChunk(ctx, dst, index[i].ref, index[i].buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//addChunk adds the content of ref to dst and verifies the key it is stored under
+func addChunk(ctx context.Context, dst chunks.Store, ref scrub.Ref, buf []byte) error {
+	key, err := dst.Add(ctx, &chunks.Chunk{Type: ref.Type, Level: ref.Level, Buf: buf})
+	if err != nil {
+		return err
+	}
+
+	if key != ref.Key {
+		return &scrub.CorruptError{Ref: ref}
+	}
+
+	return nil
+}
+
+//exportNode describes node at path p, it returns the children of a directory
+func (self *Memfs) exportNode(tx fdb.Transaction, p string, node *nodes.Node) (e *SnapshotEntry, chldr []fsckChld, errc int) {
+	st := node.Stat(tx)
+	e = &SnapshotEntry{
+		Path:     p,
+		Ino:      st.Ino,
+		Mode:     st.Mode,
+		Size:     st.Size,
+		Rdev:     st.Rdev,
+		Uid:      st.Uid,
+		Gid:      st.Gid,
+		Flags:    st.Flags,
+		Atim:     st.Atim,
+		Mtim:     st.Mtim,
+		Ctim:     st.Ctim,
+		Birthtim: st.Birthtim,
+		Owner:    node.Owner(tx),
+	}
+
+	if errc = node.XAtrEach(tx, func(name string) int {
+		errc, xatr := node.XAtrOpen(tx, self.cstore, name)
+		if 0 != errc {
+			return errc
+		}
+
+		if nil == e.XAttrs {
+			e.XAttrs = map[string][]byte{}
+		}
+
+		e.XAttrs[name] = xatr
+		return 0
+	}); 0 != errc {
+		return nil, nil, errc
+	}
+
+	switch st.Mode & fuse.S_IFMT {
+	case fuse.S_IFDIR:
+		node.ChldEach(tx, func(name string, chld *nodes.Node) bool {
+			chldr = append(chldr, fsckChld{name: name, ino: chld.Ino()})
+			return false
+		})
+	case fuse.S_IFREG:
+		if m := node.Manifest(tx); nil != m {
+			e.Manifest, e.Extents = snapshotManifest(m), node.Extents(tx)
+			if nodes.IsInline(m) {
+				var err error
+				if e.inline, err = node.Inline(tx, m); err != nil {
+					return nil, nil, -fuse.EIO
+				}
+			}
+		}
+	case fuse.S_IFLNK:
+		buff := make([]byte, self.maxPathLength)
+		n := node.ReadAt(tx, self.cstore, buff, 0)
+		e.Target = string(buff[:n])
+	}
+
+	return e, chldr, 0
+}
+
+//exportNodes describes every node that is reachable from dir, breadth first
+//such that directories come before their content
+func (self *Memfs) exportNodes(dir string) (errc int, es []*SnapshotEntry) {
+	type queued struct {
+		path string
+		node *nodes.Node
+	}
+
+	var queue []queued
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, dir, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+
+		queue = []queued{{path: path.Join("/", dir), node: node}}
+		return 0
+	}); 0 != errc {
+		return errc, nil
+	}
+
+	for len(queue) > 0 {
+		n := backupBatch
+		if len(queue) < n {
+			n = len(queue)
+		}
+
+		var batch []*SnapshotEntry
+		var next []queued
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			batch, next = nil, nil
+			for _, q := range queue[:n] {
+				e, chldr, errc := self.exportNode(tx, q.path, q.node)
+				if 0 != errc {
+					return errc
+				}
+
+				batch = append(batch, e)
+				for _, c := range chldr {
+					next = append(next, queued{path: path.Join(q.path, c.name), node: self.nstore.Node(c.ino)})
+				}
+			}
+
+			return 0
+		}); 0 != errc {
+			return errc, nil
+		}
+
+		es = append(es, batch...)
+		queue = append(queue[n:], next...)
+	}
+
+	return 0, es
+}
+
+//Backup copies the metadata of every node and the chunks of every file to
+//target as a new snapshot of volume. Files whose content was part of the
+//previous snapshot are skipped and of the other files only chunks that
+//target doesn't have yet are copied. Versions, the trash and writes that were
+//not flushed are not backed up. It fails with EEXIST if another backup of
+//the volume stored the snapshot first.
+func (self *Memfs) Backup(target BackupTarget, volume string) (errc int, rep *BackupReport) {
+	defer trace(volume)(&errc, &rep)
+	ctx := context.Background()
+	last, err := LatestSnapshot(ctx, target, volume)
+	if err != nil {
+		return -fuse.EIO, nil
+	}
+
+	//all chunks below the roots of a previous snapshot are in the target
+	done := map[cas.Key]bool{}
+	if last > 0 {
+		prev, err := ReadSnapshot(ctx, target, volume, last)
+		if err != nil {
+			return -fuse.EIO, nil
+		}
+
+		for _, e := range prev.Entries {
+			if nil != e.Manifest {
+				done[cas.NewKey(e.Manifest.Root)] = true
+			}
+		}
+	}
+
+	snap := &Snapshot{Seq: last + 1, Created: time.Now()}
+	if errc, snap.Entries = self.exportNodes("/"); 0 != errc {
+		return errc, nil
+	}
+
+	rep = &BackupReport{Snapshot: snap.Seq, Nodes: len(snap.Entries)}
+	src, inos, inline := &recentStore{Store: self.cstore}, map[uint64]bool{}, map[uint64]*SnapshotManifest{}
+	for _, e := range snap.Entries {
+		if nil == e.Manifest || inos[e.Ino] {
+			continue
+		}
+
+		inos[e.Ino] = true
+		rep.Files++
+		m := e.Manifest.blob()
+		if nodes.IsInline(m) {
+			if m, err = backupInline(ctx, target, m, e.inline); err != nil {
+				return -fuse.EIO, nil
+			}
+
+			inline[e.Ino] = snapshotManifest(m)
+			if !done[m.Root] {
+				done[m.Root] = true
+				rep.Chunks++
+				rep.Bytes += int64(m.Size)
+			}
+
+			continue
+		}
+
+		if done[m.Root] {
+			continue
+		}
+
+		if err := copyChunks(ctx, src, target, m, done, func(ref scrub.Ref, size int) (bool, error) {
+			if has, err := target.Has(ctx, ref.Key); err != nil || has {
+				if has {
+					rep.Skipped++
+				}
+
+				return false, err
+			}
+
+			rep.Chunks++
+			rep.Bytes += int64(size)
+			return true, nil
+		}); err != nil {
+			return -fuse.EIO, nil
+		}
+	}
+
+	for _, e := range snap.Entries {
+		if m, ok := inline[e.Ino]; ok {
+			e.Manifest = m
+		}
+	}
+
+	d, err := json.Marshal(snap)
+	if err != nil {
+		return -fuse.EIO, nil
+	}
+
+	//some targets overwrite silently, so a snapshot is never written twice
+	if has, err := target.HasObject(ctx, snapshotName(volume, snap.Seq)); err != nil {
+		return -fuse.EIO, nil
+	} else if has {
+		return -fuse.EEXIST, nil
+	}
+
+	if err = target.PutObject(ctx, snapshotName(volume, snap.Seq), d); err != nil {
+		return -fuse.EIO, nil
+	}
+
+	return 0, rep
+}
+
+//Restore rebuilds snapshot seq of volume in target in this filesystem, which
+//must be empty. Every chunk is verified against its key before it is stored,
+//a chunk that doesn't match fails the restore with EIO.
+func (self *Memfs) Restore(target BackupTarget, volume string, seq uint64) (errc int, rep *RestoreReport) {
+	defer trace(volume, seq)(&errc, &rep)
+	ctx := context.Background()
+	snap, err := ReadSnapshot(ctx, target, volume, seq)
+	if err != nil {
+		if _, ok := err.(kv.NotFoundError); ok {
+			return -fuse.ENOENT, nil
+		}
+
+		return -fuse.EIO, nil
+	}
+
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		if 0 < self.nstore.Root(tx).CountChld(tx) {
+			return -fuse.ENOTEMPTY
+		}
+
+		return 0
+	}); 0 != errc {
+		return errc, nil
+	}
+
+	rep = &RestoreReport{Snapshot: seq, Nodes: len(snap.Entries)}
+	src, done, first := &recentStore{Store: target}, map[cas.Key]bool{}, map[uint64]string{}
+	for _, e := range snap.Entries {
+		if _, ok := first[e.Ino]; ok {
+			continue
+		}
+
+		first[e.Ino] = e.Path
+		if nil == e.Manifest {
+			continue
+		}
+
+		rep.Files++
+		if err := copyChunks(ctx, src, self.cstore, e.Manifest.blob(), done, func(ref scrub.Ref, size int) (bool, error) {
+			rep.Chunks++
+			rep.Bytes += int64(size)
+			return true, nil
+		}); err != nil {
+			return -fuse.EIO, nil
+		}
+	}
+
+	if errc = self.restoreEntries(snap.Entries, func(tx fdb.Transaction, e *SnapshotEntry) int {
+		return self.restoreNode(tx, "restore", e, first[e.Ino])
+	}, nil); 0 != errc {
+		return errc, nil
+	}
+
+	return 0, rep
+}
+
+//restoreEntries creates the nodes of es in batches with create, progress is
+//called in the transaction of every batch when it isn't nil. Attributes are
+//set once all nodes exist, creating the content of a directory would change
+//its times otherwise.
+func (self *Memfs) restoreEntries(es []*SnapshotEntry, create func(tx fdb.Transaction, e *SnapshotEntry) int, progress func(tx fdb.Transaction, n int)) (errc int) {
+	attrs := func(tx fdb.Transaction, e *SnapshotEntry) int {
+		return self.restoreAttrs(tx, e, true)
+	}
+
+	for pass, f := range []func(tx fdb.Transaction, e *SnapshotEntry) int{create, attrs} {
+		for i := 0; i < len(es); i += backupBatch {
+			batch := es[i:]
+			if len(batch) > backupBatch {
+				batch = batch[:backupBatch]
+			}
+
+			if 1 == pass {
+				if errc = self.writeXattrs(batch); 0 != errc {
+					return errc
+				}
+			}
+
+			if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+				for _, e := range batch {
+					if errc = f(tx, e); 0 != errc {
+						return errc
+					}
+				}
+
+				if nil != progress && 0 == pass {
+					progress(tx, len(batch))
+				}
+
+				return 0
+			}); 0 != errc {
+				return errc
+			}
+		}
+	}
+
+	return 0
+}
+
+//restoreNode creates the node of e, or links it when it was created at the
+//first path of its inode already. The creation is recorded as op.
+func (self *Memfs) restoreNode(tx fdb.Transaction, op string, e *SnapshotEntry, first string) int {
+	if "/" == e.Path {
+		return 0
+	}
+
+	if first != e.Path {
+		fprnt, fname, node := self.lookupNode(tx, first, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+		prnt, name, existing := self.lookupNode(tx, e.Path, nil)
+		if nil == prnt {
+			return -fuse.ENOENT
+		}
+		if nil != existing {
+			return -fuse.EEXIST
+		}
+		if readOnly(e.Path) {
+			return -fuse.EROFS
+		}
+		if self.leased(tx, e.Path) {
+			return -fuse.EACCES
+		}
+
+		recordLink(tx, fprnt, fname, node)
+		node.StatIncNlink(tx)
+		prnt.SetChld(tx, name, node)
+		self.linked(tx, e.Path, prnt, name, node)
+		return 0
+	}
+
+	var data []byte
+	if fuse.S_IFLNK == e.Mode&fuse.S_IFMT {
+		data = []byte(e.Target)
+	}
+
+	if errc := self.As(e.Owner).makeNode(tx, op, e.Path, e.Mode, e.Rdev, data); 0 != errc {
+		return errc
+	}
+
+	if nil != e.Manifest {
+		_, _, node := self.lookupNode(tx, e.Path, nil)
+		if errc := self.resize(tx, e.Path, node, int64(e.Manifest.Size)); 0 != errc {
+			return errc
+		}
+
+		node.SetManifest(tx, e.Manifest.blob(), e.Extents)
+	}
+
+	return 0
+}
+
+//restoreAttrs sets the attributes and extended attributes of e on its node,
+//the extended attributes of an untrusted e may not be in the trusted
+//namespace
+func (self *Memfs) restoreAttrs(tx fdb.Transaction, e *SnapshotEntry, trusted bool) int {
+	_, _, node := self.lookupNode(tx, e.Path, nil)
+	if nil == node {
+		return -fuse.ENOENT
+	}
+
+	node.StatSetMode(tx, e.Mode)
+	node.StatSetUid(tx, e.Uid)
+	node.StatSetGid(tx, e.Gid)
+	node.StatSetFlags(tx, e.Flags)
+	node.StatSetATim(tx, e.Atim)
+	node.StatSetMTim(tx, e.Mtim)
+	node.StatSetCTim(tx, e.Ctim)
+	node.StatSetBirthTim(tx, e.Birthtim)
+	for name, xatr := range e.XAttrs {
+		if !trusted && strings.HasPrefix(name, xattrTrusted) {
+			return -fuse.EPERM
+		}
+
+		if errc := self.restoreXattr(tx, e.Path, node, name, xatr, e.xblobs[name]); 0 != errc {
+			return errc
+		}
+	}
+
+	return 0
+}
+
+//restoreXattr sets the attribute name of node at path with the checks of
+//SetxattrAt, the attributes that are interpreted by the filesystem itself
+//can't be restored. Large values are set to m as written by writeXattrs.
+func (self *Memfs) restoreXattr(tx fdb.Transaction, path string, node *nodes.Node, name string, value []byte, m *blobs.Manifest) int {
+	if self.leased(tx, path) {
+		return -fuse.EACCES
+	}
+	if LeaseXAttr == name || DirSizeXAttr == name || RestoreXAttr == name {
+		return -fuse.EPERM
+	}
+	if errc := self.xattrAccess(tx, node, name, true); 0 != errc {
+		return errc
+	}
+	if len(value) > XAttrSizeMax {
+		return -fuse.E2BIG
+	}
+
+	if nil != m {
+		if errc := xattrCheck(tx, node, name, value); 0 != errc {
+			return errc
+		}
+
+		node.XAtrSetBlob(tx, name, m)
+		return 0
+	}
+
+	errc, xatr := self.xattrValue(tx, node, name, value, 0)
+	if 0 != errc {
+		return errc
+	}
+
+	if nil == xatr {
+		node.XAtrDel(tx, name)
+		return 0
+	}
+
+	return node.XAtrSet(tx, name, xatr)
+}
+
+//writeXattrs writes the xattr values of es that are too large to be stored
+//with the metadata to the chunk store, it is called before the transaction
+//that restores their attributes such that no chunks are written in it
+func (self *Memfs) writeXattrs(es []*SnapshotEntry) int {
+	for _, e := range es {
+		for name, xatr := range e.XAttrs {
+			if len(xatr) <= nodes.InlineLimit || len(xatr) > XAttrSizeMax || nil != e.xblobs[name] {
+				continue
+			}
+
+			m, err := nodes.WriteXAtr(context.Background(), self.cstore, xatr)
+			if err != nil {
+				return -fuse.EIO
+			}
+
+			if nil == e.xblobs {
+				e.xblobs = map[string]*blobs.Manifest{}
+			}
+
+			e.xblobs[name] = m
+		}
+	}
+
+	return 0
+}
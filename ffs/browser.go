@@ -1,11 +1,16 @@
 package ffs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/advanderveer/dfs/ffs/events"
+	"github.com/advanderveer/dfs/ffs/nodes"
 	"github.com/billziss-gh/cgofuse/fuse"
 )
 
@@ -32,6 +37,11 @@ func NewBrowser(fs *Memfs) (b *Browser) {
 	return
 }
 
+//As returns a browser that changes the filesystem as the provided actor
+func (b *Browser) As(actor string) *Browser {
+	return &Browser{fs: b.fs.As(actor)}
+}
+
 func (b *Browser) Readfile(path string, w io.Writer) (err error) {
 	errc, fh := b.fs.Open(path, fuse.O_RDONLY)
 	if errc != 0 {
@@ -43,6 +53,10 @@ func (b *Browser) Readfile(path string, w io.Writer) (err error) {
 	ofst := int64(0)
 	for {
 		n := b.fs.Read("", buff, ofst, fh)
+		if n < 0 {
+			return fmt.Errorf("failed to read: %d", n)
+		}
+
 		w.Write(buff[:n])
 		if n < len(buff) {
 			break
@@ -78,3 +92,160 @@ func (b *Browser) Readdir(path string, f func(name string, fi os.FileInfo)) (err
 
 	return
 }
+
+//ErrLeaseHeld is returned when a directory is already leased by someone else
+var ErrLeaseHeld = errors.New("directory is leased by another holder")
+
+//ErrNoLease is returned when the holder has no lease on a directory
+var ErrNoLease = errors.New("no lease held on directory")
+
+func leaseErr(op string, errc int) error {
+	switch errc {
+	case 0:
+		return nil
+	case -fuse.EBUSY:
+		return ErrLeaseHeld
+	case -fuse.ENOLCK:
+		return ErrNoLease
+	default:
+		return fmt.Errorf("failed to %s lease: %d", op, errc)
+	}
+}
+
+func (b *Browser) AcquireLease(path, holder, reason string, ttl time.Duration) (err error) {
+	return leaseErr("acquire", b.fs.AcquireLease(path, holder, reason, ttl))
+}
+
+func (b *Browser) RenewLease(path, holder string, ttl time.Duration) (err error) {
+	return leaseErr("renew", b.fs.RenewLease(path, holder, ttl))
+}
+
+func (b *Browser) ReleaseLease(path, holder string) (err error) {
+	return leaseErr("release", b.fs.ReleaseLease(path, holder))
+}
+
+func (b *Browser) Leases(f func(path string, l *nodes.Lease)) (err error) {
+	return leaseErr("list", b.fs.Leases(func(path string, l *nodes.Lease) bool {
+		f(path, l)
+		return true
+	}))
+}
+
+//Changes returns up to limit change events after cursor, if there are none
+//yet it waits for at most wait for new changes to happen.
+func (b *Browser) Changes(cursor string, limit int, wait time.Duration) (evs []*events.Event, err error) {
+	if wait <= 0 {
+		return b.fs.estore.Read(cursor, limit)
+	}
+
+	return b.fs.estore.Next(cursor, limit, wait)
+}
+
+func (b *Browser) Versions(path string) (vers []*nodes.Version, err error) {
+	errc, vers := b.fs.Versions(path)
+	if errc != 0 {
+		return nil, fmt.Errorf("failed to list versions: %d", errc)
+	}
+
+	return vers, nil
+}
+
+//ReadVersion writes the content of the version with the provided id to w
+func (b *Browser) ReadVersion(path string, id uint64, w io.Writer) (err error) {
+	return b.Readfile(filepath.Join("/", VersionsDir, path, strconv.FormatUint(id, 10)), w)
+}
+
+func (b *Browser) RestoreVersion(path string, id uint64) (err error) {
+	errc := b.fs.Setxattr(path, RestoreXAttr, []byte(strconv.FormatUint(id, 10)), 0)
+	if errc != 0 {
+		return fmt.Errorf("failed to restore version: %d", errc)
+	}
+
+	return nil
+}
+
+func (b *Browser) Trash(f func(e *nodes.TrashEntry)) (err error) {
+	if errc := b.fs.Trash(func(e *nodes.TrashEntry) bool {
+		f(e)
+		return true
+	}); errc != 0 {
+		return fmt.Errorf("failed to list trash: %d", errc)
+	}
+
+	return nil
+}
+
+func (b *Browser) RestoreTrash(id uint64) (err error) {
+	if errc := b.fs.RestoreTrash(id); errc != 0 {
+		return fmt.Errorf("failed to restore from trash: %d", errc)
+	}
+
+	return nil
+}
+
+func (b *Browser) PurgeTrash(id uint64) (err error) {
+	if errc := b.fs.PurgeTrash(id); errc != 0 {
+		return fmt.Errorf("failed to purge from trash: %d", errc)
+	}
+
+	return nil
+}
+
+//DirSize returns the aggregate size, file and directory count below path
+func (b *Browser) DirSize(path string) (ds nodes.DirSize, err error) {
+	errc, ds := b.fs.DirSize(path)
+	if errc != 0 {
+		return ds, fmt.Errorf("failed to get directory size: %d", errc)
+	}
+
+	return ds, nil
+}
+
+//Clone creates dst as a copy of src that shares the content of its files
+func (b *Browser) Clone(src, dst string) (err error) {
+	if errc := b.fs.Clone(src, dst); errc != 0 {
+		return fmt.Errorf("failed to clone: %d", errc)
+	}
+
+	return nil
+}
+
+//Bulk starts removing, copying or moving the tree at src in the background,
+//it returns the id of the job that reports its progress
+func (b *Browser) Bulk(op, src, dst string) (id uint64, err error) {
+	errc, id := b.fs.Bulk(op, src, dst)
+	if errc != 0 {
+		return 0, fmt.Errorf("failed to start %s of '%s': %d", op, src, errc)
+	}
+
+	return id, nil
+}
+
+//BulkStatus returns the progress of the bulk job with id
+func (b *Browser) BulkStatus(id uint64) (job nodes.BulkJob, err error) {
+	errc, job := b.fs.BulkStatus(id)
+	if errc != 0 {
+		return job, fmt.Errorf("failed to get bulk job %d: %d", id, errc)
+	}
+
+	return job, nil
+}
+
+//BulkJobs returns all bulk jobs in the order they were started
+func (b *Browser) BulkJobs() (jobs []nodes.BulkJob, err error) {
+	errc, jobs := b.fs.BulkJobs()
+	if errc != 0 {
+		return nil, fmt.Errorf("failed to list bulk jobs: %d", errc)
+	}
+
+	return jobs, nil
+}
+
+//ResumeBulk starts the bulk job with id again if it didn't complete
+func (b *Browser) ResumeBulk(id uint64) (err error) {
+	if errc := b.fs.ResumeBulk(id); errc != 0 {
+		return fmt.Errorf("failed to resume bulk job %d: %d", id, errc)
+	}
+
+	return nil
+}
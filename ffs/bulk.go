@@ -0,0 +1,326 @@
+package ffs
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//Recursive operations that are executed on the server as a bulk job
+const (
+	BulkRemove = "rm"
+	BulkCopy   = "cp"
+	BulkMove   = "mv"
+)
+
+//bulkBatch is the nr of nodes a bulk job handles per transaction
+const bulkBatch = 500
+
+//bulkRun identifies a bulk job of a store
+type bulkRun struct {
+	store *nodes.Store
+	id    uint64
+}
+
+//bulkRunning holds the jobs that run in this process, a job is never run
+//twice at the same time
+var bulkRunning = struct {
+	sync.Mutex
+	m map[bulkRun]bool
+}{m: map[bulkRun]bool{}}
+
+//claimBulk marks the job with id as running, it returns false if it runs
+//already
+func (self *Memfs) claimBulk(id uint64) bool {
+	bulkRunning.Lock()
+	defer bulkRunning.Unlock()
+	r := bulkRun{store: self.nstore, id: id}
+	if bulkRunning.m[r] {
+		return false
+	}
+
+	bulkRunning.m[r] = true
+	return true
+}
+
+func (self *Memfs) releaseBulk(id uint64) {
+	bulkRunning.Lock()
+	defer bulkRunning.Unlock()
+	delete(bulkRunning.m, bulkRun{store: self.nstore, id: id})
+}
+
+//Bulk starts a job that recursively removes src, copies it to dst or moves
+//it to dst. Nodes are handled in batches of one transaction each and the
+//progress of the job is recorded with every batch. It returns the id of the
+//job to follow its progress with, the job keeps running in the background.
+//Copies share the content of files like clones do.
+func (self *Memfs) Bulk(op string, src string, dst string) (errc int, id uint64) {
+	defer trace(op, src, dst)(&errc, &id)
+	src = path.Join("/", src)
+	switch op {
+	case BulkRemove:
+		if "/" == src {
+			return -fuse.EINVAL, 0
+		}
+
+		dst = ""
+	case BulkCopy:
+		dst = path.Join("/", dst)
+		if errc = self.cloneValid(src, dst); 0 != errc {
+			return errc, 0
+		}
+	case BulkMove:
+		if "" == dst {
+			return -fuse.EINVAL, 0
+		}
+
+		dst = path.Join("/", dst)
+	default:
+		return -fuse.EINVAL, 0
+	}
+
+	now := time.Now()
+	job := &nodes.BulkJob{Op: op, Src: src, Dst: dst, Actor: self.actor, Started: now, Updated: now}
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, src, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+
+		ds := subtree(tx, node)
+		job.ID, job.Total = 0, ds.Files+ds.Dirs
+		self.nstore.PutBulk(tx, job)
+		return 0
+	}); 0 != errc {
+		return errc, 0
+	}
+
+	self.claimBulk(job.ID)
+	go self.runBulk(job.ID)
+	return 0, job.ID
+}
+
+//ResumeBulk starts a job again that was interrupted or that failed, for
+//example because the server went away. It fails with EBUSY if the job is
+//still running in this process.
+func (self *Memfs) ResumeBulk(id uint64) (errc int) {
+	defer trace(id)(&errc)
+	if !self.claimBulk(id) {
+		return -fuse.EBUSY
+	}
+
+	resume := false
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		job := self.nstore.GetBulk(tx, id)
+		if nil == job {
+			return -fuse.ENOENT
+		}
+
+		if resume = !job.Finished || 0 != job.Errc; resume {
+			job.Finished, job.Errc, job.Updated = false, 0, time.Now()
+			self.nstore.PutBulk(tx, job)
+		}
+
+		return 0
+	}); 0 != errc || !resume {
+		self.releaseBulk(id)
+		return errc
+	}
+
+	go self.runBulk(id)
+	return 0
+}
+
+//BulkStatus returns the progress of the job with id
+func (self *Memfs) BulkStatus(id uint64) (errc int, job nodes.BulkJob) {
+	defer trace(id)(&errc, &job)
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		j := self.nstore.GetBulk(tx, id)
+		if nil == j {
+			return -fuse.ENOENT
+		}
+
+		job = *j
+		return 0
+	})
+
+	return errc, job
+}
+
+//BulkJobs returns every bulk job in the order they were started
+func (self *Memfs) BulkJobs() (errc int, jobs []nodes.BulkJob) {
+	defer trace()(&errc, &jobs)
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		jobs = nil
+		self.nstore.EachBulk(tx, func(job *nodes.BulkJob) bool {
+			jobs = append(jobs, *job)
+			return false
+		})
+
+		return 0
+	})
+
+	return errc, jobs
+}
+
+//runBulk executes the job with id as the actor that started it and records
+//its outcome
+func (self *Memfs) runBulk(id uint64) {
+	defer self.releaseBulk(id)
+	errc, job := self.BulkStatus(id)
+	if 0 != errc {
+		return
+	}
+
+	as := self.As(job.Actor)
+	switch job.Op {
+	case BulkRemove:
+		errc = as.bulkRemove(id, job.Src)
+	case BulkCopy:
+		errc = as.bulkCopy(id, job.Src, job.Dst)
+	case BulkMove:
+		errc = as.bulkMove(id, job.Src, job.Dst)
+	default:
+		errc = -fuse.EINVAL
+	}
+
+	self.nstore.TxWithErrc(func(tx fdb.Transaction) int {
+		if j := self.nstore.GetBulk(tx, id); nil != j {
+			j.Finished, j.Errc, j.Updated = true, errc, time.Now()
+			self.nstore.PutBulk(tx, j)
+		}
+
+		return 0
+	})
+}
+
+//bulkProgress records that n more nodes of the job with id were handled
+func (self *Memfs) bulkProgress(tx fdb.Transaction, id uint64, n int) {
+	if j := self.nstore.GetBulk(tx, id); nil != j {
+		j.Done, j.Updated = j.Done+int64(n), time.Now()
+		self.nstore.PutBulk(tx, j)
+	}
+}
+
+//bulkRemove removes the tree at src bottom up until it is gone, it is
+//resumed by removing what is left
+func (self *Memfs) bulkRemove(id uint64, src string) (errc int) {
+	for done := false; !done; {
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			_, _, node := self.lookupNode(tx, src, nil)
+			if done = nil == node; done {
+				return 0
+			}
+
+			budget := bulkBatch
+			if errc = self.removeTree(tx, src, node, &budget); 0 != errc {
+				return errc
+			}
+
+			self.bulkProgress(tx, id, bulkBatch-budget)
+			return 0
+		}); 0 != errc {
+			return errc
+		}
+	}
+
+	return 0
+}
+
+//removeTree removes node at p after its content, it stops when budget
+//nodes were removed
+func (self *Memfs) removeTree(tx fdb.Transaction, p string, node *nodes.Node, budget *int) (errc int) {
+	dir := fuse.S_IFDIR == node.Stat(tx).Mode&fuse.S_IFMT
+	if dir {
+		var names []string
+		node.ChldEach(tx, func(name string, chld *nodes.Node) bool {
+			names = append(names, name)
+			return len(names) >= *budget
+		})
+
+		for _, name := range names {
+			if 0 == *budget {
+				return 0
+			}
+
+			if errc = self.removeTree(tx, path.Join(p, name), node.GetChld(tx, name), budget); 0 != errc {
+				return errc
+			}
+		}
+
+		if 0 == *budget || 0 < node.CountChld(tx) {
+			return 0
+		}
+	}
+
+	op := "unlink"
+	if dir {
+		op = "rmdir"
+	}
+
+	if errc = self.removeNode(tx, op, p, dir); 0 != errc {
+		return errc
+	}
+
+	*budget--
+	return 0
+}
+
+//bulkCopy clones the tree at src to dst, it is resumed by skipping the
+//nodes that exist already
+func (self *Memfs) bulkCopy(id uint64, src, dst string) (errc int) {
+	errc, es, first := self.cloneEntries(src, dst)
+	if 0 != errc {
+		return errc
+	}
+
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		if j := self.nstore.GetBulk(tx, id); nil != j {
+			j.Total, j.Done = int64(len(es)), 0
+			self.nstore.PutBulk(tx, j)
+		}
+
+		return 0
+	}); 0 != errc {
+		return errc
+	}
+
+	return self.restoreEntries(es, func(tx fdb.Transaction, e *SnapshotEntry) int {
+		if _, _, node := self.lookupNode(tx, e.Path, nil); nil != node {
+			return 0 //created before the job was interrupted
+		}
+
+		return self.restoreNode(tx, "copy", e, first[e.Ino])
+	}, func(tx fdb.Transaction, n int) {
+		self.bulkProgress(tx, id, n)
+	})
+}
+
+//bulkMove renames src to dst, directories are moved with a single entry so
+//it takes one transaction
+func (self *Memfs) bulkMove(id uint64, src, dst string) (errc int) {
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, src, nil)
+		if nil == node {
+			if _, _, moved := self.lookupNode(tx, dst, nil); nil != moved {
+				return 0 //moved before the job was interrupted
+			}
+
+			return -fuse.ENOENT
+		}
+
+		if errc = self.renameNode(tx, src, dst); 0 != errc {
+			return errc
+		}
+
+		if j := self.nstore.GetBulk(tx, id); nil != j {
+			self.bulkProgress(tx, id, int(j.Total-j.Done))
+		}
+
+		return 0
+	})
+}
@@ -0,0 +1,485 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+)
+
+//Type is the manifest type of blobs that are chunked by content, the chunk
+//size of their manifest is the average chunk size
+const Type = "cdc"
+
+//IndexType is the chunk type of the index that lists the chunks of a blob
+const IndexType = "cdc-index"
+
+//ErrCorruptIndex is returned when the index of a blob can't be decoded
+var ErrCorruptIndex = errors.New("corrupt content-defined chunk index")
+
+//extent is a range of the blob's content. A clean extent is a stored chunk
+//that is read when it is needed, a dirty extent holds modified content in
+//memory or, without data, a range of zeros.
+type extent struct {
+	key   cas.Key
+	ofst  int64
+	size  int64
+	dirty bool
+	data  []byte
+}
+
+//Blob is content that is stored as content-defined chunks. Reads are served
+//from the chunks, modifications only load the chunks they touch and saving
+//re-chunks the modified ranges together with the chunks around them.
+type Blob struct {
+	store chunks.Store
+	avg   uint32
+	size  int64
+	exts  []extent
+
+	//last read chunk
+	ckey cas.Key
+	cbuf []byte
+}
+
+//Open the blob described by manifest m
+func Open(store chunks.Store, m *blobs.Manifest) (b *Blob, err error) {
+	if m.Type != Type {
+		return nil, fmt.Errorf("unexpected manifest type '%s'", m.Type)
+	}
+
+	b = &Blob{store: store, avg: AvgSize(m.ChunkSize), size: int64(m.Size)}
+	if b.size == 0 {
+		return b, nil
+	}
+
+	c, err := store.Get(context.Background(), m.Root, IndexType, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.exts, err = decodeIndex(c.Buf); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+//Keys returns the keys of the chunks the manifest refers to, including the
+//index chunk itself
+func Keys(store chunks.Store, m *blobs.Manifest) (keys []cas.Key, err error) {
+	if m.Size == 0 {
+		return nil, nil
+	}
+
+	b, err := Open(store, m)
+	if err != nil {
+		return nil, err
+	}
+
+	keys = append(keys, m.Root)
+	for _, ext := range b.exts {
+		keys = append(keys, ext.key)
+	}
+
+	return keys, nil
+}
+
+func decodeIndex(d []byte) (exts []extent, err error) {
+	ofst := int64(0)
+	for len(d) > 0 {
+		if len(d) < cas.KeySize {
+			return nil, ErrCorruptIndex
+		}
+
+		key := cas.NewKey(d[:cas.KeySize])
+		size, n := binary.Uvarint(d[cas.KeySize:])
+		if n <= 0 {
+			return nil, ErrCorruptIndex
+		}
+
+		exts = append(exts, extent{key: key, ofst: ofst, size: int64(size)})
+		ofst += int64(size)
+		d = d[cas.KeySize+n:]
+	}
+
+	return exts, nil
+}
+
+func encodeIndex(exts []extent) []byte {
+	buf := bytes.NewBuffer(nil)
+	vbuf := make([]byte, binary.MaxVarintLen64)
+	for _, ext := range exts {
+		buf.Write(ext.key.Bytes())
+		buf.Write(vbuf[:binary.PutUvarint(vbuf, uint64(ext.size))])
+	}
+
+	return buf.Bytes()
+}
+
+//chunk returns the content of clean extent ext
+func (b *Blob) chunk(ctx context.Context, ext extent) ([]byte, error) {
+	if b.cbuf != nil && b.ckey == ext.key {
+		return b.cbuf, nil
+	}
+
+	c, err := b.store.Get(ctx, ext.key, "blob", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := c.Buf
+	if int64(len(buf)) > ext.size {
+		return nil, fmt.Errorf("chunk %s has %d bytes, expected %d", ext.key, len(buf), ext.size)
+	} else if int64(len(buf)) < ext.size {
+		//stores may trim trailing zeros
+		buf = append(buf, make([]byte, ext.size-int64(len(buf)))...)
+	}
+
+	b.ckey, b.cbuf = ext.key, buf
+	return buf, nil
+}
+
+//Size returns the size of the content
+func (b *Blob) Size() uint64 {
+	return uint64(b.size)
+}
+
+//find returns the index of the extent that holds ofst
+func (b *Blob) find(ofst int64) int {
+	return sort.Search(len(b.exts), func(i int) bool { return b.exts[i].ofst+b.exts[i].size > ofst })
+}
+
+//ReadAt reads len(p) bytes at ofst, it returns io.EOF when it reads beyond
+//the end of the content
+func (b *Blob) ReadAt(ctx context.Context, p []byte, ofst int64) (n int, err error) {
+	if ofst >= b.size {
+		return 0, io.EOF
+	}
+
+	for i := b.find(ofst); i < len(b.exts) && n < len(p); i++ {
+		ext, at := b.exts[i], ofst+int64(n)-b.exts[i].ofst
+		switch {
+		case !ext.dirty:
+			buf, err := b.chunk(ctx, ext)
+			if err != nil {
+				return n, err
+			}
+
+			n += copy(p[n:], buf[at:])
+		case nil != ext.data:
+			n += copy(p[n:], ext.data[at:])
+		default:
+			l := ext.size - at
+			if l > int64(len(p)-n) {
+				l = int64(len(p) - n)
+			}
+
+			zero(p[n : n+int(l)])
+			n += int(l)
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}
+
+//load turns the extents that overlap [start, end) into a single dirty extent
+//with its content in memory, ranges of zeros beyond it are kept as they are
+func (b *Blob) load(ctx context.Context, start, end int64) (*extent, error) {
+	i := b.find(start)
+	if i < len(b.exts) && b.exts[i].dirty && nil != b.exts[i].data && b.exts[i].ofst+b.exts[i].size >= end {
+		return &b.exts[i], nil //already loaded
+	}
+
+	b.split(start)
+	b.split(end)
+	i, j := b.find(start), b.find(end)
+	if j < len(b.exts) && b.exts[j].ofst < end {
+		j++
+	}
+
+	ext := extent{ofst: start, dirty: true}
+	if i < len(b.exts) {
+		ext.ofst = b.exts[i].ofst
+	}
+
+	for _, other := range b.exts[i:j] {
+		switch {
+		case !other.dirty:
+			buf, err := b.chunk(ctx, other)
+			if err != nil {
+				return nil, err
+			}
+
+			ext.data = append(ext.data, buf...)
+		case nil != other.data:
+			ext.data = append(ext.data, other.data...)
+		default:
+			ext.data = append(ext.data, make([]byte, other.size)...)
+		}
+	}
+
+	if nil == ext.data {
+		ext.data = []byte{}
+	}
+
+	ext.size = int64(len(ext.data))
+	b.exts = append(b.exts[:i], append([]extent{ext}, b.exts[j:]...)...)
+	return &b.exts[i], nil
+}
+
+//split makes sure an extent starts at ofst if it lies in a range of zeros,
+//stored chunks and loaded content are never split
+func (b *Blob) split(ofst int64) {
+	i := b.find(ofst)
+	if i >= len(b.exts) || b.exts[i].ofst == ofst {
+		return
+	}
+
+	ext := b.exts[i]
+	if !ext.dirty || nil != ext.data {
+		return
+	}
+
+	before, after := ext, ext
+	before.size = ofst - ext.ofst
+	after.ofst, after.size = ofst, ext.size-before.size
+	b.exts = append(b.exts[:i], append([]extent{before, after}, b.exts[i+1:]...)...)
+}
+
+//WriteAt writes p at ofst, growing the content when necessary
+func (b *Blob) WriteAt(ctx context.Context, p []byte, ofst int64) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	end := ofst + int64(len(p))
+	if end > b.size {
+		b.resize(end)
+	}
+
+	ext, err := b.load(ctx, ofst, end)
+	if err != nil {
+		return 0, err
+	}
+
+	return copy(ext.data[ofst-ext.ofst:], p), nil
+}
+
+//Zero replaces [start, end) with a range of zeros. Only the chunks that lie
+//partly outside of the range are loaded, the others are dropped.
+func (b *Blob) Zero(ctx context.Context, start, end int64) error {
+	if end > b.size {
+		end = b.size
+	}
+	if start >= end {
+		return nil
+	}
+
+	//stored chunks can't be cut, the ones on the edges are loaded first
+	for _, ofst := range []int64{start, end} {
+		i := b.find(ofst)
+		if i < len(b.exts) && !b.exts[i].dirty && b.exts[i].ofst < ofst {
+			if _, err := b.load(ctx, ofst, ofst+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	var before, after []extent
+	for _, ext := range b.exts {
+		lo, hi := ext.ofst, ext.ofst+ext.size
+		if lo < start {
+			cut := ext
+			if hi > start {
+				cut.size = start - lo
+				if nil != cut.data {
+					cut.data = cut.data[:cut.size:cut.size]
+				}
+			}
+
+			before = append(before, cut)
+		}
+
+		if hi > end {
+			cut := ext
+			if lo < end {
+				cut.ofst, cut.size = end, hi-end
+				if nil != cut.data {
+					cut.data = cut.data[end-lo:]
+				}
+			}
+
+			after = append(after, cut)
+		}
+	}
+
+	b.exts = append(append(before, extent{ofst: start, size: end - start, dirty: true}), after...)
+	return nil
+}
+
+//Truncate changes the size of the content, it grows with zeros
+func (b *Blob) Truncate(ctx context.Context, size uint64) error {
+	if int64(size) < b.size && size > 0 {
+		//the last extent is cut, so it can no longer be a stored chunk
+		if _, err := b.load(ctx, int64(size)-1, int64(size)); err != nil {
+			return err
+		}
+	}
+
+	b.resize(int64(size))
+	return nil
+}
+
+func (b *Blob) resize(size int64) {
+	if size > b.size {
+		if n := len(b.exts); n > 0 && b.exts[n-1].dirty && nil == b.exts[n-1].data {
+			b.exts[n-1].size += size - b.size
+		} else {
+			b.exts = append(b.exts, extent{ofst: b.size, size: size - b.size, dirty: true})
+		}
+
+		b.size = size
+		return
+	}
+
+	i := b.find(size)
+	if i < len(b.exts) && b.exts[i].ofst < size {
+		ext := &b.exts[i]
+		ext.size = size - ext.ofst
+		if nil != ext.data {
+			ext.data = ext.data[:ext.size]
+		}
+
+		i++
+	}
+
+	b.exts, b.size = b.exts[:i], size
+}
+
+//extentReader reads the content of an extent, stored chunks are only read
+//once the chunker gets to them
+type extentReader struct {
+	ctx context.Context
+	b   *Blob
+	ext extent
+	rd  io.Reader
+}
+
+func (r *extentReader) Read(p []byte) (int, error) {
+	if nil == r.rd {
+		switch {
+		case !r.ext.dirty:
+			buf, err := r.b.chunk(r.ctx, r.ext)
+			if err != nil {
+				return 0, err
+			}
+
+			r.rd = bytes.NewReader(buf)
+		case nil != r.ext.data:
+			r.rd = bytes.NewReader(r.ext.data)
+		default:
+			r.rd = io.LimitReader(zeros{}, r.ext.size)
+		}
+	}
+
+	return r.rd.Read(p)
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	zero(p)
+	return len(p), nil
+}
+
+//rechunk stores the content of exts as chunks and returns their extents
+func (b *Blob) rechunk(ctx context.Context, exts []extent) (out []extent, err error) {
+	rds := make([]io.Reader, 0, len(exts))
+	for _, ext := range exts {
+		rds = append(rds, &extentReader{ctx: ctx, b: b, ext: ext})
+	}
+
+	chunker, ofst := NewChunker(io.MultiReader(rds...), b.avg), exts[0].ofst
+	for {
+		buf, err := chunker.Next()
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		key, err := b.store.Add(ctx, &chunks.Chunk{Type: "blob", Level: 0, Buf: buf})
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, extent{key: key, ofst: ofst, size: int64(len(buf))})
+		ofst += int64(len(buf))
+	}
+}
+
+//Save stores modified content as chunks and returns the manifest that
+//describes it. Every range of modified extents is chunked again together with
+//the stored chunks on either side of it, such that the chunk boundaries can
+//settle, the other chunks are kept as they are.
+func (b *Blob) Save(ctx context.Context) (m *blobs.Manifest, err error) {
+	exts, done := []extent{}, 0
+	for i := 0; i < len(b.exts); {
+		if !b.exts[i].dirty {
+			exts = append(exts, b.exts[i])
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(b.exts) && b.exts[j].dirty {
+			j++
+		}
+
+		lo, hi := i, j
+		if lo > done {
+			lo, exts = lo-1, exts[:len(exts)-1] //the stored chunk before it
+		}
+		if hi < len(b.exts) {
+			hi++ //the stored chunk after it
+		}
+
+		rechunked, err := b.rechunk(ctx, b.exts[lo:hi])
+		if err != nil {
+			return nil, err
+		}
+
+		exts = append(exts, rechunked...)
+		i, done = hi, hi
+	}
+
+	b.exts = exts
+	m = &blobs.Manifest{Type: Type, Root: cas.Empty, Size: uint64(b.size), ChunkSize: b.avg}
+	if b.size == 0 {
+		return m, nil
+	}
+
+	if m.Root, err = b.store.Add(ctx, &chunks.Chunk{Type: IndexType, Level: 1, Buf: encodeIndex(b.exts)}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
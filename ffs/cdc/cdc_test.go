@@ -0,0 +1,341 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+)
+
+//text generates pseudo random prose as a stand-in for realistic content
+func text(seed int64, n int) []byte {
+	words := strings.Fields("the quick brown fox jumps over lazy dog data file chunk store volume node " +
+		"write read server client content hash rolling window boundary offset insert delete")
+	rnd := rand.New(rand.NewSource(seed))
+	buf := bytes.NewBuffer(nil)
+	for buf.Len() < n {
+		buf.WriteString(words[rnd.Intn(len(words))])
+		if rnd.Intn(12) == 0 {
+			buf.WriteString(".\n")
+		} else {
+			buf.WriteString(" ")
+		}
+	}
+
+	return buf.Bytes()[:n]
+}
+
+func split(t *testing.T, d []byte, avg uint32) (cs [][]byte) {
+	c := NewChunker(bytes.NewReader(d), avg)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return cs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		cs = append(cs, chunk)
+	}
+}
+
+func TestChunker(t *testing.T) {
+	d := text(1, 1024*1024)
+	cs := split(t, d, 8*1024)
+	if !bytes.Equal(bytes.Join(cs, nil), d) {
+		t.Fatal("expected chunks to make up the content")
+	}
+
+	for i, c := range cs {
+		if len(c) > 32*1024 || (len(c) < 2*1024 && i != len(cs)-1) {
+			t.Fatalf("chunk %d of %d bytes is out of bounds", i, len(c))
+		}
+	}
+
+	if avg := len(d) / len(cs); avg < 4*1024 || avg > 16*1024 {
+		t.Fatalf("expected average chunk size near 8KiB, got: %d", avg)
+	}
+
+	t.Run("insert only changes nearby chunks", func(t *testing.T) {
+		edited := append(append([]byte("an inserted sentence. "), d[:100]...), d[100:]...)
+		seen := map[[sha256.Size]byte]bool{}
+		for _, c := range cs {
+			seen[sha256.Sum256(c)] = true
+		}
+
+		changed := 0
+		for _, c := range split(t, edited, 8*1024) {
+			if !seen[sha256.Sum256(c)] {
+				changed++
+			}
+		}
+
+		if changed > 2 {
+			t.Fatalf("expected at most 2 changed chunks, got: %d", changed)
+		}
+	})
+}
+
+func TestReport(t *testing.T) {
+	base := text(2, 2*1024*1024)
+	vers := [][]byte{base}
+	for i := 0; i < 4; i++ {
+		ofst := (i + 1) * 300 * 1024
+		prev := vers[len(vers)-1]
+		vers = append(vers, append(append(append([]byte{}, prev[:ofst]...), text(int64(10+i), 1000)...), prev[ofst:]...))
+	}
+
+	stats, err := Measure([]Scheme{Fixed(16 * 1024), ContentDefined(16 * 1024)}, func(f func(rd io.Reader) error) error {
+		for _, v := range vers {
+			if err := f(bytes.NewReader(v)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats[0].Bytes != stats[1].Bytes {
+		t.Fatalf("expected schemes to see the same bytes, got: %d and %d", stats[0].Bytes, stats[1].Bytes)
+	}
+
+	if stats[1].Ratio() < 2*stats[0].Ratio() {
+		t.Fatalf("expected content-defined chunks to dedup far better, got %s: %.2f and %s: %.2f",
+			stats[0].Scheme, stats[0].Ratio(), stats[1].Scheme, stats[1].Ratio())
+	}
+}
+
+//memStore is a chunk store that keeps chunks in memory
+type memStore struct {
+	mu     sync.Mutex
+	chunks map[cas.Key]*chunks.Chunk
+	gets   int
+	adds   int
+}
+
+func (s *memStore) Get(ctx context.Context, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	c, ok := s.chunks[key]
+	if !ok || c.Type != typ || c.Level != level {
+		return nil, &chunks.NotFoundError{Type: typ, Level: level, Key: key}
+	}
+
+	return c, nil
+}
+
+func (s *memStore) Add(ctx context.Context, c *chunks.Chunk) (cas.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adds++
+	key := chunks.Hash(c)
+	s.chunks[key] = c
+	return key, nil
+}
+
+func TestBlob(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{chunks: map[cas.Key]*chunks.Chunk{}}
+	b, err := Open(store, &blobs.Manifest{Type: Type, ChunkSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := text(3, 100*1024)
+	if _, err = b.WriteAt(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := b.Save(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Type != Type || m.Size != uint64(len(d)) {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+
+	b, err = Open(store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 5000)
+	n, err := b.ReadAt(ctx, buf, 50000)
+	if err != nil || n != 5000 || !bytes.Equal(buf, d[50000:55000]) {
+		t.Fatalf("failed to read back content: %d, %v", n, err)
+	}
+
+	if err = b.Truncate(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err = b.ReadAt(ctx, buf, 0); err != io.EOF || n != 10 {
+		t.Fatalf("expected a short read after truncate, got: %d, %v", n, err)
+	}
+
+	keys, err := Keys(store, m)
+	if err != nil || len(keys) < 3 {
+		t.Fatalf("expected the index and chunks of the manifest, got: %d, %v", len(keys), err)
+	}
+}
+
+func TestBlobModify(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{chunks: map[cas.Key]*chunks.Chunk{}}
+	b, err := Open(store, &blobs.Manifest{Type: Type, ChunkSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := text(4, 1024*1024)
+	for ofst := 0; ofst < len(d); ofst += 4096 {
+		if _, err = b.WriteAt(ctx, d[ofst:ofst+4096], int64(ofst)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := b.Save(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a small edit only reads and stores the chunks around it
+	b, err = Open(store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.gets, store.adds = 0, 0
+	if _, err = b.WriteAt(ctx, []byte("EDIT"), 500000); err != nil {
+		t.Fatal(err)
+	}
+
+	copy(d[500000:], "EDIT")
+	if m, err = b.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.gets > 3 || store.adds > 10 {
+		t.Fatalf("expected only the chunks around the edit to be read and stored, got: %d gets, %d adds", store.gets, store.adds)
+	}
+
+	//growing leaves zeros without loading the content
+	store.gets = 0
+	if err = b.Truncate(ctx, uint64(len(d)+100000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = b.WriteAt(ctx, []byte("end"), int64(len(d)+50000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.gets != 0 {
+		t.Fatalf("expected no chunks to be read, got: %d", store.gets)
+	}
+
+	d = append(d, make([]byte, 100000)...)
+	copy(d[len(d)-50000:], "end")
+	if m, err = b.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open(store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, len(d))
+	if n, err := b.ReadAt(ctx, buf, 0); err != nil || n != len(d) || !bytes.Equal(buf, d) {
+		t.Fatalf("failed to read back modified content: %d, %v", n, err)
+	}
+
+	//shrinking cuts the last chunk
+	if err = b.Truncate(ctx, 300001); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, err = b.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open(store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := b.ReadAt(ctx, buf, 0); err != io.EOF || n != 300001 || !bytes.Equal(buf[:n], d[:n]) {
+		t.Fatalf("failed to read back truncated content: %d, %v", n, err)
+	}
+}
+
+func TestBlobZero(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{chunks: map[cas.Key]*chunks.Chunk{}}
+	b, err := Open(store, &blobs.Manifest{Type: Type, ChunkSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := text(5, 1024*1024)
+	if _, err = b.WriteAt(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := b.Save(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//only the chunks on the edges of the range are read
+	b, err = Open(store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.gets = 0
+	if err = b.Zero(ctx, 100001, 900001); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.gets > 2 {
+		t.Fatalf("expected only the chunks on the edges to be read, got: %d gets", store.gets)
+	}
+
+	copy(d[100001:900001], make([]byte, 800000))
+	if m, err = b.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open(store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, len(d))
+	if n, err := b.ReadAt(ctx, buf, 0); err != nil || n != len(d) || !bytes.Equal(buf, d) {
+		t.Fatalf("failed to read back zeroed content: %d, %v", n, err)
+	}
+
+	//a range within a single chunk
+	if err = b.Zero(ctx, 50, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	copy(d[50:60], make([]byte, 10))
+	if n, err := b.ReadAt(ctx, buf, 0); err != nil || n != len(d) || !bytes.Equal(buf, d) {
+		t.Fatalf("failed to read back content zeroed within a chunk: %d, %v", n, err)
+	}
+}
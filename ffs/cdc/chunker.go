@@ -0,0 +1,124 @@
+//Package cdc implements content-defined chunking: chunk boundaries are
+//chosen by a rolling hash over the content instead of at fixed offsets, such
+//that inserting or removing bytes only changes the chunks around the edit.
+package cdc
+
+import (
+	"io"
+)
+
+//Limits of the average chunk size
+const (
+	MinAvgSize = 64
+	MaxAvgSize = 64 * 1024 * 1024
+)
+
+//gear maps each byte to a pseudo random value, the values must never change
+//as they determine where chunks are cut
+var gear [256]uint64
+
+func init() {
+	x := uint64(0x6a09e667f3bcc908) //splitmix64 with a fixed seed
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+//AvgSize rounds avg to the power of two that is used as the average chunk
+//size, it is clamped to MinAvgSize and MaxAvgSize
+func AvgSize(avg uint32) uint32 {
+	if avg < MinAvgSize {
+		return MinAvgSize
+	}
+	if avg > MaxAvgSize {
+		return MaxAvgSize
+	}
+
+	p := uint32(MinAvgSize)
+	for p*2 <= avg {
+		p *= 2
+	}
+
+	return p
+}
+
+//Chunker splits content in chunks of at least a quarter and at most four
+//times the average size using a gear hash
+type Chunker struct {
+	rd   io.Reader
+	min  int
+	max  int
+	mask uint64
+	buf  []byte
+	n    int
+	err  error
+}
+
+//NewChunker reads content from rd to split it in chunks of avg bytes on
+//average, see AvgSize
+func NewChunker(rd io.Reader, avg uint32) *Chunker {
+	avg = AvgSize(avg)
+	bits := uint(0)
+	for (uint32(1) << bits) < avg {
+		bits++
+	}
+
+	return &Chunker{
+		rd:  rd,
+		min: int(avg / 4),
+		max: int(avg * 4),
+
+		//the hash shifts left, only its top bits depend on the whole window
+		mask: ((uint64(1) << bits) - 1) << (64 - bits),
+		buf:  make([]byte, avg*4),
+	}
+}
+
+//cut returns the length of the chunk at the start of d
+func (c *Chunker) cut(d []byte) int {
+	if len(d) <= c.min {
+		return len(d)
+	}
+
+	h := uint64(0)
+	for i := c.min; i < len(d); i++ {
+		h = (h << 1) + gear[d[i]]
+		if h&c.mask == 0 {
+			return i + 1
+		}
+	}
+
+	return len(d)
+}
+
+//Next returns the next chunk, it returns io.EOF when all content has been
+//chunked
+func (c *Chunker) Next() (chunk []byte, err error) {
+	for c.n < len(c.buf) && c.err == nil {
+		var n int
+		n, c.err = c.rd.Read(c.buf[c.n:])
+		c.n += n
+	}
+
+	if c.n == 0 {
+		if c.err == io.EOF {
+			return nil, io.EOF
+		}
+
+		return nil, c.err
+	}
+
+	if c.err != nil && c.err != io.EOF {
+		return nil, c.err
+	}
+
+	l := c.cut(c.buf[:c.n])
+	chunk = make([]byte, l)
+	copy(chunk, c.buf[:l])
+	c.n = copy(c.buf, c.buf[l:c.n])
+	return chunk, nil
+}
@@ -0,0 +1,115 @@
+package cdc
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+//Scheme splits content in chunks
+type Scheme struct {
+	Name  string
+	Split func(rd io.Reader, f func(chunk []byte)) error
+}
+
+//Fixed returns the scheme that cuts chunks at fixed offsets
+func Fixed(size int) Scheme {
+	return Scheme{
+		Name: fmt.Sprintf("fixed-%s", byteSize(int64(size))),
+		Split: func(rd io.Reader, f func(chunk []byte)) error {
+			buf := make([]byte, size)
+			for {
+				n, err := io.ReadFull(rd, buf)
+				if n > 0 {
+					f(buf[:n])
+				}
+
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		},
+	}
+}
+
+//ContentDefined returns the scheme that cuts chunks of avg bytes on average
+//where the content allows it
+func ContentDefined(avg uint32) Scheme {
+	avg = AvgSize(avg)
+	return Scheme{
+		Name: fmt.Sprintf("cdc-%s", byteSize(int64(avg))),
+		Split: func(rd io.Reader, f func(chunk []byte)) error {
+			c := NewChunker(rd, avg)
+			for {
+				chunk, err := c.Next()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+
+				f(chunk)
+			}
+		},
+	}
+}
+
+func byteSize(n int64) string {
+	switch {
+	case n >= 1024*1024 && n%(1024*1024) == 0:
+		return fmt.Sprintf("%dMiB", n/(1024*1024))
+	case n >= 1024 && n%1024 == 0:
+		return fmt.Sprintf("%dKiB", n/1024)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+//Stats describes how well content deduplicates with a scheme
+type Stats struct {
+	Scheme      string `json:"scheme"`
+	Chunks      int    `json:"chunks"`
+	Unique      int    `json:"unique"`
+	Bytes       int64  `json:"bytes"`
+	UniqueBytes int64  `json:"unique_bytes"`
+}
+
+//Ratio returns the deduplication ratio: the nr of bytes for every byte that
+//needs to be stored
+func (s *Stats) Ratio() float64 {
+	if s.UniqueBytes == 0 {
+		return 1
+	}
+
+	return float64(s.Bytes) / float64(s.UniqueBytes)
+}
+
+//Measure splits the content of every reader that each calls f with using
+//scheme and counts the unique chunks. Each is called once per scheme.
+func Measure(schemes []Scheme, each func(f func(rd io.Reader) error) error) (stats []*Stats, err error) {
+	for _, scheme := range schemes {
+		st := &Stats{Scheme: scheme.Name}
+		seen := map[[sha256.Size]byte]struct{}{}
+		if err = each(func(rd io.Reader) error {
+			return scheme.Split(rd, func(chunk []byte) {
+				st.Chunks++
+				st.Bytes += int64(len(chunk))
+
+				h := sha256.Sum256(chunk)
+				if _, ok := seen[h]; !ok {
+					seen[h] = struct{}{}
+					st.Unique++
+					st.UniqueBytes += int64(len(chunk))
+				}
+			})
+		}); err != nil {
+			return nil, fmt.Errorf("failed to measure %s: %v", scheme.Name, err)
+		}
+
+		stats = append(stats, st)
+	}
+
+	return stats, nil
+}
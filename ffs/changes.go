@@ -0,0 +1,47 @@
+package ffs
+
+import (
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//changesSweepBatch is the nr of expired change events that are removed per
+//transaction
+var changesSweepBatch = 1000
+
+//SetChangeRetention configures how long change events are kept, older events
+//are removed by SweepChanges unless age is zero.
+func (self *Memfs) SetChangeRetention(age time.Duration) (errc int) {
+	defer trace(age)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		self.estore.SetRetention(tx, age)
+		return 0
+	})
+}
+
+//SweepChanges removes the change events that expired at time now, it works
+//in batches such that a long log doesn't exceed transaction limits
+func (self *Memfs) SweepChanges(now time.Time) (errc int) {
+	defer trace(now)(&errc)
+	for {
+		var n int
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			n = 0
+			age := self.estore.Retention(tx)
+			if 0 == age {
+				return 0
+			}
+
+			var err error
+			if n, err = self.estore.Trim(tx, now.Add(-age), changesSweepBatch); err != nil {
+				return -fuse.EIO
+			}
+
+			return 0
+		}); 0 != errc || n < changesSweepBatch {
+			return errc
+		}
+	}
+}
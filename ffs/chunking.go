@@ -0,0 +1,35 @@
+package ffs
+
+import (
+	"github.com/advanderveer/dfs/ffs/cdc"
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//SetChunking selects how the content of files created from now on is split
+//in chunks: "blob" for fixed size chunks or "cdc" for content-defined chunks
+//of size bytes on average. A zero size selects the default.
+func (self *Memfs) SetChunking(scheme string, size uint32) (errc int) {
+	defer trace(scheme, size)(&errc)
+	switch scheme {
+	case "", "blob":
+		scheme = "blob"
+		if 0 == size {
+			size = 4 * 1024 * 1024
+		}
+	case cdc.Type:
+		if 0 == size {
+			size = 1024 * 1024
+		}
+
+		size = cdc.AvgSize(size)
+	default:
+		return -fuse.EINVAL
+	}
+
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		self.nstore.SetChunking(tx, nodes.Chunking{Scheme: scheme, Size: size})
+		return 0
+	})
+}
@@ -0,0 +1,69 @@
+package chunkstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bazil.org/bazil/kv"
+	"github.com/boltdb/bolt"
+)
+
+var boltBucket = []byte("chunks")
+
+//boltKV stores chunks in a single bucket of an embedded bolt database
+type boltKV struct {
+	db *bolt.DB
+}
+
+func openBolt(path string) (b *boltKV, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %v", err)
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt bucket: %v", err)
+	}
+
+	return &boltKV{db: db}, nil
+}
+
+func (b *boltKV) Get(ctx context.Context, key []byte) (v []byte, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		d := tx.Bucket(boltBucket).Get(key)
+		if d == nil {
+			return kv.NotFoundError{Key: key}
+		}
+
+		//values are only valid during the transaction
+		v = make([]byte, len(d))
+		copy(v, d)
+		return nil
+	})
+
+	return v, err
+}
+
+func (b *boltKV) Has(ctx context.Context, key []byte) (ok bool, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(boltBucket).Get(key) != nil
+		return nil
+	})
+
+	return ok, err
+}
+
+func (b *boltKV) Put(ctx context.Context, key, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+}
+
+func (b *boltKV) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,244 @@
+package chunkstore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/chunks"
+	"bazil.org/bazil/cas/chunks/kvchunks"
+	"bazil.org/bazil/kv"
+	"bazil.org/bazil/kv/kvfiles"
+	"github.com/advanderveer/dfs/ffs/crypt"
+)
+
+//Backends that can hold chunks
+const (
+	KVFiles = "kvfiles"
+	Bolt    = "bolt"
+	S3      = "s3"
+)
+
+//ErrUnknownBackend is returned when a config selects a backend that doesn't exist
+var ErrUnknownBackend = errors.New("unknown chunk store backend, use kvfiles, bolt or s3")
+
+//Config selects and configures the backend that stores chunks
+type Config struct {
+	//Backend is one of KVFiles, Bolt or S3, it defaults to KVFiles
+	Backend string `json:"backend,omitempty"`
+
+	//ChunkDir is the directory that holds the chunk files or bolt database
+	ChunkDir string `json:"chunk_dir"`
+
+	//S3 configures the object store when the S3 backend is selected
+	S3 *S3Config `json:"s3,omitempty"`
+
+	//Compression is the codec new chunks are compressed with, chunks are
+	//stored as is when empty. Compressed chunks can always be read.
+	Compression string `json:"compression,omitempty"`
+
+	//Convergent encrypts chunks such that identical chunks are still stored
+	//once, otherwise the store doesn't learn which chunks are identical
+	Convergent bool `json:"convergent,omitempty"`
+
+	//Keyring encrypts chunks when set, it is never persisted with the config
+	Keyring *crypt.Keyring `json:"-"`
+}
+
+//Store is a chunk store that holds resources until it is closed
+type Store struct {
+	chunks.Store
+	kv kv.KV
+}
+
+//Close releases the resources of the backend
+func (s *Store) Close() error {
+	if c, ok := s.kv.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+//Open the backend selected by cfg, each backend stores the same chunks.Store
+//contract on top of a key-value store
+func Open(cfg Config) (s *Store, err error) {
+	var kv kv.KV
+	switch cfg.Backend {
+	case "", KVFiles:
+		if err = os.MkdirAll(cfg.ChunkDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create chunk dir: %v", err)
+		}
+
+		files, err := kvfiles.Open(cfg.ChunkDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open chunk dir: %v", err)
+		}
+
+		kv = &filesKV{KV: files, dir: cfg.ChunkDir}
+
+	case Bolt:
+		if err = os.MkdirAll(cfg.ChunkDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create chunk dir: %v", err)
+		}
+
+		if kv, err = openBolt(filepath.Join(cfg.ChunkDir, "chunks.bolt")); err != nil {
+			return nil, err
+		}
+
+	case S3:
+		if cfg.S3 == nil {
+			return nil, errors.New("s3 backend selected without s3 config")
+		}
+
+		if kv, err = openS3(*cfg.S3); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, ErrUnknownBackend
+	}
+
+	//content is compressed before it is encrypted
+	if cfg.Keyring != nil {
+		kv = crypt.NewKV(kv, cfg.Keyring, cfg.Convergent)
+	}
+
+	//always wrapped such that chunks stay readable when compression is
+	//turned off again
+	if kv, err = newCompressKV(kv, cfg.Compression); err != nil {
+		return nil, err
+	}
+
+	return &Store{Store: kvchunks.New(kv), kv: kv}, nil
+}
+
+//Remove deletes the local data of the backend selected by cfg, objects in
+//an S3 bucket are left in place as they may be shared. The chunk dir may
+//hold other files so only the files that the backend writes are removed,
+//the dir itself only when nothing else is left in it.
+func Remove(cfg Config) (err error) {
+	if cfg.ChunkDir == "" {
+		return nil
+	}
+
+	switch cfg.Backend {
+	case "", KVFiles:
+		for _, dir := range []string{filepath.Join(cfg.ChunkDir, QuarantineDir), cfg.ChunkDir} {
+			if err = removeValues(dir); err != nil {
+				return err
+			}
+		}
+	case Bolt:
+		if err = os.Remove(filepath.Join(cfg.ChunkDir, "chunks.bolt")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	return removeEmpty(cfg.ChunkDir)
+}
+
+//kvfilesValue matches the names of the files that kvfiles stores values in
+var kvfilesValue = regexp.MustCompile(`^[0-9a-f]+\.data$`)
+
+//removeValues removes the values that kvfiles stored in dir, and dir itself
+//once it is empty
+func removeValues(dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !kvfilesValue.MatchString(fi.Name()) {
+			continue
+		}
+
+		if err = os.Remove(filepath.Join(dir, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return removeEmpty(dir)
+}
+
+//removeEmpty removes dir if nothing is left in it
+func removeEmpty(dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) || len(fis) > 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return os.Remove(dir)
+}
+
+//haser is implemented by key-value stores that can tell whether a key is
+//stored without reading its value
+type haser interface {
+	Has(ctx context.Context, key []byte) (bool, error)
+}
+
+//forwardHas asks the wrapped store whether key is stored, stores that can't
+//tell otherwise read the value
+func forwardHas(ctx context.Context, inner kv.KV, key []byte) (bool, error) {
+	if h, ok := inner.(haser); ok {
+		return h.Has(ctx, key)
+	}
+
+	_, err := inner.Get(ctx, key)
+	if _, ok := err.(kv.NotFoundError); ok {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+//Has returns whether the chunk with the provided key is stored without
+//reading it, it is neither decrypted nor verified
+func (s *Store) Has(ctx context.Context, key cas.Key) (bool, error) {
+	if key.IsSpecial() {
+		return true, nil //special keys are never stored but can always be read
+	}
+
+	return forwardHas(ctx, s.kv, key.Bytes())
+}
+
+func (f *filesKV) Has(ctx context.Context, key []byte) (bool, error) {
+	_, err := os.Stat(filepath.Join(f.dir, hex.EncodeToString(key)+".data"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+//PutObject stores d under name next to the chunks, chunks are stored under
+//their key so names never collide with them. Some backends never overwrite
+//a value so names should only be written once.
+func (s *Store) PutObject(ctx context.Context, name string, d []byte) error {
+	return s.kv.Put(ctx, []byte(name), d)
+}
+
+//HasObject returns whether something was stored under name without reading it
+func (s *Store) HasObject(ctx context.Context, name string) (bool, error) {
+	return forwardHas(ctx, s.kv, []byte(name))
+}
+
+//GetObject returns what was stored under name, it returns a kv.NotFoundError
+//if nothing was
+func (s *Store) GetObject(ctx context.Context, name string) ([]byte, error) {
+	return s.kv.Get(ctx, []byte(name))
+}
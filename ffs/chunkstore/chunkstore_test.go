@@ -0,0 +1,360 @@
+package chunkstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/chunks"
+	"bazil.org/bazil/kv"
+	"github.com/advanderveer/dfs/ffs/crypt"
+)
+
+//s3Stub is a minimal in-memory stand-in for an S3 compatible object store
+type s3Stub struct {
+	mu   sync.Mutex
+	objs map[string][]byte
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		d, err := ioutil.ReadAll(r.Body)
+		if err != nil || hashHex(d) != r.Header.Get("X-Amz-Content-Sha256") {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+
+		s.objs[r.URL.Path] = d
+	case http.MethodGet, http.MethodHead:
+		d, ok := s.objs[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			w.Write(d)
+		}
+	case http.MethodDelete:
+		delete(s.objs, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+//testConformance checks that a store implements the chunks.Store contract
+func testConformance(t *testing.T, store chunks.Store) {
+	ctx := context.Background()
+	in := &chunks.Chunk{Type: "blob", Level: 0, Buf: []byte("hello, world")}
+	key, err := store.Add(ctx, in)
+	if err != nil {
+		t.Fatalf("failed to add chunk: %v", err)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		out, err := store.Get(ctx, key, "blob", 0)
+		if err != nil {
+			t.Fatalf("failed to get chunk: %v", err)
+		}
+
+		if out.Type != in.Type || out.Level != in.Level || !bytes.Equal(out.Buf, in.Buf) {
+			t.Fatalf("expected chunk %v, got: %v", in, out)
+		}
+	})
+
+	t.Run("add is idempotent", func(t *testing.T) {
+		key2, err := store.Add(ctx, &chunks.Chunk{Type: "blob", Level: 0, Buf: []byte("hello, world")})
+		if err != nil {
+			t.Fatalf("failed to add chunk again: %v", err)
+		}
+
+		if key2 != key {
+			t.Fatalf("expected the same key for the same content, got: %v and %v", key, key2)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		other := cas.NewKey(bytes.Repeat([]byte{0x42}, cas.KeySize))
+		if _, err := store.Get(ctx, other, "blob", 0); err == nil {
+			t.Fatal("expected an error for a missing chunk")
+		} else if _, ok := err.(*chunks.NotFoundError); !ok {
+			t.Fatalf("expected a not found error, got: %#v", err)
+		}
+
+		if _, err := store.Get(ctx, key, "blob", 1); err == nil {
+			t.Fatal("expected an error for a chunk of another level")
+		}
+	})
+
+	t.Run("large and concurrent", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				buf := bytes.Repeat([]byte{byte(i)}, 1024*1024)
+				key, err := store.Add(ctx, &chunks.Chunk{Type: "blob", Level: 1, Buf: buf})
+				if err != nil {
+					t.Errorf("failed to add large chunk: %v", err)
+					return
+				}
+
+				out, err := store.Get(ctx, key, "blob", 1)
+				if err != nil || !bytes.Equal(out.Buf, buf) {
+					t.Errorf("failed to get large chunk back: %v", err)
+				}
+			}(i)
+		}
+
+		wg.Wait()
+	})
+}
+
+func TestBackends(t *testing.T) {
+	stub := httptest.NewServer(&s3Stub{objs: map[string][]byte{}})
+	defer stub.Close()
+
+	for _, cfg := range []Config{
+		{Backend: KVFiles},
+		{Backend: KVFiles, Compression: Deflate},
+		{Backend: Bolt},
+		{Backend: Bolt, Compression: Deflate},
+		{Backend: KVFiles, Compression: Deflate, Keyring: crypt.NewKeyring()},
+		{Backend: Bolt, Keyring: crypt.NewKeyring(), Convergent: true},
+		{Backend: S3, S3: &S3Config{Endpoint: stub.URL, Bucket: "chunks", Prefix: "vol/", AccessKey: "test", SecretKey: "secret"}},
+	} {
+		name := cfg.Backend + cfg.Compression
+		if cfg.Keyring != nil {
+			name += "encrypted"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "ffs_chunks_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+			if cfg.Backend != S3 {
+				cfg.ChunkDir = dir
+			}
+
+			store, err := Open(cfg)
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+
+			defer store.Close()
+			testConformance(t, store)
+
+			key, err := store.Add(context.Background(), &chunks.Chunk{Type: "blob", Buf: []byte("has me")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for k, exp := range map[cas.Key]bool{key: true, cas.NewKey(bytes.Repeat([]byte{0x42}, cas.KeySize)): false} {
+				if ok, err := store.Has(context.Background(), k); err != nil || ok != exp {
+					t.Fatalf("expected has to be %v, got: %v, %v", exp, ok, err)
+				}
+			}
+		})
+	}
+
+	if _, err := Open(Config{Backend: "tape"}); err != ErrUnknownBackend {
+		t.Fatalf("expected unknown backend, got: %v", err)
+	}
+}
+
+//mapKV is a key-value store in memory
+type mapKV map[string][]byte
+
+func (m mapKV) Get(ctx context.Context, key []byte) ([]byte, error) {
+	v, ok := m[string(key)]
+	if !ok {
+		return nil, kv.NotFoundError{Key: key}
+	}
+
+	return v, nil
+}
+
+func (m mapKV) Put(ctx context.Context, key, value []byte) error {
+	m[string(key)] = value
+	return nil
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	inner := mapKV{}
+	ckv, err := newCompressKV(inner, Deflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := []byte(strings.Repeat("2018-01-01T00:00:00Z INFO request served in 12ms\n", 1000))
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for name, d := range map[string][]byte{"logs": logs, "random": random} {
+		if err = ckv.Put(ctx, []byte(name), d); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := ckv.Get(ctx, []byte(name))
+		if err != nil || !bytes.Equal(out, d) {
+			t.Fatalf("failed to read back %s: %v", name, err)
+		}
+	}
+
+	if n := len(inner[string(framedKey([]byte("logs")))]); n > len(logs)/10 {
+		t.Fatalf("expected logs to compress well, got %d of %d bytes", n, len(logs))
+	}
+
+	if inner[string(framedKey([]byte("random")))][0] != codecNone {
+		t.Fatal("expected incompressible data to be stored without compression")
+	}
+
+	t.Run("mixed codecs", func(t *testing.T) {
+		inner["legacy"] = []byte("stored before compression")
+		inner["lookalike"] = append(append([]byte{}, framed...), codecDeflate, 'x')
+		plain, _ := newCompressKV(inner, "")
+		for _, name := range []string{"logs", "random", "legacy", "lookalike"} {
+			if _, err := plain.Get(ctx, []byte(name)); err != nil {
+				t.Fatalf("failed to read %s: %v", name, err)
+			}
+		}
+
+		if out, _ := plain.Get(ctx, []byte("logs")); !bytes.Equal(out, logs) {
+			t.Fatal("expected compressed chunks to be readable with compression turned off")
+		}
+
+		if out, _ := plain.Get(ctx, []byte("lookalike")); !bytes.Equal(out, inner["lookalike"]) {
+			t.Fatal("expected legacy values to be returned as is")
+		}
+	})
+
+	t.Run("framed without codec", func(t *testing.T) {
+		plain, _ := newCompressKV(inner, "")
+		d := append(append([]byte{}, framed...), codecNone, 'x')
+		if err := plain.Put(ctx, []byte("raw"), d); err != nil {
+			t.Fatal(err)
+		}
+
+		if out, err := plain.Get(ctx, []byte("raw")); err != nil || !bytes.Equal(out, d) {
+			t.Fatalf("expected content that looks framed to read back as is, got: %q, %v", out, err)
+		}
+	})
+
+	if _, err = newCompressKV(inner, "lz77"); err == nil {
+		t.Fatal("expected an error for an unknown codec")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+	stub := httptest.NewServer(&s3Stub{objs: map[string][]byte{}})
+	defer stub.Close()
+
+	for _, cfg := range []Config{
+		{Backend: KVFiles},
+		{Backend: Bolt, Compression: Deflate, Keyring: crypt.NewKeyring()},
+		{Backend: S3, S3: &S3Config{Endpoint: stub.URL, Bucket: "chunks", AccessKey: "test", SecretKey: "secret"}},
+	} {
+		t.Run(cfg.Backend, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "ffs_chunks_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+			if cfg.Backend != S3 {
+				cfg.ChunkDir = dir
+			}
+
+			store, err := Open(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer store.Close()
+			in := &chunks.Chunk{Type: "blob", Level: 0, Buf: []byte("quarantine me")}
+			key, err := store.Add(ctx, in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = store.Quarantine(ctx, key); err != nil {
+				t.Fatalf("failed to quarantine: %v", err)
+			}
+
+			if _, err = store.Get(ctx, key, "blob", 0); err == nil {
+				t.Fatal("expected quarantined chunk to be gone")
+			} else if _, ok := err.(*chunks.NotFoundError); !ok {
+				t.Fatalf("expected not found error, got: %v", err)
+			}
+
+			if _, err = store.Add(ctx, in); err != nil {
+				t.Fatal(err)
+			}
+
+			if out, err := store.Get(ctx, key, "blob", 0); err != nil || !bytes.Equal(out.Buf, in.Buf) {
+				t.Fatalf("expected chunk to be repaired, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	for _, backend := range []string{KVFiles, Bolt} {
+		t.Run(backend, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "ffs_chunks_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+			other := filepath.Join(dir, "notes.txt")
+			if err = ioutil.WriteFile(other, []byte("not a chunk"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := Config{Backend: backend, ChunkDir: dir}
+			store, err := Open(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = store.Add(context.Background(), &chunks.Chunk{Type: "blob", Buf: []byte("chunk")}); err != nil {
+				t.Fatal(err)
+			}
+
+			store.Close()
+			if err = Remove(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			//only the files of the backend are removed from a shared dir
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil || len(fis) != 1 || fis[0].Name() != "notes.txt" {
+				t.Fatalf("expected only the other file to be left, got: %v, %v", fis, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package chunkstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"bazil.org/bazil/kv"
+)
+
+//Codecs that chunks can be compressed with
+const (
+	NoCompression = "none"
+	Deflate       = "deflate"
+)
+
+//codec ids as recorded in front of each value
+const (
+	codecNone    = 0
+	codecDeflate = 1
+)
+
+//framed prefixes the keys of values that start with a codec id. Values
+//stored before compression existed live under the bare key and are returned
+//as is, keeping the codec out of the value means no content is mistaken for
+//a codec id. Chunk keys have a fixed size and object names hold no zero
+//bytes, so prefixed keys never collide with bare ones.
+var framed = []byte{0x00, 'f', 'f', 'z'}
+
+//sampleSize is the size of the start of a value that is compressed first to
+//quickly skip data that doesn't compress
+const sampleSize = 4 * 1024
+
+//compressKV compresses values before they are put in the wrapped store.
+//Keys are only prefixed such that chunks stay addressed by their content. As
+//each value records its codec stores can hold values of mixed codecs.
+type compressKV struct {
+	kv.KV
+	codec string
+	level int
+}
+
+func newCompressKV(inner kv.KV, codec string) (*compressKV, error) {
+	switch codec {
+	case "", NoCompression, Deflate:
+	default:
+		return nil, fmt.Errorf("unknown compression codec '%s', use none or deflate", codec)
+	}
+
+	return &compressKV{KV: inner, codec: codec, level: flate.BestSpeed}, nil
+}
+
+//Close closes the wrapped store if it can be closed
+func (c *compressKV) Close() error {
+	if cl, ok := c.KV.(interface {
+		Close() error
+	}); ok {
+		return cl.Close()
+	}
+
+	return nil
+}
+
+func (c *compressKV) deflate(d []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	w, _ := flate.NewWriter(buf, c.level)
+	w.Write(d)
+	w.Close()
+	return buf.Bytes()
+}
+
+//shrinks returns whether compressed data of size n saves at least a tenth
+//of the original size m
+func shrinks(n, m int) bool {
+	return n < m-m/10
+}
+
+//framedKey returns the key that the framed value of key is stored under
+func framedKey(key []byte) []byte {
+	return append(append(make([]byte, 0, len(framed)+len(key)), framed...), key...)
+}
+
+//encode returns value prefixed with the codec it was stored with,
+//incompressible data is stored without compression
+func (c *compressKV) encode(value []byte) []byte {
+	codec, d := byte(codecNone), value
+	if c.codec == Deflate {
+		if len(value) <= sampleSize || shrinks(len(c.deflate(value[:sampleSize])), sampleSize) {
+			if cd := c.deflate(value); shrinks(len(cd), len(value)) {
+				codec, d = codecDeflate, cd
+			}
+		}
+	}
+
+	enc := make([]byte, 0, 1+len(d))
+	enc = append(append(enc, codec), d...)
+	return enc
+}
+
+//decode returns the content of a framed value
+func decode(value []byte) ([]byte, error) {
+	if len(value) < 1 {
+		return nil, errors.New("value without codec")
+	}
+
+	d := value[1:]
+	switch value[0] {
+	case codecNone:
+		return d, nil
+	case codecDeflate:
+		r := flate.NewReader(bytes.NewReader(d))
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unknown codec %d", value[0])
+	}
+}
+
+//Get returns the framed value of key, or the value stored under the bare key
+//before compression existed
+func (c *compressKV) Get(ctx context.Context, key []byte) ([]byte, error) {
+	value, err := c.KV.Get(ctx, framedKey(key))
+	if _, ok := err.(kv.NotFoundError); ok {
+		return c.KV.Get(ctx, key)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if value, err = decode(value); err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk: %v", err)
+	}
+
+	return value, nil
+}
+
+func (c *compressKV) Put(ctx context.Context, key, value []byte) error {
+	return c.KV.Put(ctx, framedKey(key), c.encode(value))
+}
+
+//Has returns whether a framed or bare value is stored under key
+func (c *compressKV) Has(ctx context.Context, key []byte) (bool, error) {
+	if ok, err := forwardHas(ctx, c.KV, framedKey(key)); ok || err != nil {
+		return ok, err
+	}
+
+	return forwardHas(ctx, c.KV, key)
+}
@@ -0,0 +1,137 @@
+package chunkstore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/kv"
+	"github.com/boltdb/bolt"
+)
+
+//QuarantineDir is where corrupt chunks are moved to, it is relative to the
+//chunk dir or to the prefix of the S3 backend
+const QuarantineDir = "quarantine"
+
+//ErrNoQuarantine is returned by backends that can't set chunks aside
+var ErrNoQuarantine = errors.New("chunk store doesn't support quarantine")
+
+var boltQuarantine = []byte(QuarantineDir)
+
+//quarantiner is implemented by key-value stores that can move a value out of
+//the way. Afterwards the key reads as not found and can be put again.
+type quarantiner interface {
+	Quarantine(ctx context.Context, key []byte) error
+}
+
+//forwardQuarantine forwards a quarantine request to the wrapped store,
+//wrappers that map keys implement it themselves
+func forwardQuarantine(ctx context.Context, inner kv.KV, key []byte) error {
+	q, ok := inner.(quarantiner)
+	if !ok {
+		return ErrNoQuarantine
+	}
+
+	return q.Quarantine(ctx, key)
+}
+
+//Quarantine moves the chunk with the provided key out of the store such that
+//it is no longer read, adding the same chunk again repairs it. The corrupt
+//content is kept in the quarantine dir for inspection.
+func (s *Store) Quarantine(ctx context.Context, key cas.Key) error {
+	if key.IsSpecial() {
+		return nil //special keys are never stored
+	}
+
+	//chunks are stored under the bytes of their key
+	return forwardQuarantine(ctx, s.kv, key.Bytes())
+}
+
+//Quarantine moves the framed value of key aside, or the bare value when the
+//chunk was stored before compression existed
+func (c *compressKV) Quarantine(ctx context.Context, key []byte) error {
+	err := forwardQuarantine(ctx, c.KV, framedKey(key))
+	if _, ok := err.(kv.NotFoundError); ok {
+		return forwardQuarantine(ctx, c.KV, key)
+	}
+
+	return err
+}
+
+//filesKV adds quarantine to the kvfiles backend, which stores each value in
+//a file named after the hex encoded key
+type filesKV struct {
+	kv.KV
+	dir string
+}
+
+func (f *filesKV) Quarantine(ctx context.Context, key []byte) error {
+	name := hex.EncodeToString(key) + ".data"
+	if err := os.MkdirAll(filepath.Join(f.dir, QuarantineDir), 0700); err != nil {
+		return fmt.Errorf("failed to create quarantine dir: %v", err)
+	}
+
+	err := os.Rename(filepath.Join(f.dir, name), filepath.Join(f.dir, QuarantineDir, name))
+	if os.IsNotExist(err) {
+		return kv.NotFoundError{Key: key}
+	} else if err != nil {
+		return fmt.Errorf("failed to quarantine chunk: %v", err)
+	}
+
+	return nil
+}
+
+func (b *boltKV) Quarantine(ctx context.Context, key []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		d := tx.Bucket(boltBucket).Get(key)
+		if d == nil {
+			return kv.NotFoundError{Key: key}
+		}
+
+		qb, err := tx.CreateBucketIfNotExists(boltQuarantine)
+		if err != nil {
+			return fmt.Errorf("failed to create quarantine bucket: %v", err)
+		}
+
+		if err = qb.Put(key, d); err != nil {
+			return err
+		}
+
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+func (s *s3KV) Quarantine(ctx context.Context, key []byte) error {
+	d, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	qurl := strings.TrimRight(s.cfg.Endpoint, "/") + "/" + s.cfg.Bucket + "/" + s.cfg.Prefix + QuarantineDir + "/" + hex.EncodeToString(key)
+	resp, err := s.doURL(ctx, http.MethodPut, qurl, d)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %v", err)
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to copy object: unexpected status %s", resp.Status)
+	}
+
+	if resp, err = s.do(ctx, http.MethodDelete, key, nil); err != nil {
+		return fmt.Errorf("failed to delete object: %v", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete object: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
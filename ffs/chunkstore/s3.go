@@ -0,0 +1,181 @@
+package chunkstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"bazil.org/bazil/kv"
+)
+
+//S3Config configures an S3 compatible object store, credentials are not
+//persisted and default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+//environment variables
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"-"`
+	SecretKey string `json:"-"`
+}
+
+//s3KV stores each chunk as an object, it uses path style requests signed
+//with AWS signature version 4 such that it works with stand-ins like MinIO
+type s3KV struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+func openS3(cfg S3Config) (s *s3KV, err error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 backend requires an endpoint and bucket")
+	}
+
+	if _, err = url.Parse(cfg.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	return &s3KV{cfg: cfg, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+func (s *s3KV) objectURL(key []byte) string {
+	return strings.TrimRight(s.cfg.Endpoint, "/") + "/" + s.cfg.Bucket + "/" + s.cfg.Prefix + hex.EncodeToString(key)
+}
+
+func (s *s3KV) do(ctx context.Context, method string, key []byte, body []byte) (resp *http.Response, err error) {
+	return s.doURL(ctx, method, s.objectURL(key), body)
+}
+
+func (s *s3KV) doURL(ctx context.Context, method string, url string, body []byte) (resp *http.Response, err error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	sign(req, body, s.cfg, time.Now())
+	return s.client.Do(req.WithContext(ctx))
+}
+
+func (s *s3KV) Get(ctx context.Context, key []byte) (v []byte, err error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %v", err)
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, kv.NotFoundError{Key: key}
+	default:
+		return nil, fmt.Errorf("failed to get object: unexpected status %s", resp.Status)
+	}
+
+	if v, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to read object: %v", err)
+	}
+
+	return v, nil
+}
+
+//Has requests the headers of the object only
+func (s *s3KV) Has(ctx context.Context, key []byte) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to head object: %v", err)
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to head object: unexpected status %s", resp.Status)
+	}
+}
+
+func (s *s3KV) Put(ctx context.Context, key, value []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %v", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to put object: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+func hashHex(d []byte) string {
+	h := sha256.Sum256(d)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, d string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(d))
+	return h.Sum(nil)
+}
+
+//sign adds an AWS signature version 4 authorization header to req
+func sign(req *http.Request, body []byte, cfg S3Config, now time.Time) {
+	now = now.UTC()
+	amzdate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := hashHex(body)
+
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	creq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payload + "\n" +
+			"x-amz-date:" + amzdate + "\n",
+		signed,
+		payload,
+	}, "\n")
+
+	scope := date + "/" + cfg.Region + "/s3/aws4_request"
+	sts := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hashHex([]byte(creq))
+
+	key := hmacSHA256([]byte("AWS4"+cfg.SecretKey), date)
+	key = hmacSHA256(key, cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		cfg.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, sts)),
+	))
+}
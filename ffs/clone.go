@@ -0,0 +1,69 @@
+package ffs
+
+import (
+	"path"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//Clone creates dst as a copy of the file, symlink or directory tree at src
+//that shares the content of every file with it. Chunks are addressed by
+//their content so writing to either copy stores new chunks and leaves the
+//other alone. Hard links within a tree are preserved and writes that were
+//not flushed yet are not cloned. Trees are cloned in batched transactions,
+//a failure can leave part of the copy behind.
+func (self *Memfs) Clone(src string, dst string) (errc int) {
+	defer trace(src, dst)(&errc)
+	src, dst = path.Join("/", src), path.Join("/", dst)
+	if errc = self.cloneValid(src, dst); 0 != errc {
+		return errc
+	}
+
+	errc, es, first := self.cloneEntries(src, dst)
+	if 0 != errc {
+		return errc
+	}
+
+	return self.restoreEntries(es, func(tx fdb.Transaction, e *SnapshotEntry) int {
+		return self.restoreNode(tx, "clone", e, first[e.Ino])
+	}, nil)
+}
+
+//cloneValid checks that a tree can be cloned from src to dst
+func (self *Memfs) cloneValid(src, dst string) (errc int) {
+	if "/" == src || src == dst || strings.HasPrefix(dst, src+"/") {
+		return -fuse.EINVAL
+	}
+
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		prnt, _, node := self.lookupNode(tx, dst, nil)
+		if nil == prnt {
+			return -fuse.ENOENT
+		}
+		if nil != node {
+			return -fuse.EEXIST
+		}
+
+		return 0
+	})
+}
+
+//cloneEntries describes the nodes below src as they are created below dst
+//by the actor, first is the first path of every inode
+func (self *Memfs) cloneEntries(src, dst string) (errc int, es []*SnapshotEntry, first map[uint64]string) {
+	if errc, es = self.exportNodes(src); 0 != errc {
+		return errc, nil, nil
+	}
+
+	first = map[uint64]string{}
+	for _, e := range es {
+		e.Path, e.Owner = dst+strings.TrimPrefix(e.Path, src), self.actor
+		if _, ok := first[e.Ino]; !ok {
+			first[e.Ino] = e.Path
+		}
+	}
+
+	return 0, es, first
+}
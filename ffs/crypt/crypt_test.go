@@ -0,0 +1,171 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/bazil/kv"
+)
+
+func TestSeal(t *testing.T) {
+	kr := NewKeyring()
+	ad := []byte("xatr/user.secret")
+	sealed := kr.Seal([]byte("hello"), ad)
+	if !IsSealed(sealed) || bytes.Contains(sealed, []byte("hello")) {
+		t.Fatalf("expected value to be sealed, got: %q", sealed)
+	}
+
+	if out, err := kr.Open(sealed, ad); err != nil || string(out) != "hello" {
+		t.Fatalf("failed to open sealed value: %q, %v", out, err)
+	}
+
+	if _, err := kr.Open(sealed, []byte("xatr/user.other")); err != ErrDecrypt {
+		t.Fatalf("expected value to be bound to its ad, got: %v", err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := kr.Open(tampered, ad); err != ErrDecrypt {
+		t.Fatalf("expected tampering to be detected, got: %v", err)
+	}
+
+	if out, err := kr.Open([]byte("plain"), ad); err != nil || string(out) != "plain" {
+		t.Fatalf("expected unsealed values to pass through, got: %q, %v", out, err)
+	}
+
+	t.Run("rotation", func(t *testing.T) {
+		k := kr.Rotate()
+		if k.ID != 2 || kr.Active() != k {
+			t.Fatalf("expected second key to become active, got: %d", k.ID)
+		}
+
+		if out, err := kr.Open(sealed, ad); err != nil || string(out) != "hello" {
+			t.Fatalf("expected old values to remain readable: %v", err)
+		}
+
+		other := NewKeyring()
+		if _, err := other.Open(kr.Seal([]byte("hello"), ad), ad); err != ErrUnknownKey {
+			t.Fatalf("expected unknown key, got: %v", err)
+		}
+	})
+
+	t.Run("convergent", func(t *testing.T) {
+		a, b := kr.SealConvergent([]byte("chunk"), ad), kr.SealConvergent([]byte("chunk"), ad)
+		if !bytes.Equal(a, b) {
+			t.Fatal("expected convergent encryption to be deterministic")
+		}
+
+		if bytes.Equal(a, NewKeyring().SealConvergent([]byte("chunk"), ad)) {
+			t.Fatal("expected convergent encryption to depend on the keyring")
+		}
+
+		if out, err := kr.Open(a, ad); err != nil || string(out) != "chunk" {
+			t.Fatalf("failed to open convergent value: %v", err)
+		}
+
+		c := kr.SealConvergent([]byte("other"), ad)
+		nonce := func(v []byte) []byte { return v[len(magic)+1 : len(magic)+13] }
+		if bytes.Equal(nonce(a), nonce(c)) {
+			t.Fatal("expected different values under the same address to use different nonces")
+		}
+
+		if out, err := kr.Open(c, ad); err != nil || string(out) != "other" {
+			t.Fatalf("failed to open convergent value: %v", err)
+		}
+	})
+}
+
+//mapKV is a key-value store in memory
+type mapKV map[string][]byte
+
+func (m mapKV) Get(ctx context.Context, key []byte) ([]byte, error) {
+	v, ok := m[string(key)]
+	if !ok {
+		return nil, kv.NotFoundError{Key: key}
+	}
+
+	return v, nil
+}
+
+func (m mapKV) Put(ctx context.Context, key, value []byte) error {
+	m[string(key)] = value
+	return nil
+}
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+	inner, kr := mapKV{}, NewKeyring()
+	ckv := NewKV(inner, kr, false)
+	if err := ckv.Put(ctx, []byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := inner["key"]; ok || len(inner) != 1 {
+		t.Fatal("expected content address to be hidden from the store")
+	}
+
+	if out, err := ckv.Get(ctx, []byte("key")); err != nil || string(out) != "value" {
+		t.Fatalf("failed to get value: %q, %v", out, err)
+	}
+
+	if _, err := ckv.Get(ctx, []byte("other")); err == nil {
+		t.Fatal("expected not found error")
+	} else if nferr, ok := err.(kv.NotFoundError); !ok || string(nferr.Key) != "other" {
+		t.Fatalf("expected not found error for the requested key, got: %v", err)
+	}
+
+	if _, err := NewKV(inner, NewKeyring(), false).Get(ctx, []byte("key")); err == nil {
+		t.Fatal("expected a different keyring to not find the value")
+	}
+
+	cinner := mapKV{}
+	conv := NewKV(cinner, kr, true)
+	if err := conv.Put(ctx, []byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := conv.Get(ctx, []byte("key")); err != nil || string(out) != "value" {
+		t.Fatalf("failed to get convergent value: %q, %v", out, err)
+	}
+
+	cinner["plain"] = []byte("value")
+	if _, err := conv.Get(ctx, []byte("plain")); err == nil {
+		t.Fatal("expected unencrypted chunks to be rejected")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffs_keys_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vol.json")
+	if _, err = Load(path); Cause(err) != ErrKeyMissing {
+		t.Fatalf("expected missing key, got: %v", err)
+	}
+
+	kr := NewKeyring()
+	kr.Rotate()
+	if err = kr.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected keyring to be private, got: %v, %v", fi.Mode(), err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Fingerprint() != kr.Fingerprint() || len(loaded.Keys) != 2 {
+		t.Fatalf("expected keyring to be loaded, got: %s", loaded.Fingerprint())
+	}
+}
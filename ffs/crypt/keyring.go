@@ -0,0 +1,193 @@
+//Package crypt encrypts data at rest with keys that are kept outside of the
+//stores they protect
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//KeySize is the size of all keys, AES-256 is used for encryption
+const KeySize = 32
+
+var (
+	//ErrKeyMissing is returned when the keyring of an encrypted volume can't be found
+	ErrKeyMissing = errors.New("encryption key is missing")
+
+	//ErrWrongKey is returned when a keyring doesn't belong to the data it should decrypt
+	ErrWrongKey = errors.New("encryption key doesn't match")
+
+	//ErrUnknownKey is returned when data was encrypted with a key that is not in the keyring
+	ErrUnknownKey = errors.New("data is encrypted with a key that is not in the keyring")
+
+	//ErrDecrypt is returned when data fails to authenticate, it was modified or corrupted
+	ErrDecrypt = errors.New("failed to decrypt: data is corrupt or was tampered with")
+)
+
+//Key encrypts data, keys are never removed from a keyring such that data
+//encrypted before a rotation can still be read
+type Key struct {
+	ID      uint32    `json:"id"`
+	Secret  []byte    `json:"secret"`
+	Created time.Time `json:"created"`
+}
+
+//Keyring holds the keys of a volume. The name and convergence keys never
+//change as they determine where data is stored, data keys can be rotated.
+type Keyring struct {
+	NameKey        []byte `json:"name_key"`
+	ConvergenceKey []byte `json:"convergence_key"`
+	Keys           []*Key `json:"keys"`
+
+	mu sync.RWMutex
+}
+
+func random() []byte {
+	b := make([]byte, KeySize)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypt: failed to read random bytes: " + err.Error())
+	}
+
+	return b
+}
+
+//NewKeyring generates a keyring with random keys
+func NewKeyring() *Keyring {
+	kr := &Keyring{NameKey: random(), ConvergenceKey: random()}
+	kr.Rotate()
+	return kr
+}
+
+//Rotate adds a new key that is used to encrypt data from now on
+func (kr *Keyring) Rotate() *Key {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	k := &Key{ID: 1, Secret: random(), Created: time.Now()}
+	if len(kr.Keys) > 0 {
+		k.ID = kr.Keys[len(kr.Keys)-1].ID + 1
+	}
+
+	kr.Keys = append(kr.Keys, k)
+	return k
+}
+
+//Active returns the key that new data is encrypted with
+func (kr *Keyring) Active() *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.Keys[len(kr.Keys)-1]
+}
+
+//Key returns the key with the provided id or nil if it isn't in the keyring
+func (kr *Keyring) Key(id uint32) *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	for _, k := range kr.Keys {
+		if k.ID == id {
+			return k
+		}
+	}
+
+	return nil
+}
+
+//Fingerprint identifies the keyring without revealing its keys, it doesn't
+//change when keys are rotated
+func (kr *Keyring) Fingerprint() string {
+	return hex.EncodeToString(mac(kr.NameKey, []byte("ffs keyring fingerprint"))[:16])
+}
+
+func mac(key []byte, d ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, b := range d {
+		h.Write(b)
+	}
+
+	return h.Sum(nil)
+}
+
+func (kr *Keyring) validate() error {
+	if len(kr.NameKey) != KeySize || len(kr.ConvergenceKey) != KeySize || len(kr.Keys) < 1 {
+		return errors.New("invalid keyring")
+	}
+
+	for _, k := range kr.Keys {
+		if len(k.Secret) != KeySize {
+			return fmt.Errorf("invalid key %d in keyring", k.ID)
+		}
+	}
+
+	return nil
+}
+
+//Load reads the keyring at path, it returns an error that wraps
+//ErrKeyMissing with the path when it doesn't exist
+func Load(path string) (kr *Keyring, err error) {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, &PathError{Path: path, Err: ErrKeyMissing}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %v", err)
+	}
+
+	kr = &Keyring{}
+	if err = json.Unmarshal(d, kr); err != nil {
+		return nil, fmt.Errorf("failed to decode keyring '%s': %v", path, err)
+	}
+
+	if err = kr.validate(); err != nil {
+		return nil, &PathError{Path: path, Err: err}
+	}
+
+	return kr, nil
+}
+
+//Save writes the keyring to path, only the current user can read it
+func (kr *Keyring) Save(path string) (err error) {
+	kr.mu.RLock()
+	d, err := json.MarshalIndent(kr, "", "  ")
+	kr.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode keyring: %v", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key dir: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, d, 0600); err != nil {
+		return fmt.Errorf("failed to write keyring: %v", err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+//PathError records the keyring an error is about
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, e.Path)
+}
+
+//Cause returns the underlying error
+func Cause(err error) error {
+	if perr, ok := err.(*PathError); ok {
+		return perr.Err
+	}
+
+	return err
+}
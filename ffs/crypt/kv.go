@@ -0,0 +1,105 @@
+package crypt
+
+import (
+	"context"
+	"fmt"
+
+	"bazil.org/bazil/kv"
+)
+
+//KV encrypts the values of a wrapped key-value store. By default keys are
+//replaced by a keyed hash such that the store doesn't reveal content
+//addresses. In convergent mode the keys are left alone and values are
+//encrypted deterministically so identical chunks are stored once, also
+//across volumes that share the convergence key.
+type KV struct {
+	kv         kv.KV
+	kr         *Keyring
+	convergent bool
+}
+
+//NewKV wraps inner such that its values are encrypted with keys of kr
+func NewKV(inner kv.KV, kr *Keyring, convergent bool) *KV {
+	return &KV{kv: inner, kr: kr, convergent: convergent}
+}
+
+func (c *KV) name(key []byte) []byte {
+	if c.convergent {
+		return key
+	}
+
+	return mac(c.kr.NameKey, key)
+}
+
+func (c *KV) Get(ctx context.Context, key []byte) ([]byte, error) {
+	value, err := c.kv.Get(ctx, c.name(key))
+	if err != nil {
+		if _, ok := err.(kv.NotFoundError); ok {
+			return nil, kv.NotFoundError{Key: key}
+		}
+
+		return nil, err
+	}
+
+	if !IsSealed(value) {
+		return nil, fmt.Errorf("chunk is not encrypted: %v", ErrDecrypt)
+	}
+
+	return c.kr.Open(value, key)
+}
+
+func (c *KV) Put(ctx context.Context, key, value []byte) error {
+	if c.convergent {
+		return c.kv.Put(ctx, key, c.kr.SealConvergent(value, key))
+	}
+
+	return c.kv.Put(ctx, c.name(key), c.kr.Seal(value, key))
+}
+
+//Close closes the wrapped store if it can be closed
+func (c *KV) Close() error {
+	if cl, ok := c.kv.(interface {
+		Close() error
+	}); ok {
+		return cl.Close()
+	}
+
+	return nil
+}
+
+//Has returns whether a value is stored under key without decrypting it, the
+//wrapped store is asked directly if it can tell without reading the value
+func (c *KV) Has(ctx context.Context, key []byte) (bool, error) {
+	if h, ok := c.kv.(interface {
+		Has(ctx context.Context, key []byte) (bool, error)
+	}); ok {
+		return h.Has(ctx, c.name(key))
+	}
+
+	_, err := c.kv.Get(ctx, c.name(key))
+	if _, ok := err.(kv.NotFoundError); ok {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+//Quarantine moves the value of key aside if the wrapped store supports it
+func (c *KV) Quarantine(ctx context.Context, key []byte) error {
+	q, ok := c.kv.(interface {
+		Quarantine(ctx context.Context, key []byte) error
+	})
+	if !ok {
+		return fmt.Errorf("wrapped store doesn't support quarantine")
+	}
+
+	if err := q.Quarantine(ctx, c.name(key)); err != nil {
+		if _, ok := err.(kv.NotFoundError); ok {
+			return kv.NotFoundError{Key: key}
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+//magic marks encrypted values, values without it were stored before
+//encryption was enabled
+var magic = []byte{0x00, 'f', 'f', 'e'}
+
+//modes of encrypted values
+const (
+	modeKeyed      = 'k' //encrypted with a key of the keyring and a random nonce
+	modeConvergent = 'c' //encrypted with a key derived from the content address
+)
+
+func aead(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("crypt: " + err.Error()) //keys are validated to be 32 bytes
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("crypt: " + err.Error())
+	}
+
+	return gcm
+}
+
+//IsSealed returns whether value was sealed, values stored before
+//encryption was enabled are not
+func IsSealed(value []byte) bool {
+	return len(value) > len(magic) && bytes.Equal(value[:len(magic)], magic)
+}
+
+//Seal encrypts and authenticates value with the active key, ad is
+//authenticated but not encrypted and must be provided to open it again
+func (kr *Keyring) Seal(value, ad []byte) []byte {
+	k := kr.Active()
+	gcm := aead(k.Secret)
+	hdr := make([]byte, len(magic)+1+4+gcm.NonceSize())
+	copy(hdr, magic)
+	hdr[len(magic)] = modeKeyed
+	binary.BigEndian.PutUint32(hdr[len(magic)+1:], k.ID)
+	nonce := hdr[len(magic)+5:]
+	if _, err := rand.Read(nonce); err != nil {
+		panic("crypt: failed to read random nonce: " + err.Error())
+	}
+
+	return gcm.Seal(hdr, nonce, value, ad)
+}
+
+//Open decrypts a value that was sealed with Seal or SealConvergent, values
+//that were never sealed are returned as is
+func (kr *Keyring) Open(value, ad []byte) ([]byte, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	var gcm cipher.AEAD
+	rest := value[len(magic)+1:]
+	switch value[len(magic)] {
+	case modeKeyed:
+		if len(rest) < 4 {
+			return nil, ErrDecrypt
+		}
+
+		k := kr.Key(binary.BigEndian.Uint32(rest))
+		if k == nil {
+			return nil, ErrUnknownKey
+		}
+
+		gcm, rest = aead(k.Secret), rest[4:]
+	case modeConvergent:
+		gcm = aead(mac(kr.ConvergenceKey, ad))
+	default:
+		return nil, ErrDecrypt
+	}
+
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plain, nil
+}
+
+//SealConvergent encrypts value with a key that is derived from ad, the same
+//value and ad always result in the same ciphertext such that it can be
+//deduplicated. It must only be used when ad is the content address of value.
+func (kr *Keyring) SealConvergent(value, ad []byte) []byte {
+	gcm := aead(mac(kr.ConvergenceKey, ad))
+	hdr := make([]byte, len(magic)+1+gcm.NonceSize())
+	copy(hdr, magic)
+	hdr[len(magic)] = modeConvergent
+
+	//the same address can be sealed with different bytes, for example when
+	//the compression of a volume changes, so the nonce is derived from the
+	//sealed bytes as well as the key would be used twice otherwise
+	sum := sha256.Sum256(value)
+	nonce := hdr[len(magic)+1:]
+	copy(nonce, mac(mac(kr.ConvergenceKey, []byte("nonce")), ad, sum[:]))
+	return gcm.Seal(hdr, nonce, value, ad)
+}
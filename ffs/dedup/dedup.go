@@ -0,0 +1,210 @@
+//Package dedup implements uploads that only transfer content the server
+//doesn't have yet. The client splits and hashes content locally exactly like
+//the server would, asks which chunks are missing, sends only those and then
+//commits the manifest that ties the chunks together as the file's content.
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/scrub"
+)
+
+//Defaults match the chunking parameters of new nodes on the server
+const (
+	DefaultChunkSize = 4 * 1024 * 1024
+	DefaultFanout    = 64
+)
+
+//Ref identifies a chunk in a content addressed chunk store
+type Ref struct {
+	Key   []byte
+	Type  string
+	Level uint8
+}
+
+//Chunk is a chunk with its content
+type Chunk struct {
+	Ref
+	Buf []byte
+}
+
+//Manifest describes how chunks make up the content of a file
+type Manifest struct {
+	Root      []byte
+	Size      uint64
+	ChunkSize uint32
+	Fanout    uint32
+}
+
+//NewManifest converts a blob manifest to its wire format
+func NewManifest(m *blobs.Manifest) Manifest {
+	return Manifest{Root: m.Root.Bytes(), Size: m.Size, ChunkSize: m.ChunkSize, Fanout: m.Fanout}
+}
+
+//Blob converts the manifest back to a blob manifest
+func (m Manifest) Blob() *blobs.Manifest {
+	return &blobs.Manifest{Type: "blob", Root: cas.NewKey(m.Root), Size: m.Size, ChunkSize: m.ChunkSize, Fanout: m.Fanout}
+}
+
+//recorder is a chunk store that passes the content chunks that are added to
+//it on in batches, only the chunks that index them are kept in memory
+type recorder struct {
+	mu      sync.Mutex
+	seen    map[cas.Key]bool
+	index   map[cas.Key]*chunks.Chunk
+	pending []Chunk
+	size    int
+}
+
+func (r *recorder) Get(ctx context.Context, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.index[key]
+	if !ok || c.Type != typ || c.Level != level {
+		return nil, &chunks.NotFoundError{Type: typ, Level: level, Key: key}
+	}
+
+	return c, nil
+}
+
+func (r *recorder) Add(ctx context.Context, c *chunks.Chunk) (key cas.Key, err error) {
+	key = chunks.Hash(c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := make([]byte, len(c.Buf))
+	copy(buf, c.Buf)
+	if c.Level > 0 {
+		r.index[key] = &chunks.Chunk{Type: c.Type, Level: c.Level, Buf: buf}
+		return key, nil
+	}
+
+	if !r.seen[key] {
+		r.seen[key] = true
+		r.pending = append(r.pending, Chunk{Ref: Ref{Key: key.Bytes(), Type: c.Type, Level: c.Level}, Buf: buf})
+		r.size += len(buf)
+	}
+
+	return key, nil
+}
+
+//flush passes the pending content chunks to f
+func (r *recorder) flush(f func(cs []Chunk) error) error {
+	r.mu.Lock()
+	cs := r.pending
+	r.pending, r.size = nil, 0
+	r.mu.Unlock()
+	if len(cs) < 1 {
+		return nil
+	}
+
+	return f(cs)
+}
+
+//tree returns the index chunks below ref, saving the blob along the way
+//leaves index chunks behind that the final manifest doesn't refer to
+func (r *recorder) tree(ref scrub.Ref) (cs []Chunk) {
+	c, ok := r.index[ref.Key]
+	if !ok || ref.Level < 1 {
+		return nil
+	}
+
+	cs = append(cs, Chunk{Ref: Ref{Key: ref.Key.Bytes(), Type: c.Type, Level: c.Level}, Buf: c.Buf})
+	if ref.Level < 2 {
+		return cs
+	}
+
+	//stores may trim trailing zeros of the keys in a chunk
+	buf := c.Buf
+	if rem := len(buf) % cas.KeySize; rem != 0 {
+		buf = append(buf, make([]byte, cas.KeySize-rem)...)
+	}
+
+	for ; len(buf) > 0; buf = buf[cas.KeySize:] {
+		key := cas.NewKey(buf[:cas.KeySize])
+		if !key.IsSpecial() {
+			cs = append(cs, r.tree(scrub.Ref{Key: key, Type: ref.Type, Level: ref.Level - 1})...)
+		}
+	}
+
+	return cs
+}
+
+//Split chunks and hashes the content read from rd into the manifest the
+//server would produce for the same content and parameters. The chunks are
+//passed to f in batches of about MaxBatch bytes while the content is read,
+//the chunks that index them come last, such that the content never has to
+//be in memory as a whole.
+func Split(rd io.Reader, chunkSize, fanout uint32, f func(cs []Chunk) error) (m Manifest, err error) {
+	ctx := context.Background()
+	rec := &recorder{seen: map[cas.Key]bool{}, index: map[cas.Key]*chunks.Chunk{}}
+	bm := &blobs.Manifest{Type: "blob", ChunkSize: chunkSize, Fanout: fanout}
+	blob, err := blobs.Open(rec, bm)
+	if err != nil {
+		return m, fmt.Errorf("failed to open blob: %v", err)
+	}
+
+	//the blob is saved whenever a batch worth of chunks was written, always at
+	//the end of a chunk such that written chunks are never read back
+	save := func() (err error) {
+		if bm, err = blob.Save(ctx); err != nil {
+			return fmt.Errorf("failed to save blob: %v", err)
+		}
+
+		if err = rec.flush(f); err != nil {
+			return err
+		}
+
+		if blob, err = blobs.Open(rec, bm); err != nil {
+			return fmt.Errorf("failed to open blob: %v", err)
+		}
+
+		return nil
+	}
+
+	bio := blob.IO(ctx)
+	buf := make([]byte, chunkSize)
+	ofst, saved := int64(0), int64(0)
+	for {
+		n, rerr := io.ReadFull(rd, buf)
+		if n > 0 {
+			if _, err = bio.WriteAt(buf[:n], ofst); err != nil {
+				return m, fmt.Errorf("failed to write blob: %v", err)
+			}
+
+			ofst += int64(n)
+		}
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			return m, fmt.Errorf("failed to read content: %v", rerr)
+		}
+
+		if ofst-saved >= int64(MaxBatch) {
+			if err = save(); err != nil {
+				return m, err
+			}
+
+			bio, saved = blob.IO(ctx), ofst
+		}
+	}
+
+	if err = save(); err != nil {
+		return m, err
+	}
+
+	if cs := rec.tree(scrub.Root(bm)); len(cs) > 0 {
+		if err = f(cs); err != nil {
+			return m, err
+		}
+	}
+
+	return NewManifest(bm), nil
+}
@@ -0,0 +1,91 @@
+package dedup
+
+import (
+	"fmt"
+	"io"
+)
+
+//MaxBatch bounds the nr of bytes that are sent to the server per request
+var MaxBatch = 16 * 1024 * 1024
+
+//Remote is the server side of the upload protocol, error codes are negative
+//fuse error numbers
+type Remote interface {
+	//MissingChunks returns the indexes of the refs the server doesn't have
+	MissingChunks(refs []Ref) (int, []int)
+
+	//PutChunks stores chunks, their keys are verified by the server
+	PutChunks(chunks []Chunk) int
+
+	//CommitManifest makes the manifest the content of the file at path
+	CommitManifest(path string, m Manifest) int
+}
+
+//Stats describes how much of an upload was actually transferred
+type Stats struct {
+	Chunks    int   `json:"chunks"`
+	Sent      int   `json:"sent"`
+	Bytes     int64 `json:"bytes"`
+	SentBytes int64 `json:"sent_bytes"`
+}
+
+//Upload replaces the content of the existing file at path with the content
+//read from rd while only sending the chunks remote is missing. Content is
+//split and sent in batches so it is never read in memory as a whole.
+func Upload(remote Remote, path string, rd io.Reader) (stats Stats, err error) {
+	m, err := Split(rd, DefaultChunkSize, DefaultFanout, func(cs []Chunk) error {
+		refs := make([]Ref, len(cs))
+		for i, c := range cs {
+			refs[i] = c.Ref
+			stats.Chunks++
+			stats.Bytes += int64(len(c.Buf))
+		}
+
+		errc, missing := remote.MissingChunks(refs)
+		if errc != 0 {
+			return fmt.Errorf("failed to determine missing chunks: %d", errc)
+		}
+
+		batch, size := []Chunk{}, 0
+		flush := func() error {
+			if len(batch) < 1 {
+				return nil
+			}
+
+			if errc := remote.PutChunks(batch); errc != 0 {
+				return fmt.Errorf("failed to put chunks: %d", errc)
+			}
+
+			batch, size = batch[:0], 0
+			return nil
+		}
+
+		for _, idx := range missing {
+			if idx < 0 || idx >= len(cs) {
+				return fmt.Errorf("server reported invalid missing chunk %d", idx)
+			}
+
+			c := cs[idx]
+			if size+len(c.Buf) > MaxBatch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+			batch, size = append(batch, c), size+len(c.Buf)
+			stats.Sent++
+			stats.SentBytes += int64(len(c.Buf))
+		}
+
+		return flush()
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if errc := remote.CommitManifest(path, m); errc != 0 {
+		return stats, fmt.Errorf("failed to commit manifest: %d", errc)
+	}
+
+	return stats, nil
+}
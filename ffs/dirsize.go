@@ -0,0 +1,135 @@
+package ffs
+
+import (
+	"encoding/json"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//DirSizeXAttr is the read-only extended attribute that exposes the aggregate
+//size, file and directory count of a directory as JSON
+const DirSizeXAttr = "user.dfs.dirsize"
+
+//subtree returns what node adds to the aggregates of the directories above
+//it: itself and, for directories, everything below it
+func subtree(tx fdb.Transaction, node *nodes.Node) (ds nodes.DirSize) {
+	st := node.Stat(tx)
+	if fuse.S_IFDIR == st.Mode&fuse.S_IFMT {
+		ds = node.DirSize(tx)
+		ds.Dirs++
+		return ds
+	}
+
+	ds.Size, ds.Files = st.Size, 1
+	return ds
+}
+
+//account adds delta to the aggregates of every directory above path and
+//returns the directory the path ends in with the name it has there. Files
+//with multiple links are accounted to the path they are written through.
+func (self *Memfs) account(tx fdb.Transaction, path string, delta nodes.DirSize) (prnt *nodes.Node, name string) {
+	if "" == path {
+		return nil, "" //operations on a handle without a path can't be accounted
+	}
+
+	node := self.nstore.Root(tx)
+	comps := []string{}
+	for _, c := range split(path) {
+		if "" != c {
+			comps = append(comps, c)
+		}
+	}
+
+	node.AddDirSize(tx, delta)
+	for i := 0; i < len(comps)-1; i++ {
+		node = node.GetChld(tx, comps[i])
+		if nil == node {
+			return nil, ""
+		}
+
+		node.AddDirSize(tx, delta)
+	}
+
+	if 0 == len(comps) {
+		return nil, ""
+	}
+
+	return node, comps[len(comps)-1]
+}
+
+//contribution returns what the link of node in prnt under name added to the
+//aggregates above it, rec reports whether that was recorded for the link.
+//Links of files that were written through another link since contribute
+//what they were last accounted with, not the current size.
+func contribution(tx fdb.Transaction, prnt *nodes.Node, name string, node *nodes.Node) (ds nodes.DirSize, rec bool) {
+	if ds, rec = node.LinkSize(tx, prnt.Ino(), name); rec {
+		return ds, true
+	}
+
+	return subtree(tx, node), false
+}
+
+//linked accounts for node being linked in prnt under name at path. What the
+//links of files with more than one link contribute is recorded such that
+//removing one subtracts exactly that.
+func (self *Memfs) linked(tx fdb.Transaction, path string, prnt *nodes.Node, name string, node *nodes.Node) {
+	ds := subtree(tx, node)
+	if sta := node.Stat(tx); fuse.S_IFDIR != sta.Mode&fuse.S_IFMT && 1 < sta.Nlink {
+		node.SetLinkSize(tx, prnt.Ino(), name, ds)
+	}
+
+	self.account(tx, path, ds)
+}
+
+//recordLink records what the existing link of node in prnt under name
+//contributes before another link to it is made
+func recordLink(tx fdb.Transaction, prnt *nodes.Node, name string, node *nodes.Node) {
+	if fuse.S_IFDIR == node.Stat(tx).Mode&fuse.S_IFMT {
+		return
+	}
+
+	if _, rec := node.LinkSize(tx, prnt.Ino(), name); !rec {
+		node.SetLinkSize(tx, prnt.Ino(), name, subtree(tx, node))
+	}
+}
+
+//negate returns the delta that undoes ds
+func negate(ds nodes.DirSize) nodes.DirSize {
+	return nodes.DirSize{Size: -ds.Size, Files: -ds.Files, Dirs: -ds.Dirs}
+}
+
+//dirSizeXAttr returns the JSON encoded aggregates of a directory node or nil
+func (self *Memfs) dirSizeXAttr(tx fdb.Transaction, node *nodes.Node) []byte {
+	if fuse.S_IFDIR != node.Stat(tx).Mode&fuse.S_IFMT {
+		return nil
+	}
+
+	d, err := json.Marshal(node.DirSize(tx))
+	if err != nil {
+		return nil
+	}
+
+	return d
+}
+
+//DirSize returns the aggregate size, file and directory count below the
+//directory at path without walking the tree
+func (self *Memfs) DirSize(path string) (errc int, ds nodes.DirSize) {
+	defer trace(path)(&errc, &ds)
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, path, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+		if fuse.S_IFDIR != node.Stat(tx).Mode&fuse.S_IFMT {
+			return -fuse.ENOTDIR
+		}
+
+		ds = node.DirSize(tx)
+		return 0
+	})
+
+	return errc, ds
+}
@@ -0,0 +1,244 @@
+package events
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//Event describes a single mutation of the filesystem namespace or content
+type Event struct {
+	//Cursor positions a reader right after this event in the log
+	Cursor string `json:"cursor"`
+
+	//Version is the FDB version at which the mutation was committed
+	Version int64 `json:"version"`
+
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	NewPath string    `json:"new_path,omitempty"`
+	Ino     uint64    `json:"ino"`
+	Actor   string    `json:"actor"`
+	Time    time.Time `json:"time"`
+}
+
+//stampLen is the length of a versionstamp, seqLen the length of the sequence
+//that orders events that are appended in the same transaction
+const (
+	stampLen = 10
+	seqLen   = 8
+)
+
+//Store keeps an ordered log of events, the log is keyed by the versionstamp
+//of the transaction that appended them such that readers see the events in
+//commit order.
+type Store struct {
+	tr fdb.Transactor
+	ss subspace.Subspace
+}
+
+func NewStore(tr fdb.Transactor, ss subspace.Subspace) *Store {
+	return &Store{tr: tr, ss: ss}
+}
+
+func (s *Store) log() subspace.Subspace {
+	return s.ss.Sub("log")
+}
+
+//WatchKey returns the key that changes whenever an event is appended
+func (s *Store) WatchKey() fdb.Key {
+	return s.ss.Pack(tuple.Tuple{"ver"})
+}
+
+//nextSeq returns a number that increases for every event appended in a
+//transaction. It is read as a snapshot so concurrent transactions don't
+//conflict on it, uniqueness across transactions comes from the versionstamp.
+func (s *Store) nextSeq(tx fdb.Transaction) (seq uint64) {
+	k := s.ss.Pack(tuple.Tuple{"seq"})
+	d := tx.Snapshot().Get(k).MustGet()
+	if len(d) == seqLen {
+		seq = binary.BigEndian.Uint64(d)
+	}
+
+	b := make([]byte, seqLen)
+	binary.BigEndian.PutUint64(b, seq+1)
+	tx.Set(k, b)
+	return seq
+}
+
+//Append adds event e to the log as part of transaction tx, the cursor and
+//version of e are only known once the transaction commits.
+func (s *Store) Append(tx fdb.Transaction, e *Event) {
+	prefix := s.log().Bytes()
+	key := make([]byte, len(prefix), len(prefix)+stampLen+seqLen+2)
+	copy(key, prefix)
+	key = append(key, make([]byte, stampLen)...)
+	seq := make([]byte, seqLen)
+	binary.BigEndian.PutUint64(seq, s.nextSeq(tx))
+	key = append(key, seq...)
+
+	//the position of the versionstamp, before api version 520 this takes two bytes
+	pos := make([]byte, 2)
+	binary.LittleEndian.PutUint16(pos, uint16(len(prefix)))
+	key = append(key, pos...)
+
+	tx.SetVersionstampedKey(fdb.Key(key), tuple.Tuple{
+		e.Op, e.Path, e.NewPath, int64(e.Ino), e.Actor, e.Time.UnixNano(),
+	}.Pack())
+
+	tx.Add(s.WatchKey(), []byte{0x01, 0, 0, 0, 0, 0, 0, 0})
+}
+
+//SetRetention configures how long events are kept in the log, zero keeps
+//them until they are trimmed explicitly
+func (s *Store) SetRetention(tx fdb.Transaction, age time.Duration) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(age))
+	tx.Set(s.ss.Pack(tuple.Tuple{"retention"}), b)
+}
+
+//Retention returns how long events are kept in the log
+func (s *Store) Retention(tx fdb.Transaction) time.Duration {
+	d := tx.Get(s.ss.Pack(tuple.Tuple{"retention"})).MustGet()
+	if len(d) != 8 {
+		return 0
+	}
+
+	return time.Duration(binary.BigEndian.Uint64(d))
+}
+
+//Trim removes up to limit of the oldest events that were appended before t,
+//it returns how many were removed. Readers with a cursor into the trimmed
+//part of the log continue at the oldest event that is left.
+func (s *Store) Trim(tx fdb.Transaction, t time.Time, limit int) (n int, err error) {
+	rng, _ := s.after("")
+	kvs := tx.GetRange(rng, fdb.RangeOptions{Limit: limit}).GetSliceOrPanic()
+	for _, kv := range kvs {
+		e, err := s.unpack(kv)
+		if err != nil {
+			return n, err
+		}
+
+		if !e.Time.Before(t) {
+			break
+		}
+
+		n++
+	}
+
+	if n > 0 {
+		tx.ClearRange(fdb.KeyRange{Begin: rng.Begin, End: fdb.Key(append(append([]byte{}, kvs[n-1].Key...), 0x00))})
+	}
+
+	return n, nil
+}
+
+//unpack decodes an event from a log key and value
+func (s *Store) unpack(kv fdb.KeyValue) (e *Event, err error) {
+	pos := s.log().Bytes()
+	if len(kv.Key) != len(pos)+stampLen+seqLen {
+		return nil, fmt.Errorf("unexpected event key length: %d", len(kv.Key))
+	}
+
+	t, err := tuple.Unpack(kv.Value)
+	if err != nil || len(t) != 6 {
+		return nil, fmt.Errorf("failed to unpack event: %v", err)
+	}
+
+	stamp := kv.Key[len(pos):]
+	e = &Event{
+		Cursor:  hex.EncodeToString(stamp),
+		Version: int64(binary.BigEndian.Uint64(stamp[:8])),
+	}
+
+	e.Op, _ = t[0].(string)
+	e.Path, _ = t[1].(string)
+	e.NewPath, _ = t[2].(string)
+	ino, _ := t[3].(int64)
+	e.Ino = uint64(ino)
+	e.Actor, _ = t[4].(string)
+	nsec, _ := t[5].(int64)
+	e.Time = time.Unix(0, nsec)
+	return e, nil
+}
+
+//after returns the range of the log that comes after cursor, an empty
+//cursor denotes the start of the log
+func (s *Store) after(cursor string) (rng fdb.KeyRange, err error) {
+	begin, end := s.log().FDBRangeKeys()
+	rng = fdb.KeyRange{Begin: begin, End: end}
+	if cursor == "" {
+		return rng, nil
+	}
+
+	pos, err := hex.DecodeString(cursor)
+	if err != nil || len(pos) != stampLen+seqLen {
+		return rng, fmt.Errorf("invalid cursor '%s'", cursor)
+	}
+
+	rng.Begin = fdb.Key(append(append(s.log().Bytes(), pos...), 0x00))
+	return rng, nil
+}
+
+func (s *Store) read(tx fdb.ReadTransaction, cursor string, limit int) (evs []*Event, err error) {
+	rng, err := s.after(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	kvs := tx.GetRange(rng, fdb.RangeOptions{Limit: limit}).GetSliceOrPanic()
+	for _, kv := range kvs {
+		e, err := s.unpack(kv)
+		if err != nil {
+			return nil, err
+		}
+
+		evs = append(evs, e)
+	}
+
+	return evs, nil
+}
+
+//Read returns up to limit events (0 for no limit) that were appended after
+//cursor, the cursor of the last event can be used to continue reading.
+func (s *Store) Read(cursor string, limit int) (evs []*Event, err error) {
+	_, err = s.tr.ReadTransact(func(tx fdb.ReadTransaction) (r interface{}, err error) {
+		evs, err = s.read(tx, cursor, limit)
+		return
+	})
+
+	return evs, err
+}
+
+//Next is like Read but if there are no events after cursor yet it waits for
+//at most timeout for new events to be appended.
+func (s *Store) Next(cursor string, limit int, timeout time.Duration) (evs []*Event, err error) {
+	deadline := time.After(timeout)
+	for {
+		var w fdb.FutureNil
+		if _, err = s.tr.Transact(func(tx fdb.Transaction) (r interface{}, err error) {
+			evs, err = s.read(tx, cursor, limit)
+			if err == nil && len(evs) == 0 {
+				w = tx.Watch(s.WatchKey())
+			}
+
+			return
+		}); err != nil || len(evs) > 0 {
+			return evs, err
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- w.Get() }()
+		select {
+		case <-done:
+		case <-deadline:
+			w.Cancel()
+			return nil, nil
+		}
+	}
+}
@@ -1,17 +1,21 @@
 package ffs
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"os"
 	"strings"
+	"time"
 
 	"bazil.org/bazil/cas/chunks"
-	"bazil.org/bazil/cas/chunks/kvchunks"
-	"bazil.org/bazil/kv/kvfiles"
+	"github.com/advanderveer/dfs/ffs/chunkstore"
+	"github.com/advanderveer/dfs/ffs/events"
 	"github.com/advanderveer/dfs/ffs/handles"
+	"github.com/advanderveer/dfs/ffs/locks"
 	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/advanderveer/dfs/ffs/quota"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
@@ -19,6 +23,22 @@ import (
 	"github.com/billziss-gh/cgofuse/fuse"
 )
 
+//lockPoll bounds how long a blocked lock request waits for a notification
+//before it checks the lock state again
+var lockPoll = time.Second * 5
+
+//readable returns whether open flags allow reading from the handle
+func readable(flags int) bool {
+	acc := flags & fuse.O_ACCMODE
+	return fuse.O_RDONLY == acc || fuse.O_RDWR == acc
+}
+
+//writable returns whether open flags allow writing to the handle
+func writable(flags int) bool {
+	acc := flags & fuse.O_ACCMODE
+	return fuse.O_WRONLY == acc || fuse.O_RDWR == acc
+}
+
 func split(path string) []string {
 	return strings.Split(path, "/")
 }
@@ -33,55 +53,107 @@ type Memfs struct {
 	nstore *nodes.Store
 	cstore chunks.Store
 	hstore *handles.Store
+	lstore *locks.Store
+	estore *events.Store
+	qstore *quota.Store
 	getctx func() (uint32, uint32, int)
+	sess   string
+	actor  string
+}
+
+//Session returns a view on the filesystem for which all handles that are
+//opened are owned by the session with the provided id
+func (self *Memfs) Session(id string) *Memfs {
+	sess := *self
+	sess.sess = id
+	return &sess
+}
+
+//EndSession releases all handles and locks that are still owned by the
+//session with the provided id, it is called when a client goes away.
+func (self *Memfs) EndSession(id string) (errc int) {
+	defer trace(id)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		self.lstore.ReleaseSession(tx, id)
+
+		fhs := []uint64{}
+		self.hstore.Each(tx, func(hndl *handles.Handle) (stop bool) {
+			if hndl.Owner == id {
+				fhs = append(fhs, hndl.ID)
+			}
+
+			return
+		})
+
+		//the paths the handles were opened at are not known anymore
+		for _, fh := range fhs {
+			self.closeNode(tx, "", fh)
+		}
+
+		self.hstore.EndSession(tx, id)
+		return 0
+	})
 }
 
 func (self *Memfs) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
 	defer trace(path, stat)(&errc)
 
-	//https://github.com/SerCeMan/jnr-fuse/issues/16#issuecomment-323511739
-	//VolumeInfo->TotalSize = (UINT64)stbuf.f_blocks * (UINT64)stbuf.f_frsize;
-	//VolumeInfo->FreeSize = (UINT64)stbuf.f_bfree * (UINT64)stbuf.f_frsize;
-	stat.Frsize = 1024           // Fundamental file system block size.
-	stat.Blocks = math.MaxUint32 // Total number of blocks on file system in units of Frsize.
-	stat.Bfree = stat.Blocks / 2 // Total number of free blocks.
-	stat.Bavail = stat.Bfree
-	stat.Namemax = self.maxPathLength
-	return
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		lv, uv := self.qstore.Limit(tx, quota.Volume), self.qstore.Usage(tx, quota.Volume)
+		lo, uo := self.qstore.Limit(tx, self.actor), self.qstore.Usage(tx, self.actor)
+
+		//https://github.com/SerCeMan/jnr-fuse/issues/16#issuecomment-323511739
+		//VolumeInfo->TotalSize = (UINT64)stbuf.f_blocks * (UINT64)stbuf.f_frsize;
+		//VolumeInfo->FreeSize = (UINT64)stbuf.f_bfree * (UINT64)stbuf.f_frsize;
+		const frsize = 1024
+		total, free := capacity(math.MaxUint32*frsize, [2]int64{lv.HardBytes, lo.HardBytes}, [2]int64{uv.Bytes, uo.Bytes})
+		stat.Bsize = frsize
+		stat.Frsize = frsize                 // Fundamental file system block size.
+		stat.Blocks = uint64(total / frsize) // Total number of blocks on file system in units of Frsize.
+		stat.Bfree = uint64(free / frsize)   // Total number of free blocks.
+		stat.Bavail = stat.Bfree
+
+		total, free = capacity(math.MaxUint32, [2]int64{lv.HardInodes, lo.HardInodes}, [2]int64{uv.Inodes, uo.Inodes})
+		stat.Files = uint64(total)
+		stat.Ffree = uint64(free)
+		stat.Favail = stat.Ffree
+		stat.Namemax = self.maxPathLength
+		return 0
+	})
 }
 
 func (self *Memfs) Mknod(path string, mode uint32, dev uint64) (errc int) {
 	defer trace(path, mode, dev)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		return self.makeNode(tx, path, mode, dev, nil)
+		return self.makeNode(tx, "mknod", path, mode, dev, nil)
 	})
 }
 
 func (self *Memfs) Mkdir(path string, mode uint32) (errc int) {
 	defer trace(path, mode)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		return self.makeNode(tx, path, fuse.S_IFDIR|(mode&07777), 0, nil)
+		return self.makeNode(tx, "mkdir", path, fuse.S_IFDIR|(mode&07777), 0, nil)
 	})
 }
 
 func (self *Memfs) Unlink(path string) (errc int) {
 	defer trace(path)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		return self.removeNode(tx, path, false)
+		return self.removeNode(tx, "unlink", path, false)
 	})
 }
 
 func (self *Memfs) Rmdir(path string) (errc int) {
 	defer trace(path)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		return self.removeNode(tx, path, true)
+		return self.removeNode(tx, "rmdir", path, true)
 	})
 }
 
 func (self *Memfs) Link(oldpath string, newpath string) (errc int) {
 	defer trace(oldpath, newpath)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		_, _, oldnode := self.lookupNode(tx, oldpath, nil)
+		oldprnt, oldname, oldnode := self.lookupNode(tx, oldpath, nil)
 		if nil == oldnode {
 			return -fuse.ENOENT
 		}
@@ -92,14 +164,23 @@ func (self *Memfs) Link(oldpath string, newpath string) (errc int) {
 		if nil != newnode {
 			return -fuse.EEXIST
 		}
+		if readOnly(newpath) {
+			return -fuse.EROFS
+		}
+		if self.leased(tx, newpath) {
+			return -fuse.EACCES
+		}
 
+		recordLink(tx, oldprnt, oldname, oldnode)
 		oldnode.StatIncNlink(tx)
 		newprnt.SetChld(tx, newname, oldnode)
+		self.linked(tx, newpath, newprnt, newname, oldnode)
 
 		tmsp := fuse.Now()
 		oldnode.StatSetCTim(tx, tmsp)
 		newprnt.StatSetCTim(tx, tmsp)
 		newprnt.StatSetMTim(tx, tmsp)
+		self.emit(tx, "link", oldpath, newpath, oldnode)
 		return 0
 	})
 }
@@ -107,7 +188,7 @@ func (self *Memfs) Link(oldpath string, newpath string) (errc int) {
 func (self *Memfs) Symlink(target string, newpath string) (errc int) {
 	defer trace(target, newpath)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		return self.makeNode(tx, newpath, fuse.S_IFLNK|00777, 0, []byte(target))
+		return self.makeNode(tx, "symlink", newpath, fuse.S_IFLNK|00777, 0, []byte(target))
 	})
 }
 
@@ -131,32 +212,53 @@ func (self *Memfs) Readlink(path string) (errc int, target string) {
 func (self *Memfs) Rename(oldpath string, newpath string) (errc int) {
 	defer trace(oldpath, newpath)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		oldprnt, oldname, oldnode := self.lookupNode(tx, oldpath, nil)
-		if nil == oldnode {
-			return -fuse.ENOENT
-		}
-		newprnt, newname, newnode := self.lookupNode(tx, newpath, oldnode)
-		if nil == newprnt {
-			return -fuse.ENOENT
-		}
-		if "" == newname {
-			return -fuse.EINVAL // guard against directory loop creation
-		}
+		return self.renameNode(tx, oldpath, newpath)
+	})
+}
 
-		if oldprnt == newprnt && oldname == newname {
-			return 0
-		}
-		if nil != newnode {
-			errc = self.removeNode(tx, newpath, fuse.S_IFDIR == oldnode.Stat(tx).Mode&fuse.S_IFMT)
-			if 0 != errc {
-				return errc
-			}
-		}
+//renameNode moves the node at oldpath to newpath as part of tx
+func (self *Memfs) renameNode(tx fdb.Transaction, oldpath string, newpath string) (errc int) {
+	oldprnt, oldname, oldnode := self.lookupNode(tx, oldpath, nil)
+	if nil == oldnode {
+		return -fuse.ENOENT
+	}
+	newprnt, newname, newnode := self.lookupNode(tx, newpath, oldnode)
+	if nil == newprnt {
+		return -fuse.ENOENT
+	}
+	if "" == newname {
+		return -fuse.EINVAL // guard against directory loop creation
+	}
+	if readOnly(newpath) {
+		return -fuse.EROFS
+	}
+	if self.leased(tx, oldpath) || self.leased(tx, newpath) {
+		return -fuse.EACCES
+	}
 
-		oldprnt.DelChld(tx, oldname)
-		newprnt.SetChld(tx, newname, oldnode)
+	if oldprnt == newprnt && oldname == newname {
 		return 0
-	})
+	}
+	if nil != newnode {
+		errc = self.removeNode(tx, "", newpath, fuse.S_IFDIR == oldnode.Stat(tx).Mode&fuse.S_IFMT)
+		if 0 != errc {
+			return errc
+		}
+	}
+
+	ds, rec := contribution(tx, oldprnt, oldname, oldnode)
+	self.account(tx, oldpath, negate(ds))
+	oldprnt.DelChld(tx, oldname)
+	newprnt.SetChld(tx, newname, oldnode)
+	if rec {
+		oldnode.DelLinkSize(tx, oldprnt.Ino(), oldname)
+		oldnode.SetLinkSize(tx, newprnt.Ino(), newname, ds)
+	}
+
+	self.account(tx, newpath, ds)
+	self.moveLeases(tx, oldpath, newpath)
+	self.emit(tx, "rename", oldpath, newpath, oldnode)
+	return 0
 }
 
 func (self *Memfs) Chmod(path string, mode uint32) (errc int) {
@@ -166,9 +268,13 @@ func (self *Memfs) Chmod(path string, mode uint32) (errc int) {
 		if nil == node {
 			return -fuse.ENOENT
 		}
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
 
 		node.StatSetMode(tx, (node.Stat(tx).Mode&fuse.S_IFMT)|mode&07777)
 		node.StatSetCTim(tx, fuse.Now())
+		self.emit(tx, "chmod", path, "", node)
 		return 0
 	})
 }
@@ -180,6 +286,9 @@ func (self *Memfs) Chown(path string, uid uint32, gid uint32) (errc int) {
 		if nil == node {
 			return -fuse.ENOENT
 		}
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
 		if ^uint32(0) != uid {
 			node.StatSetUid(tx, uid) //@TODO change owner makes no sense on our fs
 		}
@@ -188,6 +297,7 @@ func (self *Memfs) Chown(path string, uid uint32, gid uint32) (errc int) {
 		}
 
 		node.StatSetCTim(tx, fuse.Now())
+		self.emit(tx, "chown", path, "", node)
 		return 0
 	})
 }
@@ -199,6 +309,9 @@ func (self *Memfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
 		if nil == node {
 			return -fuse.ENOENT
 		}
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
 
 		node.StatSetCTim(tx, fuse.Now())
 		if nil == tmsp {
@@ -209,20 +322,70 @@ func (self *Memfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
 
 		node.StatSetATim(tx, tmsp[0])
 		node.StatSetMTim(tx, tmsp[1])
+		self.emit(tx, "utimens", path, "", node)
 		return 0
 	})
 }
 
+func (self *Memfs) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	defer trace(path, flags, mode)(&errc, &fh)
+	return self.nstore.TxWithErrcUint64(func(tx fdb.Transaction) (int, uint64) {
+		if readOnly(path) {
+			return -fuse.EROFS, ^uint64(0)
+		}
+
+		_, _, node := self.lookupNode(tx, path, nil)
+		if nil != node && 0 != flags&fuse.O_EXCL {
+			return -fuse.EEXIST, ^uint64(0)
+		}
+
+		if nil == node {
+			errc := self.makeNode(tx, "create", path, fuse.S_IFREG|(mode&07777), 0, nil)
+			if 0 != errc {
+				return errc, ^uint64(0)
+			}
+		}
+
+		return self.openNode(tx, path, false, flags)
+	})
+}
+
 func (self *Memfs) Open(path string, flags int) (errc int, fh uint64) {
 	defer trace(path, flags)(&errc, &fh)
 	return self.nstore.TxWithErrcUint64(func(tx fdb.Transaction) (int, uint64) {
-		return self.openNode(tx, path, false)
+		if rest, ok := versionsPath(path); ok {
+			return self.openVersion(tx, rest, false, flags)
+		}
+		if rest, ok := trashPath(path); ok {
+			return self.openTrash(tx, rest, false, flags)
+		}
+
+		return self.openNode(tx, path, false, flags)
 	})
 }
 
 func (self *Memfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc, stat)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		if rest, ok := versionsPath(path); ok {
+			vn, errc := self.lookupVersion(tx, rest)
+			if 0 != errc {
+				return errc
+			}
+
+			*stat = vn.stat
+			return 0
+		}
+		if rest, ok := trashPath(path); ok {
+			_, _, sta, errc := self.lookupTrash(tx, rest)
+			if 0 != errc {
+				return errc
+			}
+
+			*stat = sta
+			return 0
+		}
+
 		node := self.getNode(tx, path, fh)
 		if nil == node {
 			return -fuse.ENOENT
@@ -240,12 +403,18 @@ func (self *Memfs) Truncate(path string, size int64, fh uint64) (errc int) {
 			return -fuse.ENOENT
 		}
 
-		node.Truncate(tx, self.cstore, size) //handle errors
+		if ^uint64(0) != fh && !writable(self.hstore.Get(tx, fh).Flags) {
+			return -fuse.EBADF
+		}
+		if "" != path && self.leased(tx, path) {
+			return -fuse.EACCES
+		}
 
-		tmsp := fuse.Now()
-		node.StatSetSize(tx, size)
-		node.StatSetCTim(tx, tmsp)
-		node.StatSetMTim(tx, tmsp)
+		if errc = self.truncNode(tx, path, node, size); 0 != errc {
+			return errc
+		}
+
+		self.emit(tx, "truncate", path, "", node)
 		return 0
 	})
 }
@@ -253,10 +422,28 @@ func (self *Memfs) Truncate(path string, size int64, fh uint64) (errc int) {
 func (self *Memfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
 	defer trace(path, buff, ofst, fh)(&n)
 	return self.nstore.TxWithInt(func(tx fdb.Transaction) (n int) {
-		node := self.getNode(tx, path, fh)
-		if nil == node {
-			return -fuse.ENOENT
+		hndl := self.hstore.Get(tx, fh)
+		if nil == hndl {
+			return -fuse.EBADF
+		}
+
+		if !readable(hndl.Flags) {
+			return -fuse.EBADF
 		}
+
+		node := hndl.Node
+		if 0 != hndl.Version {
+			ver := node.Version(tx, hndl.Version)
+			if nil == ver {
+				return -fuse.EIO //the version was pruned while open
+			}
+			if ofst >= ver.Size {
+				return 0
+			}
+
+			return node.ReadVersionAt(tx, self.cstore, ver, buff, ofst)
+		}
+
 		endofst := ofst + int64(len(buff))
 		if endofst > node.Stat(tx).Size {
 			endofst = node.Stat(tx).Size
@@ -274,15 +461,39 @@ func (self *Memfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int)
 func (self *Memfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
 	defer trace(path, buff, ofst, fh)(&n)
 	return self.nstore.TxWithInt(func(tx fdb.Transaction) (n int) {
-		node := self.getNode(tx, path, fh)
-		if nil == node {
-			return -fuse.ENOENT
+		hndl := self.hstore.Get(tx, fh)
+		if nil == hndl {
+			return -fuse.EBADF
+		}
+
+		if !writable(hndl.Flags) {
+			return -fuse.EBADF
+		}
+		if "" != path && self.leased(tx, path) {
+			return -fuse.EACCES
+		}
+
+		node := hndl.Node
+		if 0 != hndl.Flags&fuse.O_APPEND {
+			ofst = node.Stat(tx).Size //appends always land at the end of the file as seen in this tx
+		}
+
+		if endofst := ofst + int64(len(buff)); endofst > node.Stat(tx).Size {
+			if errc := self.resize(tx, path, node, endofst); 0 != errc {
+				return errc
+			}
 		}
 
 		n = node.WriteAt(tx, self.cstore, buff, ofst)
 		tmsp := fuse.Now()
 		node.StatSetCTim(tx, tmsp)
 		node.StatSetMTim(tx, tmsp)
+
+		//writes are reported once per flush instead of for every call
+		if !hndl.Written {
+			self.hstore.SetWritten(tx, hndl, true)
+		}
+
 		return
 	})
 }
@@ -290,15 +501,99 @@ func (self *Memfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int
 func (self *Memfs) Release(path string, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		return self.closeNode(tx, fh)
+		return self.closeNode(tx, path, fh)
 	})
 }
 
+//Lock performs a POSIX advisory byte-range lock operation. Locks are stored
+//with the node so they are honored by all clients, they are owned by the
+//calling process of the session and released when the handle they were taken
+//through is released or when the session ends.
+func (self *Memfs) Lock(path string, cmd int, lock *locks.Lock, fh uint64) (errc int) {
+	defer trace(path, cmd, lock, fh)(&errc)
+	if _, _, ok := locks.Range(lock); 0 != lock.Whence || !ok {
+		return -fuse.EINVAL
+	}
+
+	owner := locks.Owner{Sess: self.sess, Pid: lock.Pid}
+	for {
+		var watch fdb.FutureNil
+		errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			watch = nil
+			hndl := self.hstore.Get(tx, fh)
+			if nil == hndl {
+				return -fuse.EBADF
+			}
+
+			switch cmd {
+			case locks.GETLK:
+				if c := self.lstore.Conflict(tx, hndl.Ino, owner, lock); nil != c {
+					*lock = *c
+				} else {
+					lock.Type = locks.UNLCK
+				}
+
+				return 0
+			case locks.SETLK, locks.SETLKW:
+				switch lock.Type {
+				case locks.RDLCK:
+					if !readable(hndl.Flags) {
+						return -fuse.EBADF
+					}
+				case locks.WRLCK:
+					if !writable(hndl.Flags) {
+						return -fuse.EBADF
+					}
+				case locks.UNLCK:
+				default:
+					return -fuse.EINVAL
+				}
+
+				if locks.UNLCK != lock.Type && nil != self.lstore.Conflict(tx, hndl.Ino, owner, lock) {
+					if locks.SETLKW == cmd {
+						watch = tx.Watch(self.lstore.WatchKey(hndl.Ino))
+					}
+
+					return -fuse.EAGAIN
+				}
+
+				self.lstore.Set(tx, hndl.Ino, fh, owner, lock)
+				return 0
+			default:
+				return -fuse.EINVAL
+			}
+		})
+
+		if nil == watch {
+			return errc
+		}
+
+		//wait outside of the transaction for the locks on the node to change
+		done := make(chan struct{})
+		go func() {
+			watch.BlockUntilReady()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(lockPoll):
+			watch.Cancel()
+		}
+	}
+}
+
 func (self *Memfs) Opendir(path string) (errc int, fh uint64) {
 	defer trace(path)(&errc, &fh)
 	return self.nstore.TxWithErrcUint64(func(tx fdb.Transaction) (int, uint64) {
-		//@TODO this seems to fail for a non existing directory
-		return self.openNode(tx, path, true)
+		if rest, ok := versionsPath(path); ok {
+			return self.openVersion(tx, rest, true, fuse.O_RDONLY)
+		}
+		if rest, ok := trashPath(path); ok {
+			return self.openTrash(tx, rest, true, fuse.O_RDONLY)
+		}
+
+		return self.openNode(tx, path, true, fuse.O_RDONLY)
 	})
 
 }
@@ -312,8 +607,18 @@ func (self *Memfs) Readdir(path string,
 	fh uint64) (errc int) {
 	defer trace(path, fill, ofst, fh)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		//@TODO what if dir was not first openend?
-		node := self.hstore.Get(tx, fh)
+		if rest, ok := versionsPath(path); ok {
+			return self.readdirVersion(tx, rest, fill)
+		}
+		if rest, ok := trashPath(path); ok {
+			return self.readdirTrash(tx, rest, fill)
+		}
+
+		node := self.getNode(tx, path, fh)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+
 		sta := node.Stat(tx)
 
 		fill(".", &sta, 0)
@@ -334,58 +639,153 @@ func (self *Memfs) Readdir(path string,
 func (self *Memfs) Releasedir(path string, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		return self.closeNode(tx, fh)
+		return self.closeNode(tx, path, fh)
 	})
 }
 
 func (self *Memfs) Setxattr(path string, name string, value []byte, flags int) (errc int) {
-	defer trace(path, name, value, flags)(&errc)
-	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		_, _, node := self.lookupNode(tx, path, nil)
-		if nil == node {
-			return -fuse.ENOENT
-		}
-		if "com.apple.ResourceFork" == name {
-			return -fuse.ENOTSUP
+	return self.SetxattrAt(path, name, value, flags, 0)
+}
+
+//SetxattrAt writes value into the xattr at position, only the resource fork
+//can be written at a position other than 0
+func (self *Memfs) SetxattrAt(path string, name string, value []byte, flags int, position uint32) (errc int) {
+	defer trace(path, name, value, flags, position)(&errc)
+	for {
+		errc, m, stamp := self.xattrBlob(path, name, value, position)
+		if 0 != errc {
+			return errc
 		}
-		if fuse.XATTR_CREATE == flags {
-			if _, ok := node.XAtrGet(tx, name); ok {
-				return -fuse.EEXIST
+
+		stale := false
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			stale = false
+			_, _, node := self.lookupNode(tx, path, nil)
+			if nil == node {
+				return -fuse.ENOENT
 			}
-		} else if fuse.XATTR_REPLACE == flags {
-			if _, ok := node.XAtrGet(tx, name); !ok {
-				return -fuse.ENOATTR
+			if self.leased(tx, path) {
+				return -fuse.EACCES
+			}
+			if 0 != position && ResourceForkXAttr != name {
+				return -fuse.EINVAL
+			}
+			if LeaseXAttr == name || DirSizeXAttr == name {
+				return -fuse.EPERM
+			}
+			if RestoreXAttr == name {
+				return self.restoreVersion(tx, path, node, value)
+			}
+			if errc = self.xattrAccess(tx, node, name, true); 0 != errc {
+				return errc
+			}
+			if len(value) > XAttrSizeMax {
+				return -fuse.E2BIG
 			}
-		}
 
-		xatr := make([]byte, len(value))
-		copy(xatr, value)
-		node.XAtrSet(tx, name, xatr)
-		return 0
-	})
+			//macOS passes options such as XATTR_NOFOLLOW along with these
+			has := node.XAtrHas(tx, name)
+			if 0 != flags&fuse.XATTR_CREATE {
+				if has {
+					return -fuse.EEXIST
+				}
+			} else if 0 != flags&fuse.XATTR_REPLACE {
+				if !has {
+					return -fuse.ENOATTR
+				}
+			}
+
+			//the value was merged with one that changed since, merge again
+			if nil != stamp && !bytes.Equal(stamp, node.XAtrStamp(tx, name)) {
+				stale = true
+				return 0
+			}
+
+			if nil != m {
+				if errc = xattrCheck(tx, node, name, value); 0 != errc {
+					return errc
+				}
+
+				node.XAtrSetBlob(tx, name, m)
+				self.emit(tx, "setxattr", path, "", node)
+				return 0
+			}
+
+			errc, xatr := self.xattrValue(tx, node, name, value, position)
+			if 0 != errc {
+				return errc
+			}
+
+			if nil == xatr {
+				if has {
+					node.XAtrDel(tx, name)
+					self.emit(tx, "removexattr", path, "", node)
+				}
+
+				return 0
+			}
+
+			if len(xatr) > nodes.InlineLimit {
+				stale = true //it grew past the inline limit since it was read
+				return 0
+			}
+
+			if errc = node.XAtrSet(tx, name, xatr); 0 != errc {
+				return errc
+			}
+
+			self.emit(tx, "setxattr", path, "", node)
+			return 0
+		}); 0 != errc || !stale {
+			return errc
+		}
+	}
 }
 
 func (self *Memfs) Getxattr(path string, name string) (errc int, xatr []byte) {
-	defer trace(path, name)(&errc, &xatr)
+	return self.GetxattrAt(path, name, 0)
+}
+
+//GetxattrAt reads the xattr from position onwards, only the resource fork can
+//be read at a position other than 0
+func (self *Memfs) GetxattrAt(path string, name string, position uint32) (errc int, xatr []byte) {
+	defer trace(path, name, position)(&errc, &xatr)
 	return self.nstore.TxWithErrcBytes(func(tx fdb.Transaction) (int, []byte) {
 		_, _, node := self.lookupNode(tx, path, nil)
 		if nil == node {
 			return -fuse.ENOENT, nil
 		}
-		if "com.apple.ResourceFork" == name {
-			return -fuse.ENOTSUP, nil
+		if 0 != position && ResourceForkXAttr != name {
+			return -fuse.EINVAL, nil
 		}
+		if LeaseXAttr == name {
+			if xatr := self.leaseXAttr(tx, node); nil != xatr {
+				return 0, xatr
+			}
 
-		if strings.HasPrefix(name, "com.apple") {
-			//@TODO fixes finder crashing on our own fs, but not on memfs
 			return -fuse.ENOATTR, nil
 		}
+		if DirSizeXAttr == name {
+			if xatr := self.dirSizeXAttr(tx, node); nil != xatr {
+				return 0, xatr
+			}
 
-		xatr, ok := node.XAtrGet(tx, name)
-		if !ok {
 			return -fuse.ENOATTR, nil
 		}
-		return 0, xatr
+
+		if errc := self.xattrAccess(tx, node, name, false); 0 != errc {
+			return errc, nil
+		}
+
+		errc, xatr := node.XAtrOpen(tx, self.cstore, name)
+		if 0 != errc {
+			return errc, nil
+		}
+		if int(position) > len(xatr) {
+			return 0, []byte{}
+		}
+
+		return 0, xatr[position:]
 	})
 }
 
@@ -396,15 +796,22 @@ func (self *Memfs) Removexattr(path string, name string) (errc int) {
 		if nil == node {
 			return -fuse.ENOENT
 		}
-		if "com.apple.ResourceFork" == name {
-			return -fuse.ENOTSUP
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
+		if LeaseXAttr == name || DirSizeXAttr == name {
+			return -fuse.EPERM
+		}
+		if errc = self.xattrAccess(tx, node, name, true); 0 != errc {
+			return errc
 		}
 
-		if _, ok := node.XAtrGet(tx, name); !ok {
+		if !node.XAtrHas(tx, name) {
 			return -fuse.ENOATTR
 		}
 
 		node.XAtrDel(tx, name)
+		self.emit(tx, "removexattr", path, "", node)
 		return 0
 	})
 }
@@ -417,6 +824,13 @@ func (self *Memfs) Listxattr(path string, fill func(name string) bool) (errc int
 			return -fuse.ENOENT
 		}
 
+		if nil != self.leaseXAttr(tx, node) && !fill(LeaseXAttr) {
+			return -fuse.ERANGE
+		}
+		if nil != self.dirSizeXAttr(tx, node) && !fill(DirSizeXAttr) {
+			return -fuse.ERANGE
+		}
+
 		return node.XAtrEach(tx, func(name string) int {
 			if !fill(name) {
 				return -fuse.ERANGE
@@ -433,9 +847,13 @@ func (self *Memfs) Chflags(path string, flags uint32) (errc int) {
 		if nil == node {
 			return -fuse.ENOENT
 		}
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
 
 		node.StatSetFlags(tx, flags)
 		node.StatSetCTim(tx, fuse.Now())
+		self.emit(tx, "chflags", path, "", node)
 		return 0
 	})
 }
@@ -447,9 +865,13 @@ func (self *Memfs) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
 		if nil == node {
 			return -fuse.ENOENT
 		}
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
 
 		node.StatSetBirthTim(tx, tmsp)
 		node.StatSetCTim(tx, fuse.Now())
+		self.emit(tx, "setcrtime", path, "", node)
 		return 0
 	})
 }
@@ -461,22 +883,39 @@ func (self *Memfs) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
 		if nil == node {
 			return -fuse.ENOENT
 		}
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
 
 		node.StatSetBirthTim(tx, tmsp)
 		node.StatSetCTim(tx, fuse.Now())
+		self.emit(tx, "setchgtime", path, "", node)
 		return 0
 	})
 }
 
-func (self *Memfs) Flush(path string, fh uint64) int {
+func (self *Memfs) Flush(path string, fh uint64) (errc int) {
+	defer trace(path, fh)(&errc)
 	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
-		//@TODO if node first openend this panics
-		node := self.hstore.Get(tx, fh)
-		return node.Flush(tx, self.cstore)
+		hndl := self.hstore.Get(tx, fh)
+		if nil == hndl {
+			return -fuse.EBADF
+		}
+
+		if errc = hndl.Node.Flush(tx, self.cstore); 0 != errc {
+			return errc
+		}
+
+		if hndl.Written {
+			self.emit(tx, "write", path, "", hndl.Node)
+			self.hstore.SetWritten(tx, hndl, false)
+		}
+
+		return 0
 	})
 }
 
-func (self *Memfs) makeNode(tx fdb.Transaction, path string, mode uint32, dev uint64, data []byte) int {
+func (self *Memfs) makeNode(tx fdb.Transaction, op string, path string, mode uint32, dev uint64, data []byte) int {
 	prnt, name, node := self.lookupNode(tx, path, nil)
 	if nil == prnt {
 		return -fuse.ENOENT
@@ -484,6 +923,15 @@ func (self *Memfs) makeNode(tx fdb.Transaction, path string, mode uint32, dev ui
 	if nil != node {
 		return -fuse.EEXIST
 	}
+	if readOnly(path) {
+		return -fuse.EROFS
+	}
+	if self.leased(tx, path) {
+		return -fuse.EACCES
+	}
+	if errc := self.reserve(tx, self.actor, int64(len(data)), 1); 0 != errc {
+		return errc
+	}
 
 	//when this filesystem is run on the server side the contect will be empty
 	//and always return 0,0 as uid and gui. The client is responsible for over writing
@@ -492,18 +940,30 @@ func (self *Memfs) makeNode(tx fdb.Transaction, path string, mode uint32, dev ui
 
 	self.nstore.IncIno(tx)
 	node = self.nstore.NewNode(tx, dev, self.nstore.Ino(tx), mode, uid, gid)
+	node.SetOwner(tx, self.actor)
 	if nil != data {
 		node.WriteAt(tx, self.cstore, data, 0)
 		node.StatSetSize(tx, int64(len(data)))
+		if errc := node.Flush(tx, self.cstore); 0 != errc {
+			//the transaction is committed with the error, so the node is left
+			//unlinked and empty before its usage is charged
+			node.StatDecNlink(tx)
+			node.DropContent(tx)
+			return errc //small data such as symlink targets is stored inline
+		}
 	}
 
+	self.qstore.Add(tx, self.actor, int64(len(data)), 1, time.Now())
+
 	prnt.SetChld(tx, name, node)
+	self.account(tx, path, subtree(tx, node))
 	prnt.StatSetCTim(tx, node.Stat(tx).Ctim)
 	prnt.StatSetMTim(tx, node.Stat(tx).Ctim)
+	self.emit(tx, op, path, "", node)
 	return 0
 }
 
-func (self *Memfs) removeNode(tx fdb.Transaction, path string, dir bool) int {
+func (self *Memfs) removeNode(tx fdb.Transaction, op string, path string, dir bool) int {
 	prnt, name, node := self.lookupNode(tx, path, nil)
 	if nil == node {
 		return -fuse.ENOENT
@@ -518,18 +978,36 @@ func (self *Memfs) removeNode(tx fdb.Transaction, path string, dir bool) int {
 	if 0 < node.CountChld(tx) {
 		return -fuse.ENOTEMPTY
 	}
+	if self.leased(tx, path) {
+		return -fuse.EACCES
+	}
+	if dir {
+		self.nstore.DelLease(tx, node)
+	}
 
-	node.StatDecNlink(tx)
+	if self.nstore.TrashMode(tx).Enabled {
+		self.trashNode(tx, path, node, dir)
+	} else {
+		self.release(tx, node)
+	}
+
+	ds, rec := contribution(tx, prnt, name, node)
+	if rec {
+		node.DelLinkSize(tx, prnt.Ino(), name)
+	}
+
+	self.account(tx, path, negate(ds))
 	prnt.DelChld(tx, name)
 
 	tmsp := fuse.Now()
 	node.StatSetCTim(tx, tmsp)
 	prnt.StatSetCTim(tx, tmsp)
 	prnt.StatSetMTim(tx, tmsp)
+	self.emit(tx, op, path, "", node)
 	return 0
 }
 
-func (self *Memfs) openNode(tx fdb.Transaction, path string, dir bool) (int, uint64) {
+func (self *Memfs) openNode(tx fdb.Transaction, path string, dir bool, flags int) (int, uint64) {
 	_, _, node := self.lookupNode(tx, path, nil)
 	if nil == node {
 		return -fuse.ENOENT, ^uint64(0)
@@ -541,31 +1019,95 @@ func (self *Memfs) openNode(tx fdb.Transaction, path string, dir bool) (int, uin
 		return -fuse.ENOTDIR, ^uint64(0)
 	}
 
+	if !dir && writable(flags) && self.leased(tx, path) {
+		return -fuse.EACCES, ^uint64(0)
+	}
+
+	if !dir && 0 != flags&fuse.O_TRUNC && writable(flags) {
+		if errc := self.truncNode(tx, path, node, 0); 0 != errc {
+			return errc, ^uint64(0)
+		}
+
+		self.emit(tx, "truncate", path, "", node)
+	}
+
 	node.IncOpencnt(tx)
-	if 1 == node.Opencnt(tx) {
-		self.hstore.Set(tx, node.Stat(tx).Ino, node)
+	hndl := self.hstore.Open(tx, node, flags, self.sess)
+	return 0, hndl.ID
+}
+
+func (self *Memfs) truncNode(tx fdb.Transaction, path string, node *nodes.Node, size int64) int {
+	if errc := self.resize(tx, path, node, size); 0 != errc {
+		return errc
+	}
+
+	if errc := node.Truncate(tx, self.cstore, size); 0 != errc {
+		return errc
 	}
-	return 0, node.Stat(tx).Ino
+
+	tmsp := fuse.Now()
+	node.StatSetSize(tx, size)
+	node.StatSetCTim(tx, tmsp)
+	node.StatSetMTim(tx, tmsp)
+	return 0
 }
 
-func (self *Memfs) closeNode(tx fdb.Transaction, fh uint64) int {
-	node := self.hstore.Get(tx, fh)
-	node.Flush(tx, self.cstore) //@TODO only do this for files
+//closeNode flushes and releases handle fh that was opened at path, writes
+//through the handle that weren't flushed yet are reported now
+func (self *Memfs) closeNode(tx fdb.Transaction, path string, fh uint64) int {
+	hndl := self.hstore.Get(tx, fh)
+	if nil == hndl {
+		return -fuse.EBADF
+	}
+
+	node := hndl.Node
+	if fuse.S_IFDIR != node.Stat(tx).Mode&fuse.S_IFMT {
+		node.Flush(tx, self.cstore)
+	}
+	if hndl.Written {
+		self.emit(tx, "write", path, "", node)
+	}
+
+	self.lstore.ReleaseHandle(tx, hndl.Ino, fh)
+
 	node.DecOpencnt(tx)
-	if 0 == node.Opencnt(tx) {
-		self.hstore.Del(tx, node.Stat(tx).Ino)
+	self.hstore.Del(tx, fh)
+
+	//the last handle of a node without links keeps its content
+	if 0 == node.Opencnt(tx) && 0 == node.Stat(tx).Nlink {
+		node.DropContent(tx)
 	}
 
 	return 0
 }
 
+//emit appends an event for a mutation of node to the change log, an empty op
+//is not recorded as the mutation is part of another operation
+func (self *Memfs) emit(tx fdb.Transaction, op string, path string, newpath string, node *nodes.Node) {
+	if "" == op {
+		return
+	}
+
+	e := &events.Event{Op: op, Path: path, NewPath: newpath, Actor: self.actor, Time: time.Now()}
+	if nil != node {
+		e.Ino = node.StatGetIno(tx)
+	}
+
+	self.estore.Append(tx, e)
+}
+
 func (self *Memfs) getNode(tx fdb.Transaction, path string, fh uint64) *nodes.Node {
 	if ^uint64(0) == fh {
 		_, _, node := self.lookupNode(tx, path, nil)
 		return node
-	} else {
-		return self.hstore.Get(tx, fh)
 	}
+
+	hndl := self.hstore.Get(tx, fh)
+	if nil == hndl {
+		return nil
+	}
+
+	return hndl.Node
 }
 
 func (self *Memfs) lookupNode(tx fdb.Transaction, path string, ancestor *nodes.Node) (prnt *nodes.Node, name string, node *nodes.Node) {
@@ -588,15 +1130,31 @@ func (self *Memfs) lookupNode(tx fdb.Transaction, path string, ancestor *nodes.N
 	return
 }
 
-func NewFS(nstore *nodes.Store, cstore chunks.Store, hstore *handles.Store, getctx func() (uint32, uint32, int)) (*Memfs, error) {
+func NewFS(nstore *nodes.Store, cstore chunks.Store, hstore *handles.Store, lstore *locks.Store, estore *events.Store, qstore *quota.Store, getctx func() (uint32, uint32, int)) (*Memfs, error) {
 	self := Memfs{maxPathLength: 512}
 	self.getctx = getctx
 	self.nstore = nstore
 	self.cstore = cstore
 	self.hstore = hstore
+	self.lstore = lstore
+	self.estore = estore
+	self.qstore = qstore
 	return &self, nil
 }
 
+//NewStoreFS creates a filesystem that stores its nodes in FDB directory ss
+//and the content of files in cstore
+func NewStoreFS(db fdb.Database, ss directory.DirectorySubspace, cstore chunks.Store) (fs *Memfs, err error) {
+	nstore := nodes.NewStore(db, ss)
+	hstore := handles.NewStore(db, ss.Sub(tuple.Tuple{"handles"}), ss)
+	lstore := locks.NewStore(db, ss.Sub(tuple.Tuple{"locks"}))
+	estore := events.NewStore(db, ss.Sub(tuple.Tuple{"events"}))
+	qstore := quota.NewStore(db, ss.Sub(tuple.Tuple{"quota"}))
+	return NewFS(nstore, cstore, hstore, lstore, estore, qstore, func() (uint32, uint32, int) {
+		return 1, 1, 1
+	})
+}
+
 func NewTempFS(bdir string, db fdb.Database) (fs *Memfs, clean func() error, err error) {
 	if bdir == "" {
 		bdir, err = ioutil.TempDir("", "ffs_")
@@ -610,7 +1168,7 @@ func NewTempFS(bdir string, db fdb.Database) (fs *Memfs, clean func() error, err
 		return nil, nil, err
 	}
 
-	kv, err := kvfiles.Open(bdir)
+	cstore, err := chunkstore.Open(chunkstore.Config{ChunkDir: bdir})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -620,17 +1178,12 @@ func NewTempFS(bdir string, db fdb.Database) (fs *Memfs, clean func() error, err
 		return nil, nil, err
 	}
 
-	nstore := nodes.NewStore(db, ss)
-	hstore := handles.NewStore(db, ss.Sub(tuple.Tuple{"handles"}), ss)
-	bstore := kvchunks.New(kv)
-
-	if fs, err = NewFS(nstore, bstore, hstore, func() (uint32, uint32, int) {
-		return 1, 1, 1
-	}); err != nil {
+	if fs, err = NewStoreFS(db, ss, cstore); err != nil {
 		return nil, nil, err
 	}
 
 	return fs, func() error {
+		cstore.Close()
 		rerr := os.RemoveAll(bdir)
 		if rerr != nil {
 			return rerr
@@ -1,12 +1,31 @@
 package ffs
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"github.com/advanderveer/dfs/ffs/chunkstore"
+	"github.com/advanderveer/dfs/ffs/crypt"
+	"github.com/advanderveer/dfs/ffs/dedup"
+	"github.com/advanderveer/dfs/ffs/locks"
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/advanderveer/dfs/ffs/quota"
+	"github.com/advanderveer/dfs/ffs/scrub"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 	"github.com/billziss-gh/cgofuse/fuse"
 )
@@ -26,7 +45,7 @@ func TestRW(t *testing.T) {
 
 	errc, fh := fs.Open("foo.txt", fuse.O_CREAT|fuse.O_RDWR)
 	equals(t, 0, errc)
-	equals(t, uint64(3), fh)
+	equals(t, uint64(1<<32|1), fh) //the first handle of the first session
 
 	n := fs.Write("foo.txt", []byte{0x01, 0x02, 0x03}, 0, fh)
 	equals(t, 3, n)
@@ -51,6 +70,173 @@ func TestRW(t *testing.T) {
 
 }
 
+func TestHandles(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	errc := fs.Mknod("foo.txt", fuse.S_IFREG, 0)
+	equals(t, 0, errc)
+
+	errc, fh1 := fs.Open("foo.txt", fuse.O_RDWR)
+	equals(t, 0, errc)
+	errc, fh2 := fs.Open("foo.txt", fuse.O_RDWR)
+	equals(t, 0, errc)
+	assert(t, fh1 != fh2, "expected two opens to result in two handles, got: %d", fh1)
+
+	n := fs.Write("foo.txt", []byte{0x01, 0x02}, 0, fh1)
+	equals(t, 2, n)
+
+	errc = fs.Release("foo.txt", fh1)
+	equals(t, 0, errc)
+
+	buf := make([]byte, 2)
+	n = fs.Read("foo.txt", buf, 0, fh2)
+	equals(t, 2, n)
+	equals(t, []byte{0x01, 0x02}, buf)
+
+	n = fs.Read("foo.txt", buf, 0, fh1)
+	equals(t, -fuse.EBADF, n)
+	equals(t, -fuse.EBADF, fs.Release("foo.txt", fh1))
+	equals(t, 0, fs.Release("foo.txt", fh2))
+}
+
+func TestOpenFlags(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	errc, fh := fs.Create("foo.txt", fuse.O_CREAT|fuse.O_EXCL|fuse.O_WRONLY, 0644)
+	equals(t, 0, errc)
+	equals(t, 3, fs.Write("foo.txt", []byte{0x01, 0x02, 0x03}, 0, fh))
+	equals(t, 0, fs.Release("foo.txt", fh))
+
+	errc, _ = fs.Create("foo.txt", fuse.O_CREAT|fuse.O_EXCL|fuse.O_WRONLY, 0644)
+	equals(t, -fuse.EEXIST, errc)
+
+	t.Run("append", func(t *testing.T) {
+		errc, fh := fs.Open("foo.txt", fuse.O_WRONLY|fuse.O_APPEND)
+		equals(t, 0, errc)
+		equals(t, 1, fs.Write("foo.txt", []byte{0x04}, 0, fh))
+		equals(t, -fuse.EBADF, fs.Read("foo.txt", make([]byte, 1), 0, fh))
+		equals(t, 0, fs.Release("foo.txt", fh))
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr("foo.txt", st, ^uint64(0)))
+		equals(t, int64(4), st.Size)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		errc, fh := fs.Open("foo.txt", fuse.O_RDONLY)
+		equals(t, 0, errc)
+		equals(t, -fuse.EBADF, fs.Write("foo.txt", []byte{0x04}, 0, fh))
+		equals(t, -fuse.EBADF, fs.Truncate("foo.txt", 0, fh))
+
+		buf := make([]byte, 4)
+		equals(t, 4, fs.Read("foo.txt", buf, 0, fh))
+		equals(t, []byte{0x01, 0x02, 0x03, 0x04}, buf)
+		equals(t, 0, fs.Release("foo.txt", fh))
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		errc, fh := fs.Open("foo.txt", fuse.O_RDWR|fuse.O_TRUNC)
+		equals(t, 0, errc)
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr("foo.txt", st, fh))
+		equals(t, int64(0), st.Size)
+		equals(t, 0, fs.Release("foo.txt", fh))
+	})
+}
+
+func TestLocks(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, 0, fs.Mknod("foo.txt", fuse.S_IFREG, 0))
+	fsa, fsb := fs.Session("a"), fs.Session("b")
+
+	errc, fha := fsa.Open("foo.txt", fuse.O_RDWR)
+	equals(t, 0, errc)
+	errc, fhb := fsb.Open("foo.txt", fuse.O_RDWR)
+	equals(t, 0, errc)
+	assert(t, fha>>32 != fhb>>32, "expected sessions to count their own handles, got: %x and %x", fha, fhb)
+
+	equals(t, 0, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.WRLCK, Start: 0, Len: 10, Pid: 1}, fha))
+	equals(t, -fuse.EAGAIN, fsb.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.RDLCK, Start: 5, Len: 10, Pid: 2}, fhb))
+	equals(t, 0, fsb.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.RDLCK, Start: 10, Len: 10, Pid: 2}, fhb))
+
+	lk := &locks.Lock{Type: locks.WRLCK, Start: 0, Pid: 2}
+	equals(t, 0, fsb.Lock("foo.txt", locks.GETLK, lk, fhb))
+	equals(t, &locks.Lock{Type: locks.WRLCK, Start: 0, Len: 10, Pid: 1}, lk)
+
+	t.Run("blocking wait", func(t *testing.T) {
+		done := make(chan int)
+		go func() {
+			done <- fsb.Lock("foo.txt", locks.SETLKW, &locks.Lock{Type: locks.WRLCK, Start: 0, Len: 5, Pid: 2}, fhb)
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("expected lock to block")
+		case <-time.After(time.Millisecond * 100):
+		}
+
+		equals(t, 0, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.UNLCK, Start: 0, Len: 10, Pid: 1}, fha))
+		select {
+		case errc := <-done:
+			equals(t, 0, errc)
+		case <-time.After(time.Second * 2):
+			t.Fatal("expected lock to be granted after unlock")
+		}
+	})
+
+	t.Run("ranges", func(t *testing.T) {
+		equals(t, -fuse.EINVAL, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.WRLCK, Start: 5, Len: -10, Pid: 1}, fha))
+		equals(t, -fuse.EINVAL, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.WRLCK, Start: 1, Len: math.MaxInt64, Pid: 1}, fha))
+
+		//a negative length covers the bytes before the start
+		lk := &locks.Lock{Type: locks.WRLCK, Start: 30, Len: -5, Pid: 1}
+		equals(t, 0, fsa.Lock("foo.txt", locks.GETLK, lk, fha))
+		equals(t, int16(locks.UNLCK), lk.Type)
+		lk = &locks.Lock{Type: locks.WRLCK, Start: 12, Len: -4, Pid: 1}
+		equals(t, 0, fsa.Lock("foo.txt", locks.GETLK, lk, fha))
+		equals(t, &locks.Lock{Type: locks.RDLCK, Start: 10, Len: 10, Pid: 2}, lk)
+	})
+
+	t.Run("close releases the locks of the owner", func(t *testing.T) {
+		errc, fhb2 := fsb.Open("foo.txt", fuse.O_RDWR)
+		equals(t, 0, errc)
+		equals(t, 0, fsb.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.WRLCK, Start: 30, Len: 10, Pid: 2}, fhb2))
+		equals(t, 0, fsb.Release("foo.txt", fhb2))
+
+		//also the locks that were taken through the other handle
+		equals(t, 0, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.WRLCK, Start: 0, Pid: 1}, fha))
+		equals(t, 0, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.UNLCK, Start: 0, Pid: 1}, fha))
+		equals(t, 0, fsb.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.WRLCK, Start: 0, Len: 5, Pid: 2}, fhb))
+	})
+
+	t.Run("end session", func(t *testing.T) {
+		equals(t, -fuse.EAGAIN, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.RDLCK, Start: 0, Pid: 1}, fha))
+		equals(t, 0, fs.EndSession("b"))
+		equals(t, 0, fsa.Lock("foo.txt", locks.SETLK, &locks.Lock{Type: locks.WRLCK, Start: 0, Pid: 1}, fha))
+		equals(t, -fuse.EBADF, fsb.Read("foo.txt", make([]byte, 1), 0, fhb))
+	})
+}
+
 func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
 	if !condition {
 		_, file, line, _ := runtime.Caller(1)
@@ -74,3 +260,1460 @@ func equals(tb testing.TB, exp, act interface{}) {
 		tb.FailNow()
 	}
 }
+
+func TestLeases(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	alice, bob := fs.As("alice"), fs.As("bob")
+	equals(t, 0, fs.Mkdir("/ws", 0777))
+	equals(t, 0, fs.Mknod("/ws/foo.txt", fuse.S_IFREG|0644, 0))
+	equals(t, -fuse.ENOTDIR, fs.AcquireLease("/ws/foo.txt", "alice", "", time.Minute))
+
+	equals(t, 0, fs.AcquireLease("/ws", "alice", "job 1", time.Minute))
+	equals(t, -fuse.EBUSY, fs.AcquireLease("/ws", "bob", "job 2", time.Minute))
+
+	t.Run("enforced for others", func(t *testing.T) {
+		equals(t, -fuse.EACCES, bob.Mkdir("/ws/bar", 0777))
+		equals(t, -fuse.EACCES, bob.Unlink("/ws/foo.txt"))
+		equals(t, -fuse.EACCES, bob.Rmdir("/ws"))
+		equals(t, -fuse.EACCES, bob.Rename("/ws/foo.txt", "/foo.txt"))
+		equals(t, -fuse.EACCES, bob.Chmod("/ws/foo.txt", 0600))
+
+		errc, _ := bob.Open("/ws/foo.txt", fuse.O_WRONLY)
+		equals(t, -fuse.EACCES, errc)
+
+		errc, fh := bob.Open("/ws/foo.txt", fuse.O_RDONLY)
+		equals(t, 0, errc)
+		equals(t, 0, bob.Release("/ws/foo.txt", fh))
+	})
+
+	t.Run("holder can write", func(t *testing.T) {
+		equals(t, 0, alice.Mkdir("/ws/bar", 0777))
+		errc, fh := alice.Open("/ws/foo.txt", fuse.O_WRONLY)
+		equals(t, 0, errc)
+		equals(t, 3, alice.Write("/ws/foo.txt", []byte{0x01, 0x02, 0x03}, 0, fh))
+		equals(t, 0, alice.Release("/ws/foo.txt", fh))
+	})
+
+	t.Run("xattr", func(t *testing.T) {
+		errc, d := fs.Getxattr("/ws", LeaseXAttr)
+		equals(t, 0, errc)
+
+		l := &nodes.Lease{}
+		ok(t, json.Unmarshal(d, l))
+		equals(t, "alice", l.Holder)
+		equals(t, "job 1", l.Reason)
+		equals(t, -fuse.EPERM, alice.Setxattr("/ws", LeaseXAttr, []byte("{}"), 0))
+	})
+
+	t.Run("list renew release", func(t *testing.T) {
+		var paths []string
+		equals(t, 0, fs.Leases(func(path string, l *nodes.Lease) bool {
+			paths = append(paths, path)
+			return true
+		}))
+		equals(t, []string{"/ws"}, paths)
+
+		equals(t, -fuse.ENOLCK, fs.RenewLease("/ws", "bob", time.Minute))
+		equals(t, 0, fs.RenewLease("/ws", "alice", time.Hour))
+		equals(t, -fuse.ENOLCK, fs.ReleaseLease("/ws", "bob"))
+		equals(t, 0, fs.ReleaseLease("/ws", "alice"))
+		equals(t, 0, bob.Chmod("/ws/foo.txt", 0600))
+
+		errc, _ := fs.Getxattr("/ws", LeaseXAttr)
+		equals(t, -fuse.ENOATTR, errc)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		equals(t, 0, fs.AcquireLease("/ws", "alice", "", time.Millisecond))
+		time.Sleep(time.Millisecond * 10)
+		equals(t, 0, bob.Chmod("/ws/foo.txt", 0644))
+		equals(t, 0, fs.AcquireLease("/ws", "bob", "", time.Minute))
+	})
+}
+
+func TestChanges(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	alice := fs.As("alice")
+	equals(t, 0, alice.Mkdir("/ws", 0777))
+	errc, fh := alice.Create("/ws/foo.txt", fuse.O_CREAT|fuse.O_WRONLY, 0644)
+	equals(t, 0, errc)
+	equals(t, 3, alice.Write("/ws/foo.txt", []byte{0x01, 0x02, 0x03}, 0, fh))
+	equals(t, 3, alice.Write("/ws/foo.txt", []byte{0x04, 0x05, 0x06}, 3, fh))
+	equals(t, 0, alice.Release("/ws/foo.txt", fh))
+	equals(t, 0, alice.Rename("/ws/foo.txt", "/ws/bar.txt"))
+	equals(t, 0, alice.Unlink("/ws/bar.txt"))
+
+	evs, err := fs.estore.Read("", 0)
+	ok(t, err)
+
+	ops := []string{}
+	for _, ev := range evs {
+		ops = append(ops, ev.Op)
+		equals(t, "alice", ev.Actor)
+		assert(t, ev.Version > 0, "expected a commit version, got: %d", ev.Version)
+	}
+
+	equals(t, []string{"mkdir", "create", "write", "rename", "unlink"}, ops)
+	equals(t, "/ws/bar.txt", evs[3].NewPath)
+	equals(t, evs[1].Ino, evs[4].Ino)
+
+	t.Run("cursor", func(t *testing.T) {
+		rest, err := fs.estore.Read(evs[2].Cursor, 1)
+		ok(t, err)
+		equals(t, 1, len(rest))
+		equals(t, "rename", rest[0].Op)
+
+		rest, err = fs.estore.Next(evs[4].Cursor, 0, time.Millisecond*10)
+		ok(t, err)
+		equals(t, 0, len(rest))
+	})
+
+	t.Run("writes are reported per flush", func(t *testing.T) {
+		errc, fh := alice.Create("/ws/baz.txt", fuse.O_CREAT|fuse.O_WRONLY, 0644)
+		equals(t, 0, errc)
+		for i := 0; i < 3; i++ {
+			equals(t, 1, alice.Write("/ws/baz.txt", []byte{0x01}, int64(i), fh))
+		}
+
+		equals(t, 0, alice.Flush("/ws/baz.txt", fh))
+		equals(t, 0, alice.Flush("/ws/baz.txt", fh))
+		equals(t, 0, alice.Release("/ws/baz.txt", fh))
+
+		rest, err := fs.estore.Read(evs[4].Cursor, 0)
+		ok(t, err)
+		ops := []string{}
+		for _, ev := range rest {
+			ops = append(ops, ev.Op)
+		}
+
+		equals(t, []string{"create", "write"}, ops)
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		equals(t, 0, fs.SweepChanges(time.Now().Add(time.Hour)))
+		all, err := fs.estore.Read("", 0)
+		ok(t, err)
+		equals(t, 7, len(all))
+
+		defer func(n int) { changesSweepBatch = n }(changesSweepBatch)
+		changesSweepBatch = 2
+		equals(t, 0, fs.SetChangeRetention(time.Minute))
+		equals(t, 0, fs.SweepChanges(time.Now().Add(time.Hour)))
+		all, err = fs.estore.Read("", 0)
+		ok(t, err)
+		equals(t, 0, len(all))
+
+		//readers continue at the oldest event that is left
+		equals(t, 0, alice.Mkdir("/ws2", 0777))
+		rest, err := fs.estore.Read(evs[0].Cursor, 0)
+		ok(t, err)
+		equals(t, 1, len(rest))
+		equals(t, "mkdir", rest[0].Op)
+	})
+}
+
+func TestVersions(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, 0, fs.SetRetention(2, 0))
+	for i := byte(1); i <= 4; i++ {
+		errc, fh := fs.Create("/foo.txt", fuse.O_CREAT|fuse.O_WRONLY|fuse.O_TRUNC, 0644)
+		equals(t, 0, errc)
+		equals(t, 2, fs.Write("/foo.txt", []byte{i, i}, 0, fh))
+		equals(t, 0, fs.Release("/foo.txt", fh))
+	}
+
+	errc, vers := fs.Versions("/foo.txt")
+	equals(t, 0, errc)
+	equals(t, 2, len(vers)) //only the newest two are retained
+	equals(t, int64(2), vers[0].Size)
+
+	t.Run("virtual directory", func(t *testing.T) {
+		name := fmt.Sprintf("/.versions/foo.txt/%d", vers[0].ID)
+		names := []string{}
+		errc, fh := fs.Opendir("/.versions/foo.txt")
+		equals(t, 0, errc)
+		equals(t, 0, fs.Readdir("/.versions/foo.txt", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+			names = append(names, name)
+			return true
+		}, 0, fh))
+		equals(t, 0, fs.Releasedir("/.versions/foo.txt", fh))
+		equals(t, 4, len(names))
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr(name, st, ^uint64(0)))
+		equals(t, uint32(fuse.S_IFREG|00444), st.Mode)
+
+		errc, _ = fs.Open(name, fuse.O_RDWR)
+		equals(t, -fuse.EROFS, errc)
+
+		errc, fh = fs.Open(name, fuse.O_RDONLY)
+		equals(t, 0, errc)
+		buf := make([]byte, 2)
+		equals(t, 2, fs.Read(name, buf, 0, fh))
+		equals(t, []byte{0x03, 0x03}, buf)
+		equals(t, 0, fs.Release(name, fh))
+
+		equals(t, -fuse.EROFS, fs.Mkdir("/.versions", 0777))
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		equals(t, 0, fs.Setxattr("/foo.txt", RestoreXAttr, []byte(fmt.Sprint(vers[1].ID)), 0))
+
+		errc, fh := fs.Open("/foo.txt", fuse.O_RDONLY)
+		equals(t, 0, errc)
+		buf := make([]byte, 2)
+		equals(t, 2, fs.Read("/foo.txt", buf, 0, fh))
+		equals(t, []byte{0x02, 0x02}, buf)
+		equals(t, 0, fs.Release("/foo.txt", fh))
+
+		errc, vers = fs.Versions("/foo.txt")
+		equals(t, 0, errc)
+		equals(t, 2, len(vers))
+	})
+}
+
+func TestTrash(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, 0, fs.SetTrash(true, time.Hour))
+	equals(t, 0, fs.Mkdir("/ws", 0777))
+	equals(t, 0, fs.Mkdir("/ws/sub", 0777))
+	equals(t, 0, fs.Mknod("/ws/sub/foo.txt", fuse.S_IFREG|0644, 0))
+
+	//like rm -rf, content is removed before the directories
+	equals(t, 0, fs.Unlink("/ws/sub/foo.txt"))
+	equals(t, 0, fs.Rmdir("/ws/sub"))
+	equals(t, 0, fs.Rmdir("/ws"))
+
+	entries := []*nodes.TrashEntry{}
+	equals(t, 0, fs.Trash(func(e *nodes.TrashEntry) bool {
+		entries = append(entries, e)
+		return true
+	}))
+	equals(t, 3, len(entries))
+	equals(t, "/ws/sub/foo.txt", entries[0].Path)
+	equals(t, true, entries[2].Dir)
+
+	t.Run("virtual directory", func(t *testing.T) {
+		st := &fuse.Stat_t{}
+		name := fmt.Sprintf("/.trash/%d_foo.txt", entries[0].ID)
+		equals(t, 0, fs.Getattr(name, st, ^uint64(0)))
+		equals(t, uint32(fuse.S_IFREG|0444), st.Mode)
+		equals(t, -fuse.EROFS, fs.Mknod("/.trash/bar.txt", fuse.S_IFREG|0644, 0))
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		equals(t, 0, fs.RestoreTrash(entries[2].ID))
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr("/ws/sub/foo.txt", st, ^uint64(0)))
+		equals(t, uint32(1), st.Nlink)
+
+		n := 0
+		equals(t, 0, fs.Trash(func(e *nodes.TrashEntry) bool { n++; return true }))
+		equals(t, 0, n)
+	})
+
+	t.Run("purge and sweep", func(t *testing.T) {
+		equals(t, 0, fs.Unlink("/ws/sub/foo.txt"))
+		equals(t, 0, fs.Rmdir("/ws/sub"))
+
+		equals(t, 0, fs.SweepTrash(time.Now()))
+		n := 0
+		equals(t, 0, fs.Trash(func(e *nodes.TrashEntry) bool { n++; return true }))
+		equals(t, 2, n)
+
+		equals(t, 0, fs.SweepTrash(time.Now().Add(time.Hour*2)))
+		n = 0
+		equals(t, 0, fs.Trash(func(e *nodes.TrashEntry) bool { n++; return true }))
+		equals(t, 0, n)
+	})
+}
+
+func TestQuota(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	alice := fs.As("alice")
+	equals(t, 0, fs.SetQuota(quota.Volume, quota.Limit{HardBytes: 10 * 1024, HardInodes: 5}))
+	equals(t, 0, fs.SetQuota("alice", quota.Limit{HardBytes: 2 * 1024}))
+
+	errc, fh := alice.Create("/foo.txt", fuse.O_RDWR, 0644)
+	equals(t, 0, errc)
+	equals(t, 1024, alice.Write("/foo.txt", make([]byte, 1024), 0, fh))
+	equals(t, -EDQUOT, alice.Write("/foo.txt", make([]byte, 2048), 1024, fh))
+	equals(t, -EDQUOT, alice.Truncate("/foo.txt", 4096, fh))
+	equals(t, 0, alice.Release("/foo.txt", fh))
+
+	errc, l, u := fs.Usage("alice")
+	equals(t, 0, errc)
+	equals(t, int64(2*1024), l.HardBytes)
+	equals(t, quota.Usage{Bytes: 1024, Inodes: 1}, u)
+
+	t.Run("statfs reflects the tightest limit", func(t *testing.T) {
+		st := &fuse.Statfs_t{}
+		equals(t, 0, alice.Statfs("/", st))
+		equals(t, uint64(2), st.Blocks)
+		equals(t, uint64(1), st.Bfree)
+		equals(t, uint64(5), st.Files)
+		equals(t, uint64(4), st.Ffree)
+	})
+
+	t.Run("volume limits", func(t *testing.T) {
+		equals(t, -fuse.ENOSPC, fs.Truncate("/foo.txt", 20*1024, ^uint64(0)))
+		for i := 0; i < 4; i++ {
+			equals(t, 0, fs.Mkdir(fmt.Sprintf("/dir%d", i), 0777))
+		}
+
+		equals(t, -fuse.ENOSPC, fs.Mkdir("/dir4", 0777))
+	})
+
+	t.Run("removal releases usage", func(t *testing.T) {
+		equals(t, 0, fs.Unlink("/foo.txt"))
+		_, _, u := fs.Usage("alice")
+		equals(t, quota.Usage{}, u)
+		_, _, u = fs.Usage(quota.Volume)
+		equals(t, quota.Usage{Inodes: 4}, u)
+	})
+}
+
+func TestDirSize(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, 0, fs.Mkdir("/ws", 0777))
+	equals(t, 0, fs.Mkdir("/ws/sub", 0777))
+	errc, fh := fs.Create("/ws/sub/foo.txt", fuse.O_RDWR, 0644)
+	equals(t, 0, errc)
+	equals(t, 100, fs.Write("/ws/sub/foo.txt", make([]byte, 100), 0, fh))
+	equals(t, 0, fs.Release("/ws/sub/foo.txt", fh))
+	equals(t, 0, fs.Symlink("foo.txt", "/ws/link"))
+
+	errc, ds := fs.DirSize("/ws")
+	equals(t, 0, errc)
+	equals(t, nodes.DirSize{Size: 107, Files: 2, Dirs: 1}, ds)
+
+	errc, xatr := fs.Getxattr("/ws/sub", DirSizeXAttr)
+	equals(t, 0, errc)
+	equals(t, `{"size":100,"files":1,"dirs":0}`, string(xatr))
+	equals(t, -fuse.EPERM, fs.Setxattr("/ws/sub", DirSizeXAttr, []byte("{}"), 0))
+
+	t.Run("truncate and rename", func(t *testing.T) {
+		equals(t, 0, fs.Truncate("/ws/sub/foo.txt", 50, ^uint64(0)))
+		equals(t, 0, fs.Rename("/ws/sub", "/sub"))
+
+		_, ds := fs.DirSize("/ws")
+		equals(t, nodes.DirSize{Size: 7, Files: 1}, ds)
+		_, ds = fs.DirSize("/")
+		equals(t, nodes.DirSize{Size: 57, Files: 2, Dirs: 2}, ds)
+	})
+
+	t.Run("unlink", func(t *testing.T) {
+		equals(t, 0, fs.Unlink("/sub/foo.txt"))
+		equals(t, 0, fs.Rmdir("/sub"))
+
+		_, ds := fs.DirSize("/")
+		equals(t, nodes.DirSize{Size: 7, Files: 1, Dirs: 1}, ds)
+	})
+
+	t.Run("hard links", func(t *testing.T) {
+		equals(t, 0, fs.Mkdir("/a", 0777))
+		equals(t, 0, fs.Mkdir("/b", 0777))
+		errc, fh := fs.Create("/a/f", fuse.O_RDWR, 0644)
+		equals(t, 0, errc)
+		equals(t, 10, fs.Write("/a/f", make([]byte, 10), 0, fh))
+		equals(t, 0, fs.Link("/a/f", "/b/g"))
+		equals(t, 20, fs.Write("/a/f", make([]byte, 20), 10, fh))
+
+		_, ds := fs.DirSize("/b")
+		equals(t, nodes.DirSize{Size: 10, Files: 1}, ds)
+		equals(t, 0, fs.Unlink("/b/g"))
+		_, ds = fs.DirSize("/b")
+		equals(t, nodes.DirSize{}, ds)
+		_, ds = fs.DirSize("/")
+		equals(t, nodes.DirSize{Size: 37, Files: 2, Dirs: 3}, ds)
+
+		equals(t, 10, fs.Write("/a/f", make([]byte, 10), 30, fh))
+		equals(t, 0, fs.Release("/a/f", fh))
+		equals(t, 0, fs.Rename("/a/f", "/b/f"))
+		_, ds = fs.DirSize("/b")
+		equals(t, nodes.DirSize{Size: 40, Files: 1}, ds)
+		equals(t, 0, fs.Unlink("/b/f"))
+		_, ds = fs.DirSize("/")
+		equals(t, nodes.DirSize{Size: 7, Files: 1, Dirs: 3}, ds)
+	})
+}
+
+func TestDedupUpload(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	data := make([]byte, 3*dedup.DefaultChunkSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	equals(t, 0, fs.Mknod("/big.bin", fuse.S_IFREG|0644, 0))
+	stats, err := dedup.Upload(fs, "/big.bin", bytes.NewReader(data))
+	ok(t, err)
+	equals(t, stats.Chunks, stats.Sent)
+	equals(t, int64(len(data)), stats.Bytes)
+
+	t.Run("only the delta is sent", func(t *testing.T) {
+		data[len(data)-1] = 0x42
+		stats, err := dedup.Upload(fs, "/big.bin", bytes.NewReader(data))
+		ok(t, err)
+		assert(t, stats.Sent < stats.Chunks, "expected less chunks to be sent, got: %d of %d", stats.Sent, stats.Chunks)
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr("/big.bin", st, ^uint64(0)))
+		equals(t, int64(len(data)), st.Size)
+
+		buf := bytes.NewBuffer(nil)
+		ok(t, NewBrowser(fs).Readfile("/big.bin", buf))
+		equals(t, true, bytes.Equal(data, buf.Bytes()))
+	})
+
+	t.Run("split in batches", func(t *testing.T) {
+		defer func(max int) { dedup.MaxBatch = max }(dedup.MaxBatch)
+		dedup.MaxBatch = dedup.DefaultChunkSize
+
+		data[0] = 0x42
+		_, err := dedup.Upload(fs, "/big.bin", bytes.NewReader(data))
+		ok(t, err)
+
+		buf := bytes.NewBuffer(nil)
+		ok(t, NewBrowser(fs).Readfile("/big.bin", buf))
+		equals(t, true, bytes.Equal(data, buf.Bytes()))
+	})
+
+	t.Run("invalid uploads", func(t *testing.T) {
+		equals(t, -fuse.EINVAL, fs.PutChunks([]dedup.Chunk{{
+			Ref: dedup.Ref{Key: make([]byte, 64), Type: "blob"},
+			Buf: []byte("foo"),
+		}}))
+
+		m, err := dedup.Split(bytes.NewReader([]byte("never sent")), dedup.DefaultChunkSize, dedup.DefaultFanout, func(cs []dedup.Chunk) error { return nil })
+		ok(t, err)
+		equals(t, -fuse.ENOENT, fs.CommitManifest("/big.bin", m))
+		equals(t, -fuse.ENOENT, fs.CommitManifest("/other.bin", m))
+
+		//every chunk is checked, not just those at the start and end
+		other := make([]byte, 3*dedup.DefaultChunkSize)
+		for i := range other {
+			other[i] = byte(i % 241)
+		}
+
+		var cs []dedup.Chunk
+		m, err = dedup.Split(bytes.NewReader(other), dedup.DefaultChunkSize, dedup.DefaultFanout, func(batch []dedup.Chunk) error {
+			cs = append(cs, batch...)
+			return nil
+		})
+		ok(t, err)
+
+		for i, c := range cs {
+			if i != 1 {
+				equals(t, 0, fs.PutChunks([]dedup.Chunk{c}))
+			}
+		}
+
+		equals(t, -fuse.ENOENT, fs.CommitManifest("/big.bin", m))
+
+		//chunks that are put are bounded by the request and the quota
+		large := dedup.Chunk{Buf: make([]byte, putChunksMax/2+1)}
+		equals(t, -fuse.E2BIG, fs.PutChunks([]dedup.Chunk{large, large}))
+		equals(t, 0, fs.SetQuota(quota.Volume, quota.Limit{HardBytes: 1}))
+		equals(t, -fuse.ENOSPC, fs.PutChunks(cs[1:2]))
+		equals(t, 0, fs.SetQuota(quota.Volume, quota.Limit{}))
+		equals(t, 0, fs.PutChunks(cs[1:2]))
+		equals(t, 0, fs.CommitManifest("/big.bin", m))
+	})
+}
+
+func TestContentDefinedChunking(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, -fuse.EINVAL, fs.SetChunking("foo", 0))
+	equals(t, 0, fs.SetChunking("cdc", 4096))
+	equals(t, 0, fs.SetRetention(10, 0))
+
+	data := make([]byte, 100*1024)
+	for i := range data {
+		data[i] = byte((i * 7) % 253)
+	}
+
+	errc, fh := fs.Create("/foo.bin", fuse.O_RDWR, 0644)
+	equals(t, 0, errc)
+	equals(t, len(data), fs.Write("/foo.bin", data, 0, fh))
+	equals(t, 0, fs.Release("/foo.bin", fh))
+
+	errc, fh = fs.Open("/foo.bin", fuse.O_RDWR)
+	equals(t, 0, errc)
+	equals(t, 3, fs.Write("/foo.bin", []byte("bar"), 10, fh))
+	equals(t, 0, fs.Release("/foo.bin", fh))
+	copy(data[10:], "bar")
+
+	buf := bytes.NewBuffer(nil)
+	ok(t, NewBrowser(fs).Readfile("/foo.bin", buf))
+	equals(t, true, bytes.Equal(data, buf.Bytes()))
+
+	errc, vers := fs.Versions("/foo.bin")
+	equals(t, 0, errc)
+	equals(t, 1, len(vers))
+
+	buf.Reset()
+	ok(t, NewBrowser(fs).ReadVersion("/foo.bin", vers[0].ID, buf))
+	equals(t, len(data), buf.Len())
+}
+
+func TestScrub(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	dir, err := ioutil.TempDir("", "ffs_")
+	ok(t, err)
+	fs, clean, err := NewTempFS(dir, db)
+	ok(t, err)
+	defer clean()
+
+	data := bytes.Repeat([]byte("scrub me "), 100)
+	equals(t, 0, fs.Mknod("/a.txt", fuse.S_IFREG|0644, 0))
+	_, err = dedup.Upload(fs, "/a.txt", bytes.NewReader(data))
+	ok(t, err)
+
+	errc, rep := fs.Scrub(false)
+	equals(t, 0, errc)
+	equals(t, 1, rep.Files)
+	equals(t, 0, len(rep.Corrupt))
+
+	//flip a bit in every stored chunk, the file consists of a single one
+	ok(t, filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+
+		d, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		d[len(d)-1] ^= 0x01
+		return ioutil.WriteFile(p, d, 0644)
+	}))
+
+	equals(t, 0, fs.SetVerifyReads(true))
+	assert(t, NewBrowser(fs).Readfile("/a.txt", bytes.NewBuffer(nil)) != nil, "expected verified read to fail")
+	errc, cs := fs.CorruptChunks()
+	equals(t, 0, errc)
+	equals(t, 1, len(cs))
+
+	errc, rep = fs.Scrub(true)
+	equals(t, 0, errc)
+	equals(t, 1, len(rep.Corrupt))
+	equals(t, []string{"/a.txt"}, rep.Corrupt[0].Paths)
+	equals(t, true, rep.Corrupt[0].Quarantined)
+
+	t.Run("re-upload repairs", func(t *testing.T) {
+		stats, err := dedup.Upload(fs, "/a.txt", bytes.NewReader(data))
+		ok(t, err)
+		equals(t, 1, stats.Sent)
+
+		buf := bytes.NewBuffer(nil)
+		ok(t, NewBrowser(fs).Readfile("/a.txt", buf))
+		equals(t, data, buf.Bytes())
+
+		errc, rep = fs.Scrub(false)
+		equals(t, 0, errc)
+		equals(t, 0, len(rep.Corrupt))
+		errc, cs = fs.CorruptChunks()
+		equals(t, 0, len(cs))
+	})
+
+	t.Run("large xattrs are scrubbed", func(t *testing.T) {
+		errc, rep = fs.Scrub(false)
+		equals(t, 0, errc)
+		chunks := rep.Chunks
+
+		equals(t, 0, fs.Setxattr("/a.txt", "user.large", bytes.Repeat([]byte("x"), nodes.InlineLimit+1), 0))
+		errc, rep = fs.Scrub(false)
+		equals(t, 0, errc)
+		equals(t, 1, rep.Files)
+		assert(t, rep.Chunks > chunks, "expected the chunks of the xattr to be scrubbed")
+	})
+}
+
+func TestFsck(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, 0, fs.SetRetention(10, 0))
+	equals(t, 0, fs.Mknod("/a", fuse.S_IFREG|0644, 0))
+	equals(t, 0, fs.Mkdir("/d", 0755))
+	equals(t, 0, fs.Mknod("/d/b", fuse.S_IFREG|0644, 0))
+	equals(t, 0, fs.Mknod("/c", fuse.S_IFREG|0644, 0))
+	v1, v2 := []byte("first version"), []byte("second version")
+	_, err = dedup.Upload(fs, "/c", bytes.NewReader(v1))
+	ok(t, err)
+	_, err = dedup.Upload(fs, "/c", bytes.NewReader(v2))
+	ok(t, err)
+
+	errc, rep := fs.Fsck(false)
+	equals(t, 0, errc)
+	equals(t, 0, len(rep.Issues))
+
+	//corrupt the metadata in every way fsck knows about
+	var root scrub.Ref
+	equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, a := fs.lookupNode(tx, "/a", nil)
+		a.StatSetNlink(tx, 3)
+		a.SetOpencnt(tx, 2)
+
+		fs.nstore.Root(tx).DelChld(tx, "d")
+		fs.nstore.Root(tx).SetChld(tx, "ghost", fs.nstore.Node(9999))
+		fs.hstore.Open(tx, fs.nstore.Node(9999), fuse.O_RDONLY, "gone")
+
+		_, _, c := fs.lookupNode(tx, "/c", nil)
+		root = scrub.Root(c.Manifest(tx))
+		return 0
+	}))
+
+	ok(t, fs.cstore.(*chunkstore.Store).Quarantine(context.Background(), root.Key))
+
+	errc, rep = fs.Fsck(false)
+	equals(t, 0, errc)
+	kinds := map[string]int{}
+	for _, i := range rep.Issues {
+		kinds[i.Kind]++
+		equals(t, false, i.Repaired)
+	}
+
+	equals(t, map[string]int{
+		FsckNlink:        1,
+		FsckOrphan:       1,
+		FsckDangling:     1,
+		FsckStaleHandle:  1,
+		FsckOpencnt:      1,
+		FsckMissingChunk: 1,
+	}, kinds)
+
+	errc, rep = fs.Fsck(true)
+	equals(t, 0, errc)
+	for _, i := range rep.Issues {
+		assert(t, i.Repaired, "expected issue to be repaired: %+v", i)
+	}
+
+	errc, rep = fs.Fsck(false)
+	equals(t, 0, errc)
+	equals(t, 0, len(rep.Issues))
+
+	t.Run("repaired tree", func(t *testing.T) {
+		ino := uint64(0)
+		equals(t, -fuse.ENOENT, fs.Getattr("/d", &fuse.Stat_t{}, ^uint64(0)))
+		equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			_, _, d := fs.lookupNode(tx, "/"+LostFoundDir, nil)
+			d.ChldEach(tx, func(name string, n *nodes.Node) bool {
+				ino = n.Ino()
+				return true
+			})
+
+			return 0
+		}))
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr(fmt.Sprintf("/%s/%d/b", LostFoundDir, ino), st, ^uint64(0)))
+
+		buf := bytes.NewBuffer(nil)
+		ok(t, NewBrowser(fs).Readfile("/c", buf))
+		equals(t, v1, buf.Bytes())
+	})
+
+	t.Run("lost and found is a file", func(t *testing.T) {
+		equals(t, 0, fs.Rename("/"+LostFoundDir, "/found"))
+		equals(t, 0, fs.Mknod("/"+LostFoundDir, fuse.S_IFREG|0644, 0))
+		equals(t, 0, fs.Mkdir("/e", 0755))
+		equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			fs.nstore.Root(tx).DelChld(tx, "e")
+			return 0
+		}))
+
+		errc, rep := fs.Fsck(true)
+		equals(t, 0, errc)
+		equals(t, 1, len(rep.Issues))
+		equals(t, FsckOrphan, rep.Issues[0].Kind)
+		equals(t, false, rep.Issues[0].Repaired)
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr("/"+LostFoundDir, st, ^uint64(0)))
+		equals(t, int64(0), st.Size)
+	})
+}
+
+func TestBackup(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	tdir, err := ioutil.TempDir("", "ffs_backup_")
+	ok(t, err)
+	defer os.RemoveAll(tdir)
+	target, err := chunkstore.Open(chunkstore.Config{ChunkDir: tdir})
+	ok(t, err)
+	defer target.Close()
+
+	data := bytes.Repeat([]byte("back me up "), 1000)
+	equals(t, 0, fs.Mkdir("/d", 0700))
+	equals(t, 0, fs.Mknod("/d/a", fuse.S_IFREG|0640, 0))
+	_, err = dedup.Upload(fs, "/d/a", bytes.NewReader(data))
+	ok(t, err)
+	equals(t, 0, fs.Link("/d/a", "/b"))
+	equals(t, 0, fs.Symlink("d/a", "/l"))
+	equals(t, 0, fs.Setxattr("/d/a", "user.tag", []byte("x"), 0))
+
+	errc, rep := fs.Backup(target, "vol")
+	equals(t, 0, errc)
+	equals(t, uint64(1), rep.Snapshot)
+	equals(t, 1, rep.Files)
+	assert(t, rep.Chunks > 0, "expected chunks to be copied")
+
+	//nothing changed so the second backup copies no chunks
+	errc, rep = fs.Backup(target, "vol")
+	equals(t, 0, errc)
+	equals(t, uint64(2), rep.Snapshot)
+	equals(t, 0, rep.Chunks)
+
+	seq, err := LatestSnapshot(context.Background(), target, "vol")
+	ok(t, err)
+	equals(t, uint64(2), seq)
+
+	t.Run("restore", func(t *testing.T) {
+		rfs, rclean, err := NewTempFS("", db)
+		ok(t, err)
+		defer rclean()
+
+		errc, rrep := rfs.Restore(target, "vol", 1)
+		equals(t, 0, errc)
+		equals(t, 1, rrep.Files)
+
+		buf := bytes.NewBuffer(nil)
+		ok(t, NewBrowser(rfs).Readfile("/b", buf))
+		equals(t, data, buf.Bytes())
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, rfs.Getattr("/d/a", st, ^uint64(0)))
+		equals(t, uint32(fuse.S_IFREG|0640), st.Mode)
+		equals(t, uint32(2), st.Nlink)
+
+		errc, lnk := rfs.Readlink("/l")
+		equals(t, 0, errc)
+		equals(t, "d/a", lnk)
+
+		errc, xatr := rfs.Getxattr("/d/a", "user.tag")
+		equals(t, 0, errc)
+		equals(t, []byte("x"), xatr)
+
+		errc, _ = rfs.Restore(target, "vol", 1)
+		equals(t, -fuse.ENOTEMPTY, errc)
+	})
+
+	t.Run("volumes share a target", func(t *testing.T) {
+		errc, rep := fs.Backup(target, "other")
+		equals(t, 0, errc)
+		equals(t, uint64(1), rep.Snapshot)
+
+		seq, err := LatestSnapshot(context.Background(), target, "vol")
+		ok(t, err)
+		equals(t, uint64(2), seq)
+	})
+
+	t.Run("snapshots are not overwritten", func(t *testing.T) {
+		//another backup stores the snapshot after this one looked for it
+		errc, _ := fs.Backup(&racingTarget{Store: target}, "vol")
+		equals(t, -fuse.EEXIST, errc)
+
+		seq, err := LatestSnapshot(context.Background(), target, "vol")
+		ok(t, err)
+		equals(t, uint64(2), seq)
+	})
+
+	t.Run("corrupt target", func(t *testing.T) {
+		ok(t, filepath.Walk(tdir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() || len(fi.Name()) < hex.EncodedLen(cas.KeySize)+len(".data") {
+				return err //snapshots are stored under shorter names
+			}
+
+			d, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+
+			d[len(d)-1] ^= 0x01
+			return ioutil.WriteFile(p, d, 0644)
+		}))
+
+		rfs, rclean, err := NewTempFS("", db)
+		ok(t, err)
+		defer rclean()
+
+		errc, _ := rfs.Restore(target, "vol", 2)
+		equals(t, -fuse.EIO, errc)
+	})
+}
+
+//racingTarget misses the latest snapshot the first time it is asked for it,
+//as if another backup stored it in the meantime
+type racingTarget struct {
+	*chunkstore.Store
+	asked bool
+}
+
+func (r *racingTarget) HasObject(ctx context.Context, name string) (bool, error) {
+	has, err := r.Store.HasObject(ctx, name)
+	if has && !r.asked {
+		r.asked = true
+		return false, err
+	}
+
+	return has, err
+}
+
+func TestTar(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	data := bytes.Repeat([]byte("tar me "), 20000)
+	equals(t, 0, fs.Mkdir("/src", 0755))
+	equals(t, 0, fs.Mkdir("/src/d", 0700))
+	equals(t, 0, fs.Mknod("/src/d/a", fuse.S_IFREG|0640, 0))
+	_, err = dedup.Upload(fs, "/src/d/a", bytes.NewReader(data))
+	ok(t, err)
+	equals(t, 0, fs.Link("/src/d/a", "/src/b"))
+	equals(t, 0, fs.Symlink("d/a", "/src/l"))
+	equals(t, 0, fs.Setxattr("/src/d/a", "user.tag", []byte("x"), 0))
+	mtim := fuse.NewTimespec(time.Unix(1500000000, 0))
+	equals(t, 0, fs.Utimens("/src/d", []fuse.Timespec{mtim, mtim}))
+
+	b, buf := NewBrowser(fs), bytes.NewBuffer(nil)
+	ok(t, b.ExportTar("/src", buf))
+
+	equals(t, 0, fs.Mkdir("/dst", 0755))
+	n, err := b.ImportTar("/dst", buf)
+	ok(t, err)
+	equals(t, 4, n)
+
+	content := bytes.NewBuffer(nil)
+	ok(t, b.Readfile("/dst/b", content))
+	equals(t, data, content.Bytes())
+
+	st := &fuse.Stat_t{}
+	equals(t, 0, fs.Getattr("/dst/d/a", st, ^uint64(0)))
+	equals(t, uint32(fuse.S_IFREG|0640), st.Mode)
+	equals(t, uint32(2), st.Nlink)
+
+	equals(t, 0, fs.Getattr("/dst/d", st, ^uint64(0)))
+	equals(t, mtim.Sec, st.Mtim.Sec)
+
+	errc, lnk := fs.Readlink("/dst/l")
+	equals(t, 0, errc)
+	equals(t, "d/a", lnk)
+
+	errc, xatr := fs.Getxattr("/dst/d/a", "user.tag")
+	equals(t, 0, errc)
+	equals(t, []byte("x"), xatr)
+
+	t.Run("escaping names", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		ok(t, tw.WriteHeader(&tar.Header{Name: "../../escaped", Typeflag: tar.TypeReg, Mode: 0644}))
+		ok(t, tw.Close())
+
+		_, err := b.ImportTar("/dst/d", buf)
+		ok(t, err)
+		equals(t, 0, fs.Getattr("/dst/d/escaped", st, ^uint64(0)))
+		equals(t, -fuse.ENOENT, fs.Getattr("/escaped", st, ^uint64(0)))
+	})
+
+	t.Run("merged directories keep their attributes", func(t *testing.T) {
+		equals(t, 0, fs.Mkdir("/merge", 0755))
+		equals(t, 0, fs.Mkdir("/merge/sub", 0700))
+		equals(t, 0, fs.Setxattr("/merge/sub", "user.tag", []byte("mine"), 0))
+
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		ok(t, tw.WriteHeader(&tar.Header{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0777, PAXRecords: map[string]string{xattrPAX + "user.tag": "theirs"}}))
+		ok(t, tw.WriteHeader(&tar.Header{Name: "sub/f", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}))
+		_, err := tw.Write([]byte("abc"))
+		ok(t, err)
+		ok(t, tw.Close())
+
+		_, err = b.ImportTar("/merge", buf)
+		ok(t, err)
+		equals(t, 0, fs.Getattr("/merge/sub", st, ^uint64(0)))
+		equals(t, uint32(fuse.S_IFDIR|0700), st.Mode)
+		errc, xatr := fs.Getxattr("/merge/sub", "user.tag")
+		equals(t, 0, errc)
+		equals(t, []byte("mine"), xatr)
+
+		content := bytes.NewBuffer(nil)
+		ok(t, b.Readfile("/merge/sub/f", content))
+		equals(t, []byte("abc"), content.Bytes())
+	})
+
+	t.Run("reserved xattrs", func(t *testing.T) {
+		for i, name := range []string{RestoreXAttr, LeaseXAttr, "system.posix_acl_access", "trusted.tag"} {
+			buf := bytes.NewBuffer(nil)
+			tw := tar.NewWriter(buf)
+			ok(t, tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("r%d", i), Typeflag: tar.TypeReg, Mode: 0644, PAXRecords: map[string]string{xattrPAX + name: "1"}}))
+			ok(t, tw.Close())
+
+			_, err := b.ImportTar("/dst", buf)
+			assert(t, err != nil, "expected import of %s to fail", name)
+		}
+	})
+
+	t.Run("links over existing files", func(t *testing.T) {
+		equals(t, 0, fs.Mkdir("/over", 0755))
+		equals(t, 0, fs.Mknod("/over/g", fuse.S_IFREG|0644, 0))
+
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		ok(t, tw.WriteHeader(&tar.Header{Name: "f", Typeflag: tar.TypeReg, Mode: 0644}))
+		ok(t, tw.WriteHeader(&tar.Header{Name: "g", Typeflag: tar.TypeLink, Linkname: "f"}))
+		ok(t, tw.Close())
+
+		_, err := b.ImportTar("/over", buf)
+		assert(t, err != nil, "expected a link over an existing file to fail")
+
+		st := &fuse.Stat_t{}
+		equals(t, 0, fs.Getattr("/over/g", st, ^uint64(0)))
+		equals(t, uint32(1), st.Nlink)
+	})
+}
+
+func TestClone(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	//sealed xattrs are bound to their node so they must be sealed again
+	fs.SetSealer(crypt.NewKeyring())
+	data := bytes.Repeat([]byte("clone me "), 1000)
+	equals(t, 0, fs.Mkdir("/ws", 0755))
+	equals(t, 0, fs.Mknod("/ws/a", fuse.S_IFREG|0600, 0))
+	_, err = dedup.Upload(fs, "/ws/a", bytes.NewReader(data))
+	ok(t, err)
+	equals(t, 0, fs.Link("/ws/a", "/ws/b"))
+	equals(t, 0, fs.Setxattr("/ws/a", "user.tag", []byte("x"), 0))
+
+	equals(t, -fuse.EINVAL, fs.Clone("/ws", "/ws/sub"))
+	equals(t, -fuse.ENOENT, fs.Clone("/ws", "/nope/ws"))
+	equals(t, 0, fs.Clone("/ws", "/ws2"))
+	equals(t, -fuse.EEXIST, fs.Clone("/ws", "/ws2"))
+
+	var m1, m2 *blobs.Manifest
+	equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, a := fs.lookupNode(tx, "/ws/a", nil)
+		_, _, a2 := fs.lookupNode(tx, "/ws2/a", nil)
+		m1, m2 = a.Manifest(tx), a2.Manifest(tx)
+		return 0
+	}))
+
+	equals(t, m1.Root, m2.Root)
+
+	st := &fuse.Stat_t{}
+	equals(t, 0, fs.Getattr("/ws2/b", st, ^uint64(0)))
+	equals(t, uint32(2), st.Nlink)
+	equals(t, uint32(fuse.S_IFREG|0600), st.Mode)
+
+	errc, xatr := fs.Getxattr("/ws2/a", "user.tag")
+	equals(t, 0, errc)
+	equals(t, []byte("x"), xatr)
+
+	//writing to the clone leaves the original alone
+	errc, fh := fs.Open("/ws2/a", fuse.O_WRONLY)
+	equals(t, 0, errc)
+	equals(t, 3, fs.Write("/ws2/a", []byte("new"), 0, fh))
+	equals(t, 0, fs.Release("/ws2/a", fh))
+
+	buf := bytes.NewBuffer(nil)
+	ok(t, NewBrowser(fs).Readfile("/ws/b", buf))
+	equals(t, data, buf.Bytes())
+}
+
+func TestBulk(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	wait := func(id uint64) nodes.BulkJob {
+		for {
+			errc, job := fs.BulkStatus(id)
+			equals(t, 0, errc)
+			if job.Finished {
+				return job
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	equals(t, 0, fs.Mkdir("/ws", 0755))
+	for _, d := range []string{"/ws/a", "/ws/b", "/ws/a/c"} {
+		equals(t, 0, fs.Mkdir(d, 0755))
+		equals(t, 0, fs.Mknod(d+"/f", fuse.S_IFREG|0600, 0))
+	}
+
+	errc, _ := fs.Bulk(BulkRemove, "/", "")
+	equals(t, -fuse.EINVAL, errc)
+	errc, _ = fs.Bulk("chmod", "/ws", "")
+	equals(t, -fuse.EINVAL, errc)
+	errc, _ = fs.Bulk(BulkCopy, "/ws", "/ws/a/ws")
+	equals(t, -fuse.EINVAL, errc)
+
+	errc, id := fs.Bulk(BulkCopy, "/ws", "/ws2")
+	equals(t, 0, errc)
+	job := wait(id)
+	equals(t, 0, job.Errc)
+	equals(t, int64(7), job.Total)
+	equals(t, job.Total, job.Done)
+	errc, ds := fs.DirSize("/ws2")
+	equals(t, 0, errc)
+	equals(t, int64(3), ds.Files)
+
+	errc, id = fs.Bulk(BulkMove, "/ws2", "/ws3")
+	equals(t, 0, errc)
+	equals(t, 0, wait(id).Errc)
+	st := &fuse.Stat_t{}
+	equals(t, -fuse.ENOENT, fs.Getattr("/ws2", st, ^uint64(0)))
+	equals(t, 0, fs.Getattr("/ws3/a/c/f", st, ^uint64(0)))
+
+	errc, id = fs.Bulk(BulkRemove, "/ws3", "")
+	equals(t, 0, errc)
+	job = wait(id)
+	equals(t, 0, job.Errc)
+	equals(t, int64(7), job.Done)
+	equals(t, -fuse.ENOENT, fs.Getattr("/ws3", st, ^uint64(0)))
+
+	//resuming a job that was interrupted removes what is left of the tree
+	equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		j := fs.nstore.GetBulk(tx, id)
+		j.Src, j.Finished = "/ws", false
+		fs.nstore.PutBulk(tx, j)
+		return 0
+	}))
+
+	equals(t, 0, fs.ResumeBulk(id))
+	equals(t, 0, wait(id).Errc)
+	equals(t, -fuse.ENOENT, fs.Getattr("/ws", st, ^uint64(0)))
+	equals(t, 0, fs.ResumeBulk(id))
+	equals(t, -fuse.ENOENT, fs.ResumeBulk(id+1))
+
+	errc, jobs := fs.BulkJobs()
+	equals(t, 0, errc)
+	equals(t, 3, len(jobs))
+}
+
+func TestInline(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	manifest := func(p string) (m *blobs.Manifest) {
+		equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			_, _, node := fs.lookupNode(tx, p, nil)
+			m = node.Manifest(tx)
+			return 0
+		}))
+
+		return m
+	}
+
+	write := func(p string, data []byte, ofst int64) {
+		errc, fh := fs.Open(p, fuse.O_WRONLY)
+		equals(t, 0, errc)
+		equals(t, len(data), fs.Write(p, data, ofst, fh))
+		equals(t, 0, fs.Release(p, fh))
+	}
+
+	//symlink targets are stored inline right away
+	equals(t, 0, fs.Symlink("some/target", "/l"))
+	equals(t, true, nodes.IsInline(manifest("/l")))
+	errc, target := fs.Readlink("/l")
+	equals(t, 0, errc)
+	equals(t, "some/target", target)
+
+	equals(t, 0, fs.Mknod("/f", fuse.S_IFREG|0600, 0))
+	write("/f", []byte("key=value\n"), 0)
+	m := manifest("/f")
+	equals(t, true, nodes.IsInline(m))
+	equals(t, uint64(10), m.Size)
+
+	//the file is promoted to a blob once it grows beyond the limit
+	data := bytes.Repeat([]byte("x"), nodes.InlineLimit+1)
+	copy(data, "key=value\n")
+	write("/f", data[10:], 10)
+	m = manifest("/f")
+	equals(t, false, nodes.IsInline(m))
+	equals(t, uint64(len(data)), m.Size)
+
+	buf := bytes.NewBuffer(nil)
+	ok(t, NewBrowser(fs).Readfile("/f", buf))
+	equals(t, data, buf.Bytes())
+
+	//the inline content is kept as a version and clones share it
+	equals(t, 0, fs.SetRetention(10, 0))
+	equals(t, 0, fs.Mknod("/g", fuse.S_IFREG|0600, 0))
+	write("/g", []byte("small"), 0)
+	equals(t, 0, fs.Clone("/g", "/h"))
+	write("/g", []byte("SMALL"), 0)
+	buf.Reset()
+	ok(t, NewBrowser(fs).Readfile("/h", buf))
+	equals(t, "small", buf.String())
+
+	errc, ivs := fs.Versions("/g")
+	equals(t, 0, errc)
+	equals(t, 1, len(ivs))
+
+	//inline values are cleared once no manifest or version refers to them
+	stored := func(m *blobs.Manifest) (has bool) {
+		equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			_, err := fs.nstore.Root(tx).Inline(tx, m)
+			has = nil == err
+			return 0
+		}))
+
+		return has
+	}
+
+	equals(t, 0, fs.SetRetention(1, 0))
+	equals(t, 0, fs.Mknod("/i", fuse.S_IFREG|0600, 0))
+	ims := []*blobs.Manifest{}
+	for _, d := range []string{"one", "two", "six"} {
+		write("/i", []byte(d), 0)
+		ims = append(ims, manifest("/i"))
+	}
+
+	equals(t, []bool{false, true, true}, []bool{stored(ims[0]), stored(ims[1]), stored(ims[2])})
+	equals(t, 0, fs.Unlink("/i"))
+	equals(t, []bool{false, false}, []bool{stored(ims[1]), stored(ims[2])})
+	equals(t, true, stored(manifest("/h")))
+
+	//content that can't be opened fails the read instead of the server
+	equals(t, 0, fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := fs.lookupNode(tx, "/h", nil)
+		m := *node.Manifest(tx)
+		m.Root = cas.NewKey(bytes.Repeat([]byte{0x01}, cas.KeySize))
+		node.SetManifest(tx, &m, nil)
+		return 0
+	}))
+
+	errc, fh := fs.Open("/h", fuse.O_RDONLY)
+	equals(t, 0, errc)
+	equals(t, -fuse.EIO, fs.Read("/h", make([]byte, 5), 0, fh))
+	equals(t, 0, fs.Release("/h", fh))
+}
+
+func TestSparse(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	const mb = 1024 * 1024
+	seek := func(ofst int64, whence int) (int, int64) {
+		return fs.Lseek("/s", ofst, whence, ^uint64(0))
+	}
+
+	equals(t, 0, fs.Mknod("/s", fuse.S_IFREG|0600, 0))
+	errc, fh := fs.Open("/s", fuse.O_RDWR)
+	equals(t, 0, errc)
+	defer fs.Release("/s", fh)
+
+	//writing past the end leaves a hole that takes no blocks
+	equals(t, 3, fs.Write("/s", []byte("abc"), mb, fh))
+	st := &fuse.Stat_t{}
+	equals(t, 0, fs.Getattr("/s", st, fh))
+	equals(t, int64(mb+3), st.Size)
+	equals(t, int64(1), st.Blocks)
+
+	equals(t, []int64{mb, 0, mb + 3}, func() (ns []int64) {
+		for _, q := range []struct {
+			ofst   int64
+			whence int
+		}{{0, SeekData}, {0, SeekHole}, {mb, SeekHole}} {
+			errc, n := seek(q.ofst, q.whence)
+			equals(t, 0, errc)
+			ns = append(ns, n)
+		}
+
+		return ns
+	}())
+
+	errc, _ = seek(mb+3, SeekData)
+	equals(t, -fuse.ENXIO, errc)
+
+	buf := make([]byte, 4)
+	equals(t, 4, fs.Read("/s", buf, mb-1, fh))
+	equals(t, []byte("\x00abc"), buf)
+
+	//punching a hole replaces data with zeros
+	equals(t, -fuse.EOPNOTSUPP, fs.Fallocate("/s", FallocPunchHole, 0, 2, fh))
+	equals(t, 0, fs.Fallocate("/s", FallocPunchHole|FallocKeepSize, mb, 2, fh))
+	equals(t, 4, fs.Read("/s", buf, mb-1, fh))
+	equals(t, []byte("\x00\x00\x00c"), buf)
+	errc, n := seek(0, SeekData)
+	equals(t, 0, errc)
+	equals(t, int64(mb+2), n)
+
+	//allocating beyond the end grows the file with data that reads as zeros
+	equals(t, 0, fs.Fallocate("/s", 0, mb+3, mb, fh))
+	equals(t, 0, fs.Getattr("/s", st, fh))
+	equals(t, int64(2*mb+3), st.Size)
+	equals(t, int64((mb+1+511)/512), st.Blocks)
+	errc, n = seek(mb+2, SeekHole)
+	equals(t, 0, errc)
+	equals(t, int64(2*mb+3), n)
+
+	equals(t, 0, fs.Truncate("/s", 2, fh))
+	errc, _ = seek(0, SeekData)
+	equals(t, -fuse.ENXIO, errc)
+
+	//holes are recorded with the flushed content, copies and versions of it
+	//keep them
+	equals(t, 0, fs.SetRetention(10, 0))
+	equals(t, 3, fs.Write("/s", []byte("xyz"), mb, fh))
+	equals(t, 0, fs.Flush("/s", fh))
+	equals(t, 0, fs.Clone("/s", "/c"))
+	errc, n = fs.Lseek("/c", 0, SeekData, ^uint64(0))
+	equals(t, 0, errc)
+	equals(t, int64(mb), n)
+
+	equals(t, mb, fs.Write("/s", make([]byte, mb), 0, fh))
+	equals(t, 0, fs.Flush("/s", fh))
+	errc, n = seek(0, SeekData)
+	equals(t, 0, errc)
+	equals(t, int64(0), n)
+
+	errc, vers := fs.Versions("/s")
+	equals(t, 0, errc)
+	equals(t, 0, fs.Setxattr("/s", RestoreXAttr, []byte(fmt.Sprint(vers[0].ID)), 0))
+	errc, n = seek(0, SeekData)
+	equals(t, 0, errc)
+	equals(t, int64(mb), n)
+	equals(t, 4, fs.Read("/s", buf, mb-1, fh))
+	equals(t, []byte("\x00xyz"), buf)
+}
+
+func TestXattr(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, 0, fs.Mknod("/f", fuse.S_IFREG|0600, 0))
+	equals(t, 0, fs.Symlink("f", "/l"))
+
+	errc, _ := fs.Getxattr("/f", "user.nope")
+	equals(t, -fuse.ENOATTR, errc)
+	equals(t, -fuse.ENOATTR, fs.Removexattr("/f", "user.nope"))
+	equals(t, -fuse.ENOATTR, fs.Setxattr("/f", "user.nope", []byte("x"), fuse.XATTR_REPLACE))
+
+	//values that don't fit in the metadata are stored in chunks
+	large := bytes.Repeat([]byte("large xattr "), 30000)
+	equals(t, 0, fs.Setxattr("/f", "user.large", large, 0))
+	errc, xatr := fs.Getxattr("/f", "user.large")
+	equals(t, 0, errc)
+	equals(t, large, xatr)
+	equals(t, -fuse.EEXIST, fs.Setxattr("/f", "user.large", []byte("x"), fuse.XATTR_CREATE))
+	equals(t, 0, fs.Setxattr("/f", "user.large", []byte("small"), fuse.XATTR_REPLACE))
+	errc, xatr = fs.Getxattr("/f", "user.large")
+	equals(t, 0, errc)
+	equals(t, []byte("small"), xatr)
+	equals(t, -fuse.E2BIG, fs.Setxattr("/f", "user.huge", make([]byte, XAttrSizeMax+1), 0))
+
+	//every attribute is listed
+	for i := 0; i < 50; i++ {
+		equals(t, 0, fs.Setxattr("/f", fmt.Sprintf("user.a%02d", i), []byte("x"), 0))
+	}
+
+	var names []string
+	equals(t, 0, fs.Listxattr("/f", func(name string) bool {
+		names = append(names, name)
+		return true
+	}))
+	equals(t, 51, len(names))
+	equals(t, -fuse.ERANGE, fs.Listxattr("/f", func(name string) bool { return false }))
+
+	//namespace rules
+	equals(t, -fuse.ERANGE, fs.Setxattr("/f", "user."+strings.Repeat("n", XAttrNameMax), []byte("x"), 0))
+	equals(t, -fuse.EINVAL, fs.Setxattr("/f", "user.", []byte("x"), 0))
+	equals(t, -fuse.ENOTSUP, fs.Setxattr("/f", "system.posix_acl_access", []byte("x"), 0))
+	equals(t, -fuse.EPERM, fs.Setxattr("/l", "user.tag", []byte("x"), 0))
+	equals(t, 0, fs.Setxattr("/l", "security.selinux", []byte("x"), 0))
+	equals(t, 0, fs.Setxattr("/f", "trusted.tag", []byte("x"), 0))
+	errc, xatr = fs.Getxattr("/f", "trusted.tag")
+	equals(t, 0, errc)
+	equals(t, []byte("x"), xatr)
+}
+
+func TestFinderXattrs(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	ok(t, err)
+
+	fs, clean, err := NewTempFS("", db)
+	ok(t, err)
+	defer clean()
+
+	equals(t, 0, fs.Mkdir("/d", 0755))
+	equals(t, 0, fs.Mknod("/d/f", fuse.S_IFREG|0600, 0))
+
+	//Finder probes for its info and the resource fork before copying
+	errc, _ := fs.Getxattr("/d/f", FinderInfoXAttr)
+	equals(t, -fuse.ENOATTR, errc)
+	errc, _ = fs.GetxattrAt("/d/f", ResourceForkXAttr, 0)
+	equals(t, -fuse.ENOATTR, errc)
+
+	//the info is a fixed size record that is absent while it is all zeros
+	info := make([]byte, 32)
+	equals(t, -fuse.ERANGE, fs.Setxattr("/d/f", FinderInfoXAttr, info[:16], 0))
+	equals(t, 0, fs.Setxattr("/d/f", FinderInfoXAttr, info, 0))
+	errc, _ = fs.Getxattr("/d/f", FinderInfoXAttr)
+	equals(t, -fuse.ENOATTR, errc)
+
+	copy(info, "TEXTttxt")
+	equals(t, 0, fs.Setxattr("/d", FinderInfoXAttr, info, 0))
+	equals(t, 0, fs.Setxattr("/d/f", FinderInfoXAttr, info, 0))
+	equals(t, 0, fs.Setxattr("/d/f", "com.apple.quarantine", []byte("0081;5b3a;Safari;"), 0))
+	errc, xatr := fs.Getxattr("/d/f", FinderInfoXAttr)
+	equals(t, 0, errc)
+	equals(t, info, xatr)
+
+	//the resource fork is written in pieces at increasing positions
+	fork := bytes.Repeat([]byte("resource fork "), 10000)
+	for pos := 0; pos < len(fork); pos += 64 * 1024 {
+		end := pos + 64*1024
+		if end > len(fork) {
+			end = len(fork)
+		}
+
+		equals(t, 0, fs.SetxattrAt("/d/f", ResourceForkXAttr, fork[pos:end], 0, uint32(pos)))
+	}
+
+	errc, xatr = fs.GetxattrAt("/d/f", ResourceForkXAttr, 0)
+	equals(t, 0, errc)
+	equals(t, fork, xatr)
+	errc, xatr = fs.GetxattrAt("/d/f", ResourceForkXAttr, 100)
+	equals(t, 0, errc)
+	equals(t, fork[100:], xatr)
+	errc, xatr = fs.GetxattrAt("/d/f", ResourceForkXAttr, uint32(len(fork)+1))
+	equals(t, 0, errc)
+	equals(t, 0, len(xatr))
+
+	//only the resource fork has a position and only files have one
+	equals(t, -fuse.EINVAL, fs.SetxattrAt("/d/f", FinderInfoXAttr, info, 0, 1))
+	errc, _ = fs.GetxattrAt("/d/f", FinderInfoXAttr, 1)
+	equals(t, -fuse.EINVAL, errc)
+	equals(t, -fuse.EPERM, fs.SetxattrAt("/d", ResourceForkXAttr, []byte("x"), 0, 0))
+
+	//writing at position 0 replaces the fork, like Setxattr does
+	equals(t, 0, fs.SetxattrAt("/d/f", ResourceForkXAttr, []byte("small"), 0, 0))
+	errc, xatr = fs.Getxattr("/d/f", ResourceForkXAttr)
+	equals(t, 0, errc)
+	equals(t, []byte("small"), xatr)
+	equals(t, 0, fs.Setxattr("/d/f", ResourceForkXAttr, []byte("smaller"), 0))
+	errc, xatr = fs.GetxattrAt("/d/f", ResourceForkXAttr, 0)
+	equals(t, 0, errc)
+	equals(t, []byte("smaller"), xatr)
+
+	var names []string
+	equals(t, 0, fs.Listxattr("/d/f", func(name string) bool {
+		names = append(names, name)
+		return true
+	}))
+	equals(t, 3, len(names))
+
+	equals(t, 0, fs.Removexattr("/d/f", ResourceForkXAttr))
+	errc, _ = fs.GetxattrAt("/d/f", ResourceForkXAttr, 0)
+	equals(t, -fuse.ENOATTR, errc)
+}
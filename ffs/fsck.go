@@ -0,0 +1,327 @@
+package ffs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/handles"
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/advanderveer/dfs/ffs/scrub"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//LostFoundDir is where Fsck links nodes that are no longer referenced by any
+//directory, they are named after their inode number
+const LostFoundDir = "lost+found"
+
+//fsckBatch is the nr of inodes that are checked per transaction
+const fsckBatch = 500
+
+//Kinds of issues that Fsck reports
+const (
+	FsckNlink        = "nlink"         //the link count doesn't match the nr of directory entries
+	FsckDangling     = "dangling"      //a directory entry refers to an inode without a stat
+	FsckOrphan       = "orphan"        //a linked node is not referred to by any directory
+	FsckOpencnt      = "opencnt"       //the open count doesn't match the nr of open handles
+	FsckStaleHandle  = "stale-handle"  //a handle refers to an inode without a stat
+	FsckMissingChunk = "missing-chunk" //the content of a file refers to a chunk that is not stored
+)
+
+//FsckIssue is an inconsistency in the metadata of the filesystem
+type FsckIssue struct {
+	Kind     string `json:"kind"`
+	Ino      uint64 `json:"ino"`
+	Path     string `json:"path,omitempty"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+//FsckReport is the outcome of checking the filesystem
+type FsckReport struct {
+	Nodes   int          `json:"nodes"`
+	Handles int          `json:"handles"`
+	Issues  []*FsckIssue `json:"issues"`
+}
+
+//fsckChld is a directory entry as found by fsck
+type fsckChld struct {
+	name string
+	ino  uint64
+}
+
+//fsckNode is the part of a node that fsck checks
+type fsckNode struct {
+	nlink   uint32
+	opencnt int64
+	m       *blobs.Manifest
+	chldr   []fsckChld
+}
+
+//Fsck checks that link counts match the directory entries and trash entries
+//that refer to a node, that entries and handles refer to existing nodes, that
+//open counts match the open handles and that the root chunk of every file is
+//stored. With repair the issues are fixed where possible. It should be run
+//while the filesystem is not in use.
+func (self *Memfs) Fsck(repair bool) (errc int, rep *FsckReport) {
+	defer trace(repair)(&errc, &rep)
+	var max uint64
+	var trash []*nodes.TrashEntry
+	var hndls []*handles.Handle
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		max, trash, hndls = self.nstore.Ino(tx), nil, nil
+		self.nstore.EachTrash(tx, func(e *nodes.TrashEntry) (stop bool) {
+			trash = append(trash, e)
+			return false
+		})
+
+		self.hstore.Each(tx, func(h *handles.Handle) (stop bool) {
+			hndls = append(hndls, h)
+			return false
+		})
+
+		return 0
+	}); 0 != errc {
+		return errc, nil
+	}
+
+	all := map[uint64]*fsckNode{}
+	for first := uint64(1); first <= max; first += fsckBatch {
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			for ino := first; ino < first+fsckBatch && ino <= max; ino++ {
+				node := self.nstore.Node(ino)
+				if !node.Exists(tx) {
+					continue
+				}
+
+				st := node.Stat(tx)
+				fn := &fsckNode{nlink: st.Nlink, opencnt: node.Opencnt(tx)}
+				switch st.Mode & fuse.S_IFMT {
+				case fuse.S_IFDIR:
+					node.ChldEach(tx, func(name string, chld *nodes.Node) bool {
+						fn.chldr = append(fn.chldr, fsckChld{name: name, ino: chld.Ino()})
+						return false
+					})
+				case fuse.S_IFREG:
+					fn.m = node.Manifest(tx)
+				}
+
+				all[ino] = fn
+			}
+
+			return 0
+		}); 0 != errc {
+			return errc, nil
+		}
+	}
+
+	inos := make([]uint64, 0, len(all))
+	for ino := range all {
+		inos = append(inos, ino)
+	}
+
+	sort.Slice(inos, func(i, j int) bool { return inos[i] < inos[j] })
+	rep = &FsckReport{Nodes: len(all), Handles: len(hndls)}
+	issue := func(kind string, ino uint64, p string, format string, v ...interface{}) *FsckIssue {
+		i := &FsckIssue{Kind: kind, Ino: ino, Path: p, Detail: fmt.Sprintf(format, v...)}
+		rep.Issues = append(rep.Issues, i)
+		return i
+	}
+
+	//paths are assigned breadth first from the root, nodes that can't be
+	//reached keep an empty path
+	refs, paths := map[uint64]uint32{1: 1}, map[uint64]string{1: "/"}
+	for queue := []uint64{1}; len(queue) > 0 && nil != all[1]; queue = queue[1:] {
+		for _, c := range all[queue[0]].chldr {
+			if _, ok := paths[c.ino]; !ok && nil != all[c.ino] {
+				paths[c.ino] = path.Join(paths[queue[0]], c.name)
+				queue = append(queue, c.ino)
+			}
+		}
+	}
+
+	for _, ino := range inos {
+		fn := all[ino]
+		for _, c := range fn.chldr {
+			if nil == all[c.ino] {
+				i := issue(FsckDangling, c.ino, path.Join(paths[ino], c.name), "entry '%s' of inode %d refers to a node without a stat", c.name, ino)
+				i.Repaired = repair && 0 == self.fsckTx(func(tx fdb.Transaction) {
+					self.nstore.Node(ino).DelChld(tx, c.name)
+				})
+
+				continue
+			}
+
+			refs[c.ino]++
+		}
+	}
+
+	for _, e := range trash {
+		if nil == all[e.Ino] {
+			i := issue(FsckDangling, e.Ino, path.Join("/", TrashDir, trashName(e)), "trash entry of '%s' refers to a node without a stat", e.Path)
+			i.Repaired = repair && 0 == self.fsckTx(func(tx fdb.Transaction) {
+				self.nstore.DelTrash(tx, e.ID)
+			})
+
+			continue
+		}
+
+		refs[e.Ino]++
+		if _, ok := paths[e.Ino]; !ok {
+			paths[e.Ino] = path.Join("/", TrashDir, trashName(e))
+		}
+	}
+
+	for _, ino := range inos {
+		fn := all[ino]
+		switch {
+		case refs[ino] == fn.nlink:
+		case refs[ino] == 0:
+			i := issue(FsckOrphan, ino, "", "node has %d links but no directory refers to it", fn.nlink)
+			i.Repaired = repair && 0 == self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+				i.Path, errc = self.relink(tx, ino, fn.nlink)
+				return errc
+			})
+		default:
+			i := issue(FsckNlink, ino, paths[ino], "link count is %d but %d entries refer to it", fn.nlink, refs[ino])
+			i.Repaired = repair && 0 == self.fsckTx(func(tx fdb.Transaction) {
+				self.setNlink(tx, self.nstore.Node(ino), fn.nlink, refs[ino])
+			})
+		}
+	}
+
+	opens := map[uint64]int64{}
+	for _, h := range hndls {
+		if nil == all[h.Ino] {
+			i := issue(FsckStaleHandle, h.Ino, "", "handle %d of session '%s' refers to a node without a stat", h.ID, h.Owner)
+			i.Repaired = repair && 0 == self.fsckTx(func(tx fdb.Transaction) {
+				self.lstore.ReleaseHandle(tx, h.Ino, h.ID)
+				self.hstore.Del(tx, h.ID)
+			})
+
+			continue
+		}
+
+		opens[h.Ino]++
+	}
+
+	for _, ino := range inos {
+		fn := all[ino]
+		if opens[ino] != fn.opencnt {
+			i := issue(FsckOpencnt, ino, paths[ino], "open count is %d but %d handles are open", fn.opencnt, opens[ino])
+			i.Repaired = repair && 0 == self.fsckTx(func(tx fdb.Transaction) {
+				self.nstore.Node(ino).SetOpencnt(tx, opens[ino])
+			})
+		}
+	}
+
+	ctx := context.Background()
+	for _, ino := range inos {
+		fn := all[ino]
+		if nil == fn.m || 0 == fn.m.Size || fn.m.Root.IsSpecial() || (0 == fn.nlink && 0 == refs[ino]) {
+			continue //removed nodes keep their manifest but are never read again
+		}
+		if nodes.IsInline(fn.m) {
+			continue //the content is stored with the metadata
+		}
+
+		root := scrub.Root(fn.m)
+		if _, err := self.cstore.Get(ctx, root.Key, root.Type, root.Level); err == nil {
+			continue
+		} else if _, ok := err.(*chunks.NotFoundError); !ok {
+			return -fuse.EIO, nil
+		}
+
+		i := issue(FsckMissingChunk, ino, paths[ino], "root chunk %s of the content is missing", root.Key)
+		if repair {
+			self.fsckTx(func(tx fdb.Transaction) {
+				i.Repaired = self.restoreIntact(ctx, tx, paths[ino], self.nstore.Node(ino))
+			})
+		}
+	}
+
+	return 0, rep
+}
+
+//fsckTx runs a single repair in its own transaction
+func (self *Memfs) fsckTx(f func(tx fdb.Transaction)) int {
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		f(tx)
+		return 0
+	})
+}
+
+//setNlink repairs the link count of node, its usage is only accounted while
+//it is linked
+func (self *Memfs) setNlink(tx fdb.Transaction, node *nodes.Node, from, to uint32) {
+	node.StatSetNlink(tx, to)
+	size := node.Stat(tx).Size
+	if 0 == from && 0 < to {
+		self.qstore.Add(tx, node.Owner(tx), size, 1, time.Now())
+	} else if 0 < from && 0 == to {
+		self.qstore.Add(tx, node.Owner(tx), -size, -1, time.Now())
+	}
+}
+
+//relink links an orphaned node in the lost+found directory, which is created
+//when it doesn't exist yet. It returns the path of the node, it fails when
+//the directory can't be created or something else has its name.
+func (self *Memfs) relink(tx fdb.Transaction, ino uint64, nlink uint32) (string, int) {
+	dir := path.Join("/", LostFoundDir)
+	_, _, lf := self.lookupNode(tx, dir, nil)
+	if nil == lf {
+		if errc := self.makeNode(tx, "mkdir", dir, fuse.S_IFDIR|0700, 0, nil); 0 != errc {
+			return "", errc
+		}
+
+		_, _, lf = self.lookupNode(tx, dir, nil)
+	}
+	if nil == lf || fuse.S_IFDIR != lf.Stat(tx).Mode&fuse.S_IFMT {
+		return "", -fuse.ENOTDIR
+	}
+
+	node, p := self.nstore.Node(ino), path.Join(dir, fmt.Sprint(ino))
+	lf.SetChld(tx, fmt.Sprint(ino), node)
+	self.setNlink(tx, node, nlink, 1)
+	self.account(tx, p, subtree(tx, node))
+	self.emit(tx, "link", p, "", node)
+	return p, 0
+}
+
+//intact returns whether the content described by m can be found, for
+//blobs only its root chunk is checked
+func (self *Memfs) intact(ctx context.Context, tx fdb.Transaction, node *nodes.Node, m *blobs.Manifest) bool {
+	if nodes.IsInline(m) {
+		_, err := node.Inline(tx, m)
+		return nil == err
+	}
+
+	root := scrub.Root(m)
+	_, err := self.cstore.Get(ctx, root.Key, root.Type, root.Level)
+	return nil == err
+}
+
+//restoreIntact makes the newest version of node whose content is stored its
+//current content, it returns false if there is no such version
+func (self *Memfs) restoreIntact(ctx context.Context, tx fdb.Transaction, p string, node *nodes.Node) bool {
+	for _, v := range node.Versions(tx) {
+		if !self.intact(ctx, tx, node, v.Manifest()) {
+			continue
+		}
+
+		if 0 != self.resize(tx, p, node, v.Size) {
+			return false
+		}
+
+		node.SetManifest(tx, v.Manifest(), v.Extents())
+		self.emit(tx, "restore", p, "", node)
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,21 @@
+package fsrpc
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+//BearerAuth authenticates HTTP connections by the bearer token in their
+//Authorization header, verify checks the token and returns the actor it was
+//issued to. It can be passed to Server.WithAuth
+func BearerAuth(verify func(token string) (actor string, err error)) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		h := r.Header.Get("Authorization")
+		if !strings.HasPrefix(h, "Bearer ") {
+			return "", errors.New("no bearer token")
+		}
+
+		return verify(strings.TrimPrefix(h, "Bearer "))
+	}
+}
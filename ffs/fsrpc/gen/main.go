@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"text/template"
@@ -33,10 +34,15 @@ type ProcedureDecl struct {
 	Name    string
 	Params  []ParamDecl
 	Results []ResultDecl
+
+	//EchoArgs is set when the sender reads arguments back from the reply,
+	//others are not send back to keep large arguments from traveling twice
+	EchoArgs bool
 }
 
 type ServerDecl struct {
 	Package string
+	Imports []string
 	Procs   []ProcedureDecl
 }
 
@@ -46,7 +52,8 @@ package {{.Package}}
 import(
 	"fmt"
 	"math"
-	"github.com/billziss-gh/cgofuse/fuse"
+	{{range .Imports}}"{{.}}"
+	{{end}}
 )
 
 type ReaddirCall struct {
@@ -82,7 +89,7 @@ func (rcvr *Receiver) {{$proc.Name}}(a *{{$proc.Name}}Args, r *{{$proc.Name}}Rep
 		return true
 	}{{end}}
 	{{if $proc.Results}}{{range $j, $res := $proc.Results}}{{if ne $j 0}},{{end}}r.R{{$j}} {{end}} = {{end}}rcvr.fs.{{$proc.Name}}({{range $j, $param := $proc.Params}}{{if ne $j 0}}, {{end}}a.{{$param.FieldName}} {{end}})
-	r.Args = a
+	{{if $proc.EchoArgs}}r.Args = a{{end}}
 	return
 }
 
@@ -185,6 +192,22 @@ func parse(logs *log.Logger, fset *token.FileSet, name string) (err error) {
 		Package: pkg.Name(),
 	}
 
+	//qualify types by their package name and keep track of what to import
+	imports := map[string]struct{}{"github.com/billziss-gh/cgofuse/fuse": {}}
+	qualify := func(other *types.Package) string {
+		if other == pkg {
+			return ""
+		}
+
+		path := other.Path()
+		if idx := strings.LastIndex(path, "/vendor/"); idx >= 0 {
+			path = path[idx+len("/vendor/"):]
+		}
+
+		imports[path] = struct{}{}
+		return other.Name()
+	}
+
 	for i := 0; i < iface.NumMethods(); i++ {
 		m := iface.Method(i)
 		sig, ok := m.Type().(*types.Signature)
@@ -203,22 +226,33 @@ func parse(logs *log.Logger, fset *token.FileSet, name string) (err error) {
 			procDecl.Params[j] = ParamDecl{
 				FieldName: strings.Title(p.Name()),
 				Name:      p.Name(),
-				Type:      strings.Replace(p.Type().String(), "github.com/advanderveer/dfs/vendor/github.com/billziss-gh/cgofuse/fuse", "fuse", 1),
+				Type:      types.TypeString(p.Type(), qualify),
 			}
 
 			_, procDecl.Params[j].IsPointer = p.Type().Underlying().(*types.Pointer)
+			procDecl.EchoArgs = procDecl.EchoArgs || procDecl.Params[j].IsPointer
+		}
+
+		if procDecl.Name == "Read" {
+			procDecl.EchoArgs = true
 		}
 
 		for j := 0; j < sig.Results().Len(); j++ {
 			r := sig.Results().At(j)
 			procDecl.Results[j] = ResultDecl{
-				Type: r.Type().String(),
+				Type: types.TypeString(r.Type(), qualify),
 			}
 		}
 
 		svrDecl.Procs[i] = procDecl
 	}
 
+	for path := range imports {
+		svrDecl.Imports = append(svrDecl.Imports, path)
+	}
+
+	sort.Strings(svrDecl.Imports)
+
 	err = write(logs, strings.Replace(name, ".go", "_rpc.go", 1), svrDecl)
 	if err != nil {
 		return fmt.Errorf("failed to write output: %v", err)
@@ -1,11 +1,17 @@
 package fsrpc
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"net/rpc"
 	"time"
 
+	"github.com/advanderveer/dfs/ffs/dedup"
+	"github.com/advanderveer/dfs/ffs/locks"
+	"github.com/advanderveer/dfs/ffs/nodes"
 	"github.com/billziss-gh/cgofuse/fuse"
 )
 
@@ -15,6 +21,60 @@ type FS interface {
 	fuse.FileSystemChflags
 	fuse.FileSystemSetcrtime
 	fuse.FileSystemSetchgtime
+	FileSystemLock
+	FileSystemUpload
+	FileSystemClone
+	FileSystemBulk
+	FileSystemSparse
+	fuse.FileSystemSetxattrAt
+	fuse.FileSystemGetxattrAt
+}
+
+//FileSystemLock is the interface that wraps the Lock method. The cgofuse
+//host doesn't forward lock operations (yet) so our own Lock type is used.
+//
+//Lock performs a POSIX advisory byte-range lock operation.
+type FileSystemLock interface {
+	Lock(path string, cmd int, lock *locks.Lock, fh uint64) int
+}
+
+//FileSystemUpload is the interface of the dedup upload protocol, clients
+//chunk and hash content themselves and only send what the server is missing.
+type FileSystemUpload interface {
+	MissingChunks(refs []dedup.Ref) (int, []int)
+	PutChunks(chunks []dedup.Chunk) int
+	CommitManifest(path string, m dedup.Manifest) int
+}
+
+//FileSystemClone is the interface that wraps the Clone method.
+//
+//Clone creates dst as a copy of src that shares the content of its files,
+//nothing is read or written through the client.
+type FileSystemClone interface {
+	Clone(src string, dst string) int
+}
+
+//FileSystemSparse is the interface that wraps the Fallocate and Lseek
+//methods. The cgofuse FileSystemInterface has no equivalent of these.
+//
+//Fallocate allocates, punches holes in or zeroes a range of a file like
+//fallocate(2). Lseek finds data and holes like lseek(2) with SEEK_DATA and
+//SEEK_HOLE.
+type FileSystemSparse interface {
+	Fallocate(path string, mode uint32, ofst int64, length int64, fh uint64) int
+	Lseek(path string, ofst int64, whence int, fh uint64) (int, int64)
+}
+
+//FileSystemBulk is the interface that wraps the recursive operations that
+//the server executes in the background.
+//
+//Bulk starts removing, copying or moving a tree and returns the id of its
+//job, BulkStatus reports the progress of a job and ResumeBulk starts a job
+//again that was interrupted.
+type FileSystemBulk interface {
+	Bulk(op string, src string, dst string) (int, uint64)
+	BulkStatus(id uint64) (int, nodes.BulkJob)
+	ResumeBulk(id uint64) int
 }
 
 //Receiver responds to RPC requests
@@ -58,3 +118,31 @@ func DialHTTP(addr, path string) (*Sender, error) {
 	s := &Sender{rpc: c, LastErr: nil}
 	return s, nil
 }
+
+//DialHTTPToken dials the filesystem like DialHTTP but authenticates the session
+//with the bearer token, the server derives the actor from it
+func DialHTTPToken(addr, path, token string) (*Sender, error) {
+	if token == "" {
+		return DialHTTP(addr, path)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second*30)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %v", err)
+	}
+
+	io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\r\nAuthorization: Bearer "+token+"\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to dial HTTP: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("failed to dial HTTP: unexpected status %s", resp.Status)
+	}
+
+	s := &Sender{rpc: rpc.NewClient(conn), LastErr: nil}
+	return s, nil
+}
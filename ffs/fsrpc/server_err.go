@@ -28,8 +28,16 @@ func errc(in int) (out int) {
 			out = -fuse.ENOATTR
 		case -28:
 			out = -fuse.ENOSPC
+		case -122:
+			out = -69 //EDQUOT on darwin and freebsd
 		case -2:
 			out = -fuse.ENOENT
+		case -9:
+			out = -fuse.EBADF
+		case -11:
+			out = -fuse.EAGAIN
+		case -37:
+			out = -fuse.ENOLCK
 		case -17:
 			out = -fuse.EEXIST
 		case -22:
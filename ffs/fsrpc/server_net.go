@@ -2,29 +2,114 @@ package fsrpc
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"net/rpc"
+
+	uuid "github.com/nu7hatch/gouuid"
 )
 
+//Server serves a filesystem over RPC, each connection is considered a
+//session of its own that ends when the connection is closed. Sessions only
+//work as an actor that was authenticated when the HTTP connection was made,
+//every other session works as the anonymous actor "".
+type Server struct {
+	open func(id, actor string) FS
+	end  func(id string) int
+	auth func(r *http.Request) (actor string, err error)
+}
+
+//New creates a server that serves fs to every connection
+func New(fs FS) (s *Server) {
+	return NewSessions(func(id, actor string) FS { return fs }, nil)
+}
+
+//NewSessions creates a server that serves the filesystem returned by open
+//to each new session, end (if not nil) is called once the session is over
+//such that the filesystem can release whatever the client left behind.
+func NewSessions(open func(id, actor string) FS, end func(id string) int) (s *Server) {
+	return &Server{open: open, end: end}
+}
+
+//WithAuth makes the server authenticate HTTP connections with auth, the actor
+//it returns is the actor of the session. Connections it fails for are refused.
+func (s *Server) WithAuth(auth func(r *http.Request) (actor string, err error)) *Server {
+	s.auth = auth
+	return s
+}
+
+//ServeConn serves a single connection as a new session of the anonymous
+//actor, it blocks until the client hangs up
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	s.serve(conn, "")
+}
+
+func (s *Server) serve(conn io.ReadWriteCloser, actor string) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		fmt.Println("Err creating session:", err)
+		conn.Close()
+		return
+	}
+
+	id := uid.String()
+	svr := rpc.NewServer()
+	svr.RegisterName("FS", NewReceiver(s.open(id, actor)))
+	svr.ServeConn(conn)
+	if s.end != nil {
+		if errc := s.end(id); errc != 0 {
+			fmt.Printf("Err ending session %s: %d\n", id, errc)
+		}
+	}
+}
+
+//ServeHTTP serves rpc over a hijacked HTTP connection, it behaves the same as
+//the handler of the standard library such that rpc.DialHTTPPath can be used
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+
+	actor := ""
+	if s.auth != nil {
+		var err error
+		if actor, err = s.auth(r); err != nil {
+			http.Error(w, fmt.Sprintf("failed to authenticate: %v", err), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		fmt.Println("Err hijacking:", r.RemoteAddr, err)
+		return
+	}
+
+	io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+	s.serve(conn, actor)
+}
+
 type Svr struct {
 	l net.Listener
-	s *rpc.Server
+	s *Server
 }
 
-func New(fs FS) (s *rpc.Server) {
-	s = rpc.NewServer()
-	s.RegisterName("FS", NewReceiver(fs))
-	return
+func NewServer(fs FS, addr string) (svr *Svr, err error) {
+	return NewSessionServer(New(fs), addr)
 }
 
-func NewServer(fs FS, addr string) (svr *Svr, err error) {
-	svr = &Svr{}
+//NewSessionServer listens on addr and will serve connections using s
+func NewSessionServer(s *Server, addr string) (svr *Svr, err error) {
+	svr = &Svr{s: s}
 	svr.l, err = net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen: %v", err)
 	}
 
-	svr.s = New(fs)
 	return svr, nil
 }
 
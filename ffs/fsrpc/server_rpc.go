@@ -3,6 +3,9 @@ package fsrpc
 
 import (
 	"fmt"
+	"github.com/advanderveer/dfs/ffs/dedup"
+	"github.com/advanderveer/dfs/ffs/locks"
+	"github.com/advanderveer/dfs/ffs/nodes"
 	"github.com/billziss-gh/cgofuse/fuse"
 	"math"
 )
@@ -30,7 +33,7 @@ type AccessReply struct {
 func (rcvr *Receiver) Access(a *AccessArgs, r *AccessReply) (err error) {
 
 	r.R0 = rcvr.fs.Access(a.Path, a.Mask)
-	r.Args = a
+
 	return
 }
 
@@ -50,6 +53,74 @@ func (sndr *Sender) Access(path string, mask uint32) int {
 	return errc(r.R0)
 }
 
+type BulkArgs struct {
+	Op  string
+	Src string
+	Dst string
+}
+
+type BulkReply struct {
+	Args *BulkArgs
+	R0   int
+	R1   uint64
+}
+
+func (rcvr *Receiver) Bulk(a *BulkArgs, r *BulkReply) (err error) {
+
+	r.R0, r.R1 = rcvr.fs.Bulk(a.Op, a.Src, a.Dst)
+
+	return
+}
+
+func (sndr *Sender) Bulk(op string, src string, dst string) (int, uint64) {
+
+	r := &BulkReply{}
+	a := &BulkArgs{
+		Op:  op,
+		Src: src,
+		Dst: dst,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.Bulk", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0), r.R1
+}
+
+type BulkStatusArgs struct {
+	Id uint64
+}
+
+type BulkStatusReply struct {
+	Args *BulkStatusArgs
+	R0   int
+	R1   nodes.BulkJob
+}
+
+func (rcvr *Receiver) BulkStatus(a *BulkStatusArgs, r *BulkStatusReply) (err error) {
+
+	r.R0, r.R1 = rcvr.fs.BulkStatus(a.Id)
+
+	return
+}
+
+func (sndr *Sender) BulkStatus(id uint64) (int, nodes.BulkJob) {
+
+	r := &BulkStatusReply{}
+	a := &BulkStatusArgs{
+		Id: id,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.BulkStatus", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0), r.R1
+}
+
 type ChflagsArgs struct {
 	Path  string
 	Flags uint32
@@ -63,7 +134,7 @@ type ChflagsReply struct {
 func (rcvr *Receiver) Chflags(a *ChflagsArgs, r *ChflagsReply) (err error) {
 
 	r.R0 = rcvr.fs.Chflags(a.Path, a.Flags)
-	r.Args = a
+
 	return
 }
 
@@ -96,7 +167,7 @@ type ChmodReply struct {
 func (rcvr *Receiver) Chmod(a *ChmodArgs, r *ChmodReply) (err error) {
 
 	r.R0 = rcvr.fs.Chmod(a.Path, a.Mode)
-	r.Args = a
+
 	return
 }
 
@@ -130,7 +201,7 @@ type ChownReply struct {
 func (rcvr *Receiver) Chown(a *ChownArgs, r *ChownReply) (err error) {
 
 	r.R0 = rcvr.fs.Chown(a.Path, a.Uid, a.Gid)
-	r.Args = a
+
 	return
 }
 
@@ -151,6 +222,72 @@ func (sndr *Sender) Chown(path string, uid uint32, gid uint32) int {
 	return errc(r.R0)
 }
 
+type CloneArgs struct {
+	Src string
+	Dst string
+}
+
+type CloneReply struct {
+	Args *CloneArgs
+	R0   int
+}
+
+func (rcvr *Receiver) Clone(a *CloneArgs, r *CloneReply) (err error) {
+
+	r.R0 = rcvr.fs.Clone(a.Src, a.Dst)
+
+	return
+}
+
+func (sndr *Sender) Clone(src string, dst string) int {
+
+	r := &CloneReply{}
+	a := &CloneArgs{
+		Src: src,
+		Dst: dst,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.Clone", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0)
+}
+
+type CommitManifestArgs struct {
+	Path string
+	M    dedup.Manifest
+}
+
+type CommitManifestReply struct {
+	Args *CommitManifestArgs
+	R0   int
+}
+
+func (rcvr *Receiver) CommitManifest(a *CommitManifestArgs, r *CommitManifestReply) (err error) {
+
+	r.R0 = rcvr.fs.CommitManifest(a.Path, a.M)
+
+	return
+}
+
+func (sndr *Sender) CommitManifest(path string, m dedup.Manifest) int {
+
+	r := &CommitManifestReply{}
+	a := &CommitManifestArgs{
+		Path: path,
+		M:    m,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.CommitManifest", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0)
+}
+
 type CreateArgs struct {
 	Path  string
 	Flags int
@@ -166,7 +303,7 @@ type CreateReply struct {
 func (rcvr *Receiver) Create(a *CreateArgs, r *CreateReply) (err error) {
 
 	r.R0, r.R1 = rcvr.fs.Create(a.Path, a.Flags, a.Mode)
-	r.Args = a
+
 	return
 }
 
@@ -197,7 +334,7 @@ type DestroyReply struct {
 func (rcvr *Receiver) Destroy(a *DestroyArgs, r *DestroyReply) (err error) {
 
 	rcvr.fs.Destroy()
-	r.Args = a
+
 	return
 }
 
@@ -214,6 +351,45 @@ func (sndr *Sender) Destroy() {
 	return
 }
 
+type FallocateArgs struct {
+	Path   string
+	Mode   uint32
+	Ofst   int64
+	Length int64
+	Fh     uint64
+}
+
+type FallocateReply struct {
+	Args *FallocateArgs
+	R0   int
+}
+
+func (rcvr *Receiver) Fallocate(a *FallocateArgs, r *FallocateReply) (err error) {
+
+	r.R0 = rcvr.fs.Fallocate(a.Path, a.Mode, a.Ofst, a.Length, a.Fh)
+
+	return
+}
+
+func (sndr *Sender) Fallocate(path string, mode uint32, ofst int64, length int64, fh uint64) int {
+
+	r := &FallocateReply{}
+	a := &FallocateArgs{
+		Path:   path,
+		Mode:   mode,
+		Ofst:   ofst,
+		Length: length,
+		Fh:     fh,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.Fallocate", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0)
+}
+
 type FlushArgs struct {
 	Path string
 	Fh   uint64
@@ -227,7 +403,7 @@ type FlushReply struct {
 func (rcvr *Receiver) Flush(a *FlushArgs, r *FlushReply) (err error) {
 
 	r.R0 = rcvr.fs.Flush(a.Path, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -261,7 +437,7 @@ type FsyncReply struct {
 func (rcvr *Receiver) Fsync(a *FsyncArgs, r *FsyncReply) (err error) {
 
 	r.R0 = rcvr.fs.Fsync(a.Path, a.Datasync, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -296,7 +472,7 @@ type FsyncdirReply struct {
 func (rcvr *Receiver) Fsyncdir(a *FsyncdirArgs, r *FsyncdirReply) (err error) {
 
 	r.R0 = rcvr.fs.Fsyncdir(a.Path, a.Datasync, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -375,7 +551,7 @@ type GetxattrReply struct {
 func (rcvr *Receiver) Getxattr(a *GetxattrArgs, r *GetxattrReply) (err error) {
 
 	r.R0, r.R1 = rcvr.fs.Getxattr(a.Path, a.Name)
-	r.Args = a
+
 	return
 }
 
@@ -395,6 +571,42 @@ func (sndr *Sender) Getxattr(path string, name string) (int, []byte) {
 	return errc(r.R0), r.R1
 }
 
+type GetxattrAtArgs struct {
+	Path     string
+	Name     string
+	Position uint32
+}
+
+type GetxattrAtReply struct {
+	Args *GetxattrAtArgs
+	R0   int
+	R1   []byte
+}
+
+func (rcvr *Receiver) GetxattrAt(a *GetxattrAtArgs, r *GetxattrAtReply) (err error) {
+
+	r.R0, r.R1 = rcvr.fs.GetxattrAt(a.Path, a.Name, a.Position)
+
+	return
+}
+
+func (sndr *Sender) GetxattrAt(path string, name string, position uint32) (int, []byte) {
+
+	r := &GetxattrAtReply{}
+	a := &GetxattrAtArgs{
+		Path:     path,
+		Name:     name,
+		Position: position,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.GetxattrAt", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0), r.R1
+}
+
 type InitArgs struct {
 }
 
@@ -405,7 +617,7 @@ type InitReply struct {
 func (rcvr *Receiver) Init(a *InitArgs, r *InitReply) (err error) {
 
 	rcvr.fs.Init()
-	r.Args = a
+
 	return
 }
 
@@ -435,7 +647,7 @@ type LinkReply struct {
 func (rcvr *Receiver) Link(a *LinkArgs, r *LinkReply) (err error) {
 
 	r.R0 = rcvr.fs.Link(a.Oldpath, a.Newpath)
-	r.Args = a
+
 	return
 }
 
@@ -474,7 +686,7 @@ func (rcvr *Receiver) Listxattr(a *ListxattrArgs, r *ListxattrReply) (err error)
 		return true
 	}
 	r.R0 = rcvr.fs.Listxattr(a.Path, a.Fill)
-	r.Args = a
+
 	return
 }
 
@@ -500,6 +712,115 @@ func (sndr *Sender) Listxattr(path string, fill func(name string) bool) int {
 	return errc(r.R0)
 }
 
+type LockArgs struct {
+	Path string
+	Cmd  int
+	Lock *locks.Lock
+	Fh   uint64
+}
+
+type LockReply struct {
+	Args *LockArgs
+	R0   int
+}
+
+func (rcvr *Receiver) Lock(a *LockArgs, r *LockReply) (err error) {
+
+	r.R0 = rcvr.fs.Lock(a.Path, a.Cmd, a.Lock, a.Fh)
+	r.Args = a
+	return
+}
+
+func (sndr *Sender) Lock(path string, cmd int, lock *locks.Lock, fh uint64) int {
+
+	r := &LockReply{}
+	a := &LockArgs{
+		Path: path,
+		Cmd:  cmd,
+		Lock: lock,
+		Fh:   fh,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.Lock", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	*lock = *r.Args.Lock
+
+	return errc(r.R0)
+}
+
+type LseekArgs struct {
+	Path   string
+	Ofst   int64
+	Whence int
+	Fh     uint64
+}
+
+type LseekReply struct {
+	Args *LseekArgs
+	R0   int
+	R1   int64
+}
+
+func (rcvr *Receiver) Lseek(a *LseekArgs, r *LseekReply) (err error) {
+
+	r.R0, r.R1 = rcvr.fs.Lseek(a.Path, a.Ofst, a.Whence, a.Fh)
+
+	return
+}
+
+func (sndr *Sender) Lseek(path string, ofst int64, whence int, fh uint64) (int, int64) {
+
+	r := &LseekReply{}
+	a := &LseekArgs{
+		Path:   path,
+		Ofst:   ofst,
+		Whence: whence,
+		Fh:     fh,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.Lseek", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0), r.R1
+}
+
+type MissingChunksArgs struct {
+	Refs []dedup.Ref
+}
+
+type MissingChunksReply struct {
+	Args *MissingChunksArgs
+	R0   int
+	R1   []int
+}
+
+func (rcvr *Receiver) MissingChunks(a *MissingChunksArgs, r *MissingChunksReply) (err error) {
+
+	r.R0, r.R1 = rcvr.fs.MissingChunks(a.Refs)
+
+	return
+}
+
+func (sndr *Sender) MissingChunks(refs []dedup.Ref) (int, []int) {
+
+	r := &MissingChunksReply{}
+	a := &MissingChunksArgs{
+		Refs: refs,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.MissingChunks", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0), r.R1
+}
+
 type MkdirArgs struct {
 	Path string
 	Mode uint32
@@ -513,7 +834,7 @@ type MkdirReply struct {
 func (rcvr *Receiver) Mkdir(a *MkdirArgs, r *MkdirReply) (err error) {
 
 	r.R0 = rcvr.fs.Mkdir(a.Path, a.Mode)
-	r.Args = a
+
 	return
 }
 
@@ -547,7 +868,7 @@ type MknodReply struct {
 func (rcvr *Receiver) Mknod(a *MknodArgs, r *MknodReply) (err error) {
 
 	r.R0 = rcvr.fs.Mknod(a.Path, a.Mode, a.Dev)
-	r.Args = a
+
 	return
 }
 
@@ -582,7 +903,7 @@ type OpenReply struct {
 func (rcvr *Receiver) Open(a *OpenArgs, r *OpenReply) (err error) {
 
 	r.R0, r.R1 = rcvr.fs.Open(a.Path, a.Flags)
-	r.Args = a
+
 	return
 }
 
@@ -615,7 +936,7 @@ type OpendirReply struct {
 func (rcvr *Receiver) Opendir(a *OpendirArgs, r *OpendirReply) (err error) {
 
 	r.R0, r.R1 = rcvr.fs.Opendir(a.Path)
-	r.Args = a
+
 	return
 }
 
@@ -634,6 +955,37 @@ func (sndr *Sender) Opendir(path string) (int, uint64) {
 	return errc(r.R0), r.R1
 }
 
+type PutChunksArgs struct {
+	Chunks []dedup.Chunk
+}
+
+type PutChunksReply struct {
+	Args *PutChunksArgs
+	R0   int
+}
+
+func (rcvr *Receiver) PutChunks(a *PutChunksArgs, r *PutChunksReply) (err error) {
+
+	r.R0 = rcvr.fs.PutChunks(a.Chunks)
+
+	return
+}
+
+func (sndr *Sender) PutChunks(chunks []dedup.Chunk) int {
+
+	r := &PutChunksReply{}
+	a := &PutChunksArgs{
+		Chunks: chunks,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.PutChunks", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0)
+}
+
 type ReadArgs struct {
 	Path string
 	Buff []byte
@@ -693,7 +1045,7 @@ func (rcvr *Receiver) Readdir(a *ReaddirArgs, r *ReaddirReply) (err error) {
 		return true
 	}
 	r.R0 = rcvr.fs.Readdir(a.Path, a.Fill, a.Ofst, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -738,7 +1090,7 @@ type ReadlinkReply struct {
 func (rcvr *Receiver) Readlink(a *ReadlinkArgs, r *ReadlinkReply) (err error) {
 
 	r.R0, r.R1 = rcvr.fs.Readlink(a.Path)
-	r.Args = a
+
 	return
 }
 
@@ -770,7 +1122,7 @@ type ReleaseReply struct {
 func (rcvr *Receiver) Release(a *ReleaseArgs, r *ReleaseReply) (err error) {
 
 	r.R0 = rcvr.fs.Release(a.Path, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -803,7 +1155,7 @@ type ReleasedirReply struct {
 func (rcvr *Receiver) Releasedir(a *ReleasedirArgs, r *ReleasedirReply) (err error) {
 
 	r.R0 = rcvr.fs.Releasedir(a.Path, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -836,7 +1188,7 @@ type RemovexattrReply struct {
 func (rcvr *Receiver) Removexattr(a *RemovexattrArgs, r *RemovexattrReply) (err error) {
 
 	r.R0 = rcvr.fs.Removexattr(a.Path, a.Name)
-	r.Args = a
+
 	return
 }
 
@@ -869,7 +1221,7 @@ type RenameReply struct {
 func (rcvr *Receiver) Rename(a *RenameArgs, r *RenameReply) (err error) {
 
 	r.R0 = rcvr.fs.Rename(a.Oldpath, a.Newpath)
-	r.Args = a
+
 	return
 }
 
@@ -889,6 +1241,37 @@ func (sndr *Sender) Rename(oldpath string, newpath string) int {
 	return errc(r.R0)
 }
 
+type ResumeBulkArgs struct {
+	Id uint64
+}
+
+type ResumeBulkReply struct {
+	Args *ResumeBulkArgs
+	R0   int
+}
+
+func (rcvr *Receiver) ResumeBulk(a *ResumeBulkArgs, r *ResumeBulkReply) (err error) {
+
+	r.R0 = rcvr.fs.ResumeBulk(a.Id)
+
+	return
+}
+
+func (sndr *Sender) ResumeBulk(id uint64) int {
+
+	r := &ResumeBulkReply{}
+	a := &ResumeBulkArgs{
+		Id: id,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.ResumeBulk", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0)
+}
+
 type RmdirArgs struct {
 	Path string
 }
@@ -901,7 +1284,7 @@ type RmdirReply struct {
 func (rcvr *Receiver) Rmdir(a *RmdirArgs, r *RmdirReply) (err error) {
 
 	r.R0 = rcvr.fs.Rmdir(a.Path)
-	r.Args = a
+
 	return
 }
 
@@ -933,7 +1316,7 @@ type SetchgtimeReply struct {
 func (rcvr *Receiver) Setchgtime(a *SetchgtimeArgs, r *SetchgtimeReply) (err error) {
 
 	r.R0 = rcvr.fs.Setchgtime(a.Path, a.Tmsp)
-	r.Args = a
+
 	return
 }
 
@@ -966,7 +1349,7 @@ type SetcrtimeReply struct {
 func (rcvr *Receiver) Setcrtime(a *SetcrtimeArgs, r *SetcrtimeReply) (err error) {
 
 	r.R0 = rcvr.fs.Setcrtime(a.Path, a.Tmsp)
-	r.Args = a
+
 	return
 }
 
@@ -1001,7 +1384,7 @@ type SetxattrReply struct {
 func (rcvr *Receiver) Setxattr(a *SetxattrArgs, r *SetxattrReply) (err error) {
 
 	r.R0 = rcvr.fs.Setxattr(a.Path, a.Name, a.Value, a.Flags)
-	r.Args = a
+
 	return
 }
 
@@ -1023,6 +1406,45 @@ func (sndr *Sender) Setxattr(path string, name string, value []byte, flags int)
 	return errc(r.R0)
 }
 
+type SetxattrAtArgs struct {
+	Path     string
+	Name     string
+	Value    []byte
+	Flags    int
+	Position uint32
+}
+
+type SetxattrAtReply struct {
+	Args *SetxattrAtArgs
+	R0   int
+}
+
+func (rcvr *Receiver) SetxattrAt(a *SetxattrAtArgs, r *SetxattrAtReply) (err error) {
+
+	r.R0 = rcvr.fs.SetxattrAt(a.Path, a.Name, a.Value, a.Flags, a.Position)
+
+	return
+}
+
+func (sndr *Sender) SetxattrAt(path string, name string, value []byte, flags int, position uint32) int {
+
+	r := &SetxattrAtReply{}
+	a := &SetxattrAtArgs{
+		Path:     path,
+		Name:     name,
+		Value:    value,
+		Flags:    flags,
+		Position: position,
+	}
+
+	sndr.LastErr = sndr.rpc.Call("FS.SetxattrAt", a, r)
+	if sndr.LastErr != nil {
+		fmt.Println("Transport Error:", sndr.LastErr.Error())
+	}
+
+	return errc(r.R0)
+}
+
 type StatfsArgs struct {
 	Path string
 	Stat *fuse.Statfs_t
@@ -1071,7 +1493,7 @@ type SymlinkReply struct {
 func (rcvr *Receiver) Symlink(a *SymlinkArgs, r *SymlinkReply) (err error) {
 
 	r.R0 = rcvr.fs.Symlink(a.Target, a.Newpath)
-	r.Args = a
+
 	return
 }
 
@@ -1105,7 +1527,7 @@ type TruncateReply struct {
 func (rcvr *Receiver) Truncate(a *TruncateArgs, r *TruncateReply) (err error) {
 
 	r.R0 = rcvr.fs.Truncate(a.Path, a.Size, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -1138,7 +1560,7 @@ type UnlinkReply struct {
 func (rcvr *Receiver) Unlink(a *UnlinkArgs, r *UnlinkReply) (err error) {
 
 	r.R0 = rcvr.fs.Unlink(a.Path)
-	r.Args = a
+
 	return
 }
 
@@ -1170,7 +1592,7 @@ type UtimensReply struct {
 func (rcvr *Receiver) Utimens(a *UtimensArgs, r *UtimensReply) (err error) {
 
 	r.R0 = rcvr.fs.Utimens(a.Path, a.Tmsp)
-	r.Args = a
+
 	return
 }
 
@@ -1205,7 +1627,7 @@ type WriteReply struct {
 func (rcvr *Receiver) Write(a *WriteArgs, r *WriteReply) (err error) {
 
 	r.R0 = rcvr.fs.Write(a.Path, a.Buff, a.Ofst, a.Fh)
-	r.Args = a
+
 	return
 }
 
@@ -2,9 +2,12 @@ package fsrpc
 
 import (
 	"bytes"
+	"errors"
+	"net/http/httptest"
 	"net/rpc"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -173,4 +176,76 @@ func TestFSRPC(t *testing.T) {
 			t.Fatal(sndr.LastErr)
 		}
 	})
+
+	t.Run("resource fork", func(t *testing.T) {
+		if errc := sndr.Mknod("/fork", fuse.S_IFREG|0666, 0); errc != 0 || sndr.LastErr != nil {
+			t.Fatalf("failed to create file (%d): %v", errc, sndr.LastErr)
+		}
+
+		for i, chunk := range []string{"first ", "second"} {
+			if errc := sndr.SetxattrAt("/fork", ffs.ResourceForkXAttr, []byte(chunk), 0, uint32(i*6)); errc != 0 || sndr.LastErr != nil {
+				t.Fatalf("failed to write fork (%d): %v", errc, sndr.LastErr)
+			}
+		}
+
+		errc, fork := sndr.GetxattrAt("/fork", ffs.ResourceForkXAttr, 6)
+		if errc != 0 || sndr.LastErr != nil {
+			t.Fatalf("failed to read fork (%d): %v", errc, sndr.LastErr)
+		}
+
+		if !bytes.Equal(fork, []byte("second")) {
+			t.Fatalf("expected the fork to be read from its position, got: %q", fork)
+		}
+	})
+}
+
+func TestHTTPAuth(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, clean, err := ffs.NewTempFS("e2e", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer clean()
+	actors := make(chan string, 1)
+	fsr := NewSessions(func(id, actor string) FS {
+		actors <- actor
+		return fs.Session(id).As(actor)
+	}, fs.EndSession).WithAuth(BearerAuth(func(token string) (string, error) {
+		if token != "good" {
+			return "", errors.New("bad token")
+		}
+
+		return "alice", nil
+	}))
+
+	svr := httptest.NewServer(fsr)
+	defer svr.Close()
+	addr := strings.TrimPrefix(svr.URL, "http://")
+
+	if _, err = DialHTTPToken(addr, "/", "bad"); err == nil {
+		t.Fatal("expected a bad token to be refused")
+	}
+
+	if _, err = DialHTTP(addr, "/?actor=alice"); err == nil {
+		t.Fatal("expected a session without token to be refused")
+	}
+
+	sndr, err := DialHTTPToken(addr, "/", "good")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errc := sndr.Statfs("/", &fuse.Statfs_t{}); errc != 0 {
+		t.Fatalf("expected statfs to succeed, got: %d", errc)
+	}
+
+	if actor := <-actors; actor != "alice" {
+		t.Fatalf("expected session to work as 'alice', got: '%s'", actor)
+	}
 }
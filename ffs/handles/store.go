@@ -1,12 +1,35 @@
 package handles
 
 import (
+	"encoding/binary"
+	"math"
+
 	"github.com/advanderveer/dfs/ffs/nodes"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
 )
 
+//Handle is the record of a single open of a node, two opens of the same
+//node result in two handles that each keep their own state
+type Handle struct {
+	ID    uint64
+	Ino   uint64
+	Flags int
+	Owner string
+	Node  *nodes.Node
+
+	//Version is the id of the previous version the handle reads from, zero
+	//if it refers to the current content of the node
+	Version uint64
+
+	//Written is set once content is written through the handle, the write
+	//is reported when the handle is flushed or released
+	Written bool
+}
+
+var endianess = binary.LittleEndian
+
 type Store struct {
 	tr  fdb.Transactor
 	ss  subspace.Subspace
@@ -21,19 +44,150 @@ func NewStore(tr fdb.Transactor, ss subspace.Subspace, sss subspace.Subspace) *S
 	}
 }
 
-func (s *Store) Get(tx fdb.Transaction, fh uint64) (n *nodes.Node) {
-	d := tx.Get(s.ss.Pack(tuple.Tuple{int64(fh)})).MustGet()
-	if len(d) > 0 {
-		return nodes.NewNode(s.sss, fh)
+//getUint64 reads a counter at key k, zero if it isn't set
+func getUint64(tx fdb.Transaction, k fdb.Key) uint64 {
+	d := tx.Get(k).MustGet()
+	if len(d) != 8 {
+		return 0
+	}
+
+	return endianess.Uint64(d)
+}
+
+func putUint64(tx fdb.Transaction, k fdb.Key, v uint64) {
+	b := make([]byte, 8)
+	endianess.PutUint64(b, v)
+	tx.Set(k, b)
+}
+
+//nextID returns a new handle id, id's are never re-used such that a stale
+//file handle from a client will never point to another open. Every session
+//gets a number once and counts its own handles after it, so opens only
+//conflict with opens of the same session. Session numbers start at 1, ids
+//from the single counter that was used before lie below them.
+func (s *Store) nextID(tx fdb.Transaction, sess string) (fh uint64) {
+	nk, ck := s.ss.Pack(tuple.Tuple{"sess", sess}), s.ss.Pack(tuple.Tuple{"fh", sess})
+	num, seq := getUint64(tx, nk), getUint64(tx, ck)+1
+	if num == 0 || seq > math.MaxUint32 {
+		num, seq = getUint64(tx, s.ss.Pack(tuple.Tuple{"sess"}))+1, 1
+		putUint64(tx, s.ss.Pack(tuple.Tuple{"sess"}), num)
+		putUint64(tx, nk, num)
+	}
+
+	putUint64(tx, ck, seq)
+	return num<<32 | seq
+}
+
+//EndSession forgets the handle counter of session sess, the session gets
+//a new number if it opens handles again
+func (s *Store) EndSession(tx fdb.Transaction, sess string) {
+	tx.Clear(s.ss.Pack(tuple.Tuple{"sess", sess}))
+	tx.Clear(s.ss.Pack(tuple.Tuple{"fh", sess}))
+}
+
+//Open records a new open of node n with the provided flags for the owner
+func (s *Store) Open(tx fdb.Transaction, n *nodes.Node, flags int, owner string) (h *Handle) {
+	return s.OpenVersion(tx, n, 0, flags, owner)
+}
+
+//OpenVersion records a new open of a previous version of node n
+func (s *Store) OpenVersion(tx fdb.Transaction, n *nodes.Node, version uint64, flags int, owner string) (h *Handle) {
+	h = &Handle{
+		ID:      s.nextID(tx, owner),
+		Ino:     n.StatGetIno(tx),
+		Flags:   flags,
+		Owner:   owner,
+		Node:    n,
+		Version: version,
+	}
+
+	s.put(tx, h)
+	return h
+}
+
+//SetWritten records whether content was written through handle h since it
+//was last flushed
+func (s *Store) SetWritten(tx fdb.Transaction, h *Handle, written bool) {
+	h.Written = written
+	s.put(tx, h)
+}
+
+func (s *Store) put(tx fdb.Transaction, h *Handle) {
+	written := int64(0)
+	if h.Written {
+		written = 1
 	}
 
-	return
+	tx.Set(s.ss.Pack(tuple.Tuple{int64(h.ID)}), tuple.Tuple{int64(h.Ino), int64(h.Flags), h.Owner, int64(h.Version), written}.Pack())
 }
 
-func (s *Store) Set(tx fdb.Transaction, fh uint64, n *nodes.Node) {
-	tx.Set(s.ss.Pack(tuple.Tuple{int64(fh)}), []byte{0x01})
+//Get the handle with id fh, returns nil if no such handle is open
+func (s *Store) Get(tx fdb.Transaction, fh uint64) (h *Handle) {
+	d := tx.Get(s.ss.Pack(tuple.Tuple{int64(fh)})).MustGet()
+	if len(d) < 1 {
+		return nil
+	}
+
+	return s.unpack(fh, d)
 }
 
+//Del removes the handle record with id fh
 func (s *Store) Del(tx fdb.Transaction, fh uint64) {
 	tx.Clear(s.ss.Pack(tuple.Tuple{int64(fh)}))
 }
+
+//Each calls f for every open handle until it returns true
+func (s *Store) Each(tx fdb.Transaction, f func(h *Handle) (stop bool)) {
+	iter := tx.GetRange(s.ss, fdb.RangeOptions{}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		t, err := s.ss.Unpack(kv.Key)
+		if err != nil || len(t) != 1 {
+			continue
+		}
+
+		fh, ok := t[0].(int64)
+		if !ok {
+			continue //the id counters
+		}
+
+		h := s.unpack(uint64(fh), kv.Value)
+		if h == nil {
+			continue
+		}
+
+		if f(h) {
+			return
+		}
+	}
+}
+
+func (s *Store) unpack(fh uint64, d []byte) (h *Handle) {
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) < 3 {
+		return nil
+	}
+
+	ino, _ := t[0].(int64)
+	flags, _ := t[1].(int64)
+	owner, _ := t[2].(string)
+	h = &Handle{
+		ID:    fh,
+		Ino:   uint64(ino),
+		Flags: int(flags),
+		Owner: owner,
+		Node:  nodes.NewNode(s.sss, uint64(ino)),
+	}
+
+	if len(t) > 3 {
+		ver, _ := t[3].(int64)
+		h.Version = uint64(ver)
+	}
+
+	if len(t) > 4 {
+		written, _ := t[4].(int64)
+		h.Written = written == 1
+	}
+
+	return h
+}
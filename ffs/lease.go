@@ -0,0 +1,191 @@
+package ffs
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//LeaseXAttr is the read-only extended attribute that exposes the lease on a
+//directory as JSON
+const LeaseXAttr = "user.dfs.lease"
+
+//As returns a view on the filesystem that writes as the provided actor, an
+//actor can write into the directories it holds a lease on
+func (self *Memfs) As(actor string) *Memfs {
+	as := *self
+	as.actor = actor
+	return &as
+}
+
+//leased returns whether path lies in (or is) a directory that is leased by
+//someone other than the actor of this view on the filesystem
+func (self *Memfs) leased(tx fdb.Transaction, path string) bool {
+	if !self.nstore.HasLeases(tx) {
+		return false
+	}
+
+	now := time.Now()
+	other := func(node *nodes.Node) bool {
+		l := self.nstore.Lease(tx, node)
+		return nil != l && l.Active(now) && l.Holder != self.actor
+	}
+
+	node := self.nstore.Root(tx)
+	if other(node) {
+		return true
+	}
+
+	for _, c := range split(path) {
+		if "" == c {
+			continue
+		}
+
+		node = node.GetChld(tx, c)
+		if nil == node {
+			return false
+		}
+
+		if other(node) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//leaseXAttr returns the JSON encoded active lease of node or nil
+func (self *Memfs) leaseXAttr(tx fdb.Transaction, node *nodes.Node) []byte {
+	l := self.nstore.Lease(tx, node)
+	if nil == l || !l.Active(time.Now()) {
+		return nil
+	}
+
+	d, err := json.Marshal(l)
+	if err != nil {
+		return nil
+	}
+
+	return d
+}
+
+//AcquireLease claims the directory at path for holder until ttl has passed,
+//it fails with EBUSY when someone else holds an active lease on it.
+func (self *Memfs) AcquireLease(path string, holder string, reason string, ttl time.Duration) (errc int) {
+	defer trace(path, holder, reason, ttl)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, path, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+		if fuse.S_IFDIR != node.Stat(tx).Mode&fuse.S_IFMT {
+			return -fuse.ENOTDIR
+		}
+
+		now := time.Now()
+		if l := self.nstore.Lease(tx, node); nil != l && l.Active(now) && l.Holder != holder {
+			return -fuse.EBUSY
+		}
+
+		self.nstore.SetLease(tx, node, path, &nodes.Lease{
+			Holder:  holder,
+			Reason:  reason,
+			Expires: now.Add(ttl),
+		})
+
+		return 0
+	})
+}
+
+//RenewLease extends the active lease of holder on path to expire after ttl
+func (self *Memfs) RenewLease(path string, holder string, ttl time.Duration) (errc int) {
+	defer trace(path, holder, ttl)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, path, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+
+		now := time.Now()
+		l := self.nstore.Lease(tx, node)
+		if nil == l || !l.Active(now) || l.Holder != holder {
+			return -fuse.ENOLCK
+		}
+
+		l.Expires = now.Add(ttl)
+		self.nstore.SetLease(tx, node, path, l)
+		return 0
+	})
+}
+
+//ReleaseLease removes the lease of holder from the directory at path
+func (self *Memfs) ReleaseLease(path string, holder string) (errc int) {
+	defer trace(path, holder)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, path, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+
+		l := self.nstore.Lease(tx, node)
+		if nil == l || l.Holder != holder {
+			return -fuse.ENOLCK
+		}
+
+		self.nstore.DelLease(tx, node)
+		return 0
+	})
+}
+
+//Leases calls f for every active lease with the path it was acquired on
+func (self *Memfs) Leases(f func(path string, l *nodes.Lease) bool) (errc int) {
+	defer trace()(&errc)
+
+	var paths []string
+	var leases []*nodes.Lease
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		paths, leases = nil, nil
+		now := time.Now()
+		self.nstore.EachLease(tx, func(n *nodes.Node, path string, l *nodes.Lease) (stop bool) {
+			if l.Active(now) {
+				paths = append(paths, path)
+				leases = append(leases, l)
+			}
+
+			return false
+		})
+
+		return 0
+	}); 0 != errc {
+		return errc
+	}
+
+	for i, l := range leases {
+		if !f(paths[i], l) {
+			break
+		}
+	}
+
+	return 0
+}
+
+//moveLeases rewrites the recorded path of leases on or below oldpath after
+//it was renamed to newpath
+func (self *Memfs) moveLeases(tx fdb.Transaction, oldpath string, newpath string) {
+	if !self.nstore.HasLeases(tx) {
+		return
+	}
+
+	oldpath, newpath = strings.TrimRight(oldpath, "/"), strings.TrimRight(newpath, "/")
+	self.nstore.EachLease(tx, func(n *nodes.Node, path string, l *nodes.Lease) (stop bool) {
+		if path == oldpath || strings.HasPrefix(path, oldpath+"/") {
+			self.nstore.SetLease(tx, n, newpath+strings.TrimPrefix(path, oldpath), l)
+		}
+
+		return false
+	})
+}
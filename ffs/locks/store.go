@@ -0,0 +1,238 @@
+package locks
+
+import (
+	"math"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//Lock types, these are our own wire values as the F_* constants differ
+//between client platforms
+const (
+	RDLCK = 0
+	WRLCK = 1
+	UNLCK = 2
+)
+
+//Lock commands
+const (
+	GETLK  = 0
+	SETLK  = 1
+	SETLKW = 2
+)
+
+//Lock describes a byte range lock, analogous to the POSIX struct flock.
+type Lock struct {
+	// Type of lock; RDLCK, WRLCK, UNLCK.
+	Type int16
+
+	// Flag for starting offset, only SEEK_SET (0) is supported.
+	Whence int16
+
+	// Relative offset in bytes.
+	Start int64
+
+	// Size; if 0 then until EOF, if negative the range ends at Start.
+	Len int64
+
+	// Process ID of the process holding the lock
+	Pid int
+}
+
+//Owner identifies who holds a lock: a process of a client session. Locks
+//of the same owner never conflict with each other.
+type Owner struct {
+	Sess string
+	Pid  int
+}
+
+//held is a lock as it is stored for a node
+type held struct {
+	owner Owner
+	start int64
+	end   int64 //exclusive, math.MaxInt64 if until EOF
+	typ   int16
+	fh    uint64
+}
+
+func (h *held) overlaps(start, end int64) bool { return h.start < end && start < h.end }
+
+type Store struct {
+	tr fdb.Transactor
+	ss subspace.Subspace
+}
+
+func NewStore(tr fdb.Transactor, ss subspace.Subspace) *Store {
+	return &Store{tr: tr, ss: ss}
+}
+
+//Range returns the half open range [start, end) a lock covers, a negative
+//length covers [Start+Len, Start) like POSIX. It returns false if the range
+//starts before 0 or ends beyond what an offset can hold.
+func Range(lk *Lock) (start, end int64, ok bool) {
+	switch {
+	case lk.Start < 0:
+		return 0, 0, false
+	case lk.Len < 0:
+		if lk.Start+lk.Len < 0 {
+			return 0, 0, false
+		}
+
+		return lk.Start + lk.Len, lk.Start, true
+	case lk.Len == 0:
+		return lk.Start, math.MaxInt64, true
+	case lk.Len > math.MaxInt64-lk.Start:
+		return 0, 0, false
+	default:
+		return lk.Start, lk.Start + lk.Len, true
+	}
+}
+
+//rng returns the range of a lock that was checked with Range
+func rng(lk *Lock) (start, end int64) {
+	start, end, _ = Range(lk)
+	return
+}
+
+//WatchKey returns the key that changes whenever locks on node ino change
+func (s *Store) WatchKey(ino uint64) fdb.Key {
+	return s.ss.Pack(tuple.Tuple{"ver", int64(ino)})
+}
+
+func (s *Store) touch(tx fdb.Transaction, ino uint64) {
+	one := []byte{0x01, 0, 0, 0, 0, 0, 0, 0}
+	tx.Add(s.WatchKey(ino), one)
+}
+
+func (s *Store) each(tx fdb.Transaction, ino uint64, f func(k fdb.Key, h *held)) {
+	sub := s.ss.Sub("rng", int64(ino))
+	iter := tx.GetRange(sub, fdb.RangeOptions{}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		kt, err := sub.Unpack(kv.Key)
+		if err != nil || len(kt) != 3 {
+			continue
+		}
+
+		vt, err := tuple.Unpack(kv.Value)
+		if err != nil || len(vt) != 3 {
+			continue
+		}
+
+		sess, _ := kt[0].(string)
+		pid, _ := kt[1].(int64)
+		start, _ := kt[2].(int64)
+		end, _ := vt[0].(int64)
+		typ, _ := vt[1].(int64)
+		fh, _ := vt[2].(int64)
+		f(kv.Key, &held{
+			owner: Owner{Sess: sess, Pid: int(pid)},
+			start: start,
+			end:   end,
+			typ:   int16(typ),
+			fh:    uint64(fh),
+		})
+	}
+}
+
+func (s *Store) put(tx fdb.Transaction, ino uint64, h *held) {
+	tx.Set(
+		s.ss.Pack(tuple.Tuple{"rng", int64(ino), h.owner.Sess, int64(h.owner.Pid), h.start}),
+		tuple.Tuple{h.end, int64(h.typ), int64(h.fh)}.Pack(),
+	)
+}
+
+//Conflict returns the first lock of another owner that prevents lk from
+//being taken on node ino, it returns nil if there is no such lock.
+func (s *Store) Conflict(tx fdb.Transaction, ino uint64, owner Owner, lk *Lock) (c *Lock) {
+	start, end := rng(lk)
+	s.each(tx, ino, func(k fdb.Key, h *held) {
+		if c != nil || h.owner == owner || !h.overlaps(start, end) {
+			return
+		}
+
+		if lk.Type == WRLCK || h.typ == WRLCK {
+			c = &Lock{Type: h.typ, Start: h.start, Pid: h.owner.Pid}
+			if h.end != math.MaxInt64 {
+				c.Len = h.end - h.start
+			}
+		}
+	})
+
+	return c
+}
+
+//Set applies lk for owner on node ino through handle fh. Ranges already held
+//by the owner are replaced or split such that the new lock (or unlock) takes
+//effect for exactly the requested range. The caller is expected to have
+//checked for conflicts.
+func (s *Store) Set(tx fdb.Transaction, ino uint64, fh uint64, owner Owner, lk *Lock) {
+	start, end := rng(lk)
+	s.each(tx, ino, func(k fdb.Key, h *held) {
+		if h.owner != owner || !h.overlaps(start, end) {
+			return
+		}
+
+		tx.Clear(k)
+		if h.start < start {
+			s.put(tx, ino, &held{owner: owner, start: h.start, end: start, typ: h.typ, fh: h.fh})
+		}
+
+		if h.end > end {
+			s.put(tx, ino, &held{owner: owner, start: end, end: h.end, typ: h.typ, fh: h.fh})
+		}
+	})
+
+	if lk.Type != UNLCK {
+		s.put(tx, ino, &held{owner: owner, start: start, end: end, typ: lk.Type, fh: fh})
+	}
+
+	s.touch(tx, ino)
+}
+
+//ReleaseHandle removes all locks on node ino of the owners that took a lock
+//through handle fh. Like close(2) this includes the locks they took through
+//other handles of the node.
+func (s *Store) ReleaseHandle(tx fdb.Transaction, ino uint64, fh uint64) {
+	owners := map[Owner]bool{}
+	s.each(tx, ino, func(k fdb.Key, h *held) {
+		if h.fh == fh {
+			owners[h.owner] = true
+		}
+	})
+
+	if len(owners) < 1 {
+		return
+	}
+
+	s.each(tx, ino, func(k fdb.Key, h *held) {
+		if owners[h.owner] {
+			tx.Clear(k)
+		}
+	})
+
+	s.touch(tx, ino)
+}
+
+//ReleaseSession removes all locks held by processes of session sess
+func (s *Store) ReleaseSession(tx fdb.Transaction, sess string) {
+	sub := s.ss.Sub("rng")
+	iter := tx.GetRange(sub, fdb.RangeOptions{}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		kt, err := sub.Unpack(kv.Key)
+		if err != nil || len(kt) != 4 {
+			continue
+		}
+
+		ino, _ := kt[0].(int64)
+		if owner, _ := kt[1].(string); owner != sess {
+			continue
+		}
+
+		tx.Clear(kv.Key)
+		s.touch(tx, uint64(ino))
+	}
+}
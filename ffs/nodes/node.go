@@ -2,9 +2,9 @@ package nodes
 
 import (
 	"encoding/binary"
+	"sync"
 	"time"
 
-	"bazil.org/bazil/cas/blobs"
 	"bazil.org/bazil/cas/chunks"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
@@ -13,22 +13,56 @@ import (
 )
 
 //@TODO store unpersisted blobs somewhere else (with the handle?)
-var dirtyBlobs = map[uint64]*blobs.Blob{}
+//blobs are keyed by the node's subspace as inode numbers are only unique
+//within a single volume, the volumes of a process share the map so it has a
+//lock of its own
+var (
+	dirtyBlobs   = map[string]content{}
+	dirtyBlobsMu sync.Mutex
+)
+
+func (node *Node) dirtyBlob() (blob content, ok bool) {
+	dirtyBlobsMu.Lock()
+	defer dirtyBlobsMu.Unlock()
+	blob, ok = dirtyBlobs[node.blobKey()]
+	return blob, ok
+}
+
+func (node *Node) setDirtyBlob(blob content) {
+	dirtyBlobsMu.Lock()
+	defer dirtyBlobsMu.Unlock()
+	dirtyBlobs[node.blobKey()] = blob
+}
 
-func (node *Node) blob(tx fdb.Transaction, cstore chunks.Store) *blobs.Blob {
+func (node *Node) delDirtyBlob() {
+	dirtyBlobsMu.Lock()
+	defer dirtyBlobsMu.Unlock()
+	delete(dirtyBlobs, node.blobKey())
+}
+
+func (node *Node) blobKey() string {
+	return string(node.ss.Bytes())
+}
+
+//blob returns the open content of the node, it fails when the content can't
+//be opened because its index chunk or inline value is missing or corrupt
+func (node *Node) blob(tx fdb.Transaction, cstore chunks.Store) (content, error) {
 	var err error
-	ino := node.StatGetIno(tx)
-	blob, ok := dirtyBlobs[ino]
+	blob, ok := node.dirtyBlob()
 	if !ok {
-		blob, err = blobs.Open(cstore, node.manifest(tx))
-		if err != nil {
-			panic(err)
+		if node.hasManifest(tx) {
+			blob, err = node.open(tx, node.chunks(tx, cstore), node.manifest(tx))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			blob = &inlineContent{cstore: node.chunks(tx, cstore), base: node.manifest(tx)} //new content starts inline
 		}
 
-		dirtyBlobs[ino] = blob
+		node.setDirtyBlob(blob)
 	}
 
-	return blob
+	return blob, nil
 }
 
 var endianess = binary.LittleEndian
@@ -96,6 +130,23 @@ func NewNode(sss subspace.Subspace, ino uint64) *Node {
 	return &Node{sss: sss, ss: sss.Sub(int64(ino))}
 }
 
+//Ino returns the inode number the node is stored under without reading its
+//stat, it is known even if the node doesn't exist
+func (n *Node) Ino() uint64 {
+	t, err := n.sss.Unpack(n.ss)
+	if err != nil || len(t) < 1 {
+		return 0
+	}
+
+	ino, _ := t[0].(int64)
+	return uint64(ino)
+}
+
+//Exists returns whether the stat of the node was ever initialized
+func (n *Node) Exists(tx fdb.Transaction) bool {
+	return len(tx.Get(n.ss.Pack(tuple.Tuple{"ino"})).MustGet()) > 0
+}
+
 func (n *Node) Init(tx fdb.Transaction, dev uint64, ino uint64, mode uint32, uid uint32, gid uint32) {
 	tmsp := fuse.Now()
 	n.statSetDev(tx, dev)
@@ -110,8 +161,14 @@ func (n *Node) Init(tx fdb.Transaction, dev uint64, ino uint64, mode uint32, uid
 	n.StatSetBirthTim(tx, tmsp)
 	n.StatSetFlags(tx, 0)
 
-	const kB = 1024
-	const MB = 1024 * kB
-	n.putUint32At(tx, "csize", 4*MB)
+	n.putUint32At(tx, "csize", defaultChunkSize)
 	n.putUint32At(tx, "fanout", 64)
 }
+
+const defaultChunkSize = 4 * 1024 * 1024
+
+//setChunking configures how the content of a new node is chunked
+func (n *Node) setChunking(tx fdb.Transaction, c Chunking) {
+	tx.Set(n.ss.Pack(tuple.Tuple{"mtype"}), []byte(c.Scheme))
+	n.putUint32At(tx, "csize", c.Size)
+}
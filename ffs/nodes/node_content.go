@@ -0,0 +1,163 @@
+package nodes
+
+import (
+	"context"
+	"io"
+
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/cdc"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//content is the mutable content of a file, how it is chunked depends on the
+//type of the manifest it was opened with
+type content interface {
+	ReadAt(ctx context.Context, p []byte, ofst int64) (int, error)
+	WriteAt(ctx context.Context, p []byte, ofst int64) (int, error)
+	Truncate(ctx context.Context, size uint64) error
+	Save(ctx context.Context) (*blobs.Manifest, error)
+}
+
+//zeroer is implemented by content that can replace a range with zeros
+//without writing them
+type zeroer interface {
+	Zero(ctx context.Context, start, end int64) error
+}
+
+//zeroBufSize is the size of the writes that replace content with zeros
+const zeroBufSize = 1024 * 1024
+
+//zeroContent replaces [start, end) of content c of size with zeros. A range
+//at the end is cut off and grown back, content that is chunked by content
+//replaces the chunks in the range. Fixed chunks can only be written so they
+//are overwritten with zeros.
+func zeroContent(ctx context.Context, c content, size, start, end int64) error {
+	if end >= size {
+		if err := c.Truncate(ctx, uint64(start)); err != nil {
+			return err
+		}
+
+		return c.Truncate(ctx, uint64(size))
+	}
+
+	if z, ok := c.(zeroer); ok {
+		return z.Zero(ctx, start, end)
+	}
+
+	zeros := make([]byte, zeroBufSize)
+	for ofst := start; ofst < end; {
+		buf := zeros
+		if int64(len(buf)) > end-ofst {
+			buf = buf[:end-ofst]
+		}
+
+		if _, err := c.WriteAt(ctx, buf, ofst); err != nil {
+			return err
+		}
+
+		ofst += int64(len(buf))
+	}
+
+	return nil
+}
+
+//fixedContent is content that is chunked at fixed offsets
+type fixedContent struct {
+	*blobs.Blob
+}
+
+func (c fixedContent) ReadAt(ctx context.Context, p []byte, ofst int64) (int, error) {
+	return c.IO(ctx).ReadAt(p, ofst)
+}
+
+func (c fixedContent) WriteAt(ctx context.Context, p []byte, ofst int64) (int, error) {
+	return c.IO(ctx).WriteAt(p, ofst)
+}
+
+func openContent(cstore chunks.Store, m *blobs.Manifest) (content, error) {
+	if cdc.Type == m.Type {
+		return cdc.Open(cstore, m)
+	}
+
+	blob, err := blobs.Open(cstore, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return fixedContent{blob}, nil
+}
+
+//WriteContent writes what r yields to a new blob that is chunked like c and
+//returns its manifest. Only chunks are stored so it is called outside of a
+//transaction, the manifest can be set on a node afterwards.
+func WriteContent(ctx context.Context, cstore chunks.Store, c Chunking, r io.Reader) (*blobs.Manifest, error) {
+	cnt, err := openContent(cstore, &blobs.Manifest{Type: c.Scheme, ChunkSize: c.Size, Fanout: 64})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 64*1024)
+	for ofst := int64(0); ; {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err := cnt.WriteAt(ctx, buf[:n], ofst); err != nil {
+				return nil, err
+			}
+
+			ofst += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return cnt.Save(ctx)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+//Chunking configures how the content of new files is split in chunks
+type Chunking struct {
+	//Scheme is the manifest type, "blob" for fixed size chunks or cdc.Type
+	//for content-defined chunks
+	Scheme string `json:"scheme"`
+
+	//Size is the (average) chunk size
+	Size uint32 `json:"size"`
+}
+
+//SetChunking configures the chunking of files that are created from now on,
+//existing files keep the scheme that is recorded in their manifest
+func (store *Store) SetChunking(tx fdb.Transaction, c Chunking) {
+	tx.Set(store.ss.Pack(tuple.Tuple{"chunking"}), tuple.Tuple{c.Scheme, int64(c.Size)}.Pack())
+}
+
+//Chunking returns the chunking of new files
+func (store *Store) Chunking(tx fdb.Transaction) (c Chunking) {
+	return chunking(tx, store.ss.Pack(tuple.Tuple{"chunking"}))
+}
+
+func chunking(tx fdb.Transaction, k fdb.Key) (c Chunking) {
+	c = Chunking{Scheme: "blob", Size: defaultChunkSize}
+	d := tx.Get(k).MustGet()
+	if len(d) < 1 {
+		return c
+	}
+
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) != 2 {
+		return c
+	}
+
+	scheme, _ := t[0].(string)
+	size, _ := t[1].(int64)
+	if scheme != "" {
+		c.Scheme = scheme
+	}
+	if size > 0 {
+		c.Size = uint32(size)
+	}
+
+	return c
+}
@@ -0,0 +1,98 @@
+package nodes
+
+import (
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//DirSize is the aggregate of everything below a directory
+type DirSize struct {
+	Size  int64 `json:"size"`
+	Files int64 `json:"files"`
+	Dirs  int64 `json:"dirs"`
+}
+
+func (n *Node) getCounter(tx fdb.Transaction, k string) int64 {
+	d := tx.Get(n.ss.Pack(tuple.Tuple{"du", k})).MustGet()
+	if len(d) < 8 {
+		return 0
+	}
+
+	return int64(endianess.Uint64(d))
+}
+
+func (n *Node) addCounter(tx fdb.Transaction, k string, v int64) {
+	if 0 == v {
+		return
+	}
+
+	b := make([]byte, 8)
+	endianess.PutUint64(b, uint64(v))
+	tx.Add(n.ss.Pack(tuple.Tuple{"du", k}), b)
+}
+
+//DirSize returns the aggregate size, file and directory count below the node
+func (n *Node) DirSize(tx fdb.Transaction) (ds DirSize) {
+	ds.Size = n.getCounter(tx, "size")
+	ds.Files = n.getCounter(tx, "files")
+	ds.Dirs = n.getCounter(tx, "dirs")
+	return ds
+}
+
+//AddDirSize adds delta to the aggregates of the node using atomic operations
+//such that updates to the same directory don't conflict
+func (n *Node) AddDirSize(tx fdb.Transaction, delta DirSize) {
+	n.addCounter(tx, "size", delta.Size)
+	n.addCounter(tx, "files", delta.Files)
+	n.addCounter(tx, "dirs", delta.Dirs)
+}
+
+func (n *Node) linkSizeKey(prnt uint64, name string) fdb.Key {
+	return n.ss.Pack(tuple.Tuple{"du", "link", int64(prnt), name})
+}
+
+//LinkSize returns what the link of the node from the directory with inode
+//prnt under name contributed to the aggregates above it, ok is false when
+//nothing was recorded for the link
+func (n *Node) LinkSize(tx fdb.Transaction, prnt uint64, name string) (ds DirSize, ok bool) {
+	d := tx.Get(n.linkSizeKey(prnt, name)).MustGet()
+	if len(d) < 24 {
+		return ds, false
+	}
+
+	ds.Size = int64(endianess.Uint64(d[0:]))
+	ds.Files = int64(endianess.Uint64(d[8:]))
+	ds.Dirs = int64(endianess.Uint64(d[16:]))
+	return ds, true
+}
+
+//SetLinkSize records what the link of the node from the directory with inode
+//prnt under name contributed to the aggregates above it
+func (n *Node) SetLinkSize(tx fdb.Transaction, prnt uint64, name string, ds DirSize) {
+	b := make([]byte, 24)
+	endianess.PutUint64(b[0:], uint64(ds.Size))
+	endianess.PutUint64(b[8:], uint64(ds.Files))
+	endianess.PutUint64(b[16:], uint64(ds.Dirs))
+	tx.Set(n.linkSizeKey(prnt, name), b)
+}
+
+//AddLinkSize adds delta to what was recorded for the link from the directory
+//with inode prnt under name, links without a record are left as they are
+func (n *Node) AddLinkSize(tx fdb.Transaction, prnt uint64, name string, delta DirSize) {
+	ds, ok := n.LinkSize(tx, prnt, name)
+	if !ok {
+		return
+	}
+
+	n.SetLinkSize(tx, prnt, name, DirSize{
+		Size:  ds.Size + delta.Size,
+		Files: ds.Files + delta.Files,
+		Dirs:  ds.Dirs + delta.Dirs,
+	})
+}
+
+//DelLinkSize clears what was recorded for the link from the directory with
+//inode prnt under name
+func (n *Node) DelLinkSize(tx fdb.Transaction, prnt uint64, name string) {
+	tx.Clear(n.linkSizeKey(prnt, name))
+}
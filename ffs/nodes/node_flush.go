@@ -8,14 +8,30 @@ import (
 	"github.com/billziss-gh/cgofuse/fuse"
 )
 
+//Flush persists the content of the node, if it replaces other content the
+//previous manifest is kept as a version
 func (node *Node) Flush(tx fdb.Transaction, cstore chunks.Store) (errc int) {
-	blob := node.blob(tx, cstore)
+	blob, err := node.blob(tx, cstore)
+	if err != nil {
+		node.reportCorrupt(tx, err)
+		return -fuse.EIO
+	}
+
 	m, err := blob.Save(context.Background())
 	if err != nil {
 		return -fuse.EIO
 	}
 
-	node.setManifest(tx, m)
-	delete(dirtyBlobs, node.StatGetIno(tx))
+	prev, has := node.manifest(tx), node.hasManifest(tx)
+	if c, ok := blob.(*inlineContent); ok && IsInline(m) && (!has || prev.Root != m.Root) {
+		node.putInline(tx, m.Root, c.data)
+	}
+
+	if has && prev.Root != m.Root {
+		node.keepVersion(tx, prev, node.manifestExtents(tx))
+	}
+
+	node.setManifest(tx, m, node.allExtents(tx))
+	node.delDirtyBlob()
 	return
 }
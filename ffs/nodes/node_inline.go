@@ -0,0 +1,244 @@
+package nodes
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//InlineType is the manifest type of content that is stored in FDB instead of
+//the chunk store, the root of such a manifest identifies the stored value
+const InlineType = "inline"
+
+//InlineLimit is the size up to which the content of new files and symlinks
+//is stored inline, content that grows beyond it is promoted to a blob
+const InlineLimit = 4 * 1024
+
+//IsInline returns whether the content described by m is stored inline
+func IsInline(m *blobs.Manifest) bool {
+	return nil != m && InlineType == m.Type
+}
+
+//inlineContent is content that is small enough to be stored in FDB. Once it
+//grows beyond the inline limit it is moved to a blob that is chunked like
+//base and every operation is passed on to it.
+type inlineContent struct {
+	m      *blobs.Manifest //what the content was last saved as, if anything
+	data   []byte
+	dirty  bool
+	cstore chunks.Store
+	base   *blobs.Manifest
+	blob   content
+}
+
+func (c *inlineContent) promote(ctx context.Context) (err error) {
+	c.blob, err = openContent(c.cstore, &blobs.Manifest{Type: c.base.Type, ChunkSize: c.base.ChunkSize, Fanout: c.base.Fanout})
+	if err != nil {
+		return err
+	}
+
+	if len(c.data) > 0 {
+		if _, err = c.blob.WriteAt(ctx, c.data, 0); err != nil {
+			return err
+		}
+	}
+
+	c.data = nil
+	return nil
+}
+
+func (c *inlineContent) ReadAt(ctx context.Context, p []byte, ofst int64) (int, error) {
+	if nil != c.blob {
+		return c.blob.ReadAt(ctx, p, ofst)
+	}
+
+	if ofst >= int64(len(c.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.data[ofst:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (c *inlineContent) WriteAt(ctx context.Context, p []byte, ofst int64) (int, error) {
+	end := ofst + int64(len(p))
+	if nil == c.blob && end > InlineLimit {
+		if err := c.promote(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	if nil != c.blob {
+		return c.blob.WriteAt(ctx, p, ofst)
+	}
+
+	if end > int64(len(c.data)) {
+		c.data = append(c.data, make([]byte, end-int64(len(c.data)))...)
+	}
+
+	c.dirty = true
+	return copy(c.data[ofst:], p), nil
+}
+
+func (c *inlineContent) Truncate(ctx context.Context, size uint64) error {
+	if nil == c.blob && size > InlineLimit {
+		if err := c.promote(ctx); err != nil {
+			return err
+		}
+	}
+
+	if nil != c.blob {
+		return c.blob.Truncate(ctx, size)
+	}
+
+	if size == uint64(len(c.data)) {
+		return nil
+	}
+
+	if size < uint64(len(c.data)) {
+		c.data = c.data[:size]
+	} else {
+		c.data = append(c.data, make([]byte, size-uint64(len(c.data)))...)
+	}
+
+	c.dirty = true
+	return nil
+}
+
+//Save returns the manifest of a promoted blob or an inline manifest with a
+//new root if the content changed, the value itself is stored when the node
+//is flushed.
+func (c *inlineContent) Save(ctx context.Context) (*blobs.Manifest, error) {
+	if nil != c.blob {
+		return c.blob.Save(ctx)
+	}
+
+	if nil != c.m && !c.dirty {
+		return c.m, nil
+	}
+
+	root := make([]byte, cas.KeySize)
+	if _, err := rand.Read(root); err != nil {
+		return nil, fmt.Errorf("failed to generate inline root: %v", err)
+	}
+
+	c.m = &blobs.Manifest{Type: InlineType, Root: cas.NewKey(root), Size: uint64(len(c.data)), ChunkSize: c.base.ChunkSize, Fanout: c.base.Fanout}
+	c.dirty = false
+	return c.m, nil
+}
+
+func (n *Node) inlineKey(root cas.Key) fdb.Key {
+	return n.sss.Pack(tuple.Tuple{"inline", root.Bytes()})
+}
+
+func (n *Node) inlineRefsKey(root cas.Key) fdb.Key {
+	return n.sss.Pack(tuple.Tuple{"inlinerefs", root.Bytes()})
+}
+
+//putInline stores the inline value with root, values are never changed once
+//they are stored such that versions and clones can share them. The value
+//starts without references, it is cleared once the last manifest or version
+//that referred to it is gone.
+func (n *Node) putInline(tx fdb.Transaction, root cas.Key, data []byte) {
+	k := n.inlineKey(root)
+	if s := sealerOf(n.sss); s != nil {
+		data = s.Seal(data, k)
+	}
+
+	tx.Set(k, data)
+	tx.Set(n.inlineRefsKey(root), make([]byte, 8))
+}
+
+//inlineRefs returns the nr of references to the inline value with root, ok
+//is false for values that were stored before references were counted
+func (n *Node) inlineRefs(tx fdb.Transaction, root cas.Key) (refs int64, ok bool) {
+	d := tx.Get(n.inlineRefsKey(root)).MustGet()
+	if len(d) != 8 {
+		return 0, false
+	}
+
+	return int64(endianess.Uint64(d)), true
+}
+
+//refInline adds a reference to the value of m if its content is inline
+func (n *Node) refInline(tx fdb.Transaction, m *blobs.Manifest) {
+	if !IsInline(m) {
+		return
+	}
+
+	refs, ok := n.inlineRefs(tx, m.Root)
+	if !ok {
+		return //uncounted values are never cleared
+	}
+
+	b := make([]byte, 8)
+	endianess.PutUint64(b, uint64(refs+1))
+	tx.Set(n.inlineRefsKey(m.Root), b)
+}
+
+//unrefInline drops a reference to the value of m if its content is inline,
+//the value is cleared when it was the last one
+func (n *Node) unrefInline(tx fdb.Transaction, m *blobs.Manifest) {
+	if !IsInline(m) {
+		return
+	}
+
+	refs, ok := n.inlineRefs(tx, m.Root)
+	if !ok {
+		return
+	}
+
+	if refs <= 1 {
+		tx.Clear(n.inlineKey(m.Root))
+		tx.Clear(n.inlineRefsKey(m.Root))
+		return
+	}
+
+	b := make([]byte, 8)
+	endianess.PutUint64(b, uint64(refs-1))
+	tx.Set(n.inlineRefsKey(m.Root), b)
+}
+
+//Inline returns the content described by the inline manifest m
+func (n *Node) Inline(tx fdb.Transaction, m *blobs.Manifest) (data []byte, err error) {
+	k := n.inlineKey(m.Root)
+	data = tx.Get(k).MustGet()
+	if s := sealerOf(n.sss); s != nil && len(data) > 0 {
+		if data, err = s.Open(data, k); err != nil {
+			return nil, err
+		}
+	}
+
+	if uint64(len(data)) != m.Size {
+		return nil, fmt.Errorf("inline content of %d bytes doesn't match its manifest of %d bytes", len(data), m.Size)
+	}
+
+	return data, nil
+}
+
+//open opens the content described by m, inline content that grows is
+//promoted to a blob with the chunking that new files get
+func (n *Node) open(tx fdb.Transaction, cstore chunks.Store, m *blobs.Manifest) (content, error) {
+	if !IsInline(m) {
+		return openContent(cstore, m)
+	}
+
+	data, err := n.Inline(tx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	c := chunking(tx, n.sss.Pack(tuple.Tuple{"chunking"}))
+	return &inlineContent{m: m, data: data, cstore: cstore, base: &blobs.Manifest{Type: c.Scheme, ChunkSize: c.Size, Fanout: m.Fanout}}, nil
+}
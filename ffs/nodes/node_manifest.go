@@ -7,15 +7,44 @@ import (
 	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
 )
 
-func (n *Node) setManifest(tx fdb.Transaction, m *blobs.Manifest) {
+//setManifest records m as the content of the node, the node refers to the
+//value of inline content instead of the content it had before. The extents
+//of sparse content are recorded with it, nil for dense content.
+func (n *Node) setManifest(tx fdb.Transaction, m *blobs.Manifest, exts []Extent) {
+	if prev, has := n.manifest(tx), n.hasManifest(tx); !has || prev.Root != m.Root {
+		n.refInline(tx, m)
+		if has {
+			n.unrefInline(tx, prev)
+		}
+	}
+
 	n.putUint64At(tx, "msize", m.Size)
 	n.putUint32At(tx, "csize", m.ChunkSize)
 	n.putUint32At(tx, "fanout", m.Fanout)
+	tx.Set(n.ss.Pack(tuple.Tuple{"mtype"}), []byte(m.Type))
 	tx.Set(n.ss.Pack(tuple.Tuple{"mroot"}), m.Root.Bytes())
+	if nil == exts {
+		tx.Clear(n.ss.Pack(tuple.Tuple{"mexts"}))
+	} else {
+		tx.Set(n.ss.Pack(tuple.Tuple{"mexts"}), encodeExtents(exts))
+	}
+}
+
+//manifestExtents returns the extents that were recorded with the manifest or
+//nil if its content is dense
+func (n *Node) manifestExtents(tx fdb.Transaction) []Extent {
+	return decodeExtents(tx.Get(n.ss.Pack(tuple.Tuple{"mexts"})).MustGet())
+}
+
+func (n *Node) hasManifest(tx fdb.Transaction) bool {
+	return len(tx.Get(n.ss.Pack(tuple.Tuple{"mroot"})).MustGet()) > 0
 }
 
 func (n *Node) manifest(tx fdb.Transaction) (m *blobs.Manifest) {
 	m = &blobs.Manifest{Type: "blob"}
+	if typ := tx.Get(n.ss.Pack(tuple.Tuple{"mtype"})).MustGet(); len(typ) > 0 {
+		m.Type = string(typ) //the chunking scheme, nodes from before it was recorded use fixed chunks
+	}
 
 	keyd := tx.Get(n.ss.Pack(tuple.Tuple{"mroot"})).MustGet()
 	if len(keyd) > 0 {
@@ -27,3 +56,35 @@ func (n *Node) manifest(tx fdb.Transaction) (m *blobs.Manifest) {
 	m.Fanout = n.getUint32At(tx, "fanout")
 	return
 }
+
+//SetManifest replaces the content of the node with the blob described by m
+//with the extents exts that hold data, nil if all of it does. The previous
+//content is kept as a version. Unflushed writes are discarded.
+func (n *Node) SetManifest(tx fdb.Transaction, m *blobs.Manifest, exts []Extent) {
+	if prev := n.manifest(tx); n.hasManifest(tx) && prev.Root != m.Root {
+		n.keepVersion(tx, prev, n.manifestExtents(tx))
+	}
+
+	n.setManifest(tx, m, exts)
+	n.StatSetSize(tx, int64(m.Size))
+	n.setExtents(tx, exts)
+	n.delDirtyBlob()
+}
+
+//DropContent forgets the content and versions of a node that has neither
+//links nor handles left, inline values that nothing else refers to are
+//cleared. Chunks are shared by content so they are left alone.
+func (n *Node) DropContent(tx fdb.Transaction) {
+	if n.hasManifest(tx) {
+		n.unrefInline(tx, n.manifest(tx))
+		tx.Clear(n.ss.Pack(tuple.Tuple{"mroot"}))
+		tx.Clear(n.ss.Pack(tuple.Tuple{"mexts"}))
+	}
+
+	for _, v := range n.Versions(tx) {
+		n.unrefInline(tx, v.m)
+	}
+
+	tx.ClearRange(n.ss.Sub("vers"))
+	n.delDirtyBlob()
+}
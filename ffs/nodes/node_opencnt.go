@@ -19,3 +19,8 @@ func (n *Node) DecOpencnt(tx fdb.Transaction) {
 	cnt--
 	n.putInt64At(tx, "opencnt", cnt)
 }
+
+//SetOpencnt overwrites the open count, it is used to repair it
+func (n *Node) SetOpencnt(tx fdb.Transaction, cnt int64) {
+	n.putInt64At(tx, "opencnt", cnt)
+}
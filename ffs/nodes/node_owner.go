@@ -0,0 +1,15 @@
+package nodes
+
+import (
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//Owner returns the actor that created the node, its usage is accounted to
+func (n *Node) Owner(tx fdb.Transaction) string {
+	return string(tx.Get(n.ss.Pack(tuple.Tuple{"owner"})).MustGet())
+}
+
+func (n *Node) SetOwner(tx fdb.Transaction, owner string) {
+	tx.Set(n.ss.Pack(tuple.Tuple{"owner"}), []byte(owner))
+}
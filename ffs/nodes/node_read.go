@@ -10,14 +10,20 @@ import (
 )
 
 func (node *Node) ReadAt(tx fdb.Transaction, cstore chunks.Store, buff []byte, ofst int64) (n int) {
-	blob := node.blob(tx, cstore)
-	n, err := blob.IO(context.Background()).ReadAt(buff, ofst)
+	blob, err := node.blob(tx, cstore)
+	if err != nil {
+		node.reportCorrupt(tx, err)
+		return -fuse.EIO
+	}
+
+	n, err = blob.ReadAt(context.Background(), buff, ofst)
 	if err != nil {
 		if err == io.EOF {
 			return n
 		}
 
-		return -fuse.EIO //@TODO report
+		node.reportCorrupt(tx, err)
+		return -fuse.EIO
 	}
 
 	return
@@ -0,0 +1,26 @@
+package nodes
+
+import (
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+)
+
+//Sealer encrypts values before they are stored in FDB, additional data (ad)
+//binds a value to where it is stored
+type Sealer interface {
+	Seal(value, ad []byte) []byte
+	Open(value, ad []byte) ([]byte, error)
+}
+
+//sealers are keyed by the subspace of a store like dirty blobs, nodes only
+//know the subspace of the store they belong to
+var sealers = map[string]Sealer{}
+
+func sealerOf(sss subspace.Subspace) Sealer {
+	return sealers[string(sss.Bytes())]
+}
+
+//SetSealer encrypts sensitive metadata of the store's nodes with s from now
+//on, values that were stored before remain readable
+func (store *Store) SetSealer(s Sealer) {
+	sealers[string(store.ss.Bytes())] = s
+}
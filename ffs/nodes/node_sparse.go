@@ -0,0 +1,314 @@
+package nodes
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+
+	"bazil.org/bazil/cas/chunks"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//The content of a sparse node records the extents that hold data next to
+//its manifest, everything else is a hole that reads as zeros and references
+//no chunks. Content without recorded extents is dense up to its size, nodes
+//only become sparse once a hole is created in them. The extents of flushed
+//content are stored with its manifest so they move along with it when the
+//manifest is kept as a version, cloned or backed up.
+
+//blockSize is the preferred io size reported in the stat of nodes, block
+//counts are always in units of 512 bytes
+const blockSize = 4096
+
+//Extent is a range of content that holds data
+type Extent struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+//encodeExtents packs the extents of sparse content, the nr of extents comes
+//first such that content without any data still encodes to something
+func encodeExtents(exts []Extent) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	d := append([]byte{}, buf[:binary.PutUvarint(buf, uint64(len(exts)))]...)
+	for _, ext := range exts {
+		d = append(d, buf[:binary.PutVarint(buf, ext.Start)]...)
+		d = append(d, buf[:binary.PutVarint(buf, ext.End)]...)
+	}
+
+	return d
+}
+
+//decodeExtents unpacks what encodeExtents packed, it returns nil for dense
+//content
+func decodeExtents(d []byte) (exts []Extent) {
+	cnt, n := binary.Uvarint(d)
+	if n <= 0 {
+		return nil
+	}
+
+	exts, d = []Extent{}, d[n:]
+	for i := uint64(0); i < cnt; i++ {
+		start, n := binary.Varint(d)
+		if n <= 0 {
+			return nil
+		}
+
+		end, m := binary.Varint(d[n:])
+		if m <= 0 {
+			return nil
+		}
+
+		exts, d = append(exts, Extent{Start: start, End: end}), d[n+m:]
+	}
+
+	return exts
+}
+
+func (n *Node) allocKey() fdb.Key {
+	return n.ss.Pack(tuple.Tuple{"alloc"})
+}
+
+//Sparse returns whether the node records the extents of its content
+func (n *Node) Sparse(tx fdb.Transaction) bool {
+	return len(tx.Get(n.allocKey()).MustGet()) > 0
+}
+
+//allocated returns the nr of bytes of content that hold data
+func (n *Node) allocated(tx fdb.Transaction, size int64) int64 {
+	d := tx.Get(n.allocKey()).MustGet()
+	if len(d) < 1 {
+		return size
+	}
+
+	v, _ := binary.Varint(d)
+	return v
+}
+
+func (n *Node) addAllocated(tx fdb.Transaction, delta int64) {
+	n.putInt64At(tx, "alloc", n.allocated(tx, 0)+delta)
+}
+
+//makeSparse starts recording the extents of content that is dense up to
+//size
+func (n *Node) makeSparse(tx fdb.Transaction, size int64) {
+	if n.Sparse(tx) {
+		return
+	}
+
+	n.putInt64At(tx, "alloc", 0)
+	if size > 0 {
+		n.addData(tx, 0, size)
+	}
+}
+
+//makeDense forgets the extents of the content, it is called when the content
+//is replaced as a whole
+func (n *Node) makeDense(tx fdb.Transaction) {
+	tx.ClearRange(n.ss.Sub("data"))
+	tx.Clear(n.allocKey())
+}
+
+//setExtents replaces the extents of the content with exts, nil makes it
+//dense
+func (n *Node) setExtents(tx fdb.Transaction, exts []Extent) {
+	n.makeDense(tx)
+	if nil == exts {
+		return
+	}
+
+	n.putInt64At(tx, "alloc", 0)
+	for _, ext := range exts {
+		n.addData(tx, ext.Start, ext.End) //extents from a snapshot may overlap
+	}
+}
+
+//allExtents returns the extents of the content or nil if it is dense
+func (n *Node) allExtents(tx fdb.Transaction) []Extent {
+	if !n.Sparse(tx) {
+		return nil
+	}
+
+	return n.extents(tx, 0, math.MaxInt64)
+}
+
+//extents returns the extents that overlap or touch [start, end]
+func (n *Node) extents(tx fdb.Transaction, start, end int64) (exts []Extent) {
+	rng := n.ss.Sub("data")
+	begin, _ := rng.FDBRangeKeys()
+	unpack := func(kv fdb.KeyValue) (ext Extent, ok bool) {
+		t, err := rng.Unpack(kv.Key)
+		if err != nil || len(t) != 1 {
+			return ext, false
+		}
+
+		ext.Start, _ = t[0].(int64)
+		ext.End, _ = binary.Varint(kv.Value)
+		return ext, true
+	}
+
+	//the extent that starts at or before start may reach into the range
+	upto := func(ofst int64) fdb.Key { return append(rng.Pack(tuple.Tuple{ofst}), 0x00) }
+	for _, kv := range tx.GetRange(fdb.KeyRange{Begin: begin, End: upto(start)}, fdb.RangeOptions{Limit: 1, Reverse: true}).GetSliceOrPanic() {
+		if ext, ok := unpack(kv); ok && ext.End >= start {
+			exts = append(exts, ext)
+		}
+	}
+
+	if end <= start {
+		return exts
+	}
+
+	for _, kv := range tx.GetRange(fdb.KeyRange{Begin: upto(start), End: upto(end)}, fdb.RangeOptions{}).GetSliceOrPanic() {
+		if ext, ok := unpack(kv); ok {
+			exts = append(exts, ext)
+		}
+	}
+
+	return exts
+}
+
+func (n *Node) putExtent(tx fdb.Transaction, ext Extent) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	tx.Set(n.ss.Pack(tuple.Tuple{"data", ext.Start}), buf[:binary.PutVarint(buf, ext.End)])
+	n.addAllocated(tx, ext.End-ext.Start)
+}
+
+func (n *Node) delExtent(tx fdb.Transaction, ext Extent) {
+	tx.Clear(n.ss.Pack(tuple.Tuple{"data", ext.Start}))
+	n.addAllocated(tx, ext.Start-ext.End)
+}
+
+//addData records that [start, end) holds data, extents that overlap or touch
+//it are merged
+func (n *Node) addData(tx fdb.Transaction, start, end int64) {
+	if end <= start {
+		return
+	}
+
+	ext := Extent{Start: start, End: end}
+	for _, other := range n.extents(tx, start, end) {
+		if other.Start < ext.Start {
+			ext.Start = other.Start
+		}
+		if other.End > ext.End {
+			ext.End = other.End
+		}
+
+		n.delExtent(tx, other)
+	}
+
+	n.putExtent(tx, ext)
+}
+
+//delData records that [start, end) is a hole
+func (n *Node) delData(tx fdb.Transaction, start, end int64) {
+	for _, ext := range n.extents(tx, start, end) {
+		if ext.End <= start || ext.Start >= end {
+			continue //only touches the range
+		}
+
+		n.delExtent(tx, ext)
+		if ext.Start < start {
+			n.putExtent(tx, Extent{Start: ext.Start, End: start})
+		}
+		if ext.End > end {
+			n.putExtent(tx, Extent{Start: end, End: ext.End})
+		}
+	}
+}
+
+//SeekData returns the first offset at or after ofst that holds data, it
+//returns false if there is none before size
+func (n *Node) SeekData(tx fdb.Transaction, ofst, size int64) (int64, bool) {
+	if ofst >= size {
+		return 0, false
+	}
+	if !n.Sparse(tx) {
+		return ofst, true
+	}
+
+	for _, ext := range n.extents(tx, ofst, size) {
+		if ext.End <= ofst {
+			continue
+		}
+		if ext.Start <= ofst {
+			return ofst, true
+		}
+		if ext.Start < size {
+			return ext.Start, true
+		}
+	}
+
+	return 0, false
+}
+
+//SeekHole returns the first offset at or after ofst that lies in a hole, the
+//end of the content counts as one
+func (n *Node) SeekHole(tx fdb.Transaction, ofst, size int64) int64 {
+	if ofst >= size || !n.Sparse(tx) {
+		return size
+	}
+
+	for _, ext := range n.extents(tx, ofst, ofst) {
+		if ext.Start <= ofst && ext.End > ofst {
+			if ext.End > size {
+				return size
+			}
+
+			return ext.End //extents are merged so a hole follows
+		}
+	}
+
+	return ofst
+}
+
+//Allocate records that [start, end) holds data, like preallocated space it
+//reads as zeros until it is written
+func (n *Node) Allocate(tx fdb.Transaction, start, end int64) {
+	if size := n.Stat(tx).Size; end > size {
+		end = size
+	}
+
+	if n.Sparse(tx) {
+		n.addData(tx, start, end)
+	}
+}
+
+//Zero replaces the content in [start, end) with a hole, the range reads as
+//zeros and no longer references chunks with data
+func (n *Node) Zero(tx fdb.Transaction, cstore chunks.Store, start, end int64) (errc int) {
+	size := n.Stat(tx).Size
+	if end > size {
+		end = size
+	}
+	if start >= end {
+		return 0
+	}
+
+	n.makeSparse(tx, size)
+	blob, err := n.blob(tx, cstore)
+	if err != nil {
+		n.reportCorrupt(tx, err)
+		return -fuse.EIO
+	}
+
+	if err = zeroContent(context.Background(), blob, size, start, end); err != nil {
+		return -fuse.EIO
+	}
+
+	n.delData(tx, start, end)
+	return 0
+}
+
+//truncData forgets the extents beyond size when content shrinks, growing
+//content leaves a hole
+func (n *Node) truncData(tx fdb.Transaction, prev, size int64) {
+	if size > prev {
+		n.makeSparse(tx, prev)
+	} else if size < prev && n.Sparse(tx) {
+		n.delData(tx, size, math.MaxInt64)
+	}
+}
@@ -21,6 +21,8 @@ func (n *Node) Stat(tx fdb.Transaction) fuse.Stat_t {
 	sta.Atim = n.getTimeSpec(tx, "atim")
 	sta.Birthtim = n.getTimeSpec(tx, "btim")
 	sta.Nlink = n.getUint32At(tx, "nlink")
+	sta.Blksize = blockSize
+	sta.Blocks = (n.allocated(tx, sta.Size) + 511) / 512
 	return sta
 }
 
@@ -36,6 +38,9 @@ func (n *Node) StatSetMTim(tx fdb.Transaction, t fuse.Timespec)     { n.putTimeS
 func (n *Node) StatSetATim(tx fdb.Transaction, t fuse.Timespec)     { n.putTimeSpec(tx, "atim", t) }
 func (n *Node) StatSetBirthTim(tx fdb.Transaction, t fuse.Timespec) { n.putTimeSpec(tx, "btim", t) }
 
+//StatSetNlink overwrites the link count, it is used to repair it
+func (n *Node) StatSetNlink(tx fdb.Transaction, v uint32) { n.putUint32At(tx, "nlink", v) }
+
 //@TODO use the tx.Add method instead
 func (n *Node) StatIncNlink(tx fdb.Transaction) {
 	v := n.getUint32At(tx, "nlink")
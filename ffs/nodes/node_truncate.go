@@ -8,9 +8,25 @@ import (
 	"github.com/billziss-gh/cgofuse/fuse"
 )
 
+//Truncate changes the size of the content, content that grows ends in a hole
 func (node *Node) Truncate(tx fdb.Transaction, cstore chunks.Store, size int64) (errc int) {
-	blob := node.blob(tx, cstore)
-	err := blob.Truncate(context.Background(), uint64(size))
+	prev := node.Stat(tx).Size
+	if errc = node.truncate(tx, cstore, size); errc != 0 {
+		return errc
+	}
+
+	node.truncData(tx, prev, size)
+	return 0
+}
+
+func (node *Node) truncate(tx fdb.Transaction, cstore chunks.Store, size int64) (errc int) {
+	blob, err := node.blob(tx, cstore)
+	if err != nil {
+		node.reportCorrupt(tx, err)
+		return -fuse.EIO
+	}
+
+	err = blob.Truncate(context.Background(), uint64(size))
 	if err != nil {
 		return -fuse.EIO //@TODO report
 	}
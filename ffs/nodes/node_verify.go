@@ -0,0 +1,134 @@
+package nodes
+
+import (
+	"time"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/scrub"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//Corrupt records a chunk that didn't match its key when it was read or
+//scrubbed, it is removed once the chunk is found intact again
+type Corrupt struct {
+	scrub.Ref
+	Ino   uint64
+	Found time.Time
+}
+
+//SetVerifyReads configures whether chunks are verified against their key
+//when file content is read, content that is already open is not affected
+func (store *Store) SetVerifyReads(tx fdb.Transaction, on bool) {
+	tx.Set(store.ss.Pack(tuple.Tuple{"verify"}), tuple.Tuple{flag(on)}.Pack())
+}
+
+//VerifyReads returns whether chunks are verified when they are read
+func (store *Store) VerifyReads(tx fdb.Transaction) bool {
+	return verifyReads(tx, store.ss.Pack(tuple.Tuple{"verify"}))
+}
+
+func verifyReads(tx fdb.Transaction, k fdb.Key) bool {
+	t, err := tuple.Unpack(tx.Get(k).MustGet())
+	if err != nil || len(t) != 1 {
+		return false
+	}
+
+	on, _ := t[0].(int64)
+	return on == 1
+}
+
+//chunks returns the chunk store the node's content is read from
+func (n *Node) chunks(tx fdb.Transaction, cstore chunks.Store) chunks.Store {
+	if verifyReads(tx, n.sss.Pack(tuple.Tuple{"verify"})) {
+		return scrub.Verifying(cstore)
+	}
+
+	return cstore
+}
+
+//reportCorrupt records err if it is caused by a corrupt chunk
+func (n *Node) reportCorrupt(tx fdb.Transaction, err error) {
+	cerr, ok := err.(*scrub.CorruptError)
+	if !ok {
+		return
+	}
+
+	putCorrupt(tx, n.sss.Pack(tuple.Tuple{"corrupt", cerr.Key.Bytes()}), &Corrupt{
+		Ref: cerr.Ref, Ino: n.StatGetIno(tx), Found: time.Now(),
+	})
+}
+
+func putCorrupt(tx fdb.Transaction, k fdb.Key, c *Corrupt) {
+	tx.Set(k, tuple.Tuple{c.Type, int64(c.Level), int64(c.Ino), c.Found.UnixNano()}.Pack())
+}
+
+//PutCorrupt records a corrupt chunk
+func (store *Store) PutCorrupt(tx fdb.Transaction, c *Corrupt) {
+	putCorrupt(tx, store.ss.Pack(tuple.Tuple{"corrupt", c.Key.Bytes()}), c)
+}
+
+//DelCorrupt removes the record of the chunk with key
+func (store *Store) DelCorrupt(tx fdb.Transaction, key cas.Key) {
+	tx.Clear(store.ss.Pack(tuple.Tuple{"corrupt", key.Bytes()}))
+}
+
+//EachCorrupt calls f for every chunk that was recorded as corrupt
+func (store *Store) EachCorrupt(tx fdb.Transaction, f func(c *Corrupt) (stop bool)) {
+	sub := store.ss.Sub("corrupt")
+	iter := tx.GetRange(sub, fdb.RangeOptions{}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		k, err := sub.Unpack(kv.Key)
+		if err != nil || len(k) != 1 {
+			continue
+		}
+
+		t, err := tuple.Unpack(kv.Value)
+		if err != nil || len(t) != 4 {
+			continue
+		}
+
+		key, _ := k[0].([]byte)
+		typ, _ := t[0].(string)
+		lvl, _ := t[1].(int64)
+		ino, _ := t[2].(int64)
+		nsec, _ := t[3].(int64)
+		if f(&Corrupt{
+			Ref:   scrub.Ref{Key: cas.NewKey(key), Type: typ, Level: uint8(lvl)},
+			Ino:   uint64(ino),
+			Found: time.Unix(0, nsec),
+		}) {
+			return
+		}
+	}
+}
+
+//Manifest returns the manifest of the node's flushed content or nil if it
+//has none
+func (n *Node) Manifest(tx fdb.Transaction) *blobs.Manifest {
+	if !n.hasManifest(tx) {
+		return nil
+	}
+
+	return n.manifest(tx)
+}
+
+//Extents returns the extents of the node's flushed content that hold data,
+//it returns nil if all of it does
+func (n *Node) Extents(tx fdb.Transaction) []Extent {
+	return n.manifestExtents(tx)
+}
+
+//Manifest returns the manifest of the version's content
+func (v *Version) Manifest() *blobs.Manifest {
+	return v.m
+}
+
+//Extents returns the extents of the version's content that hold data, it
+//returns nil if all of it does
+func (v *Version) Extents() []Extent {
+	return v.exts
+}
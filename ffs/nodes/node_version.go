@@ -0,0 +1,190 @@
+package nodes
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//Version is a previous content of a file, it refers to the manifest that was
+//replaced when the file was flushed with new content
+type Version struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+	m    *blobs.Manifest
+	exts []Extent
+}
+
+//Retention configures how many previous versions of a file are kept (Count)
+//and for how long (Age), zero means no limit. With both set to zero no
+//versions are kept at all.
+type Retention struct {
+	Count int
+	Age   time.Duration
+}
+
+//SetRetention configures the version retention for all files of the store
+func (store *Store) SetRetention(tx fdb.Transaction, r Retention) {
+	tx.Set(store.ss.Pack(tuple.Tuple{"retention"}), tuple.Tuple{int64(r.Count), int64(r.Age)}.Pack())
+}
+
+//Retention returns the configured version retention
+func (store *Store) Retention(tx fdb.Transaction) Retention {
+	return retention(tx, store.ss.Pack(tuple.Tuple{"retention"}))
+}
+
+func retention(tx fdb.Transaction, k fdb.Key) (r Retention) {
+	d := tx.Get(k).MustGet()
+	if len(d) < 1 {
+		return
+	}
+
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) != 2 {
+		return
+	}
+
+	cnt, _ := t[0].(int64)
+	age, _ := t[1].(int64)
+	return Retention{Count: int(cnt), Age: time.Duration(age)}
+}
+
+//keepVersion records manifest m with its extents as a previous version of
+//the node and prunes versions that fall outside the retention of the store
+func (n *Node) keepVersion(tx fdb.Transaction, m *blobs.Manifest, exts []Extent) {
+	r := retention(tx, n.sss.Pack(tuple.Tuple{"retention"}))
+	if r.Count == 0 && r.Age == 0 {
+		return
+	}
+
+	id := n.getUint64At(tx, "vseq") + 1
+	n.putUint64At(tx, "vseq", id)
+	var extd []byte //nil for dense content
+	if nil != exts {
+		extd = encodeExtents(exts)
+	}
+
+	tx.Set(n.ss.Pack(tuple.Tuple{"vers", int64(id)}), tuple.Tuple{
+		time.Now().UnixNano(), m.Root.Bytes(), int64(m.Size), int64(m.ChunkSize), int64(m.Fanout), m.Type, extd,
+	}.Pack())
+	n.refInline(tx, m)
+
+	vers := n.Versions(tx)
+	now := time.Now()
+	for i, v := range vers {
+		if (r.Count > 0 && i >= r.Count) || (r.Age > 0 && now.Sub(v.Time) > r.Age) {
+			tx.Clear(n.ss.Pack(tuple.Tuple{"vers", int64(v.ID)}))
+			n.unrefInline(tx, v.m)
+		}
+	}
+}
+
+func (n *Node) unpackVersion(id int64, d []byte) *Version {
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) < 5 {
+		return nil
+	}
+
+	typ := "blob" //versions kept before the chunking scheme was recorded
+	if len(t) > 5 {
+		if s, ok := t[5].(string); ok && s != "" {
+			typ = s
+		}
+	}
+
+	var exts []Extent //versions kept before extents were recorded are dense
+	if len(t) > 6 {
+		if d, ok := t[6].([]byte); ok {
+			exts = decodeExtents(d)
+		}
+	}
+
+	nsec, _ := t[0].(int64)
+	root, _ := t[1].([]byte)
+	size, _ := t[2].(int64)
+	csize, _ := t[3].(int64)
+	fanout, _ := t[4].(int64)
+	return &Version{
+		ID:   uint64(id),
+		Time: time.Unix(0, nsec),
+		Size: size,
+		m: &blobs.Manifest{
+			Type:      typ,
+			Root:      cas.NewKey(root),
+			Size:      uint64(size),
+			ChunkSize: uint32(csize),
+			Fanout:    uint32(fanout),
+		},
+		exts: exts,
+	}
+}
+
+//Versions returns the previous versions of the node, newest first
+func (n *Node) Versions(tx fdb.Transaction) (vers []*Version) {
+	sub := n.ss.Sub("vers")
+	iter := tx.GetRange(sub, fdb.RangeOptions{Reverse: true}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		t, err := sub.Unpack(kv.Key)
+		if err != nil || len(t) != 1 {
+			continue
+		}
+
+		id, _ := t[0].(int64)
+		if v := n.unpackVersion(id, kv.Value); v != nil {
+			vers = append(vers, v)
+		}
+	}
+
+	return vers
+}
+
+//Version returns the previous version with the provided id or nil if it
+//doesn't exist (anymore)
+func (n *Node) Version(tx fdb.Transaction, id uint64) *Version {
+	d := tx.Get(n.ss.Pack(tuple.Tuple{"vers", int64(id)})).MustGet()
+	if len(d) < 1 {
+		return nil
+	}
+
+	return n.unpackVersion(int64(id), d)
+}
+
+//ReadVersionAt reads from the content of version v like ReadAt
+func (n *Node) ReadVersionAt(tx fdb.Transaction, cstore chunks.Store, v *Version, buff []byte, ofst int64) int {
+	blob, err := n.open(tx, n.chunks(tx, cstore), v.m)
+	if err != nil {
+		n.reportCorrupt(tx, err)
+		return -fuse.EIO
+	}
+
+	nr, err := blob.ReadAt(context.Background(), buff, ofst)
+	if err != nil && err != io.EOF {
+		n.reportCorrupt(tx, err)
+		return -fuse.EIO
+	}
+
+	return nr
+}
+
+//RestoreVersion makes version v the current content of the node, the
+//content it replaces is kept as a version of its own.
+func (n *Node) RestoreVersion(tx fdb.Transaction, cstore chunks.Store, v *Version) (errc int) {
+	if errc = n.Flush(tx, cstore); errc != 0 {
+		return errc
+	}
+
+	n.keepVersion(tx, n.manifest(tx), n.manifestExtents(tx))
+	n.setManifest(tx, v.m, v.exts)
+	n.StatSetSize(tx, v.Size)
+	n.setExtents(tx, v.exts)
+	return 0
+}
@@ -9,9 +9,14 @@ import (
 )
 
 func (node *Node) WriteAt(tx fdb.Transaction, cstore chunks.Store, buff []byte, ofst int64) (n int) {
+	size := node.Stat(tx).Size
+	if ofst > size {
+		node.makeSparse(tx, size) //writing past the end leaves a hole
+	}
+
 	endofst := ofst + int64(len(buff))
-	if endofst > node.Stat(tx).Size {
-		n = node.Truncate(tx, cstore, endofst) //@TODO truncate without trying to open another manifest
+	if endofst > size {
+		n = node.truncate(tx, cstore, endofst) //@TODO truncate without trying to open another manifest
 		if n != 0 {
 			return n
 		}
@@ -19,11 +24,20 @@ func (node *Node) WriteAt(tx fdb.Transaction, cstore chunks.Store, buff []byte,
 		node.StatSetSize(tx, endofst)
 	}
 
-	blob := node.blob(tx, cstore)
-	n, err := blob.IO(context.Background()).WriteAt(buff, ofst)
+	blob, err := node.blob(tx, cstore)
+	if err != nil {
+		node.reportCorrupt(tx, err)
+		return -fuse.EIO
+	}
+
+	n, err = blob.WriteAt(context.Background(), buff, ofst)
 	if err != nil {
 		return -fuse.EIO
 	}
 
+	if node.Sparse(tx) {
+		node.addData(tx, ofst, ofst+int64(n))
+	}
+
 	return n
 }
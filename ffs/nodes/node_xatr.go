@@ -1,35 +1,109 @@
 package nodes
 
 import (
+	"context"
+	"io"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
 	"github.com/billziss-gh/cgofuse/fuse"
 )
 
+//Values of xattrs that are larger than the inline limit are spilled into a
+//blob in the chunk store, their "xatr" key is kept empty such that listing
+//and checking for an xattr never reads the blob. The manifest of the blob is
+//stored under the "xatrb" key of the same name.
+
 func (n *Node) XAtrDel(tx fdb.Transaction, name string) {
 	tx.Clear(n.ss.Pack(tuple.Tuple{"xatr", name}))
+	tx.Clear(n.ss.Pack(tuple.Tuple{"xatrb", name}))
 }
 
-func (n *Node) XAtrGet(tx fdb.Transaction, name string) (a []byte, ok bool) {
-	d, err := tx.Get(n.ss.Pack(tuple.Tuple{"xatr", name})).Get()
-	if err != nil { //@TODO handle other errors
+//XAtrHas returns whether the node has an xattr with the provided name
+func (n *Node) XAtrHas(tx fdb.Transaction, name string) bool {
+	return nil != tx.Get(n.ss.Pack(tuple.Tuple{"xatr", name})).MustGet()
+}
+
+func (n *Node) XAtrGet(tx fdb.Transaction, cstore chunks.Store, name string) (a []byte, ok bool) {
+	errc, a := n.XAtrOpen(tx, cstore, name)
+	if errc == -fuse.ENOATTR {
 		return nil, false
 	}
 
-	return d, true
+	return a, true
+}
+
+//XAtrOpen returns the decrypted value of an xattr, it fails with ENOATTR if
+//there is no such xattr and with EIO if the value cannot be opened with the
+//store's key or its blob can't be read
+func (n *Node) XAtrOpen(tx fdb.Transaction, cstore chunks.Store, name string) (errc int, a []byte) {
+	k := n.ss.Pack(tuple.Tuple{"xatr", name})
+	d, err := tx.Get(k).Get()
+	if err != nil { //@TODO handle other errors
+		return -fuse.ENOATTR, nil
+	}
+	if d == nil {
+		return -fuse.ENOATTR, nil
+	}
+
+	if len(d) == 0 {
+		if m := n.XAtrManifest(tx, name); nil != m {
+			return n.xatrRead(tx, cstore, m)
+		}
+	}
+
+	if s := sealerOf(n.sss); s != nil && len(d) > 0 {
+		if d, err = s.Open(d, k); err != nil {
+			return -fuse.EIO, nil
+		}
+	}
+
+	return 0, d
 }
 
-func (n *Node) XAtrSet(tx fdb.Transaction, name string, xatr []byte) {
-	if len(xatr) > 100*1000 {
-		panic("ffs: tried to set xattr that is larger then 100KB reached") //@TODO handle more graceflly
+//XAtrSet stores xatr as the value of the xattr name with the metadata, larger
+//values than the inline limit are written with WriteXAtr before the
+//transaction and set with XAtrSetBlob such that no chunks are written in it
+func (n *Node) XAtrSet(tx fdb.Transaction, name string, xatr []byte) (errc int) {
+	if len(xatr) > InlineLimit {
+		return -fuse.EIO
 	}
 
-	tx.Set(n.ss.Pack(tuple.Tuple{"xatr", name}), xatr)
+	k := n.ss.Pack(tuple.Tuple{"xatr", name})
+	if s := sealerOf(n.sss); s != nil {
+		xatr = s.Seal(xatr, k) //the key binds the value to its node and name
+	}
+
+	tx.Set(k, xatr)
+	tx.Clear(n.ss.Pack(tuple.Tuple{"xatrb", name}))
+	return 0
+}
+
+//XAtrSetBlob sets the xattr name to the value that was written to the blob of
+//manifest m with WriteXAtr
+func (n *Node) XAtrSetBlob(tx fdb.Transaction, name string, m *blobs.Manifest) {
+	tx.Set(n.ss.Pack(tuple.Tuple{"xatr", name}), []byte{})
+	tx.Set(n.ss.Pack(tuple.Tuple{"xatrb", name}), tuple.Tuple{
+		m.Root.Bytes(), int64(m.Size), int64(m.ChunkSize), int64(m.Fanout), m.Type,
+	}.Pack())
 }
 
+//XAtrStamp returns what is stored for the xattr name, it changes whenever
+//its value does such that a value that was read before a transaction can be
+//checked to be current in it
+func (n *Node) XAtrStamp(tx fdb.Transaction, name string) []byte {
+	stamp := tx.Get(n.ss.Pack(tuple.Tuple{"xatr", name})).MustGet()
+	return append(append([]byte{}, stamp...), tx.Get(n.ss.Pack(tuple.Tuple{"xatrb", name})).MustGet()...)
+}
+
+//XAtrEach calls f with the name of every xattr of the node until f returns
+//an error code
 func (n *Node) XAtrEach(tx fdb.Transaction, f func(name string) int) (errc int) {
 	rng := n.ss.Sub("xatr")
-	iter := tx.GetRange(rng, fdb.RangeOptions{Limit: 20}).Iterator()
+	iter := tx.GetRange(rng, fdb.RangeOptions{}).Iterator()
 	for iter.Advance() {
 		kv := iter.MustGet()
 		t, _ := rng.Unpack(kv.Key)
@@ -50,3 +124,51 @@ func (n *Node) XAtrEach(tx fdb.Transaction, f func(name string) int) (errc int)
 
 	return 0
 }
+
+//XAtrManifest returns the manifest of the blob that holds the value of a large
+//xattr, it returns nil for values that are stored with the metadata
+func (n *Node) XAtrManifest(tx fdb.Transaction, name string) *blobs.Manifest {
+	t, err := tuple.Unpack(tx.Get(n.ss.Pack(tuple.Tuple{"xatrb", name})).MustGet())
+	if err != nil || len(t) != 5 {
+		return nil
+	}
+
+	root, _ := t[0].([]byte)
+	size, _ := t[1].(int64)
+	csize, _ := t[2].(int64)
+	fanout, _ := t[3].(int64)
+	typ, _ := t[4].(string)
+	return &blobs.Manifest{Type: typ, Root: cas.NewKey(root), Size: uint64(size), ChunkSize: uint32(csize), Fanout: uint32(fanout)}
+}
+
+//WriteXAtr writes a value that is larger than the inline limit to a blob of
+//its own and returns its manifest. Only chunks are stored so it is called
+//outside of a transaction, the manifest is set with XAtrSetBlob.
+func WriteXAtr(ctx context.Context, cstore chunks.Store, xatr []byte) (*blobs.Manifest, error) {
+	blob, err := openContent(cstore, &blobs.Manifest{Type: "blob", ChunkSize: defaultChunkSize, Fanout: 64})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = blob.WriteAt(ctx, xatr, 0); err != nil {
+		return nil, err
+	}
+
+	return blob.Save(ctx)
+}
+
+func (n *Node) xatrRead(tx fdb.Transaction, cstore chunks.Store, m *blobs.Manifest) (errc int, xatr []byte) {
+	blob, err := openContent(n.chunks(tx, cstore), m)
+	if err != nil {
+		n.reportCorrupt(tx, err)
+		return -fuse.EIO, nil
+	}
+
+	xatr = make([]byte, m.Size)
+	if nr, err := blob.ReadAt(context.Background(), xatr, 0); err != nil && (err != io.EOF || uint64(nr) != m.Size) {
+		n.reportCorrupt(tx, err)
+		return -fuse.EIO, nil
+	}
+
+	return 0, xatr
+}
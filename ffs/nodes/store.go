@@ -55,6 +55,10 @@ func (store *Store) setIno(tx fdb.Transaction, ino uint64) {
 func (store *Store) NewNode(tx fdb.Transaction, dev uint64, ino uint64, mode uint32, uid uint32, gid uint32) *Node {
 	node := NewNode(store.ss, ino)
 	node.Init(tx, dev, ino, mode, uid, gid)
+	if c := store.Chunking(tx); c.Scheme != "blob" || c.Size != defaultChunkSize {
+		node.setChunking(tx, c)
+	}
+
 	return node
 }
 
@@ -0,0 +1,104 @@
+package nodes
+
+import (
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//BulkJob records a recursive operation that the server executes in batches,
+//its progress is updated with every batch such that it can be resumed
+type BulkJob struct {
+	ID       uint64    `json:"id"`
+	Op       string    `json:"op"`
+	Src      string    `json:"src"`
+	Dst      string    `json:"dst,omitempty"`
+	Actor    string    `json:"actor"`
+	Total    int64     `json:"total"`
+	Done     int64     `json:"done"`
+	Finished bool      `json:"finished"`
+	Errc     int       `json:"errc"`
+	Started  time.Time `json:"started"`
+	Updated  time.Time `json:"updated"`
+}
+
+//PutBulk records job, a job without an id is assigned the next one
+func (store *Store) PutBulk(tx fdb.Transaction, job *BulkJob) {
+	if job.ID == 0 {
+		k := store.ss.Pack(tuple.Tuple{"bulkseq"})
+		var id uint64
+		if d := tx.Get(k).MustGet(); len(d) == 8 {
+			id = endianess.Uint64(d)
+		}
+
+		id++
+		b := make([]byte, 8)
+		endianess.PutUint64(b, id)
+		tx.Set(k, b)
+		job.ID = id
+	}
+
+	tx.Set(store.ss.Pack(tuple.Tuple{"bulk", int64(job.ID)}), tuple.Tuple{
+		job.Op, job.Src, job.Dst, job.Actor, job.Total, job.Done, flag(job.Finished),
+		int64(job.Errc), job.Started.UnixNano(), job.Updated.UnixNano(),
+	}.Pack())
+}
+
+func unpackBulk(id int64, d []byte) *BulkJob {
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) != 10 {
+		return nil
+	}
+
+	job := &BulkJob{ID: uint64(id)}
+	job.Op, _ = t[0].(string)
+	job.Src, _ = t[1].(string)
+	job.Dst, _ = t[2].(string)
+	job.Actor, _ = t[3].(string)
+	job.Total, _ = t[4].(int64)
+	job.Done, _ = t[5].(int64)
+	finished, _ := t[6].(int64)
+	job.Finished = finished == 1
+	errc, _ := t[7].(int64)
+	job.Errc = int(errc)
+	started, _ := t[8].(int64)
+	job.Started = time.Unix(0, started)
+	updated, _ := t[9].(int64)
+	job.Updated = time.Unix(0, updated)
+	return job
+}
+
+//GetBulk returns the job with the provided id or nil
+func (store *Store) GetBulk(tx fdb.Transaction, id uint64) *BulkJob {
+	d := tx.Get(store.ss.Pack(tuple.Tuple{"bulk", int64(id)})).MustGet()
+	if len(d) < 1 {
+		return nil
+	}
+
+	return unpackBulk(int64(id), d)
+}
+
+//EachBulk calls f for every job in the order they were started until f
+//returns true
+func (store *Store) EachBulk(tx fdb.Transaction, f func(job *BulkJob) (stop bool)) {
+	rng := store.ss.Sub("bulk")
+	iter := tx.GetRange(rng, fdb.RangeOptions{}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		t, err := rng.Unpack(kv.Key)
+		if err != nil || len(t) != 1 {
+			continue
+		}
+
+		id, _ := t[0].(int64)
+		job := unpackBulk(id, kv.Value)
+		if job == nil {
+			continue
+		}
+
+		if f(job) {
+			return
+		}
+	}
+}
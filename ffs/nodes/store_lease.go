@@ -0,0 +1,89 @@
+package nodes
+
+import (
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//Lease is a claim of a holder on a directory, other actors can't write
+//into the directory until the lease is released or expires
+type Lease struct {
+	Holder  string    `json:"holder"`
+	Reason  string    `json:"reason"`
+	Expires time.Time `json:"expires"`
+}
+
+//Active returns whether the lease is still in effect at time t
+func (l *Lease) Active(t time.Time) bool {
+	return l.Expires.After(t)
+}
+
+//Lease returns the lease on node n or nil if there is none, expired leases
+//are returned as well.
+func (store *Store) Lease(tx fdb.Transaction, n *Node) (l *Lease) {
+	d := tx.Get(n.ss.Pack(tuple.Tuple{"lease"})).MustGet()
+	if len(d) < 1 {
+		return nil
+	}
+
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) != 3 {
+		return nil
+	}
+
+	l = &Lease{}
+	l.Holder, _ = t[0].(string)
+	l.Reason, _ = t[1].(string)
+	exp, _ := t[2].(int64)
+	l.Expires = time.Unix(0, exp)
+	return l
+}
+
+//SetLease stores lease l on node n, the path is recorded for listing only
+func (store *Store) SetLease(tx fdb.Transaction, n *Node, path string, l *Lease) {
+	tx.Set(n.ss.Pack(tuple.Tuple{"lease"}), tuple.Tuple{l.Holder, l.Reason, l.Expires.UnixNano()}.Pack())
+	tx.Set(store.ss.Pack(tuple.Tuple{"leases", int64(n.StatGetIno(tx))}), []byte(path))
+}
+
+//DelLease removes any lease from node n
+func (store *Store) DelLease(tx fdb.Transaction, n *Node) {
+	tx.Clear(n.ss.Pack(tuple.Tuple{"lease"}))
+	tx.Clear(store.ss.Pack(tuple.Tuple{"leases", int64(n.StatGetIno(tx))}))
+}
+
+//HasLeases returns whether any node holds a lease, this allows skipping
+//lease checks altogether in the common case
+func (store *Store) HasLeases(tx fdb.Transaction) bool {
+	kvs := tx.GetRange(store.ss.Sub("leases"), fdb.RangeOptions{Limit: 1}).GetSliceOrPanic()
+	return len(kvs) > 0
+}
+
+//EachLease calls f for every node that holds a lease until f returns true
+func (store *Store) EachLease(tx fdb.Transaction, f func(n *Node, path string, l *Lease) (stop bool)) {
+	rng := store.ss.Sub("leases")
+	iter := tx.GetRange(rng, fdb.RangeOptions{}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		t, err := rng.Unpack(kv.Key)
+		if err != nil || len(t) != 1 {
+			continue
+		}
+
+		ino, ok := t[0].(int64)
+		if !ok {
+			continue
+		}
+
+		n := NewNode(store.ss, uint64(ino))
+		l := store.Lease(tx, n)
+		if l == nil {
+			continue
+		}
+
+		if f(n, string(kv.Value), l) {
+			return
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package nodes
+
+import (
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+//TrashMode configures whether removed nodes are moved to the trash and for
+//how long they stay there, an Expiry of zero keeps them until purged.
+type TrashMode struct {
+	Enabled bool
+	Expiry  time.Duration
+}
+
+//TrashEntry records a node that was removed while the trash was enabled,
+//the node keeps its link count until the entry is purged
+type TrashEntry struct {
+	ID      uint64    `json:"id"`
+	Path    string    `json:"path"`
+	Ino     uint64    `json:"ino"`
+	Dir     bool      `json:"dir"`
+	Actor   string    `json:"actor"`
+	Deleted time.Time `json:"deleted"`
+}
+
+//flag encodes a boolean as a tuple element
+func flag(b bool) int64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+//Node returns the node with inode number ino, it doesn't check whether it
+//exists
+func (store *Store) Node(ino uint64) *Node {
+	return NewNode(store.ss, ino)
+}
+
+//SetTrashMode configures the trash for all nodes of the store
+func (store *Store) SetTrashMode(tx fdb.Transaction, m TrashMode) {
+	tx.Set(store.ss.Pack(tuple.Tuple{"trashmode"}), tuple.Tuple{flag(m.Enabled), int64(m.Expiry)}.Pack())
+}
+
+//TrashMode returns the configured trash mode, the trash is disabled by default
+func (store *Store) TrashMode(tx fdb.Transaction) (m TrashMode) {
+	d := tx.Get(store.ss.Pack(tuple.Tuple{"trashmode"})).MustGet()
+	if len(d) < 1 {
+		return
+	}
+
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) != 2 {
+		return
+	}
+
+	enabled, _ := t[0].(int64)
+	m.Enabled = enabled == 1
+	exp, _ := t[1].(int64)
+	m.Expiry = time.Duration(exp)
+	return m
+}
+
+//PutTrash records a new trash entry, it assigns the entry its id
+func (store *Store) PutTrash(tx fdb.Transaction, e *TrashEntry) {
+	k := store.ss.Pack(tuple.Tuple{"trashseq"})
+	var id uint64
+	if d := tx.Get(k).MustGet(); len(d) == 8 {
+		id = endianess.Uint64(d)
+	}
+
+	id++
+	b := make([]byte, 8)
+	endianess.PutUint64(b, id)
+	tx.Set(k, b)
+
+	e.ID = id
+	tx.Set(store.ss.Pack(tuple.Tuple{"trash", int64(id)}), tuple.Tuple{
+		e.Path, int64(e.Ino), flag(e.Dir), e.Actor, e.Deleted.UnixNano(),
+	}.Pack())
+}
+
+func unpackTrash(id int64, d []byte) *TrashEntry {
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) != 5 {
+		return nil
+	}
+
+	e := &TrashEntry{ID: uint64(id)}
+	e.Path, _ = t[0].(string)
+	ino, _ := t[1].(int64)
+	e.Ino = uint64(ino)
+	dir, _ := t[2].(int64)
+	e.Dir = dir == 1
+	e.Actor, _ = t[3].(string)
+	nsec, _ := t[4].(int64)
+	e.Deleted = time.Unix(0, nsec)
+	return e
+}
+
+//GetTrash returns the trash entry with the provided id or nil
+func (store *Store) GetTrash(tx fdb.Transaction, id uint64) *TrashEntry {
+	d := tx.Get(store.ss.Pack(tuple.Tuple{"trash", int64(id)})).MustGet()
+	if len(d) < 1 {
+		return nil
+	}
+
+	return unpackTrash(int64(id), d)
+}
+
+//DelTrash removes the trash entry with the provided id
+func (store *Store) DelTrash(tx fdb.Transaction, id uint64) {
+	tx.Clear(store.ss.Pack(tuple.Tuple{"trash", int64(id)}))
+}
+
+//EachTrash calls f for every trash entry in the order they were removed
+//until f returns true
+func (store *Store) EachTrash(tx fdb.Transaction, f func(e *TrashEntry) (stop bool)) {
+	rng := store.ss.Sub("trash")
+	iter := tx.GetRange(rng, fdb.RangeOptions{}).Iterator()
+	for iter.Advance() {
+		kv := iter.MustGet()
+		t, err := rng.Unpack(kv.Key)
+		if err != nil || len(t) != 1 {
+			continue
+		}
+
+		id, _ := t[0].(int64)
+		e := unpackTrash(id, kv.Value)
+		if e == nil {
+			continue
+		}
+
+		if f(e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package ffs
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/advanderveer/dfs/ffs/quota"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//EDQUOT is the platform's error code for an exceeded quota, cgofuse doesn't
+//provide it
+var EDQUOT = func() int {
+	switch runtime.GOOS {
+	case "darwin", "freebsd":
+		return 69
+	default:
+		return 122
+	}
+}()
+
+//reserve returns an error code if owner can't grow with the provided nr of
+//bytes and inodes
+func (self *Memfs) reserve(tx fdb.Transaction, owner string, bytes, inodes int64) int {
+	switch self.qstore.Check(tx, owner, bytes, inodes, time.Now()) {
+	case nil:
+		return 0
+	case quota.ErrNoSpace:
+		return -fuse.ENOSPC
+	default:
+		return -EDQUOT
+	}
+}
+
+//resize checks and accounts for the size of node at path changing to size
+func (self *Memfs) resize(tx fdb.Transaction, path string, node *nodes.Node, size int64) int {
+	owner, delta := node.Owner(tx), size-node.Stat(tx).Size
+	if errc := self.reserve(tx, owner, delta, 0); 0 != errc {
+		return errc
+	}
+
+	self.qstore.Add(tx, owner, delta, 0, time.Now())
+	if prnt, name := self.account(tx, path, nodes.DirSize{Size: delta}); nil != prnt {
+		node.AddLinkSize(tx, prnt.Ino(), name, nodes.DirSize{Size: delta})
+	}
+
+	return 0
+}
+
+//release accounts for node losing a link, the usage of the node is released
+//once the last link is gone. Its content is dropped when no handles are
+//left either.
+func (self *Memfs) release(tx fdb.Transaction, node *nodes.Node) {
+	node.StatDecNlink(tx)
+	if sta := node.Stat(tx); 0 == sta.Nlink {
+		self.qstore.Add(tx, node.Owner(tx), -sta.Size, -1, time.Now())
+		if 0 == node.Opencnt(tx) {
+			node.DropContent(tx)
+		}
+	}
+}
+
+//SetQuota sets the limit for owner, use quota.Volume to limit the volume
+func (self *Memfs) SetQuota(owner string, l quota.Limit) (errc int) {
+	defer trace(owner, l)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		self.qstore.SetLimit(tx, owner, l)
+		return 0
+	})
+}
+
+//Usage returns the limit and usage of owner
+func (self *Memfs) Usage(owner string) (errc int, l quota.Limit, u quota.Usage) {
+	defer trace(owner)(&errc, &l, &u)
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		l, u = self.qstore.Limit(tx, owner), self.qstore.Usage(tx, owner)
+		return 0
+	})
+
+	return errc, l, u
+}
+
+//capacity returns the total and free amount of a resource given the limits
+//and usage of both the volume and the owner, the tightest limit applies.
+func capacity(unlimited int64, limits [2]int64, used [2]int64) (total, free int64) {
+	total, free = unlimited, unlimited-used[0]
+	for i, l := range limits {
+		if l > 0 && l-used[i] < free {
+			total, free = l, l-used[i]
+		}
+	}
+
+	if free < 0 {
+		free = 0
+	}
+
+	return total, free
+}
@@ -0,0 +1,186 @@
+package quota
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+var (
+	//ErrNoSpace is returned when growing would exceed the limit of the volume
+	ErrNoSpace = errors.New("no space left on volume")
+
+	//ErrQuota is returned when growing would exceed the quota of an owner
+	ErrQuota = errors.New("quota exceeded")
+)
+
+//Volume is the owner name under which the usage and limit of the volume as
+//a whole are stored
+const Volume = ""
+
+//Limit restricts the usage of a volume or an owner, zero means unlimited.
+//Soft limits may be exceeded for the Grace period (forever if zero), hard
+//limits can never be exceeded.
+type Limit struct {
+	SoftBytes  int64         `json:"soft_bytes"`
+	HardBytes  int64         `json:"hard_bytes"`
+	SoftInodes int64         `json:"soft_inodes"`
+	HardInodes int64         `json:"hard_inodes"`
+	Grace      time.Duration `json:"grace"`
+}
+
+//Usage is the nr of bytes and inodes in use
+type Usage struct {
+	Bytes  int64 `json:"bytes"`
+	Inodes int64 `json:"inodes"`
+}
+
+//Store keeps usage counters and limits, counters are updated with atomic
+//operations and read as snapshots such that accounting doesn't cause
+//transactions to conflict. Only checks against a hard limit read the usage
+//with conflicts, concurrent growth that would exceed it together retries.
+type Store struct {
+	tr fdb.Transactor
+	ss subspace.Subspace
+}
+
+func NewStore(tr fdb.Transactor, ss subspace.Subspace) *Store {
+	return &Store{tr: tr, ss: ss}
+}
+
+func le(v int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func unle(b []byte) (v int64) {
+	if len(b) != 8 {
+		return 0
+	}
+
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+//SetLimit sets the limit of owner, use Volume for the volume as a whole
+func (s *Store) SetLimit(tx fdb.Transaction, owner string, l Limit) {
+	tx.Set(s.ss.Pack(tuple.Tuple{"limit", owner}), tuple.Tuple{
+		l.SoftBytes, l.HardBytes, l.SoftInodes, l.HardInodes, int64(l.Grace),
+	}.Pack())
+}
+
+//Limit returns the limit of owner
+func (s *Store) Limit(tx fdb.Transaction, owner string) (l Limit) {
+	d := tx.Get(s.ss.Pack(tuple.Tuple{"limit", owner})).MustGet()
+	if len(d) < 1 {
+		return
+	}
+
+	t, err := tuple.Unpack(d)
+	if err != nil || len(t) != 5 {
+		return
+	}
+
+	l.SoftBytes, _ = t[0].(int64)
+	l.HardBytes, _ = t[1].(int64)
+	l.SoftInodes, _ = t[2].(int64)
+	l.HardInodes, _ = t[3].(int64)
+	grace, _ := t[4].(int64)
+	l.Grace = time.Duration(grace)
+	return l
+}
+
+//Usage returns what owner currently uses, it is read as a snapshot
+func (s *Store) Usage(tx fdb.Transaction, owner string) (u Usage) {
+	return s.usage(tx.Snapshot(), owner)
+}
+
+func (s *Store) usage(rtx fdb.ReadTransaction, owner string) (u Usage) {
+	u.Bytes = unle(rtx.Get(s.ss.Pack(tuple.Tuple{"use", owner, "bytes"})).MustGet())
+	u.Inodes = unle(rtx.Get(s.ss.Pack(tuple.Tuple{"use", owner, "inodes"})).MustGet())
+	return u
+}
+
+//Add changes the usage of owner and of the volume by the provided amounts,
+//negative amounts release usage
+func (s *Store) Add(tx fdb.Transaction, owner string, bytes, inodes int64, now time.Time) {
+	owners := []string{Volume}
+	if owner != Volume {
+		owners = append(owners, owner)
+	}
+
+	for _, o := range owners {
+		if bytes != 0 {
+			tx.Add(s.ss.Pack(tuple.Tuple{"use", o, "bytes"}), le(bytes))
+		}
+
+		if inodes != 0 {
+			tx.Add(s.ss.Pack(tuple.Tuple{"use", o, "inodes"}), le(inodes))
+		}
+
+		//once back under the soft limit the grace period starts over
+		l, u := s.Limit(tx, o), s.Usage(tx, o)
+		if !over(l, u) {
+			tx.Clear(s.ss.Pack(tuple.Tuple{"over", o}))
+		}
+	}
+}
+
+//over returns whether usage u exceeds a soft limit of l
+func over(l Limit, u Usage) bool {
+	return (l.SoftBytes > 0 && u.Bytes > l.SoftBytes) || (l.SoftInodes > 0 && u.Inodes > l.SoftInodes)
+}
+
+//Check returns an error if owner or the volume can't grow with the provided
+//nr of bytes and inodes
+func (s *Store) Check(tx fdb.Transaction, owner string, bytes, inodes int64, now time.Time) (err error) {
+	if bytes <= 0 && inodes <= 0 {
+		return nil //releasing is always allowed
+	}
+
+	owners := []string{Volume}
+	if owner != Volume {
+		owners = append(owners, owner)
+	}
+
+	for _, o := range owners {
+		exceeded := ErrQuota
+		if o == Volume {
+			exceeded = ErrNoSpace
+		}
+
+		//a hard limit reads the usage such that concurrent growth conflicts
+		l, rtx := s.Limit(tx, o), fdb.ReadTransaction(tx.Snapshot())
+		if l.HardBytes > 0 || l.HardInodes > 0 {
+			rtx = tx
+		}
+
+		u := s.usage(rtx, o)
+		u.Bytes += bytes
+		u.Inodes += inodes
+		if (l.HardBytes > 0 && u.Bytes > l.HardBytes) || (l.HardInodes > 0 && u.Inodes > l.HardInodes) {
+			return exceeded
+		}
+
+		if !over(l, u) || l.Grace == 0 {
+			continue
+		}
+
+		k := s.ss.Pack(tuple.Tuple{"over", o})
+		since := unle(tx.Snapshot().Get(k).MustGet())
+		if since == 0 {
+			tx.Set(k, le(now.UnixNano()))
+			continue
+		}
+
+		if now.Sub(time.Unix(0, since)) > l.Grace {
+			return exceeded
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package ffs
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/advanderveer/dfs/ffs/scrub"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//CorruptChunk is a chunk that can't be read or doesn't match its key, Paths
+//lists the files, versions and trash entries that refer to it
+type CorruptChunk struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"`
+	Level       uint8    `json:"level"`
+	Error       string   `json:"error"`
+	Missing     bool     `json:"missing"`
+	Quarantined bool     `json:"quarantined"`
+	Paths       []string `json:"paths"`
+}
+
+//ScrubReport is the outcome of scrubbing a filesystem
+type ScrubReport struct {
+	Files   int             `json:"files"`
+	Chunks  int             `json:"chunks"`
+	Bytes   int64           `json:"bytes"`
+	Corrupt []*CorruptChunk `json:"corrupt"`
+}
+
+//scrubBatch is the nr of nodes whose content is collected per transaction
+const scrubBatch = 500
+
+//scrubTarget is content that is scrubbed, path is where it can be read
+type scrubTarget struct {
+	path  string
+	ino   uint64
+	m     *blobs.Manifest
+	xattr bool
+}
+
+//scrubQueued is a node whose content is still to be collected
+type scrubQueued struct {
+	path string
+	node *nodes.Node
+}
+
+//scrubNode collects the content of node and returns its children. Versions
+//are listed with their path in the versions directory and large xattrs with
+//their name after the path of the node.
+func scrubNode(tx fdb.Transaction, p string, node *nodes.Node) (targets []scrubTarget, chldr []scrubQueued) {
+	st := node.Stat(tx)
+	switch st.Mode & fuse.S_IFMT {
+	case fuse.S_IFDIR:
+		node.ChldEach(tx, func(name string, chld *nodes.Node) bool {
+			chldr = append(chldr, scrubQueued{path: path.Join(p, name), node: chld})
+			return false
+		})
+	case fuse.S_IFREG:
+		if m := node.Manifest(tx); nil != m && !nodes.IsInline(m) {
+			targets = append(targets, scrubTarget{path: p, ino: st.Ino, m: m})
+		}
+
+		for _, v := range node.Versions(tx) {
+			if nodes.IsInline(v.Manifest()) {
+				continue //stored with the metadata instead of in chunks
+			}
+
+			vpath := path.Join("/", VersionsDir, p, fmt.Sprint(v.ID))
+			targets = append(targets, scrubTarget{path: vpath, ino: st.Ino, m: v.Manifest()})
+		}
+	}
+
+	node.XAtrEach(tx, func(name string) int {
+		if m := node.XAtrManifest(tx, name); nil != m {
+			targets = append(targets, scrubTarget{path: p + ":" + name, ino: st.Ino, m: m, xattr: true})
+		}
+
+		return 0
+	})
+
+	return targets, chldr
+}
+
+//scrubTargets collects the content of the tree and the trash in batches of
+//nodes such that no transaction grows too large
+func (self *Memfs) scrubTargets() (errc int, targets []scrubTarget) {
+	var queue []scrubQueued
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		queue = []scrubQueued{{path: "/", node: self.nstore.Root(tx)}}
+		self.nstore.EachTrash(tx, func(e *nodes.TrashEntry) (stop bool) {
+			tpath := path.Join("/", TrashDir, trashName(e))
+			queue = append(queue, scrubQueued{path: tpath, node: self.nstore.Node(e.Ino)})
+			return false
+		})
+
+		return 0
+	}); 0 != errc {
+		return errc, nil
+	}
+
+	for len(queue) > 0 {
+		n := scrubBatch
+		if len(queue) < n {
+			n = len(queue)
+		}
+
+		var batch []scrubTarget
+		var next []scrubQueued
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			batch, next = nil, nil
+			for _, q := range queue[:n] {
+				ts, chldr := scrubNode(tx, q.path, q.node)
+				batch = append(batch, ts...)
+				next = append(next, chldr...)
+			}
+
+			return 0
+		}); 0 != errc {
+			return errc, nil
+		}
+
+		targets = append(targets, batch...)
+		queue = append(queue[n:], next...)
+	}
+
+	return 0, targets
+}
+
+//Scrub reads every chunk that is referred to by files, their versions and
+//the trash and verifies it against its key. Corrupt chunks are recorded and,
+//with quarantine, moved out of the chunk store such that they can be
+//repaired by uploading the original content again.
+func (self *Memfs) Scrub(quarantine bool) (errc int, rep *ScrubReport) {
+	defer trace(quarantine)(&errc, &rep)
+	qstore, ok := self.cstore.(interface {
+		Quarantine(ctx context.Context, key cas.Key) error
+	})
+	if quarantine && !ok {
+		return -fuse.ENOTSUP, nil
+	}
+
+	errc, targets := self.scrubTargets()
+	if 0 != errc {
+		return errc, nil
+	}
+
+	ctx := context.Background()
+	rep = &ScrubReport{}
+	seen := map[cas.Key]*CorruptChunk{}
+	found := map[cas.Key]*nodes.Corrupt{}
+	for _, t := range targets {
+		if !t.xattr && !strings.HasPrefix(t.path, "/"+VersionsDir+"/") {
+			rep.Files++
+		}
+
+		scrub.Walk(ctx, self.cstore, t.m, func(ref scrub.Ref, size int, err error) error {
+			if cc, ok := seen[ref.Key]; ok {
+				if nil != cc {
+					cc.Paths = append(cc.Paths, t.path)
+				}
+
+				return nil
+			}
+
+			rep.Chunks++
+			rep.Bytes += int64(size)
+			if nil == err {
+				seen[ref.Key] = nil
+				return nil
+			}
+
+			_, missing := err.(*chunks.NotFoundError)
+			cc := &CorruptChunk{
+				Key:     hex.EncodeToString(ref.Key.Bytes()),
+				Type:    ref.Type,
+				Level:   ref.Level,
+				Error:   err.Error(),
+				Missing: missing,
+				Paths:   []string{t.path},
+			}
+
+			seen[ref.Key] = cc
+			found[ref.Key] = &nodes.Corrupt{Ref: ref, Ino: t.ino, Found: time.Now()}
+			rep.Corrupt = append(rep.Corrupt, cc)
+			if quarantine && !missing {
+				cc.Quarantined = nil == qstore.Quarantine(ctx, ref.Key)
+			}
+
+			return nil
+		})
+	}
+
+	//records of chunks that are intact again or no longer referred to are
+	//dropped, the scrub has seen every chunk that is still in use
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		var stale []cas.Key
+		self.nstore.EachCorrupt(tx, func(c *nodes.Corrupt) (stop bool) {
+			stale = append(stale, c.Key)
+			return false
+		})
+
+		for _, key := range stale {
+			self.nstore.DelCorrupt(tx, key)
+		}
+
+		for _, c := range found {
+			self.nstore.PutCorrupt(tx, c)
+		}
+
+		return 0
+	})
+
+	return errc, rep
+}
+
+//CorruptChunks returns the chunks that were found to be corrupt by the last
+//scrub or by reads since then
+func (self *Memfs) CorruptChunks() (errc int, cs []*nodes.Corrupt) {
+	defer trace()(&errc, &cs)
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		cs = nil
+		self.nstore.EachCorrupt(tx, func(c *nodes.Corrupt) (stop bool) {
+			cs = append(cs, c)
+			return false
+		})
+
+		return 0
+	})
+
+	return errc, cs
+}
+
+//SetVerifyReads configures whether every chunk that is read is verified
+//against its key, reads of corrupt chunks then fail with EIO and the chunk
+//is recorded instead of returning altered content
+func (self *Memfs) SetVerifyReads(on bool) (errc int) {
+	defer trace(on)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		self.nstore.SetVerifyReads(tx, on)
+		return 0
+	})
+}
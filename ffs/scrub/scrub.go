@@ -0,0 +1,176 @@
+//Package scrub verifies that chunks still hash to the key they are stored
+//under, chunks are addressed by their content so any difference means the
+//chunk was corrupted after it was written
+package scrub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/cdc"
+)
+
+//Ref identifies a chunk the way the chunk store does
+type Ref struct {
+	Key   cas.Key
+	Type  string
+	Level uint8
+}
+
+//CorruptError is returned when the content of a chunk doesn't match its key
+type CorruptError struct {
+	Ref
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("chunk %s (%s, level %d) doesn't match its key", e.Key, e.Type, e.Level)
+}
+
+//Check reads the chunk of ref and verifies it against its key, it returns
+//the chunk store's error if the chunk can't be read and a *CorruptError if
+//its content was altered
+func Check(ctx context.Context, store chunks.Store, ref Ref) (c *chunks.Chunk, err error) {
+	c, err = store.Get(ctx, ref.Key, ref.Type, ref.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref.Key.IsSpecial() {
+		return c, nil //special keys are not a hash of their content
+	}
+
+	if chunks.Hash(&chunks.Chunk{Type: ref.Type, Level: ref.Level, Buf: c.Buf}) != ref.Key {
+		return nil, &CorruptError{Ref: ref}
+	}
+
+	return c, nil
+}
+
+//level returns the level of the root chunk of a fixed size blob, level 0
+//holds content and every level above it the keys of the level below
+func level(m *blobs.Manifest) (lvl uint8) {
+	if m.Size == 0 || m.ChunkSize == 0 || m.Fanout < 2 {
+		return 0
+	}
+
+	for idx := (m.Size - 1) / uint64(m.ChunkSize); idx > 0; idx /= uint64(m.Fanout) {
+		lvl++
+	}
+
+	return lvl
+}
+
+//Root returns the chunk the manifest's content is reached through
+func Root(m *blobs.Manifest) Ref {
+	if cdc.Type == m.Type {
+		return Ref{Key: m.Root, Type: cdc.IndexType, Level: 1}
+	}
+
+	return Ref{Key: m.Root, Type: m.Type, Level: level(m)}
+}
+
+//SkipChunks is returned by the function passed to Walk to skip the chunks
+//below the chunk it was called for, it is not returned by Walk itself
+var SkipChunks = errors.New("skip the chunks below this chunk")
+
+//skipped returns nil for SkipChunks and err otherwise
+func skipped(err error) error {
+	if err == SkipChunks {
+		return nil
+	}
+
+	return err
+}
+
+//Walk calls f for every chunk the manifest refers to with the result of
+//checking it. Chunks below a chunk that fails the check can't be found and
+//are skipped, as are the chunks below a chunk for which f returns
+//SkipChunks. Walk stops at the first other error returned by f.
+func Walk(ctx context.Context, store chunks.Store, m *blobs.Manifest, f func(ref Ref, size int, err error) error) error {
+	if m.Size == 0 || m.Root.IsSpecial() {
+		return nil
+	}
+
+	if cdc.Type == m.Type {
+		ref := Root(m)
+		c, err := Check(ctx, store, ref)
+		if err != nil {
+			return skipped(f(ref, 0, err))
+		}
+
+		if err = f(ref, len(c.Buf), nil); err != nil {
+			return skipped(err)
+		}
+
+		keys, err := cdc.Keys(store, m)
+		if err != nil {
+			return skipped(f(ref, 0, err)) //the index chunk matches its key but can't be decoded
+		}
+
+		for _, key := range keys[1:] {
+			ref := Ref{Key: key, Type: "blob", Level: 0}
+			c, err := Check(ctx, store, ref)
+			size := 0
+			if c != nil {
+				size = len(c.Buf)
+			}
+
+			if err = skipped(f(ref, size, err)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return walk(ctx, store, Root(m), f)
+}
+
+func walk(ctx context.Context, store chunks.Store, ref Ref, f func(ref Ref, size int, err error) error) error {
+	c, err := Check(ctx, store, ref)
+	if err != nil {
+		return skipped(f(ref, 0, err))
+	}
+
+	if err = f(ref, len(c.Buf), nil); err != nil || ref.Level == 0 {
+		return skipped(err)
+	}
+
+	//stores may trim trailing zeros of the keys in a chunk
+	buf := c.Buf
+	if rem := len(buf) % cas.KeySize; rem != 0 {
+		buf = append(buf, make([]byte, cas.KeySize-rem)...)
+	}
+
+	for ; len(buf) > 0; buf = buf[cas.KeySize:] {
+		key := cas.NewKey(buf[:cas.KeySize])
+		if key.IsSpecial() {
+			continue //holes are not stored
+		}
+
+		if err = walk(ctx, store, Ref{Key: key, Type: ref.Type, Level: ref.Level - 1}, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//verifying is a chunk store that checks every chunk it returns
+type verifying struct {
+	chunks.Store
+}
+
+//Verifying wraps store such that reading a chunk that doesn't match its key
+//fails with a *CorruptError instead of returning the altered content
+func Verifying(store chunks.Store) chunks.Store {
+	return verifying{store}
+}
+
+func (v verifying) Get(ctx context.Context, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
+	return Check(ctx, v.Store, Ref{Key: key, Type: typ, Level: level})
+}
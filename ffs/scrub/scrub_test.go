@@ -0,0 +1,171 @@
+package scrub
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/cdc"
+)
+
+//memStore is a chunk store that keeps chunks in memory
+type memStore struct {
+	mu     sync.Mutex
+	chunks map[cas.Key]*chunks.Chunk
+}
+
+func (s *memStore) Get(ctx context.Context, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.chunks[key]
+	if !ok || c.Type != typ || c.Level != level {
+		return nil, &chunks.NotFoundError{Type: typ, Level: level, Key: key}
+	}
+
+	return c, nil
+}
+
+func (s *memStore) Add(ctx context.Context, c *chunks.Chunk) (cas.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := chunks.Hash(c)
+	buf := make([]byte, len(c.Buf))
+	copy(buf, c.Buf)
+	s.chunks[key] = &chunks.Chunk{Type: c.Type, Level: c.Level, Buf: buf}
+	return key, nil
+}
+
+//flip alters the chunk with key in place
+func (s *memStore) flip(key cas.Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks[key].Buf[0] ^= 0xff
+}
+
+func walkAll(t *testing.T, store chunks.Store, m *blobs.Manifest) (refs []Ref, errs []error) {
+	if err := Walk(context.Background(), store, m, func(ref Ref, size int, err error) error {
+		refs = append(refs, ref)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return refs, errs
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{chunks: map[cas.Key]*chunks.Chunk{}}
+	data := make([]byte, 2000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	blob, err := blobs.Open(store, &blobs.Manifest{Type: "blob", ChunkSize: 256, Fanout: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = blob.IO(ctx).WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := blob.Save(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, errs := walkAll(t, store, m)
+	if len(errs) != 0 {
+		t.Fatalf("expected intact blob, got: %v", errs)
+	}
+
+	leaves := []Ref{}
+	for _, ref := range refs {
+		if ref.Level == 0 {
+			leaves = append(leaves, ref)
+		}
+	}
+
+	if len(leaves) != 8 || refs[0].Key != m.Root || refs[0].Level != 3 {
+		t.Fatalf("expected a tree of 3 levels above 8 leaves, got: %d leaves, root at level %d", len(leaves), refs[0].Level)
+	}
+
+	t.Run("corrupt leaf", func(t *testing.T) {
+		store.flip(leaves[3].Key)
+		defer store.flip(leaves[3].Key)
+
+		_, errs := walkAll(t, store, m)
+		if len(errs) != 1 {
+			t.Fatalf("expected one corrupt chunk, got: %v", errs)
+		}
+
+		if cerr, ok := errs[0].(*CorruptError); !ok || cerr.Key != leaves[3].Key {
+			t.Fatalf("expected the flipped leaf to be reported, got: %v", errs[0])
+		}
+
+		if _, err := Verifying(store).Get(ctx, leaves[3].Key, "blob", 0); err == nil {
+			t.Fatal("expected verifying store to refuse the corrupt chunk")
+		}
+	})
+
+	t.Run("corrupt root", func(t *testing.T) {
+		store.flip(m.Root)
+		defer store.flip(m.Root)
+
+		refs, errs := walkAll(t, store, m)
+		if len(refs) != 1 || len(errs) != 1 {
+			t.Fatalf("expected chunks below a corrupt root to be skipped, got: %d", len(refs))
+		}
+	})
+
+	t.Run("skip chunks", func(t *testing.T) {
+		n := 0
+		if err := Walk(ctx, store, m, func(ref Ref, size int, err error) error {
+			n++
+			if ref.Level == 2 {
+				return SkipChunks
+			}
+
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 3 {
+			t.Fatalf("expected only the root and the chunks at level 2 to be walked, got: %d", n)
+		}
+	})
+
+	t.Run("content-defined", func(t *testing.T) {
+		b, err := cdc.Open(store, &blobs.Manifest{Type: cdc.Type, ChunkSize: 256})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = b.WriteAt(ctx, data, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := b.Save(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := cdc.Keys(store, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		refs, errs := walkAll(t, store, m)
+		if len(errs) != 0 || len(refs) != len(keys) {
+			t.Fatalf("expected %d intact chunks, got: %d, %v", len(keys), len(refs), errs)
+		}
+	})
+}
@@ -0,0 +1,11 @@
+package ffs
+
+import (
+	"github.com/advanderveer/dfs/ffs/nodes"
+)
+
+//SetSealer encrypts xattr values that are written from now on with s, values
+//that were written before stay readable
+func (self *Memfs) SetSealer(s nodes.Sealer) {
+	self.nstore.SetSealer(s)
+}
@@ -0,0 +1,130 @@
+package ffs
+
+import (
+	"math"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//Modes of Fallocate, they match the flags of fallocate(2) on Linux
+const (
+	FallocKeepSize  = 0x01
+	FallocPunchHole = 0x02
+	FallocZeroRange = 0x10
+)
+
+//Whence values of Lseek, they match those of lseek(2)
+const (
+	SeekData = 3
+	SeekHole = 4
+)
+
+//Fallocate manipulates the space of a file like fallocate(2). Without flags
+//the range is allocated, FallocPunchHole (which requires FallocKeepSize)
+//turns it into a hole and FallocZeroRange makes it read as zeros. Holes and
+//zeroed ranges don't take up chunk storage. The file grows to cover the
+//range unless FallocKeepSize is set.
+func (self *Memfs) Fallocate(path string, mode uint32, ofst int64, length int64, fh uint64) (errc int) {
+	defer trace(path, mode, ofst, length, fh)(&errc)
+	if ofst < 0 || length <= 0 {
+		return -fuse.EINVAL
+	}
+	if length > math.MaxInt64-ofst {
+		return -fuse.EFBIG
+	}
+	if 0 != mode&^(FallocKeepSize|FallocPunchHole|FallocZeroRange) {
+		return -fuse.EOPNOTSUPP
+	}
+	if 0 != mode&FallocPunchHole && 0 == mode&FallocKeepSize {
+		return -fuse.EOPNOTSUPP
+	}
+	if 0 != mode&FallocPunchHole && 0 != mode&FallocZeroRange {
+		return -fuse.EINVAL
+	}
+
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		node := self.getNode(tx, path, fh)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+
+		switch node.Stat(tx).Mode & fuse.S_IFMT {
+		case fuse.S_IFREG:
+		case fuse.S_IFDIR:
+			return -fuse.EISDIR
+		default:
+			return -fuse.ENODEV
+		}
+
+		if ^uint64(0) != fh && !writable(self.hstore.Get(tx, fh).Flags) {
+			return -fuse.EBADF
+		}
+		if "" != path && self.leased(tx, path) {
+			return -fuse.EACCES
+		}
+
+		end := ofst + length
+		if 0 == mode&FallocKeepSize && end > node.Stat(tx).Size {
+			if errc = self.truncNode(tx, path, node, end); 0 != errc {
+				return errc
+			}
+
+			self.emit(tx, "truncate", path, "", node)
+		}
+
+		if 0 == mode&(FallocPunchHole|FallocZeroRange) {
+			node.Allocate(tx, ofst, end)
+			return 0
+		}
+
+		if errc = node.Zero(tx, self.cstore, ofst, end); 0 != errc {
+			return errc
+		}
+
+		tmsp := fuse.Now()
+		node.StatSetCTim(tx, tmsp)
+		node.StatSetMTim(tx, tmsp)
+		self.emit(tx, "write", path, "", node)
+		return 0
+	})
+}
+
+//Lseek finds data or holes in a file like lseek(2) with SEEK_DATA and
+//SEEK_HOLE, other whence values are handled by the client. It fails with
+//ENXIO if ofst lies beyond the end of the file or no data follows it.
+func (self *Memfs) Lseek(path string, ofst int64, whence int, fh uint64) (errc int, n int64) {
+	defer trace(path, ofst, whence, fh)(&errc, &n)
+	if SeekData != whence && SeekHole != whence {
+		return -fuse.EINVAL, 0
+	}
+	if ofst < 0 {
+		return -fuse.ENXIO, 0
+	}
+
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		node := self.getNode(tx, path, fh)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+
+		size := node.Stat(tx).Size
+		if ofst >= size {
+			return -fuse.ENXIO
+		}
+
+		if SeekHole == whence {
+			n = node.SeekHole(tx, ofst, size)
+			return 0
+		}
+
+		var ok bool
+		if n, ok = node.SeekData(tx, ofst, size); !ok {
+			return -fuse.ENXIO
+		}
+
+		return 0
+	})
+
+	return errc, n
+}
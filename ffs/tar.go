@@ -0,0 +1,335 @@
+package ffs
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//tarBatch is the nr of archive entries that are imported per transaction
+const tarBatch = 500
+
+//tarBufSize is the size of the reads and writes that copy file content
+const tarBufSize = 64 * 1024
+
+//xattrPAX prefixes the PAX records that hold extended attributes
+const xattrPAX = "SCHILY.xattr."
+
+//tarHeader converts e to a tar header with the provided name, it returns
+//nil for nodes that can't be archived
+func tarHeader(e *SnapshotEntry, name string) *tar.Header {
+	hdr := &tar.Header{
+		Name:       name,
+		Mode:       int64(e.Mode & 07777),
+		Uid:        int(e.Uid),
+		Gid:        int(e.Gid),
+		ModTime:    e.Mtim.Time(),
+		AccessTime: e.Atim.Time(),
+		ChangeTime: e.Ctim.Time(),
+		Format:     tar.FormatPAX,
+	}
+
+	switch e.Mode & fuse.S_IFMT {
+	case fuse.S_IFDIR:
+		hdr.Typeflag, hdr.Name = tar.TypeDir, name+"/"
+	case fuse.S_IFREG:
+		hdr.Typeflag, hdr.Size = tar.TypeReg, e.Size
+	case fuse.S_IFLNK:
+		hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.Target
+	case fuse.S_IFIFO:
+		hdr.Typeflag = tar.TypeFifo
+	case fuse.S_IFCHR, fuse.S_IFBLK:
+		hdr.Typeflag = tar.TypeChar
+		if fuse.S_IFBLK == e.Mode&fuse.S_IFMT {
+			hdr.Typeflag = tar.TypeBlock
+		}
+
+		hdr.Devmajor = int64((e.Rdev >> 8) & 0xfff)
+		hdr.Devminor = int64((e.Rdev & 0xff) | ((e.Rdev >> 12) & 0xfff00))
+	default:
+		return nil
+	}
+
+	for name, xatr := range e.XAttrs {
+		if nil == hdr.PAXRecords {
+			hdr.PAXRecords = map[string]string{}
+		}
+
+		hdr.PAXRecords[xattrPAX+name] = string(xatr)
+	}
+
+	return hdr
+}
+
+//tarEntry converts a tar header to the node it describes at path p
+func tarEntry(hdr *tar.Header, p string) (e *SnapshotEntry, err error) {
+	e = &SnapshotEntry{
+		Path:     p,
+		Mode:     uint32(hdr.Mode & 07777),
+		Uid:      uint32(hdr.Uid),
+		Gid:      uint32(hdr.Gid),
+		Mtim:     fuse.NewTimespec(hdr.ModTime),
+		Atim:     fuse.NewTimespec(hdr.ModTime),
+		Ctim:     fuse.NewTimespec(hdr.ModTime),
+		Birthtim: fuse.NewTimespec(hdr.ModTime),
+	}
+
+	if !hdr.AccessTime.IsZero() {
+		e.Atim = fuse.NewTimespec(hdr.AccessTime)
+	}
+	if !hdr.ChangeTime.IsZero() {
+		e.Ctim = fuse.NewTimespec(hdr.ChangeTime)
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		e.Mode |= fuse.S_IFDIR
+	case tar.TypeReg:
+		e.Mode |= fuse.S_IFREG
+		e.Size = hdr.Size
+	case tar.TypeSymlink:
+		e.Mode |= fuse.S_IFLNK
+		e.Target = hdr.Linkname
+	case tar.TypeFifo:
+		e.Mode |= fuse.S_IFIFO
+	case tar.TypeChar, tar.TypeBlock:
+		e.Mode |= fuse.S_IFCHR
+		if tar.TypeBlock == hdr.Typeflag {
+			e.Mode = e.Mode&^fuse.S_IFMT | fuse.S_IFBLK
+		}
+
+		major, minor := uint64(hdr.Devmajor), uint64(hdr.Devminor)
+		e.Rdev = (major&0xfff)<<8 | (minor & 0xff) | (minor&0xfff00)<<12
+	default:
+		return nil, fmt.Errorf("unsupported entry type '%c' of '%s'", hdr.Typeflag, hdr.Name)
+	}
+
+	for k, v := range hdr.PAXRecords {
+		if strings.HasPrefix(k, xattrPAX) {
+			if nil == e.XAttrs {
+				e.XAttrs = map[string][]byte{}
+			}
+
+			e.XAttrs[strings.TrimPrefix(k, xattrPAX)] = []byte(v)
+		}
+	}
+
+	return e, nil
+}
+
+//ExportTar writes the tree below dir to w as a tar archive with names that
+//are relative to dir, a file is archived under its own name. Modes, owners,
+//times, symlinks, hard links and extended attributes are preserved.
+func (b *Browser) ExportTar(dir string, w io.Writer) (err error) {
+	errc, es := b.fs.exportNodes(dir)
+	if errc != 0 {
+		return fmt.Errorf("failed to list nodes: %d", errc)
+	}
+
+	base := path.Join("/", dir)
+	if len(es) > 0 && fuse.S_IFDIR != es[0].Mode&fuse.S_IFMT {
+		base = path.Dir(base)
+	}
+
+	tw, links := tar.NewWriter(w), map[uint64]string{}
+	for _, e := range es {
+		name := strings.TrimLeft(strings.TrimPrefix(e.Path, base), "/")
+		if "" == name {
+			continue //the directory itself
+		}
+
+		hdr := tarHeader(e, name)
+		if nil == hdr {
+			continue
+		}
+
+		if tar.TypeReg == hdr.Typeflag {
+			if first, ok := links[e.Ino]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				links[e.Ino] = name
+			}
+		}
+
+		if err = tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write header of '%s': %v", e.Path, err)
+		}
+
+		if tar.TypeReg == hdr.Typeflag && hdr.Size > 0 {
+			if err = b.copyOut(e.Path, hdr.Size, tw); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+//copyOut writes size bytes of the file at p to w
+func (b *Browser) copyOut(p string, size int64, w io.Writer) (err error) {
+	errc, fh := b.fs.Open(p, fuse.O_RDONLY)
+	if errc != 0 {
+		return fmt.Errorf("failed to open '%s': %d", p, errc)
+	}
+
+	defer b.fs.Release(p, fh)
+	buff := make([]byte, tarBufSize)
+	for ofst := int64(0); ofst < size; {
+		if int64(len(buff)) > size-ofst {
+			buff = buff[:size-ofst]
+		}
+
+		n := b.fs.Read(p, buff, ofst, fh)
+		if n < 0 {
+			return fmt.Errorf("failed to read '%s': %d", p, n)
+		} else if n == 0 {
+			return fmt.Errorf("failed to read '%s': content is shorter than its size", p)
+		}
+
+		if _, err = w.Write(buff[:n]); err != nil {
+			return fmt.Errorf("failed to write content of '%s': %v", p, err)
+		}
+
+		ofst += int64(n)
+	}
+
+	return nil
+}
+
+//ImportTar creates the entries of the tar archive in r below the existing
+//directory dir, it returns the nr of entries that were imported. File
+//content is written to the chunk store as it is read, nodes are created in
+//batched transactions and their attributes are set once all nodes exist.
+//Directories that exist already are merged with and keep their attributes.
+func (b *Browser) ImportTar(dir string, r io.Reader) (n int, err error) {
+	dir = path.Join("/", dir)
+	type pending struct {
+		e     *SnapshotEntry
+		first string
+	}
+
+	var c nodes.Chunking
+	if errc := b.fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		c = b.fs.nstore.Chunking(tx)
+		return 0
+	}); errc != 0 {
+		return 0, fmt.Errorf("failed to read chunking: %d", errc)
+	}
+
+	var batch []pending
+	var attrs []*SnapshotEntry
+	merged := map[string]bool{}
+	create := func() error {
+		var dirs []string
+		if errc := b.fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			dirs = dirs[:0]
+			for _, p := range batch {
+				if fuse.S_IFDIR == p.e.Mode&fuse.S_IFMT {
+					if _, _, node := b.fs.lookupNode(tx, p.e.Path, nil); nil != node && fuse.S_IFDIR == node.Stat(tx).Mode&fuse.S_IFMT {
+						dirs = append(dirs, p.e.Path)
+						continue
+					}
+				}
+
+				if errc = b.fs.restoreNode(tx, "import", p.e, p.first); 0 != errc {
+					return errc
+				}
+			}
+
+			return 0
+		}); errc != 0 {
+			return fmt.Errorf("failed to create nodes: %d", errc)
+		}
+
+		for _, d := range dirs {
+			merged[d] = true
+		}
+
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, fmt.Errorf("failed to read archive: %v", err)
+		}
+
+		//names are cleaned as if they are rooted such that they can't escape dir
+		p := path.Join(dir, path.Clean("/"+hdr.Name))
+		if p == dir {
+			continue
+		}
+
+		if tar.TypeLink == hdr.Typeflag {
+			batch = append(batch, pending{e: &SnapshotEntry{Path: p}, first: path.Join(dir, path.Clean("/"+hdr.Linkname))})
+		} else {
+			e, err := tarEntry(hdr, p)
+			if err != nil {
+				return n, err
+			}
+
+			if tar.TypeReg == hdr.Typeflag && hdr.Size > 0 {
+				m, err := nodes.WriteContent(context.Background(), b.fs.cstore, c, tr)
+				if err != nil {
+					return n, fmt.Errorf("failed to write content of '%s': %v", p, err)
+				}
+
+				e.Manifest = snapshotManifest(m)
+			}
+
+			e.Owner = b.fs.actor
+			batch, attrs = append(batch, pending{e: e, first: p}), append(attrs, e)
+		}
+
+		if len(batch) >= tarBatch {
+			if err = create(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	if err = create(); err != nil {
+		return n, err
+	}
+
+	for i := 0; i < len(attrs); i += tarBatch {
+		end := i + tarBatch
+		if end > len(attrs) {
+			end = len(attrs)
+		}
+
+		if errc := b.fs.writeXattrs(attrs[i:end]); errc != 0 {
+			return n, fmt.Errorf("failed to write xattrs: %d", errc)
+		}
+
+		if errc := b.fs.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			for _, e := range attrs[i:end] {
+				if merged[e.Path] {
+					continue
+				}
+
+				if errc = b.fs.restoreAttrs(tx, e, false); 0 != errc {
+					return errc
+				}
+			}
+
+			return 0
+		}); errc != 0 {
+			return n, fmt.Errorf("failed to set attributes: %d", errc)
+		}
+	}
+
+	return n, nil
+}
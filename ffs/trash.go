@@ -0,0 +1,286 @@
+package ffs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//TrashDir is the read-only virtual directory in the root that lists what
+//was removed while the trash was enabled, entries are named <id>_<name>
+const TrashDir = ".trash"
+
+//trashSweepBatch is the nr of expired entries that are purged per transaction
+var trashSweepBatch = 100
+
+//trashPath returns whether path lies in the trash directory and if so the
+//remainder of the path below it
+func trashPath(path string) (rest string, ok bool) {
+	return virtualPath(path, TrashDir)
+}
+
+//readOnly returns whether path lies in one of the virtual directories
+func readOnly(path string) bool {
+	_, versions := versionsPath(path)
+	_, trash := trashPath(path)
+	return versions || trash
+}
+
+func trashName(e *nodes.TrashEntry) string {
+	return fmt.Sprintf("%d_%s", e.ID, filepath.Base(e.Path))
+}
+
+//lookupTrash resolves a path in the trash directory, the entry is nil for
+//the trash directory itself
+func (self *Memfs) lookupTrash(tx fdb.Transaction, rest string) (e *nodes.TrashEntry, node *nodes.Node, stat fuse.Stat_t, errc int) {
+	name := strings.Trim(rest, "/")
+	if "" == name {
+		node = self.nstore.Root(tx)
+		stat = node.Stat(tx)
+		stat.Mode = fuse.S_IFDIR | 00555
+		return nil, node, stat, 0
+	}
+
+	idx := strings.Index(name, "_")
+	if idx < 0 {
+		return nil, nil, stat, -fuse.ENOENT
+	}
+
+	id, err := strconv.ParseUint(name[:idx], 10, 64)
+	if err != nil {
+		return nil, nil, stat, -fuse.ENOENT
+	}
+
+	e = self.nstore.GetTrash(tx, id)
+	if nil == e || trashName(e) != name {
+		return nil, nil, stat, -fuse.ENOENT
+	}
+
+	node = self.nstore.Node(e.Ino)
+	stat = node.Stat(tx)
+	stat.Mode &^= 00222
+	return e, node, stat, 0
+}
+
+//openTrash opens a node of the trash directory for reading
+func (self *Memfs) openTrash(tx fdb.Transaction, rest string, dir bool, flags int) (int, uint64) {
+	if writable(flags) || 0 != flags&fuse.O_TRUNC {
+		return -fuse.EROFS, ^uint64(0)
+	}
+
+	_, node, stat, errc := self.lookupTrash(tx, rest)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	if !dir && fuse.S_IFDIR == stat.Mode&fuse.S_IFMT {
+		return -fuse.EISDIR, ^uint64(0)
+	}
+	if dir && fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+		return -fuse.ENOTDIR, ^uint64(0)
+	}
+
+	node.IncOpencnt(tx)
+	hndl := self.hstore.Open(tx, node, flags, self.sess)
+	return 0, hndl.ID
+}
+
+//readdirTrash lists the trash directory, removed directories are always
+//empty as their content was removed before them
+func (self *Memfs) readdirTrash(tx fdb.Transaction, rest string, fill func(name string, stat *fuse.Stat_t, ofst int64) bool) int {
+	e, _, stat, errc := self.lookupTrash(tx, rest)
+	if 0 != errc {
+		return errc
+	}
+	if fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+		return -fuse.ENOTDIR
+	}
+
+	fill(".", &stat, 0)
+	fill("..", nil, 0)
+	if nil != e {
+		return 0
+	}
+
+	self.nstore.EachTrash(tx, func(e *nodes.TrashEntry) (stop bool) {
+		csta := self.nstore.Node(e.Ino).Stat(tx)
+		csta.Mode &^= 00222
+		return !fill(trashName(e), &csta, 0)
+	})
+
+	return 0
+}
+
+//trashNode moves a node that is removed from path into the trash, it keeps
+//its link such that it can be restored
+func (self *Memfs) trashNode(tx fdb.Transaction, path string, node *nodes.Node, dir bool) {
+	self.nstore.PutTrash(tx, &nodes.TrashEntry{
+		Path:    filepath.Join("/", path),
+		Ino:     node.StatGetIno(tx),
+		Dir:     dir,
+		Actor:   self.actor,
+		Deleted: time.Now(),
+	})
+}
+
+//restoreEntry links the node of trash entry e at its original path again
+func (self *Memfs) restoreEntry(tx fdb.Transaction, e *nodes.TrashEntry) int {
+	prnt, name, node := self.lookupNode(tx, e.Path, nil)
+	if nil == prnt {
+		return -fuse.ENOENT
+	}
+	if "" == name {
+		return -fuse.EINVAL
+	}
+	if nil != node {
+		return -fuse.EEXIST
+	}
+	if self.leased(tx, e.Path) {
+		return -fuse.EACCES
+	}
+
+	node = self.nstore.Node(e.Ino)
+	prnt.SetChld(tx, name, node)
+	self.linked(tx, e.Path, prnt, name, node)
+
+	tmsp := fuse.Now()
+	node.StatSetCTim(tx, tmsp)
+	prnt.StatSetCTim(tx, tmsp)
+	prnt.StatSetMTim(tx, tmsp)
+	self.nstore.DelTrash(tx, e.ID)
+	self.emit(tx, "untrash", e.Path, "", node)
+	return 0
+}
+
+//purgeEntry removes trash entry e for good
+func (self *Memfs) purgeEntry(tx fdb.Transaction, e *nodes.TrashEntry) {
+	node := self.nstore.Node(e.Ino)
+	self.release(tx, node)
+	self.nstore.DelTrash(tx, e.ID)
+	self.emit(tx, "purge", e.Path, "", node)
+}
+
+//SetTrash enables or disables the trash, entries older then expiry are
+//purged by SweepTrash unless expiry is zero.
+func (self *Memfs) SetTrash(enabled bool, expiry time.Duration) (errc int) {
+	defer trace(enabled, expiry)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		self.nstore.SetTrashMode(tx, nodes.TrashMode{Enabled: enabled, Expiry: expiry})
+		return 0
+	})
+}
+
+//Trash calls f for every entry in the trash in the order they were removed
+func (self *Memfs) Trash(f func(e *nodes.TrashEntry) bool) (errc int) {
+	defer trace()(&errc)
+
+	var entries []*nodes.TrashEntry
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		entries = nil
+		self.nstore.EachTrash(tx, func(e *nodes.TrashEntry) (stop bool) {
+			entries = append(entries, e)
+			return false
+		})
+
+		return 0
+	}); 0 != errc {
+		return errc
+	}
+
+	for _, e := range entries {
+		if !f(e) {
+			break
+		}
+	}
+
+	return 0
+}
+
+//RestoreTrash restores the trash entry with the provided id at its original
+//path. For a directory, the entries that were removed from below it are
+//restored as well, if possible.
+func (self *Memfs) RestoreTrash(id uint64) (errc int) {
+	defer trace(id)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		e := self.nstore.GetTrash(tx, id)
+		if nil == e {
+			return -fuse.ENOENT
+		}
+
+		if errc = self.restoreEntry(tx, e); 0 != errc || !e.Dir {
+			return errc
+		}
+
+		below := []*nodes.TrashEntry{}
+		self.nstore.EachTrash(tx, func(ce *nodes.TrashEntry) (stop bool) {
+			if strings.HasPrefix(ce.Path, e.Path+"/") {
+				below = append(below, ce)
+			}
+
+			return false
+		})
+
+		//directories are removed after their content, so the most recently
+		//removed entries are restored first
+		sort.Slice(below, func(i, j int) bool { return below[i].ID > below[j].ID })
+		for _, ce := range below {
+			self.restoreEntry(tx, ce) //conflicting entries stay in the trash
+		}
+
+		return 0
+	})
+}
+
+//PurgeTrash removes the trash entry with the provided id for good
+func (self *Memfs) PurgeTrash(id uint64) (errc int) {
+	defer trace(id)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		e := self.nstore.GetTrash(tx, id)
+		if nil == e {
+			return -fuse.ENOENT
+		}
+
+		self.purgeEntry(tx, e)
+		return 0
+	})
+}
+
+//SweepTrash purges all trash entries that expired at time now, it works in
+//batches such that a large trash doesn't exceed transaction limits
+func (self *Memfs) SweepTrash(now time.Time) (errc int) {
+	defer trace(now)(&errc)
+	for {
+		var n int
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			n = 0
+			mode := self.nstore.TrashMode(tx)
+			if 0 == mode.Expiry {
+				return 0
+			}
+
+			expired := []*nodes.TrashEntry{}
+			self.nstore.EachTrash(tx, func(e *nodes.TrashEntry) (stop bool) {
+				if now.Sub(e.Deleted) > mode.Expiry {
+					expired = append(expired, e)
+				}
+
+				return len(expired) >= trashSweepBatch
+			})
+
+			for _, e := range expired {
+				self.purgeEntry(tx, e)
+			}
+
+			n = len(expired)
+			return 0
+		}); 0 != errc || n < trashSweepBatch {
+			return errc
+		}
+	}
+}
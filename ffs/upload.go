@@ -0,0 +1,154 @@
+package ffs
+
+import (
+	"bytes"
+	"context"
+
+	"bazil.org/bazil/cas"
+	"bazil.org/bazil/cas/blobs"
+	"bazil.org/bazil/cas/chunks"
+	"github.com/advanderveer/dfs/ffs/dedup"
+	"github.com/advanderveer/dfs/ffs/scrub"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//putChunksMax bounds the nr of bytes of chunks that are put per request,
+//clients send batches of at most dedup.MaxBatch
+const putChunksMax = 64 * 1024 * 1024
+
+//MissingChunks returns the indexes of the chunks in refs that are not in the
+//chunk store yet, clients only need to send those when uploading. Chunk
+//stores that can tell whether they hold a chunk are asked so, others have to
+//read it.
+func (self *Memfs) MissingChunks(refs []dedup.Ref) (errc int, missing []int) {
+	defer trace(len(refs))(&errc, &missing)
+	hstore, canHas := self.cstore.(interface {
+		Has(ctx context.Context, key cas.Key) (bool, error)
+	})
+
+	ctx := context.Background()
+	for i, ref := range refs {
+		key := cas.NewKey(ref.Key)
+		if key.IsSpecial() {
+			continue //special keys are never stored
+		}
+
+		if canHas {
+			has, err := hstore.Has(ctx, key)
+			if err != nil {
+				return -fuse.EIO, nil
+			}
+			if !has {
+				missing = append(missing, i)
+			}
+
+			continue
+		}
+
+		if _, err := self.cstore.Get(ctx, key, ref.Type, ref.Level); err != nil {
+			if _, ok := err.(*chunks.NotFoundError); !ok {
+				return -fuse.EIO, nil
+			}
+
+			missing = append(missing, i)
+		}
+	}
+
+	return 0, missing
+}
+
+//PutChunks adds chunks to the chunk store, it fails with EINVAL if the key a
+//client computed for a chunk doesn't match its content. Chunks are shared by
+//content so they are only charged to the actor once a manifest that refers to
+//them is committed, until then an actor can only put as much as its quota
+//has room for and at most putChunksMax per request (E2BIG).
+func (self *Memfs) PutChunks(cs []dedup.Chunk) (errc int) {
+	defer trace(len(cs))(&errc)
+	size := int64(0)
+	for _, c := range cs {
+		size += int64(len(c.Buf))
+	}
+
+	if size > putChunksMax {
+		return -fuse.E2BIG
+	}
+
+	if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		return self.reserve(tx, self.actor, size, 0)
+	}); 0 != errc {
+		return errc
+	}
+
+	ctx := context.Background()
+	for _, c := range cs {
+		key, err := self.cstore.Add(ctx, &chunks.Chunk{Type: c.Type, Level: c.Level, Buf: c.Buf})
+		if err != nil {
+			return -fuse.EIO
+		}
+
+		if !bytes.Equal(key.Bytes(), c.Key) {
+			return -fuse.EINVAL
+		}
+	}
+
+	return 0
+}
+
+//CommitManifest makes the uploaded blob described by m the content of the
+//existing file at path, its chunks must have been put before
+func (self *Memfs) CommitManifest(path string, m dedup.Manifest) (errc int) {
+	defer trace(path, m.Size)(&errc)
+	bm := m.Blob()
+	if errc = self.verifyBlob(bm); 0 != errc {
+		return errc
+	}
+
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, path, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+		if fuse.S_IFREG != node.Stat(tx).Mode&fuse.S_IFMT {
+			return -fuse.EISDIR
+		}
+		if readOnly(path) {
+			return -fuse.EROFS
+		}
+		if self.leased(tx, path) {
+			return -fuse.EACCES
+		}
+
+		if errc = self.resize(tx, path, node, int64(m.Size)); 0 != errc {
+			return errc
+		}
+
+		node.SetManifest(tx, bm, nil)
+		tmsp := fuse.Now()
+		node.StatSetCTim(tx, tmsp)
+		node.StatSetMTim(tx, tmsp)
+		self.emit(tx, "write", path, "", node)
+		return 0
+	})
+}
+
+//verifyBlob checks that every chunk of a blob is stored and intact, a
+//missing chunk means the upload is incomplete. It reads the whole blob so it
+//is called outside of a transaction.
+func (self *Memfs) verifyBlob(m *blobs.Manifest) int {
+	if m.ChunkSize < 1 || m.Fanout < 2 {
+		return -fuse.EINVAL
+	}
+
+	err := scrub.Walk(context.Background(), self.cstore, m, func(ref scrub.Ref, size int, err error) error {
+		return err
+	})
+
+	if _, ok := err.(*chunks.NotFoundError); ok {
+		return -fuse.ENOENT
+	} else if err != nil {
+		return -fuse.EIO
+	}
+
+	return 0
+}
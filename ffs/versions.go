@@ -0,0 +1,216 @@
+package ffs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//VersionsDir is the read-only virtual directory in the root that mirrors the
+//tree, for each file it holds a directory with a file per previous version:
+// /.versions/a/b.txt/3 is version 3 of /a/b.txt
+const VersionsDir = ".versions"
+
+//RestoreXAttr restores the version with the id written to it as the current
+//content of a file
+const RestoreXAttr = "user.dfs.restore"
+
+//vnode is a node in the virtual versions tree
+type vnode struct {
+	node *nodes.Node
+	ver  *nodes.Version
+	file bool //the node is a file, listed as a directory of its versions
+	stat fuse.Stat_t
+}
+
+//versionsPath returns whether path lies in the versions directory and if
+//so the path of the real node it mirrors
+func versionsPath(path string) (rest string, ok bool) {
+	return virtualPath(path, VersionsDir)
+}
+
+//virtualPath returns whether path lies in the virtual directory dir of the
+//root and if so the remainder of the path below it
+func virtualPath(path string, dir string) (rest string, ok bool) {
+	path = strings.TrimLeft(path, "/")
+	if path != dir && !strings.HasPrefix(path, dir+"/") {
+		return "", false
+	}
+
+	return "/" + strings.TrimLeft(strings.TrimPrefix(path, dir), "/"), true
+}
+
+//lookupVersion resolves a path in the versions tree, rest is the path of
+//the real node as returned by versionsPath
+func (self *Memfs) lookupVersion(tx fdb.Transaction, rest string) (vn *vnode, errc int) {
+	_, _, node := self.lookupNode(tx, rest, nil)
+	if nil != node {
+		vn = &vnode{node: node, stat: node.Stat(tx)}
+		switch vn.stat.Mode & fuse.S_IFMT {
+		case fuse.S_IFDIR:
+		case fuse.S_IFREG:
+			vn.file = true
+			vn.stat.Nlink = 2
+			vn.stat.Size, vn.stat.Blocks = 0, 0
+		default:
+			return nil, -fuse.ENOENT
+		}
+
+		vn.stat.Mode = fuse.S_IFDIR | 00555
+		return vn, 0
+	}
+
+	idx := strings.LastIndex(rest, "/")
+	id, err := strconv.ParseUint(rest[idx+1:], 10, 64)
+	if err != nil {
+		return nil, -fuse.ENOENT
+	}
+
+	_, _, node = self.lookupNode(tx, rest[:idx], nil)
+	if nil == node || fuse.S_IFREG != node.Stat(tx).Mode&fuse.S_IFMT {
+		return nil, -fuse.ENOENT
+	}
+
+	ver := node.Version(tx, id)
+	if nil == ver {
+		return nil, -fuse.ENOENT
+	}
+
+	vn = &vnode{node: node, ver: ver, stat: node.Stat(tx)}
+	tmsp := fuse.NewTimespec(ver.Time)
+	vn.stat.Mode = fuse.S_IFREG | 00444
+	vn.stat.Size, vn.stat.Blocks = ver.Size, (ver.Size+511)/512
+	vn.stat.Nlink = 1
+	vn.stat.Mtim, vn.stat.Ctim = tmsp, tmsp
+	return vn, 0
+}
+
+//openVersion opens a node of the versions tree for reading
+func (self *Memfs) openVersion(tx fdb.Transaction, rest string, dir bool, flags int) (int, uint64) {
+	if writable(flags) || 0 != flags&fuse.O_TRUNC {
+		return -fuse.EROFS, ^uint64(0)
+	}
+
+	vn, errc := self.lookupVersion(tx, rest)
+	if 0 != errc {
+		return errc, ^uint64(0)
+	}
+	if !dir && nil == vn.ver {
+		return -fuse.EISDIR, ^uint64(0)
+	}
+	if dir && nil != vn.ver {
+		return -fuse.ENOTDIR, ^uint64(0)
+	}
+
+	var id uint64
+	if nil != vn.ver {
+		id = vn.ver.ID
+	}
+
+	vn.node.IncOpencnt(tx)
+	hndl := self.hstore.OpenVersion(tx, vn.node, id, flags, self.sess)
+	return 0, hndl.ID
+}
+
+//readdirVersion lists a directory of the versions tree
+func (self *Memfs) readdirVersion(tx fdb.Transaction, rest string, fill func(name string, stat *fuse.Stat_t, ofst int64) bool) int {
+	vn, errc := self.lookupVersion(tx, rest)
+	if 0 != errc {
+		return errc
+	}
+	if nil != vn.ver {
+		return -fuse.ENOTDIR
+	}
+
+	fill(".", &vn.stat, 0)
+	fill("..", nil, 0)
+	if vn.file {
+		for _, ver := range vn.node.Versions(tx) {
+			name := strconv.FormatUint(ver.ID, 10)
+			cvn, errc := self.lookupVersion(tx, strings.TrimRight(rest, "/")+"/"+name)
+			if 0 != errc {
+				continue
+			}
+
+			if !fill(name, &cvn.stat, 0) {
+				break
+			}
+		}
+
+		return 0
+	}
+
+	vn.node.ChldEach(tx, func(name string, chld *nodes.Node) (stop bool) {
+		cvn, errc := self.lookupVersion(tx, strings.TrimRight(rest, "/")+"/"+name)
+		if 0 != errc {
+			return //only files and directories are mirrored
+		}
+
+		return !fill(name, &cvn.stat, 0)
+	})
+
+	return 0
+}
+
+//restoreVersion makes the version with the provided id the current content
+//of the file at path, the id is formatted as a decimal number
+func (self *Memfs) restoreVersion(tx fdb.Transaction, path string, node *nodes.Node, value []byte) int {
+	id, err := strconv.ParseUint(strings.TrimSpace(string(value)), 10, 64)
+	if err != nil {
+		return -fuse.EINVAL
+	}
+	if fuse.S_IFREG != node.Stat(tx).Mode&fuse.S_IFMT {
+		return -fuse.EINVAL
+	}
+
+	ver := node.Version(tx, id)
+	if nil == ver {
+		return -fuse.ENOENT
+	}
+
+	if errc := self.resize(tx, path, node, ver.Size); 0 != errc {
+		return errc
+	}
+
+	if errc := node.RestoreVersion(tx, self.cstore, ver); 0 != errc {
+		return errc
+	}
+
+	tmsp := fuse.Now()
+	node.StatSetCTim(tx, tmsp)
+	node.StatSetMTim(tx, tmsp)
+	self.emit(tx, "restore", path, "", node)
+	return 0
+}
+
+//Versions returns the previous versions of the file at path, newest first
+func (self *Memfs) Versions(path string) (errc int, vers []*nodes.Version) {
+	defer trace(path)(&errc, &vers)
+	errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		_, _, node := self.lookupNode(tx, path, nil)
+		if nil == node {
+			return -fuse.ENOENT
+		}
+		if fuse.S_IFREG != node.Stat(tx).Mode&fuse.S_IFMT {
+			return -fuse.EINVAL
+		}
+
+		vers = node.Versions(tx)
+		return 0
+	})
+
+	return errc, vers
+}
+
+//SetRetention configures how many previous versions of files are kept
+func (self *Memfs) SetRetention(count int, age time.Duration) (errc int) {
+	defer trace(count, age)(&errc)
+	return self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+		self.nstore.SetRetention(tx, nodes.Retention{Count: count, Age: age})
+		return 0
+	})
+}
@@ -0,0 +1,554 @@
+package volumes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"bazil.org/bazil/kv"
+	"github.com/advanderveer/dfs/ffs"
+	"github.com/advanderveer/dfs/ffs/chunkstore"
+	"github.com/advanderveer/dfs/ffs/crypt"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	homedir "github.com/mitchellh/go-homedir"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+var (
+	//ErrNotExist is returned when no volume with the requested name exists
+	ErrNotExist = errors.New("volume does not exist")
+
+	//ErrExist is returned when a volume with the requested name already exists
+	ErrExist = errors.New("volume already exists")
+
+	//ErrInvalidName is returned for names that can't be used for a volume
+	ErrInvalidName = errors.New("invalid volume name, use letters, digits, '.', '_' and '-'")
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,127}$`)
+
+//Config determines how a volume stores its data, the chunk dir defaults to
+//a directory named after the volume id in the managers dir
+type Config struct {
+	chunkstore.Config
+
+	//Chunking selects how file content is split in chunks, "cdc" for
+	//content-defined chunks or fixed size chunks otherwise
+	Chunking string `json:"chunking,omitempty"`
+
+	//ChunkSize is the (average) size of chunks, zero selects the default
+	ChunkSize uint32 `json:"chunk_size,omitempty"`
+
+	//Encrypted volumes encrypt their chunks and xattr values with a keyring
+	//that is stored in the key dir, never in the cluster itself
+	Encrypted bool `json:"encrypted,omitempty"`
+
+	//KeyFingerprint identifies the keyring an encrypted volume was created with
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
+}
+
+//Volume describes a named filesystem, it is identified by an id that never
+//changes such that it can be renamed without moving its data
+type Volume struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Config  Config    `json:"config"`
+}
+
+//Manager keeps track of the volumes that are stored in a FDB cluster
+type Manager struct {
+	db  fdb.Database
+	dir string
+	ss  directory.DirectorySubspace
+
+	mu     sync.Mutex
+	stores map[string]*chunkstore.Store
+	keys   map[string]*crypt.Keyring
+}
+
+//NewManager opens the volume manager, chunks of volumes without an explicit
+//chunk directory are stored below dir. Keys of encrypted volumes are stored
+//in the directory set by FFS_KEY_DIR or else in the "keys" directory below dir.
+func NewManager(db fdb.Database, dir string) (m *Manager, err error) {
+	m = &Manager{db: db, dir: dir, stores: map[string]*chunkstore.Store{}, keys: map[string]*crypt.Keyring{}}
+	m.ss, err = directory.CreateOrOpen(db, []string{"ffs_volumes"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open volumes directory: %v", err)
+	}
+
+	return m, nil
+}
+
+//keyPath returns where the keyring of the volume with id is stored
+func (m *Manager) keyPath(id string) string {
+	dir := os.Getenv("FFS_KEY_DIR")
+	if dir == "" {
+		dir = filepath.Join(m.dir, "keys")
+	}
+
+	return filepath.Join(dir, id+".json")
+}
+
+//keyring loads the keyring of encrypted volume v, it returns an error that
+//wraps crypt.ErrKeyMissing when the key can't be found
+func (m *Manager) keyring(v *Volume) (kr *crypt.Keyring, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if kr, ok := m.keys[v.ID]; ok {
+		return kr, nil
+	}
+
+	if kr, err = crypt.Load(m.keyPath(v.ID)); err != nil {
+		return nil, fmt.Errorf("volume '%s' is encrypted: %v", v.Name, err)
+	}
+
+	if kr.Fingerprint() != v.Config.KeyFingerprint {
+		return nil, fmt.Errorf("volume '%s' is encrypted: %v: %s", v.Name, crypt.ErrWrongKey, m.keyPath(v.ID))
+	}
+
+	m.keys[v.ID] = kr
+	return kr, nil
+}
+
+func (m *Manager) nameKey(name string) fdb.Key {
+	return m.ss.Pack(tuple.Tuple{"names", name})
+}
+
+func (m *Manager) volKey(id string) fdb.Key {
+	return m.ss.Pack(tuple.Tuple{"vols", id})
+}
+
+func (m *Manager) get(tx fdb.ReadTransaction, name string) (v *Volume, err error) {
+	id := tx.Get(m.nameKey(name)).MustGet()
+	if len(id) < 1 {
+		return nil, ErrNotExist
+	}
+
+	d := tx.Get(m.volKey(string(id))).MustGet()
+	if len(d) < 1 {
+		return nil, ErrNotExist
+	}
+
+	v = &Volume{}
+	if err = json.Unmarshal(d, v); err != nil {
+		return nil, fmt.Errorf("failed to decode volume '%s': %v", name, err)
+	}
+
+	return v, nil
+}
+
+func (m *Manager) put(tx fdb.Transaction, v *Volume) (err error) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode volume: %v", err)
+	}
+
+	tx.Set(m.nameKey(v.Name), []byte(v.ID))
+	tx.Set(m.volKey(v.ID), d)
+	return nil
+}
+
+//Create a new volume with the provided name and config
+func (m *Manager) Create(name string, cfg Config) (v *Volume, err error) {
+	if !validName.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate volume id: %v", err)
+	}
+
+	v = &Volume{ID: uid.String(), Name: name, Created: time.Now(), Config: cfg}
+	if v.Config.ChunkDir == "" {
+		v.Config.ChunkDir = filepath.Join(m.dir, v.ID)
+	}
+
+	if v.Config.Encrypted {
+		kr := crypt.NewKeyring()
+		if err = kr.Save(m.keyPath(v.ID)); err != nil {
+			return nil, err
+		}
+
+		v.Config.KeyFingerprint = kr.Fingerprint()
+	}
+
+	if _, err = m.db.Transact(func(tx fdb.Transaction) (r interface{}, err error) {
+		if _, err = m.get(tx, name); err != ErrNotExist {
+			if err == nil {
+				err = ErrExist
+			}
+
+			return nil, err
+		}
+
+		return nil, m.put(tx, v)
+	}); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+//Get returns the volume with the provided name
+func (m *Manager) Get(name string) (v *Volume, err error) {
+	_, err = m.db.ReadTransact(func(tx fdb.ReadTransaction) (r interface{}, err error) {
+		v, err = m.get(tx, name)
+		return
+	})
+
+	return v, err
+}
+
+//List all volumes ordered by name
+func (m *Manager) List() (vols []*Volume, err error) {
+	_, err = m.db.ReadTransact(func(tx fdb.ReadTransaction) (r interface{}, err error) {
+		vols = nil
+		kvs := tx.GetRange(m.ss.Sub("vols"), fdb.RangeOptions{}).GetSliceOrPanic()
+		for _, kv := range kvs {
+			v := &Volume{}
+			if err = json.Unmarshal(kv.Value, v); err != nil {
+				return nil, fmt.Errorf("failed to decode volume: %v", err)
+			}
+
+			vols = append(vols, v)
+		}
+
+		return
+	})
+
+	sort.Slice(vols, func(i, j int) bool { return vols[i].Name < vols[j].Name })
+	return vols, err
+}
+
+//Rename the volume with name old to new, its data stays where it is
+func (m *Manager) Rename(old, new string) (err error) {
+	if !validName.MatchString(new) {
+		return ErrInvalidName
+	}
+
+	_, err = m.db.Transact(func(tx fdb.Transaction) (r interface{}, err error) {
+		v, err := m.get(tx, old)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = m.get(tx, new); err != ErrNotExist {
+			if err == nil {
+				err = ErrExist
+			}
+
+			return nil, err
+		}
+
+		tx.Clear(m.nameKey(old))
+		v.Name = new
+		return nil, m.put(tx, v)
+	})
+
+	return err
+}
+
+//Delete the volume with the provided name including all its nodes and
+//chunks, the volume should not be in use. Other files in a chunk dir that
+//was provided when the volume was created are left in place.
+func (m *Manager) Delete(name string) (err error) {
+	v, err := m.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = m.ss.Remove(m.db, []string{"data", v.ID}); err != nil {
+		return fmt.Errorf("failed to remove volume data: %v", err)
+	}
+
+	if _, err = m.db.Transact(func(tx fdb.Transaction) (r interface{}, err error) {
+		tx.Clear(m.nameKey(v.Name))
+		tx.Clear(m.volKey(v.ID))
+		return
+	}); err != nil {
+		return fmt.Errorf("failed to remove volume: %v", err)
+	}
+
+	m.mu.Lock()
+	if s, ok := m.stores[v.ID]; ok {
+		s.Close()
+		delete(m.stores, v.ID)
+	}
+	m.mu.Unlock()
+
+	//a chunk dir that was provided when the volume was created may hold other
+	//data, only one the manager created is removed as a whole
+	if v.Config.ChunkDir == filepath.Join(m.dir, v.ID) {
+		err = os.RemoveAll(v.Config.ChunkDir)
+	} else {
+		err = chunkstore.Remove(v.Config.Config)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to remove chunks: %v", err)
+	}
+
+	if v.Config.Encrypted {
+		m.mu.Lock()
+		delete(m.keys, v.ID)
+		m.mu.Unlock()
+		if err = os.Remove(m.keyPath(v.ID)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove key: %v", err)
+		}
+	}
+
+	return nil
+}
+
+//RotateKey adds a new key to the keyring of an encrypted volume, data that
+//is written from now on is encrypted with it. Existing data is not
+//re-encrypted, older keys stay in the keyring to read it.
+func (m *Manager) RotateKey(name string) (id uint32, err error) {
+	v, err := m.Get(name)
+	if err != nil {
+		return 0, err
+	}
+
+	if !v.Config.Encrypted {
+		return 0, fmt.Errorf("volume '%s' is not encrypted", name)
+	}
+
+	kr, err := m.keyring(v)
+	if err != nil {
+		return 0, err
+	}
+
+	k := kr.Rotate()
+	if err = kr.Save(m.keyPath(v.ID)); err != nil {
+		return 0, err
+	}
+
+	return k.ID, nil
+}
+
+//chunks opens the chunk store of volume v, stores stay open for the lifetime
+//of the manager as some backends can only be opened once
+func (m *Manager) chunks(v *Volume) (cstore *chunkstore.Store, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cstore, ok := m.stores[v.ID]; ok {
+		return cstore, nil
+	}
+
+	if cstore, err = chunkstore.Open(v.Config.Config); err != nil {
+		return nil, err
+	}
+
+	m.stores[v.ID] = cstore
+	return cstore, nil
+}
+
+//Open the filesystem of the volume with the provided name
+func (m *Manager) Open(name string) (fs *ffs.Memfs, err error) {
+	v, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var kr *crypt.Keyring
+	if v.Config.Encrypted {
+		if kr, err = m.keyring(v); err != nil {
+			return nil, err
+		}
+
+		v.Config.Keyring = kr
+	}
+
+	cstore, err := m.chunks(v)
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := m.ss.CreateOrOpen(m.db, []string{"data", v.ID}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open volume directory: %v", err)
+	}
+
+	if fs, err = ffs.NewStoreFS(m.db, ss, cstore); err != nil {
+		return nil, err
+	}
+
+	if v.Config.Chunking != "" || v.Config.ChunkSize != 0 {
+		if errc := fs.SetChunking(v.Config.Chunking, v.Config.ChunkSize); errc != 0 {
+			return nil, fmt.Errorf("failed to configure chunking: %d", errc)
+		}
+	}
+
+	if kr != nil {
+		fs.SetSealer(kr)
+	}
+
+	return fs, nil
+}
+
+//Backup copies volume name to the backup target selected by cfg as a new
+//snapshot, only chunks the target doesn't have yet are copied. Snapshots are
+//stored under the ID of the volume such that volumes can share a target. Backups of an
+//encrypted volume are encrypted with its keyring, which is needed again to
+//restore them.
+func (m *Manager) Backup(name string, cfg chunkstore.Config) (rep *ffs.BackupReport, err error) {
+	v, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Config.Encrypted {
+		if cfg.Keyring, err = m.keyring(v); err != nil {
+			return nil, err
+		}
+	}
+
+	fs, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := chunkstore.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup target: %v", err)
+	}
+
+	defer target.Close()
+	errc, rep := fs.Backup(target, v.ID)
+	if errc != 0 {
+		return nil, fmt.Errorf("failed to back up volume '%s': %d", name, errc)
+	}
+
+	//the location of the chunks is not backed up, a restore picks its own
+	bcfg := Config{Chunking: v.Config.Chunking, ChunkSize: v.Config.ChunkSize, Encrypted: v.Config.Encrypted}
+	bcfg.Compression = v.Config.Compression
+	d, err := json.Marshal(bcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode volume config: %v", err)
+	}
+
+	if err = target.PutObject(context.Background(), backupConfigName(v.ID, rep.Snapshot), d); err != nil {
+		return nil, fmt.Errorf("failed to store volume config: %v", err)
+	}
+
+	return rep, nil
+}
+
+//backupConfigName is the object that a backup stores the config of the
+//volume with id under next to its snapshot seq
+func backupConfigName(id string, seq uint64) string {
+	if id == "" {
+		return fmt.Sprintf("ffs-backup/volume/%d", seq)
+	}
+
+	return fmt.Sprintf("ffs-backup/%s/volume/%d", id, seq)
+}
+
+//inheritConfig returns vcfg with the settings it leaves empty taken from the
+//config of the volume with id that snapshot seq of target was made of. Snapshots of
+//older backups don't record it, an encrypted target still means the volume
+//was encrypted.
+func inheritConfig(target *chunkstore.Store, id string, seq uint64, encrypted bool, vcfg Config) (Config, error) {
+	vcfg.Encrypted = vcfg.Encrypted || encrypted
+	d, err := target.GetObject(context.Background(), backupConfigName(id, seq))
+	if _, ok := err.(kv.NotFoundError); ok {
+		return vcfg, nil
+	} else if err != nil {
+		return vcfg, fmt.Errorf("failed to read volume config: %v", err)
+	}
+
+	bcfg := Config{}
+	if err = json.Unmarshal(d, &bcfg); err != nil {
+		return vcfg, fmt.Errorf("failed to decode volume config: %v", err)
+	}
+
+	vcfg.Encrypted = vcfg.Encrypted || bcfg.Encrypted
+	if vcfg.Chunking == "" && vcfg.ChunkSize == 0 {
+		vcfg.Chunking, vcfg.ChunkSize = bcfg.Chunking, bcfg.ChunkSize
+	}
+
+	if vcfg.Compression == "" {
+		vcfg.Compression = bcfg.Compression
+	}
+
+	return vcfg, nil
+}
+
+//Restore creates volume name with vcfg and rebuilds snapshot seq of the
+//volume with id src in the backup target selected by cfg in it, the latest
+//snapshot is restored when seq is zero. Backups that were made before
+//snapshots were stored per volume are restored with an empty src. Encryption, chunking and compression that vcfg leaves empty
+//are those of the volume that was backed up. The volume is deleted again
+//when the restore fails.
+func (m *Manager) Restore(cfg chunkstore.Config, src string, seq uint64, name string, vcfg Config) (rep *ffs.RestoreReport, err error) {
+	target, err := chunkstore.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup target: %v", err)
+	}
+
+	defer target.Close()
+	if seq == 0 {
+		if seq, err = ffs.LatestSnapshot(context.Background(), target, src); err != nil {
+			return nil, fmt.Errorf("failed to find latest snapshot: %v", err)
+		} else if seq == 0 {
+			return nil, fmt.Errorf("backup target holds no snapshots of volume '%s'", src)
+		}
+	}
+
+	if vcfg, err = inheritConfig(target, src, seq, cfg.Keyring != nil, vcfg); err != nil {
+		return nil, err
+	}
+
+	if _, err = m.Create(name, vcfg); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	errc, rep := fs.Restore(target, src, seq)
+	if errc != 0 {
+		m.Delete(name)
+		return nil, fmt.Errorf("failed to restore snapshot %d: %d", seq, errc)
+	}
+
+	return rep, nil
+}
+
+//OpenOrCreate opens the volume with the provided name, it is created with
+//the default config if it doesn't exist yet
+func (m *Manager) OpenOrCreate(name string) (fs *ffs.Memfs, err error) {
+	if _, err = m.Create(name, Config{}); err != nil && err != ErrExist {
+		return nil, err
+	}
+
+	return m.Open(name)
+}
+
+//DefaultDir returns the directory that holds the chunks of volumes, it can
+//be configured with the FFS_DATA_DIR environment variable
+func DefaultDir() (dir string, err error) {
+	if dir = os.Getenv("FFS_DATA_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	hdir, err := homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+
+	return filepath.Join(hdir, ".ffs", "volumes"), nil
+}
@@ -0,0 +1,252 @@
+package volumes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/dfs/ffs/chunkstore"
+	"github.com/advanderveer/dfs/ffs/crypt"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+func TestManager(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "ffs_volumes_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	vm, err := NewManager(db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = vm.Create("../foo", Config{}); err != ErrInvalidName {
+		t.Fatalf("expected invalid name, got: %v", err)
+	}
+
+	v, err := vm.Create("test-vol", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer vm.Delete("test-vol2")
+	if _, err = vm.Create("test-vol", Config{}); err != ErrExist {
+		t.Fatalf("expected volume to exist, got: %v", err)
+	}
+
+	fs, err := vm.Open("test-vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errc := fs.Mkdir("/foo", 0777); errc != 0 {
+		t.Fatalf("failed to mkdir: %d", errc)
+	}
+
+	if err = vm.Rename("test-vol", "test-vol2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = vm.Open("test-vol"); err != ErrNotExist {
+		t.Fatalf("expected volume to not exist, got: %v", err)
+	}
+
+	vols, err := vm.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, vol := range vols {
+		if vol.ID == v.ID {
+			found = vol.Name == "test-vol2"
+		}
+	}
+
+	if !found {
+		t.Fatalf("expected renamed volume in list, got: %v", vols)
+	}
+
+	fs, err = vm.Open("test-vol2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &fuse.Stat_t{}
+	if errc := fs.Getattr("/foo", st, ^uint64(0)); errc != 0 {
+		t.Fatalf("expected data to survive the rename, got: %d", errc)
+	}
+
+	if err = vm.Delete("test-vol2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(v.Config.ChunkDir); !os.IsNotExist(err) {
+		t.Fatalf("expected chunk dir to be removed, got: %v", err)
+	}
+}
+
+func TestEncryptedVolume(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "ffs_volumes_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	vm, err := NewManager(db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{Encrypted: true}
+	cfg.Convergent = true
+	v, err := vm.Create("test-enc", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer vm.Delete("test-enc")
+	if v.Config.KeyFingerprint == "" {
+		t.Fatal("expected key fingerprint to be recorded")
+	}
+
+	fs, err := vm.Open("test-enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errc := fs.Mknod("/secret", 0666, 0); errc != 0 {
+		t.Fatalf("failed to mknod: %d", errc)
+	}
+
+	if errc := fs.Setxattr("/secret", "user.label", []byte("classified"), 0); errc != 0 {
+		t.Fatalf("failed to set xattr: %d", errc)
+	}
+
+	if id, err := vm.RotateKey("test-enc"); err != nil || id != 2 {
+		t.Fatalf("expected key to be rotated, got: %d, %v", id, err)
+	}
+
+	if errc, xatr := fs.Getxattr("/secret", "user.label"); errc != 0 || string(xatr) != "classified" {
+		t.Fatalf("expected xattr to be readable after rotation, got: %d %q", errc, xatr)
+	}
+
+	other, err := NewManager(db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Rename(vm.keyPath(v.ID), vm.keyPath(v.ID)+".bak"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = other.Open("test-enc"); err == nil || !strings.Contains(err.Error(), crypt.ErrKeyMissing.Error()) {
+		t.Fatalf("expected missing key error, got: %v", err)
+	}
+
+	if err = os.Rename(vm.keyPath(v.ID)+".bak", vm.keyPath(v.ID)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	fdb.MustAPIVersion(510)
+	db, err := fdb.OpenDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "ffs_volumes_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	vm, err := NewManager(db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := vm.OpenOrCreate("test-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer vm.Delete("test-src")
+	if errc := fs.Mkdir("/foo", 0755); errc != 0 {
+		t.Fatalf("failed to mkdir: %d", errc)
+	}
+
+	src, err := vm.Get("test-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := chunkstore.Config{ChunkDir: filepath.Join(dir, "backup")}
+	if _, err = vm.Restore(target, src.ID, 0, "test-dst", Config{}); err == nil {
+		t.Fatal("expected restore of an empty target to fail")
+	}
+
+	if rep, err := vm.Backup("test-src", target); err != nil || rep.Snapshot != 1 {
+		t.Fatalf("expected first snapshot, got: %+v, %v", rep, err)
+	}
+
+	if _, err = vm.Restore(target, src.ID, 0, "test-dst", Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer vm.Delete("test-dst")
+	dst, err := vm.Open("test-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &fuse.Stat_t{}
+	if errc := dst.Getattr("/foo", st, ^uint64(0)); errc != 0 || st.Mode != fuse.S_IFDIR|0755 {
+		t.Fatalf("expected restored dir, got: %d, %o", errc, st.Mode)
+	}
+
+	t.Run("config of the volume", func(t *testing.T) {
+		v, err := vm.Create("test-enc-src", Config{Encrypted: true, Chunking: "cdc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer vm.Delete("test-enc-src")
+		target := chunkstore.Config{ChunkDir: filepath.Join(dir, "backup-enc")}
+		if _, err = vm.Backup("test-enc-src", target); err != nil {
+			t.Fatal(err)
+		}
+
+		if target.Keyring, err = vm.keyring(v); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = vm.Restore(target, v.ID, 0, "test-enc-dst", Config{}); err != nil {
+			t.Fatal(err)
+		}
+
+		defer vm.Delete("test-enc-dst")
+		rv, err := vm.Get("test-enc-dst")
+		if err != nil || !rv.Config.Encrypted || rv.Config.Chunking != "cdc" {
+			t.Fatalf("expected the encryption and chunking of the backed up volume, got: %+v, %v", rv, err)
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package ffs
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"bazil.org/bazil/cas/blobs"
+	"github.com/advanderveer/dfs/ffs/nodes"
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+//XAttrNameMax is the longest name of an extended attribute, like on Linux
+const XAttrNameMax = 255
+
+//XAttrSizeMax is the largest value of an extended attribute, values that
+//don't fit in the metadata are stored in the chunk store
+const XAttrSizeMax = 64 * 1024 * 1024
+
+//Namespaces of extended attributes that have rules of their own, names
+//without one of these prefixes (such as those used on macOS) are stored like
+//user attributes
+const (
+	xattrUser     = "user."
+	xattrTrusted  = "trusted."
+	xattrSecurity = "security."
+	xattrSystem   = "system."
+)
+
+//Extended attributes that macOS gives a meaning of their own. The Finder info
+//is a fixed size record that is absent while it is all zeros, the resource
+//fork is the only attribute that is read and written at a position and it is
+//absent while it is empty.
+const (
+	FinderInfoXAttr   = "com.apple.FinderInfo"
+	ResourceForkXAttr = "com.apple.ResourceFork"
+)
+
+//finderInfoSize is the size of the Finder info record
+const finderInfoSize = 32
+
+//xattrValue returns what an xattr becomes when value is written into it at
+//position, a nil value means the attribute is to be removed. Writing the
+//resource fork at position 0 replaces it, like HFS+ does, while writing it
+//further along keeps what comes before.
+func (self *Memfs) xattrValue(tx fdb.Transaction, node *nodes.Node, name string, value []byte, position uint32) (errc int, xatr []byte) {
+	if errc = xattrCheck(tx, node, name, value); 0 != errc {
+		return errc, nil
+	}
+
+	switch name {
+	case FinderInfoXAttr:
+		if bytes.Equal(value, make([]byte, finderInfoSize)) {
+			return 0, nil
+		}
+	case ResourceForkXAttr:
+		if position > 0 {
+			if errc, xatr = node.XAtrOpen(tx, self.cstore, name); 0 != errc && -fuse.ENOATTR != errc {
+				return errc, nil
+			}
+		}
+	}
+
+	if int(position)+len(value) > XAttrSizeMax {
+		return -fuse.E2BIG, nil
+	}
+
+	xatr = splice(xatr, value, position)
+	if ResourceForkXAttr == name && 0 == len(xatr) {
+		return 0, nil
+	}
+
+	return 0, xatr
+}
+
+//xattrCheck checks whether value can be written to the xattr name of node
+func xattrCheck(tx fdb.Transaction, node *nodes.Node, name string, value []byte) int {
+	switch name {
+	case FinderInfoXAttr:
+		if len(value) != finderInfoSize {
+			return -fuse.ERANGE
+		}
+	case ResourceForkXAttr:
+		if fuse.S_IFREG != node.Stat(tx).Mode&fuse.S_IFMT {
+			return -fuse.EPERM
+		}
+	}
+
+	return 0
+}
+
+//splice writes value into xatr at position, growing it as necessary
+func splice(xatr, value []byte, position uint32) []byte {
+	if end := int(position) + len(value); len(xatr) < end {
+		xatr = append(xatr, make([]byte, end-len(xatr))...)
+	}
+
+	copy(xatr[position:], value)
+	return xatr
+}
+
+//xattrBlob writes what the xattr name at path becomes when value is written
+//into it at position to the chunk store when it is too large to be stored
+//with the metadata. It is called before the transaction that sets the xattr
+//such that no chunks are written in it, values that are written at a
+//position are merged with the current value and stamp tells whether that is
+//still current in the transaction.
+func (self *Memfs) xattrBlob(path string, name string, value []byte, position uint32) (errc int, m *blobs.Manifest, stamp []byte) {
+	end := int(position) + len(value)
+	if end > XAttrSizeMax || (0 != position && ResourceForkXAttr != name) || (0 == position && end <= nodes.InlineLimit) {
+		return 0, nil, nil //refused or stored with the metadata in the transaction
+	}
+
+	xatr := value
+	if 0 < position {
+		if errc = self.nstore.TxWithErrc(func(tx fdb.Transaction) (errc int) {
+			xatr, stamp = nil, nil
+			_, _, node := self.lookupNode(tx, path, nil)
+			if nil == node {
+				return 0 //reported by the transaction that sets it
+			}
+
+			stamp = node.XAtrStamp(tx, name)
+			if errc, xatr = node.XAtrOpen(tx, self.cstore, name); 0 != errc && -fuse.ENOATTR != errc {
+				return errc
+			}
+
+			return 0
+		}); 0 != errc {
+			return errc, nil, nil
+		}
+
+		xatr = splice(xatr, value, position)
+		if len(xatr) <= nodes.InlineLimit {
+			return 0, nil, stamp
+		}
+	}
+
+	m, err := nodes.WriteXAtr(context.Background(), self.cstore, xatr)
+	if err != nil {
+		return -fuse.EIO, nil, nil
+	}
+
+	return 0, m, stamp
+}
+
+//xattrAccess checks whether the attribute name of node may be accessed,
+//written or only read. User attributes are only supported on files and
+//directories and the system namespace is reserved for ACLs which aren't
+//supported. The server doesn't know the credentials of the caller so trusted
+//attributes are stored like any other, the kernel only forwards them for
+//privileged processes.
+func (self *Memfs) xattrAccess(tx fdb.Transaction, node *nodes.Node, name string, write bool) int {
+	if "" == name {
+		return -fuse.EINVAL
+	}
+	if len(name) > XAttrNameMax {
+		return -fuse.ERANGE
+	}
+
+	for _, ns := range []string{xattrUser, xattrTrusted, xattrSecurity, xattrSystem} {
+		if name == ns {
+			return -fuse.EINVAL //a namespace without a name
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(name, xattrSystem):
+		return -fuse.ENOTSUP
+	case strings.HasPrefix(name, xattrUser):
+		switch node.Stat(tx).Mode & fuse.S_IFMT {
+		case fuse.S_IFREG, fuse.S_IFDIR:
+		default:
+			if write {
+				return -fuse.EPERM
+			}
+
+			return -fuse.ENOATTR
+		}
+	}
+
+	return 0
+}
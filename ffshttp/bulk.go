@@ -0,0 +1,86 @@
+package ffshttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/advanderveer/dfs/ffs"
+)
+
+func (s *Server) startBulk(w http.ResponseWriter, r *http.Request, actor string) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	op, src, dst := r.FormValue("op"), r.FormValue("src"), r.FormValue("dst")
+	if src == "" || (op != ffs.BulkRemove && dst == "") {
+		http.Error(w, "src is required, and dst unless op is rm", http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.b.As(actor).Bulk(op, src, dst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID uint64 `json:"id"`
+	}{id})
+}
+
+func (s *Server) bulkStatus(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query().Get("id")
+	if v == "" {
+		jobs, err := s.b.BulkJobs()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobs)
+		return
+	}
+
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid id '%s'", v), http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.b.BulkStatus(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (s *Server) resumeBulk(w http.ResponseWriter, r *http.Request, actor string) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid id '%s'", r.FormValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	if err = s.b.As(actor).ResumeBulk(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "resumed bulk job %d", id)
+}
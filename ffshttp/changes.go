@@ -0,0 +1,81 @@
+package ffshttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	//changesPoll is how long a following stream waits for new changes
+	//before it checks whether the client is still there
+	changesPoll = time.Second * 10
+
+	//changesBatch is the max nr of changes that are read at once
+	changesBatch = 100
+)
+
+//streamChanges writes the change feed as newline delimited JSON starting
+//after the 'cursor' query parameter. With 'follow' set the response stays
+//open and new changes are written as they happen. Once changes were written
+//an error can't be reported with a status anymore, the connection is aborted
+//instead such that the client doesn't mistake it for the end of the feed.
+func (s *Server) streamChanges(w http.ResponseWriter, r *http.Request) {
+	cursor := r.URL.Query().Get("cursor")
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+	limit := changesBatch
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid limit '%s'", v), http.StatusBadRequest)
+			return
+		}
+
+		limit = n
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc, streamed := json.NewEncoder(w), false
+	for {
+		wait := time.Duration(0)
+		if follow {
+			wait = changesPoll
+		}
+
+		evs, err := s.b.Changes(cursor, limit, wait)
+		if err != nil {
+			if streamed {
+				panic(http.ErrAbortHandler)
+			}
+
+			http.Error(w, fmt.Sprintf("failed to read changes: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		for _, ev := range evs {
+			if err = enc.Encode(ev); err != nil {
+				return //client went away
+			}
+
+			cursor, streamed = ev.Cursor, true
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+			streamed = true
+		}
+
+		if !follow && len(evs) < limit {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package ffshttp
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func (s *Server) clone(w http.ResponseWriter, r *http.Request, actor string) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	src, dst := r.FormValue("src"), r.FormValue("dst")
+	if src == "" || dst == "" {
+		http.Error(w, "src and dst are required", http.StatusBadRequest)
+		return
+	}
+
+	if err = s.b.As(actor).Clone(src, dst); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "cloned '%s' to '%s'", src, dst)
+}
@@ -0,0 +1,27 @@
+package ffshttp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+)
+
+func (s *Server) dirSize(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	ds, err := s.b.DirSize(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Path string `json:"path"`
+		nodes.DirSize
+	}{path, ds})
+}
@@ -0,0 +1,120 @@
+package ffshttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/advanderveer/dfs/ffs"
+	"github.com/advanderveer/dfs/ffs/nodes"
+)
+
+var (
+	//defaultLeaseTTL is used when a lease request doesn't specify a ttl
+	defaultLeaseTTL = time.Hour
+)
+
+//LeaseInfo describes an active lease on a directory
+type LeaseInfo struct {
+	Path string `json:"path"`
+	nodes.Lease
+}
+
+//leaseParams reads the common form parameters of lease requests, leases are
+//held by the authenticated actor such that the anonymous actor can't hold any
+func leaseParams(w http.ResponseWriter, r *http.Request, actor string) (path string, ttl time.Duration, ok bool) {
+	if actor == "" {
+		http.Error(w, "leases can only be held by an authenticated actor", http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	path = r.FormValue("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	ttl = defaultLeaseTTL
+	if v := r.FormValue("ttl"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl '%s'", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	return path, ttl, true
+}
+
+func leaseError(w http.ResponseWriter, err error) {
+	switch err {
+	case ffs.ErrLeaseHeld:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case ffs.ErrNoLease:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) listLeases(w http.ResponseWriter, r *http.Request) {
+	leases := []*LeaseInfo{}
+	if err := s.b.Leases(func(path string, l *nodes.Lease) {
+		leases = append(leases, &LeaseInfo{Path: path, Lease: *l})
+	}); err != nil {
+		leaseError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(leases)
+}
+
+func (s *Server) acquireLease(w http.ResponseWriter, r *http.Request, actor string) {
+	path, ttl, ok := leaseParams(w, r, actor)
+	if !ok {
+		return
+	}
+
+	if err := s.b.AcquireLease(path, actor, r.FormValue("reason"), ttl); err != nil {
+		leaseError(w, err)
+		return
+	}
+
+	fmt.Fprintf(w, "acquired lease on '%s' for %s", path, ttl)
+}
+
+func (s *Server) renewLease(w http.ResponseWriter, r *http.Request, actor string) {
+	path, ttl, ok := leaseParams(w, r, actor)
+	if !ok {
+		return
+	}
+
+	if err := s.b.RenewLease(path, actor, ttl); err != nil {
+		leaseError(w, err)
+		return
+	}
+
+	fmt.Fprintf(w, "renewed lease on '%s' for %s", path, ttl)
+}
+
+func (s *Server) releaseLease(w http.ResponseWriter, r *http.Request, actor string) {
+	path, _, ok := leaseParams(w, r, actor)
+	if !ok {
+		return
+	}
+
+	if err := s.b.ReleaseLease(path, actor); err != nil {
+		leaseError(w, err)
+		return
+	}
+
+	fmt.Fprintf(w, "released lease on '%s'", path)
+}
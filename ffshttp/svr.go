@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"net/rpc"
 	"os"
 	"path/filepath"
 	"strings"
@@ -35,9 +34,11 @@ type Server struct {
 	l  net.Listener
 	r  *mux.Router
 	s  *http.Server
+
+	auth func(r *http.Request) (actor string, err error)
 }
 
-func NewServer(fsrcp *rpc.Server, fsb *ffs.Browser, m *model.Model, addr string) (s *Server, err error) {
+func NewServer(fsrcp http.Handler, fsb *ffs.Browser, m *model.Model, addr string) (s *Server, err error) {
 	s = &Server{b: fsb, m: m}
 	s.l, err = net.Listen("tcp", addr)
 	if err != nil {
@@ -56,6 +57,24 @@ func NewServer(fsrcp *rpc.Server, fsb *ffs.Browser, m *model.Model, addr string)
 	s.r.HandleFunc("/createRun", s.createRun).Name(routeNameCreateRun)
 	s.r.HandleFunc("/listRuns", s.listRuns).Name(routeNameListRuns)
 	s.r.HandleFunc("/events", s.lp.SubscriptionHandler)
+	s.r.HandleFunc("/changes", s.streamChanges).Methods("GET")
+	s.r.HandleFunc("/versions", s.listVersions).Methods("GET")
+	s.r.HandleFunc("/versions/download", s.downloadVersion).Methods("GET")
+	s.r.HandleFunc("/versions/restore", s.authed(s.restoreVersion)).Methods("POST")
+	s.r.HandleFunc("/trash", s.listTrash).Methods("GET")
+	s.r.HandleFunc("/trash/restore", s.authed(s.restoreTrash)).Methods("POST")
+	s.r.HandleFunc("/trash/purge", s.authed(s.purgeTrash)).Methods("POST")
+	s.r.HandleFunc("/du", s.dirSize).Methods("GET")
+	s.r.HandleFunc("/tar", s.downloadTar).Methods("GET")
+	s.r.HandleFunc("/tar", s.authed(s.uploadTar)).Methods("POST")
+	s.r.HandleFunc("/clone", s.authed(s.clone)).Methods("POST")
+	s.r.HandleFunc("/bulk", s.bulkStatus).Methods("GET")
+	s.r.HandleFunc("/bulk", s.authed(s.startBulk)).Methods("POST")
+	s.r.HandleFunc("/bulk/resume", s.authed(s.resumeBulk)).Methods("POST")
+	s.r.HandleFunc("/leases", s.listLeases).Methods("GET")
+	s.r.HandleFunc("/leases/acquire", s.authed(s.acquireLease)).Methods("POST")
+	s.r.HandleFunc("/leases/renew", s.authed(s.renewLease)).Methods("POST")
+	s.r.HandleFunc("/leases/release", s.authed(s.releaseLease)).Methods("POST")
 	s.r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		browse, _ := s.r.Get(routeNameBrowse).URL()
 		listRuns, _ := s.r.Get(routeNameListRuns).URL()
@@ -71,6 +90,31 @@ func NewServer(fsrcp *rpc.Server, fsb *ffs.Browser, m *model.Model, addr string)
 	return s, nil
 }
 
+//WithAuth makes the server authenticate requests that change the filesystem
+//with auth, they change it as the actor it returns. Requests it fails for are
+//refused.
+func (s *Server) WithAuth(auth func(r *http.Request) (actor string, err error)) *Server {
+	s.auth = auth
+	return s
+}
+
+//authed wraps a handler that changes the filesystem such that it is passed the
+//authenticated actor, without auth every request works as the anonymous actor
+func (s *Server) authed(h func(w http.ResponseWriter, r *http.Request, actor string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor := ""
+		if s.auth != nil {
+			var err error
+			if actor, err = s.auth(r); err != nil {
+				http.Error(w, fmt.Sprintf("failed to authenticate: %v", err), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		h(w, r, actor)
+	}
+}
+
 func (s *Server) viewRun(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	run, err := s.m.ViewRun(vars["id"])
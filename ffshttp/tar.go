@@ -0,0 +1,43 @@
+package ffshttp
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+)
+
+func (s *Server) downloadTar(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	name := filepath.Base(path)
+	if name == "/" {
+		name = "root"
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, name))
+	if err := s.b.ExportTar(path, w); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+}
+
+func (s *Server) uploadTar(w http.ResponseWriter, r *http.Request, actor string) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+	n, err := s.b.As(actor).ImportTar(path, r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("imported %d entries: %v", n, err), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "imported %d entries into '%s'", n, path)
+}
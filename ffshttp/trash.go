@@ -0,0 +1,68 @@
+package ffshttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/advanderveer/dfs/ffs/nodes"
+)
+
+//trashID reads the id of the trash entry a request is about
+func trashID(w http.ResponseWriter, r *http.Request) (id uint64, ok bool) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err = strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "a numeric id is required", http.StatusBadRequest)
+		return
+	}
+
+	return id, true
+}
+
+func (s *Server) listTrash(w http.ResponseWriter, r *http.Request) {
+	entries := []*nodes.TrashEntry{}
+	if err := s.b.Trash(func(e *nodes.TrashEntry) {
+		entries = append(entries, e)
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (s *Server) restoreTrash(w http.ResponseWriter, r *http.Request, actor string) {
+	id, ok := trashID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.b.As(actor).RestoreTrash(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "restored trash entry %d", id)
+}
+
+func (s *Server) purgeTrash(w http.ResponseWriter, r *http.Request, actor string) {
+	id, ok := trashID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.b.As(actor).PurgeTrash(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "purged trash entry %d", id)
+}
@@ -0,0 +1,72 @@
+package ffshttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+//versionParams reads the path and version id of a version request
+func versionParams(w http.ResponseWriter, r *http.Request) (path string, id uint64, ok bool) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	path = r.FormValue("path")
+	id, err = strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if path == "" || err != nil {
+		http.Error(w, "path and a numeric id are required", http.StatusBadRequest)
+		return
+	}
+
+	return path, id, true
+}
+
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	vers, err := s.b.Versions(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vers)
+}
+
+func (s *Server) downloadVersion(w http.ResponseWriter, r *http.Request) {
+	path, id, ok := versionParams(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(path)))
+	if err := s.b.ReadVersion(path, id, w); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+}
+
+func (s *Server) restoreVersion(w http.ResponseWriter, r *http.Request, actor string) {
+	path, id, ok := versionParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.b.As(actor).RestoreVersion(path, id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "restored version %d of '%s'", id, path)
+}
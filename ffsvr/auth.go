@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+//verifyIDToken returns a function that verifies id tokens signed by the
+//issuer for the audience, the actor is the subject the token was issued to
+func verifyIDToken(issuer, audience string) func(token string) (string, error) {
+	return func(raw string) (actor string, err error) {
+		token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+			claims := token.Claims.(jwt.MapClaims)
+			if !claims.VerifyAudience(audience, true) {
+				return nil, fmt.Errorf("invalid audience")
+			}
+
+			if !claims.VerifyIssuer(issuer, true) {
+				return nil, fmt.Errorf("invalid issuer")
+			}
+
+			cert, err := issuerCert(issuer, token)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get signing certificate: %v", err)
+			}
+
+			return jwt.ParseRSAPublicKeyFromPEM([]byte(cert))
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to parse token: %v", err)
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || !token.Valid {
+			return "", errors.New("invalid token")
+		}
+
+		actor, _ = claims["sub"].(string)
+		if actor == "" {
+			return "", errors.New("token has no subject")
+		}
+
+		return actor, nil
+	}
+}
+
+//issuerCert returns the certificate the token was signed with from the well
+//known key set of the issuer
+func issuerCert(issuer string, token *jwt.Token) (string, error) {
+	resp, err := http.Get(fmt.Sprintf("%s.well-known/jwks.json", issuer))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+	var jwks struct {
+		Keys []struct {
+			Kid string   `json:"kid"`
+			X5c []string `json:"x5c"`
+		} `json:"keys"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return "", err
+	}
+
+	for _, k := range jwks.Keys {
+		if token.Header["kid"] == k.Kid && len(k.X5c) > 0 {
+			return "-----BEGIN CERTIFICATE-----\n" + k.X5c[0] + "\n-----END CERTIFICATE-----", nil
+		}
+	}
+
+	return "", errors.New("unable to find appropriate key")
+}
@@ -5,9 +5,12 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"time"
 
 	"github.com/advanderveer/dfs/ffs"
 	"github.com/advanderveer/dfs/ffs/fsrpc"
+	"github.com/advanderveer/dfs/ffs/volumes"
 	"github.com/advanderveer/dfs/ffshttp"
 	"github.com/advanderveer/dfs/model"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
@@ -19,7 +22,12 @@ func main() {
 
 	logs := log.New(os.Stderr, "ffs/", log.Lshortfile)
 	if len(os.Args) < 3 {
-		logs.Fatalf("ffsvr [dbdir] [addr]")
+		logs.Fatalf("ffsvr [datadir] [addr] [volume]")
+	}
+
+	volume := "default"
+	if len(os.Args) > 3 {
+		volume = os.Args[3]
 	}
 
 	fdb.MustAPIVersion(510)
@@ -28,11 +36,84 @@ func main() {
 		logs.Fatal(err)
 	}
 
-	fs, clean, err := ffs.NewTempFS(os.Args[1], db)
+	vm, err := volumes.NewManager(db, os.Args[1])
+	if err != nil {
+		logs.Fatalf("failed to setup volume manager: %v", err)
+	}
+
+	fs, err := vm.OpenOrCreate(volume)
+	if err != nil {
+		logs.Fatalf("failed to open volume '%s': %v", volume, err)
+	}
+
+	//version retention, the trash and the change feed are off unless they are
+	//configured, zero counts and ages keep nothing
+	vcount, err := envInt("FFS_VERSIONS")
+	if err != nil {
+		logs.Fatalf("invalid FFS_VERSIONS: %v", err)
+	}
+
+	vage, err := envDuration("FFS_VERSIONS_AGE")
+	if err != nil {
+		logs.Fatalf("invalid FFS_VERSIONS_AGE: %v", err)
+	}
+
+	if errc := fs.SetRetention(vcount, vage); errc != 0 {
+		logs.Fatalf("failed to configure version retention: %d", errc)
+	}
+
+	trash, err := envBool("FFS_TRASH")
+	if err != nil {
+		logs.Fatalf("invalid FFS_TRASH: %v", err)
+	}
+
+	texpiry, err := envDuration("FFS_TRASH_EXPIRY")
+	if err != nil {
+		logs.Fatalf("invalid FFS_TRASH_EXPIRY: %v", err)
+	}
+
+	if errc := fs.SetTrash(trash, texpiry); errc != 0 {
+		logs.Fatalf("failed to configure trash: %d", errc)
+	}
+
+	cage, err := envDuration("FFS_CHANGES_AGE")
 	if err != nil {
-		logs.Fatalf("failed to setup fs: %v", err)
+		logs.Fatalf("invalid FFS_CHANGES_AGE: %v", err)
+	}
+
+	if errc := fs.SetChangeRetention(cage); errc != 0 {
+		logs.Fatalf("failed to configure change retention: %d", errc)
 	}
 
+	//the sweeper stops when the server exits, a sweep that is in progress is
+	//allowed to finish first
+	done := make(chan struct{})
+	swept := make(chan struct{})
+	go func() {
+		defer close(swept)
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if errc := fs.SweepTrash(now); errc != 0 {
+					logs.Printf("failed to sweep trash: %d", errc)
+				}
+
+				if errc := fs.SweepChanges(now); errc != 0 {
+					logs.Printf("failed to sweep changes: %d", errc)
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-swept
+	}()
+
 	m, clean, err := model.New(db)
 	if err != nil {
 		logs.Fatalf("failed to setup mode: %v", err)
@@ -40,11 +121,23 @@ func main() {
 
 	_ = clean
 	// defer clean()
-	svr, err := ffshttp.NewServer(fsrpc.New(fs), ffs.NewBrowser(fs), m, os.Args[2])
+	//sessions work as the subject of the id token they connect with, without
+	//an issuer every session works as the anonymous actor
+	fsr := fsrpc.NewSessions(func(id, actor string) fsrpc.FS { return fs.Session(id).As(actor) }, fs.EndSession)
+	svr, err := ffshttp.NewServer(fsr, ffs.NewBrowser(fs), m, os.Args[2])
 	if err != nil {
 		logs.Fatalf("failed to create server: %v", err)
 	}
 
+	//http requests that change the filesystem authenticate the same way
+	if issuer := os.Getenv("FFS_ISSUER"); issuer != "" {
+		auth := fsrpc.BearerAuth(verifyIDToken(issuer, os.Getenv("FFS_AUDIENCE")))
+		fsr.WithAuth(auth)
+		svr.WithAuth(auth)
+	} else {
+		logs.Printf("FFS_ISSUER not set, sessions are not authenticated and work as the anonymous actor")
+	}
+
 	defer fmt.Println("exited")
 	go func() {
 		logs.Printf("starting http on: %v", os.Args[2])
@@ -52,3 +145,34 @@ func main() {
 	}()
 	<-c
 }
+
+//envInt reads an integer from the environment, it is zero when not set
+func envInt(name string) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(v)
+}
+
+//envDuration reads a duration like "168h" from the environment, it is zero
+//when not set
+func envDuration(name string) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(v)
+}
+
+//envBool reads a boolean from the environment, it is false when not set
+func envBool(name string) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(v)
+}
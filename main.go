@@ -11,9 +11,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/advanderveer/dfs/ffs"
 	"github.com/advanderveer/dfs/ffs/fsrpc"
+	"github.com/advanderveer/dfs/ffs/volumes"
 	"github.com/advanderveer/dfs/memfs"
 	"github.com/advanderveer/dfs/msg"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
@@ -24,7 +26,7 @@ import (
 func main() {
 	logs := log.New(os.Stderr, "ffs/", log.Lshortfile)
 	if len(os.Args) < 3 {
-		logs.Fatalf("ffs [addr|'local'|'memfs'] [mountpoint]")
+		logs.Fatalf("ffs [addr|'local'|'local:<volume>'|'memfs'] [mountpoint]")
 	}
 
 	logs.Printf("mounting filesystem from '%s' at '%s'", os.Args[1], os.Args[2])
@@ -35,8 +37,8 @@ func main() {
 		err error
 	)
 
-	switch os.Args[1] {
-	case "local":
+	switch {
+	case os.Args[1] == "local":
 		logs.Println("using a own-mounted fs")
 		var err error
 
@@ -54,12 +56,39 @@ func main() {
 
 		defer clean()
 
-	case "memfs":
+	case strings.HasPrefix(os.Args[1], "local:"):
+		volume := strings.TrimPrefix(os.Args[1], "local:")
+		logs.Printf("using own-mounted volume '%s'", volume)
+
+		fdb.MustAPIVersion(510)
+		db, err := fdb.OpenDefault()
+		if err != nil {
+			logs.Fatal(err)
+		}
+
+		dir, err := volumes.DefaultDir()
+		if err != nil {
+			logs.Fatal(err)
+		}
+
+		vm, err := volumes.NewManager(db, dir)
+		if err != nil {
+			logs.Fatal(err)
+		}
+
+		fs, err = vm.Open(volume)
+		if err != nil {
+			logs.Fatalf("failed to open volume '%s': %v", volume, err)
+		}
+
+	case os.Args[1] == "memfs":
 		logs.Println("using a memory fs")
 		fs = memfs.NewMemfs()
 	default:
 		logs.Println("using a remote fs")
-		fs, err = fsrpc.DialHTTP(os.Args[1], "/fs")
+		//the server derives the actor from the id token, without one the
+		//session works as the anonymous actor
+		fs, err = fsrpc.DialHTTPToken(os.Args[1], "/fs", os.Getenv("FFS_TOKEN"))
 		if err != nil {
 			log.Fatalf("failed to dial: %v", err)
 		}
@@ -36,7 +36,7 @@ func TestEnd2End(t *testing.T) {
 	ok(t, err)
 	defer clean2()
 
-	fsr := fsrpc.New(fs)
+	fsr := fsrpc.NewSessions(func(id, actor string) fsrpc.FS { return fs.Session(id).As(actor) }, fs.EndSession)
 	svr, err := ffshttp.NewServer(fsr, ffs.NewBrowser(fs), m, "localhost:")
 	if err != nil {
 		t.Fatal(err)
@@ -168,6 +168,25 @@ func LinuxDarwinEnd2End(mntdir string, remotefs fuse.FileSystemInterface, t *tes
 		equals(t, 1, n)
 	})
 
+	t.Run("resource fork through the host", func(t *testing.T) {
+		//the host passes the position of each chunk of the fork on to these
+		intf, isPositional := remotefs.(interface {
+			fuse.FileSystemSetxattrAt
+			fuse.FileSystemGetxattrAt
+		})
+		assert(t, isPositional, "expected the remote fs to read and write xattrs at a position")
+
+		equals(t, 0, remotefs.Mknod("/fork", fuse.S_IFREG|0666, 0))
+		equals(t, 0, intf.SetxattrAt("/fork", ffs.ResourceForkXAttr, []byte("first "), 0, 0))
+		equals(t, 0, intf.SetxattrAt("/fork", ffs.ResourceForkXAttr, []byte("second"), 0, 6))
+		errc, fork := remotefs.Getxattr("/fork", ffs.ResourceForkXAttr)
+		equals(t, 0, errc)
+		equals(t, []byte("first second"), fork)
+		errc, fork = intf.GetxattrAt("/fork", ffs.ResourceForkXAttr, 6)
+		equals(t, 0, errc)
+		equals(t, []byte("second"), fork)
+	})
+
 	dira := filepath.Join(mntdir, "a")
 	err := os.Mkdir(dira, 0777)
 	ok(t, err)